@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+)
+
+// newHandler wraps the public routes in the middleware chain, outermost first: HSTS, security
+// headers, compression, body limits, timeouts and redirects
+func newHandler(cfg config.ServerConfig, routes http.Handler) (http.Handler, error) {
+	redirector, err := middleware.NewRedirector(cfg.Redirects)
+	if err != nil {
+		return nil, fmt.Errorf("init redirects: %w", err)
+	}
+
+	compress := middleware.CompressionMiddleware(middleware.NewCompressionConfig(cfg))
+
+	limitBodies := middleware.BodyLimitMiddleware(cfg.MaxBodyBytes, cfg.BodyLimits)
+	timeouts := middleware.TimeoutMiddleware(cfg.RequestTimeout, cfg.RouteTimeouts)
+	hsts := middleware.HSTSMiddleware(middleware.HSTSConfig{
+		MaxAge:            cfg.HSTSMaxAge,
+		IncludeSubDomains: cfg.HSTSIncludeSubDomains,
+		Preload:           cfg.HSTSPreload,
+		TrustProxy:        cfg.HSTSTrustProxy,
+	})
+	return hsts(secureHeaders(cfg)(compress(limitBodies(timeouts(redirector.Middleware(routes)))))), nil
+}
+
+// newAdminHandler wraps the admin routes in security headers and timeouts
+func newAdminHandler(cfg config.ServerConfig, routes http.Handler) http.Handler {
+	timeouts := middleware.TimeoutMiddleware(cfg.RequestTimeout, cfg.RouteTimeouts)
+	return secureHeaders(cfg)(timeouts(routes))
+}
+
+func secureHeaders(cfg config.ServerConfig) func(http.Handler) http.Handler {
+	return middleware.SecurityHeadersMiddleware(middleware.SecurityHeadersScope(cfg.SecurityHeaders), middleware.DefaultSecurityHeaders())
+}
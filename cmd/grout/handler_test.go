@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grout/internal/config"
+	"grout/internal/handlers"
+	"grout/internal/render"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// streamingRoutes serves /stream, which flushes a first line and writes the second only once
+// release is closed, so the first line must reach the client on its own
+func streamingRoutes(t *testing.T, release <-chan struct{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "first\n")
+		http.NewResponseController(w).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+			t.Error("expected the flushed line to reach the client before the response ended")
+		}
+		_, _ = io.WriteString(w, "second\n")
+	})
+	return mux
+}
+
+func TestHandlerStreamsFlushedResponses(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+	}{
+		{"Identity", ""},
+		{"Gzip", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultServerConfig()
+			if cfg.RequestTimeout <= 0 {
+				t.Fatal("expected the default config to time requests out")
+			}
+			release := make(chan struct{})
+			handler, err := newHandler(cfg, streamingRoutes(t, release))
+			if err != nil {
+				t.Fatalf("new handler: %v", err)
+			}
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream", nil)
+			if tt.acceptEncoding != "" {
+				// Set explicitly, the transport leaves the body compressed
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
+				t.Fatal("expected the security headers on a streamed response")
+			}
+			if encoding := resp.Header.Get("Content-Encoding"); encoding != tt.acceptEncoding {
+				t.Fatalf("expected Content-Encoding %q got %q", tt.acceptEncoding, encoding)
+			}
+
+			var body io.Reader = resp.Body
+			if tt.acceptEncoding == "gzip" {
+				if body, err = gzip.NewReader(resp.Body); err != nil {
+					t.Fatalf("gzip: %v", err)
+				}
+			}
+			lines := bufio.NewReader(body)
+			if line, err := lines.ReadString('\n'); err != nil || line != "first\n" {
+				t.Fatalf("expected the flushed line got %q: %v", line, err)
+			}
+			close(release)
+			if rest, err := io.ReadAll(lines); err != nil || string(rest) != "second\n" {
+				t.Fatalf("expected the rest of the response got %q: %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestHandlerHijacks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		_ = rw.Flush()
+	})
+	handler, err := newHandler(config.DefaultServerConfig(), mux)
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/hijack")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hijacked" {
+		t.Fatalf("expected the hijacked connection's response got %q", body)
+	}
+}
+
+func TestHandlerHeadMatchesGet(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("init renderer: %v", err)
+	}
+	cache, err := lru.New[string, []byte](100)
+	if err != nil {
+		t.Fatalf("init cache: %v", err)
+	}
+	cfg := config.DefaultServerConfig()
+	mux := http.NewServeMux()
+	handlers.NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+	handler, err := newHandler(cfg, mux)
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	do := func(method, path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		// Set explicitly, the transport leaves the body compressed
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp
+	}
+
+	for _, path := range []string{"/avatar/Jane%20Doe", "/placeholder/300x200", "/avatar/Jane%20Doe?format=png"} {
+		t.Run(path, func(t *testing.T) {
+			get := do(http.MethodGet, path)
+			body, _ := io.ReadAll(get.Body)
+			get.Body.Close()
+			head := do(http.MethodHead, path)
+			headBody, _ := io.ReadAll(head.Body)
+			head.Body.Close()
+
+			if get.StatusCode != http.StatusOK || head.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200 for both got GET %d HEAD %d", get.StatusCode, head.StatusCode)
+			}
+			if len(body) == 0 || len(headBody) != 0 {
+				t.Fatalf("expected a body only for GET got %d and %d bytes", len(body), len(headBody))
+			}
+			for _, name := range []string{"Content-Type", "Content-Encoding", "Content-Length", "ETag", "Cache-Control", "Vary"} {
+				if got, want := head.Header.Get(name), get.Header.Get(name); got != want {
+					t.Errorf("expected HEAD %s %q like GET got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
@@ -15,6 +15,9 @@ import (
 
 func main() {
 	cfg := config.LoadServerConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	renderer, err := render.New()
 	if err != nil {
@@ -29,9 +32,27 @@ func main() {
 	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPM, cfg.RateLimitBurst)
 
 	svc := handlers.NewService(renderer, cache, cfg)
+	if err := runSelfTest(cfg, svc); err != nil {
+		log.Fatal(err)
+	}
 	mux := http.NewServeMux()
 	svc.RegisterRoutes(mux, rateLimiter)
 
+	handler, err := newHandler(cfg, mux)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The admin listener shares the service, so toggles made there apply to the public routes
+	if cfg.AdminAddr != "" {
+		adminMux := http.NewServeMux()
+		svc.RegisterAdminRoutes(adminMux)
+		adminSrv := newServer(cfg, newAdminHandler(cfg, adminMux))
+		adminSrv.Addr = cfg.AdminAddr
+		fmt.Printf("Grout admin endpoints on %s\n", cfg.AdminAddr)
+		go func() { log.Fatal(adminSrv.ListenAndServe()) }()
+	}
+
 	fmt.Printf("Grout running on %s (rate limit: %d req/min, burst: %d)\n", cfg.Addr, cfg.RateLimitRPM, cfg.RateLimitBurst)
-	log.Fatal(http.ListenAndServe(cfg.Addr, mux))
+	log.Fatal(newServer(cfg, handler).ListenAndServe())
 }
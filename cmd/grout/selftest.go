@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"grout/internal/config"
+)
+
+// selfTester renders the startup samples; *handlers.Service is one
+type selfTester interface {
+	SelfTest() error
+}
+
+// runSelfTest runs the startup self-test when cfg asks for it. Failures are logged and only
+// returned under cfg.StrictStartup, so a lenient server still starts and serves what works.
+func runSelfTest(cfg config.ServerConfig, svc selfTester) error {
+	if !cfg.SelfTest && !cfg.StrictStartup {
+		return nil
+	}
+	if err := svc.SelfTest(); err != nil {
+		if cfg.StrictStartup {
+			return fmt.Errorf("startup self-test failed: %w", err)
+		}
+		log.Printf("startup self-test failed; serving anyway as STRICT_STARTUP is off: %v", err)
+		return nil
+	}
+	log.Print("startup self-test passed")
+	return nil
+}
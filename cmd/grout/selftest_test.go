@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"grout/internal/config"
+)
+
+// fakeSelfTester returns err from SelfTest and counts the runs
+type fakeSelfTester struct {
+	err  error
+	runs int
+}
+
+func (f *fakeSelfTester) SelfTest() error {
+	f.runs++
+	return f.err
+}
+
+func TestRunSelfTest(t *testing.T) {
+	broken := errors.New("self-test: default webp: encode webp: encoder unavailable")
+	tests := []struct {
+		name        string
+		selfTest    bool
+		strict      bool
+		err         error
+		expectRuns  int
+		expectError bool
+	}{
+		{"Off", false, false, broken, 0, false},
+		{"Passes", true, false, nil, 1, false},
+		{"Lenient failure", true, false, broken, 1, false},
+		{"Strict failure", true, true, broken, 1, true},
+		{"Strict alone runs it", false, true, broken, 1, true},
+		{"Strict pass", false, true, nil, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultServerConfig()
+			cfg.SelfTest = tt.selfTest
+			cfg.StrictStartup = tt.strict
+			svc := &fakeSelfTester{err: tt.err}
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			err := runSelfTest(cfg, svc)
+			if svc.runs != tt.expectRuns {
+				t.Fatalf("expected %d self-test runs got %d", tt.expectRuns, svc.runs)
+			}
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error %t got %v", tt.expectError, err)
+			}
+			if err != nil && (!errors.Is(err, broken) || !strings.Contains(err.Error(), "startup self-test failed")) {
+				t.Fatalf("expected the self-test failure to be wrapped got %v", err)
+			}
+			// A lenient server logs what failed, not just that something did
+			if tt.expectRuns > 0 && tt.err != nil && !tt.expectError && !strings.Contains(logs.String(), tt.err.Error()) {
+				t.Fatalf("expected the failure to be logged got %q", logs.String())
+			}
+		})
+	}
+}
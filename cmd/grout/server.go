@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	"grout/internal/config"
+)
+
+// newServer returns the HTTP server for handler with limits taken from cfg.
+// Requests whose headers exceed MaxHeaderBytes are answered with 431 by net/http.
+func newServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           cfg.Addr,
+		Handler:        handler,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"grout/internal/config"
+)
+
+func TestNewServerMaxHeaderBytes(t *testing.T) {
+	cfg := config.DefaultServerConfig()
+	cfg.MaxHeaderBytes = 8 * 1024
+	srv := newServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if srv.MaxHeaderBytes != cfg.MaxHeaderBytes {
+		t.Fatalf("expected MaxHeaderBytes %d got %d", cfg.MaxHeaderBytes, srv.MaxHeaderBytes)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	tests := []struct {
+		name           string
+		headerSize     int
+		expectedStatus int
+	}{
+		{"Small headers", 1024, http.StatusOK},
+		// net/http allows 4096 bytes of slack on top of MaxHeaderBytes
+		{"Oversized Accept-Encoding", 64 * 1024, http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://"+ln.Addr().String()+"/", nil)
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			req.Header.Set("Accept-Encoding", strings.Repeat("x,", tt.headerSize/2))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
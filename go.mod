@@ -9,8 +9,14 @@ require (
 	github.com/fogleman/gg v1.3.0
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	github.com/hashicorp/golang-lru/v2 v2.0.7
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/image v0.34.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/time v0.14.0
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/text v0.32.0
+	golang.org/x/time v0.14.0
+)
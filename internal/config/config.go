@@ -2,12 +2,21 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"log"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
 )
 
 const (
 	DefaultSize               = 128
+	MaxImageSize              = 4096 // Largest accepted width or height in pixels
 	DefaultBgColor            = "cccccc"
 	DefaultFontColor          = "969696"
 	DefaultAvatarBg           = "f0e9e9"
@@ -22,29 +31,260 @@ const (
 	MinTextLengthForSmallFont = 2   // Text longer than this uses smaller font (and may enable wrapping)
 	// MinTextLengthForWrapping is kept for backward compatibility; prefer MinTextLengthForSmallFont.
 	MinTextLengthForWrapping = MinTextLengthForSmallFont
-	MinCharsPerLine          = 10 // Minimum characters per line for SVG text estimation
+	MinCharsPerLine          = 10  // Minimum characters per line for SVG text estimation
+	DefaultMaxInitials       = 2   // Initials drawn on an avatar when maxInitials is not given
+	MaxInitials              = 4   // Upper bound for the maxInitials parameter
+	MaxBatchItems            = 50  // Maximum number of images in a single batch request
+	DefaultMaxNameLength     = 256 // Longest accepted avatar name, in characters
+	MaxSaltLength            = 64  // Longest accepted color salt, in characters
+	MaxAltLength             = 256 // Longest accepted alt text, in characters
+	DefaultCornerRadiusPct   = 15  // Corner radius for shape=rounded, as a percentage of the smaller dimension
+	DefaultSecurityHeaders   = "pages"
+	DefaultMaxCacheKeyLength = 256       // Longer cache keys are stored as their SHA-256 hash
+	LowMemoryCacheSize       = 200       // Default cache size in low-memory mode
+	MaxCacheDirectiveSeconds = 31536000  // Upper bound for s-maxage and stale-if-error (one year)
+	DefaultMaxHeaderBytes    = 16 * 1024 // Larger request headers are rejected with 431
+	DefaultHSTSMaxAge        = 31536000  // Strict-Transport-Security max-age for HTTPS requests (one year)
+	DefaultMaxBodyBytes      = 1 << 20   // Larger request bodies are rejected with 413
+	DefaultMaintenanceRetry  = 120       // Retry-After seconds sent while in maintenance mode
+	MaxIdempotencyEntries    = 256       // Batch responses kept for Idempotency-Key replays
+	MaxIdempotencyEntryBytes = 4 << 20   // Larger batch responses are not kept for replays
+	MaxIdempotencyBytes      = 64 << 20  // Total body bytes kept for replays; the oldest go first
+	MaxStaticCacheEntries    = 1024      // Static files kept in memory
+	MaxStaticCacheFileBytes  = 1 << 20   // Larger static files are streamed from disk instead of kept in memory
+	MaxStaticCacheBytes      = 32 << 20  // Total static file bytes kept in memory; the least recently used go first
+	// Timeout defaults
+	DefaultRequestTimeout   = 30 * time.Second // Slower requests are answered with 503
+	DefaultRasterEncodeWait = 5 * time.Second  // Raster renders waiting longer for an encode slot get 503
+	DefaultIdempotencyTTL   = 24 * time.Hour   // How long a batch response is replayed for its Idempotency-Key
 	// Rate limiting defaults
 	DefaultRateLimitRPM   = 100 // Default requests per minute per IP
 	DefaultRateLimitBurst = 10  // Default burst size for rate limiter
+	// Compression defaults
+	DefaultCompressionLevelSmall     = 1         // gzip.BestSpeed for small bodies
+	DefaultCompressionLevelLarge     = 6         // gzip default level for large bodies
+	DefaultCompressionLargeThreshold = 32 * 1024 // Bodies of at least this many bytes use the large level
+	DefaultCompressionMinSize        = 256       // Smaller bodies are sent uncompressed, as framing would outweigh the savings
+	// Raster encoding defaults
+	DefaultJPEGQuality    = 90
+	DefaultWebPQuality    = 90
+	DefaultPNGCompression = "default"
+	// DefaultInitialsSplit splits names into words on whitespace only
+	DefaultInitialsSplit = "words"
+	// DefaultQRLevel is the error correction of qr= placeholders: M recovers 15% of the code
+	DefaultQRLevel = "M"
+	// DefaultPictureFormats are the formats of format=picture snippets, most preferred first
+	DefaultPictureFormats = "webp,png"
 )
 
 // ServerConfig represents runtime server settings.
 type ServerConfig struct {
-	Addr           string
-	Domain         string
+	Addr   string
+	Domain string
+	// BaseURL is the public URL the service is reached at, including any path prefix.
+	// Empty means https://<Domain>.
+	BaseURL        string
 	StaticDir      string
 	CacheSize      int
 	RateLimitRPM   int // Requests per minute per IP
 	RateLimitBurst int // Burst size for rate limiter
+	// Palettes maps a palette name to the hex colors name-derived backgrounds are picked from
+	Palettes map[string][]string
+	// DefaultPalette is used when a request does not select a palette; empty keeps the built-in hash colors
+	DefaultPalette string
+	// Locale is the BCP 47 tag whose case rules uppercase avatar initials (e.g. "tr");
+	// empty uses locale-independent rules. A request's ?locale= overrides it
+	Locale string
+	// ColorSalt is mixed into the name hash before color selection so tenants get distinct colors;
+	// a request's ?salt= overrides it
+	ColorSalt string
+	// AltTemplate is the accessible label of SVG images without ?alt=, e.g. "Avatar for {name}".
+	// {name}, {initials} and {size} are substituted; empty adds no label
+	AltTemplate string
+	// ColorHash names the hash mapping names to colors: md5 (default), fnv32, fnv64, sha256 or crc32
+	ColorHash string
+	// Compression level selection based on the buffered response size
+	CompressionLevelSmall     int
+	CompressionLevelLarge     int
+	CompressionLargeThreshold int
+	// CompressionBrotliLevel sets the brotli quality (1-11) for every body; 0 maps the gzip-scale levels above
+	CompressionBrotliLevel int
+	// CompressionMinSize is the body size in bytes below which responses are sent uncompressed; 0 compresses any body
+	CompressionMinSize int
+	// CompressionDebug adds an X-Compression-Debug header explaining each compression decision
+	CompressionDebug bool
+	// CompressionAdaptiveThreshold sheds compression work while more requests are in flight; 0 disables it
+	CompressionAdaptiveThreshold int
+	// CompressionSkipPaths lists path prefixes, such as /healthz, whose responses are never compressed
+	CompressionSkipPaths []string
+	// CompressionContentTypes lists the media types to compress, "text/*" covering a whole type;
+	// nil keeps the built-in list of text, SVG, JSON, XML and JavaScript
+	CompressionContentTypes []string
+	// MaxNameLength caps the avatar name, in characters; longer names are rejected
+	MaxNameLength int
+	// AllowCacheBypass lets ?nocache=1 (or ?fresh=1) skip the cache read for a request
+	AllowCacheBypass bool
+	// CacheBypassWriteBack stores the fresh render of a cache bypass in place of the cached copy;
+	// when false a bypass leaves the cache untouched
+	CacheBypassWriteBack bool
+	// StrictParams rejects image requests carrying unknown query parameters with 400
+	// instead of ignoring them, so extra params cannot bust the cache
+	StrictParams bool
+	// LowMemory disables raster output and brotli/zstd, and lowers the default cache size,
+	// for small edge deployments
+	LowMemory bool
+	// SelfTest renders a tiny sample of every enabled style and format at startup, logging
+	// failures, so broken font or encoder wiring shows at boot instead of on a user request
+	SelfTest bool
+	// StrictStartup refuses to start when the self-test fails; it runs the self-test even
+	// without SelfTest
+	StrictStartup bool
+	// MaxHeaderBytes caps the size of request headers; larger requests get 431
+	MaxHeaderBytes int
+	// MaxBodyBytes caps request bodies of non-GET requests; larger bodies get 413. 0 disables the cap
+	MaxBodyBytes int64
+	// BodyLimits overrides MaxBodyBytes for paths starting with a prefix, e.g. "/batch"
+	BodyLimits map[string]int64
+	// RequestTimeout bounds how long a request may take before it is answered with 503; 0 disables it
+	RequestTimeout time.Duration
+	// RasterEncodeWorkers caps how many raster images are rendered and encoded at once, bounding
+	// their memory; SVG output is never queued behind them. 0 disables the cap
+	RasterEncodeWorkers int
+	// RasterEncodeWait is how long a raster render may wait for a free slot before it gets 503
+	RasterEncodeWait time.Duration
+	// IdempotencyTTL is how long a batch response is kept for retries with the same
+	// Idempotency-Key header; 0 disables replays
+	IdempotencyTTL time.Duration
+	// JPEGQuality and WebPQuality are the encoder qualities (1-100) of raster output
+	JPEGQuality int
+	WebPQuality int
+	// WebPLossless encodes WebP losslessly, ignoring WebPQuality
+	WebPLossless bool
+	// InitialsSplit is how names break into words for initials: words splits on whitespace
+	// only, camel-hump also on camelCase humps and the separators _, - and .
+	InitialsSplit string
+	// QRLevel is the error correction level of qr= placeholders without qrLevel: L, M, Q or H
+	QRLevel string
+	// PNGCompression is the zlib effort of PNG output: default, none, fast or best
+	PNGCompression string
+	// GammaCorrect blends the text of raster output in linear light for cleaner edges; the
+	// gammaCorrect param overrides it per request
+	GammaCorrect bool
+	// PictureFormats lists the formats of format=picture snippets, most preferred first: each
+	// but the last becomes a <source>, the last the <img> fallback
+	PictureFormats []string
+	// EnabledStyles lists the avatar styles that may be requested; nil enables every registered
+	// style. The default style is always enabled.
+	EnabledStyles []string
+	// LabelFont is the font of placeholder labels as family or family:weight, e.g. "go-mono";
+	// empty uses the default family. Avatar initials are not affected.
+	LabelFont string
+	// RouteTimeouts overrides RequestTimeout for paths starting with a prefix, e.g. "/batch"
+	RouteTimeouts map[string]time.Duration
+	// MaxCacheKeyLength is the longest cache key stored verbatim; longer keys are hashed
+	MaxCacheKeyLength int
+	// CacheSMaxAge and CacheStaleIfError add s-maxage and stale-if-error (in seconds) to the
+	// Cache-Control of image responses for shared caches; 0 omits the directive
+	CacheSMaxAge      int
+	CacheStaleIfError int
+	// SecurityHeaders selects which responses get default security headers: "pages" (non-image), "all" or "off"
+	SecurityHeaders string
+	// HSTSMaxAge is the Strict-Transport-Security max-age sent on HTTPS requests; 0 disables HSTS
+	HSTSMaxAge            int
+	HSTSIncludeSubDomains bool
+	HSTSPreload           bool
+	// HSTSTrustProxy treats X-Forwarded-Proto: https as HTTPS when a proxy terminates TLS
+	HSTSTrustProxy bool
+	// HotlinkAllowedHosts lists the Referer hosts allowed to embed images ("*.example.com" matches
+	// subdomains); other sites get 403. Empty disables hotlink protection
+	HotlinkAllowedHosts []string
+	// HotlinkAllowEmptyReferer lets image requests without a Referer through when protection is on
+	HotlinkAllowEmptyReferer bool
+	// Redirects maps legacy path patterns to new locations, checked in order before routing
+	Redirects []RedirectRule
+	// MaintenanceMode starts the service answering generation requests with 503
+	MaintenanceMode bool
+	// MaintenanceRetryAfter is the Retry-After in seconds sent while in maintenance mode
+	MaintenanceRetryAfter int
+	// AdminToken enables the /admin endpoints for bearer requests carrying it; empty disables them
+	AdminToken string
+	// AdminAddr serves the /admin endpoints on a separate listener, e.g. "127.0.0.1:9090",
+	// and removes them from the public one; empty keeps them on Addr
+	AdminAddr string
+
+	// loadErrors are the env and flag values LoadServerConfig could not use; Validate reports them
+	loadErrors ValidationErrors
+}
+
+// RedirectRule redirects paths matching From to the To template; both may use {param} captures.
+type RedirectRule struct {
+	From string
+	To   string
 }
 
 var (
-	addrFlag           = flag.String("addr", "", "HTTP listen address (env ADDR)")
-	domainFlag         = flag.String("domain", "", "Public domain for example URLs (env DOMAIN)")
-	staticDirFlag      = flag.String("static-dir", "", "Directory for static files (env STATIC_DIR)")
-	cacheSizeFlag      = flag.Int("cache-size", 0, "LRU cache size (env CACHE_SIZE)")
-	rateLimitRPMFlag   = flag.Int("rate-limit-rpm", 0, "Rate limit requests per minute per IP (env RATE_LIMIT_RPM)")
-	rateLimitBurstFlag = flag.Int("rate-limit-burst", 0, "Rate limit burst size (env RATE_LIMIT_BURST)")
+	addrFlag                      = flag.String("addr", "", "HTTP listen address (env ADDR)")
+	domainFlag                    = flag.String("domain", "", "Public domain for example URLs (env DOMAIN)")
+	baseURLFlag                   = flag.String("base-url", "", "Public base URL for example URLs, e.g. https://img.example.com/grout (env BASE_URL)")
+	staticDirFlag                 = flag.String("static-dir", "", "Directory for static files (env STATIC_DIR)")
+	cacheSizeFlag                 = flag.Int("cache-size", 0, "LRU cache size (env CACHE_SIZE)")
+	rateLimitRPMFlag              = flag.Int("rate-limit-rpm", 0, "Rate limit requests per minute per IP (env RATE_LIMIT_RPM)")
+	rateLimitBurstFlag            = flag.Int("rate-limit-burst", 0, "Rate limit burst size (env RATE_LIMIT_BURST)")
+	palettesFlag                  = flag.String("palettes", "", "Custom palettes as name=hex,hex;name=hex,... (env PALETTES)")
+	localeFlag                    = flag.String("locale", "", "Default BCP 47 locale for uppercasing initials, e.g. tr (env LOCALE)")
+	colorSaltFlag                 = flag.String("color-salt", "", "Salt mixed into name-derived avatar colors, e.g. a tenant id (env COLOR_SALT)")
+	altTemplateFlag               = flag.String("alt-template", "", "Accessible label template for SVG images, e.g. \"Avatar for {name}\" (env ALT_TEMPLATE)")
+	colorHashFlag                 = flag.String("color-hash", "", "Hash mapping names to colors: md5, fnv32, fnv64, sha256 or crc32 (env COLOR_HASH)")
+	defaultPaletteFlag            = flag.String("default-palette", "", "Palette used when a request omits ?palette= (env DEFAULT_PALETTE)")
+	compressionLevelSmallFlag     = flag.Int("compression-level-small", 0, "gzip level (1-9) for small responses (env COMPRESSION_LEVEL_SMALL)")
+	compressionLevelLargeFlag     = flag.Int("compression-level-large", 0, "gzip level (1-9) for large responses (env COMPRESSION_LEVEL_LARGE)")
+	compressionLargeThresholdFlag = flag.Int("compression-large-threshold", 0, "Response size in bytes that selects the large gzip level (env COMPRESSION_LARGE_THRESHOLD)")
+	compressionBrotliLevelFlag    = flag.Int("compression-brotli-level", 0, "brotli quality (1-11) for every response, overriding the gzip-scale levels (env COMPRESSION_BROTLI_LEVEL)")
+	compressionMinSizeFlag        = flag.Int("compression-min-size", -1, "Response size in bytes below which responses are not compressed (env COMPRESSION_MIN_SIZE)")
+	compressionDebugFlag          = flag.String("compression-debug", "", "Explain compression decisions in an X-Compression-Debug header, true or false (env COMPRESSION_DEBUG)")
+	compressionAdaptiveFlag       = flag.Int("compression-adaptive-threshold", 0, "In-flight requests above which brotli is downgraded and large responses go uncompressed (env COMPRESSION_ADAPTIVE_THRESHOLD)")
+	compressionSkipPathsFlag      = flag.String("compression-skip-paths", "", "Comma-separated path prefixes never compressed, e.g. /healthz (env COMPRESSION_SKIP_PATHS)")
+	compressionContentTypesFlag   = flag.String("compression-content-types", "", "Comma-separated media types to compress, type/* covering a whole type (env COMPRESSION_CONTENT_TYPES)")
+	maxNameLengthFlag             = flag.Int("max-name-length", 0, "Longest accepted avatar name in characters (env MAX_NAME_LENGTH)")
+	allowCacheBypassFlag          = flag.String("allow-cache-bypass", "", "Allow ?nocache=1 to skip the cache read, true or false (env ALLOW_CACHE_BYPASS)")
+	cacheBypassWriteBackFlag      = flag.String("cache-bypass-write-back", "", "Store the fresh render of a cache bypass in the cache, true or false (env CACHE_BYPASS_WRITE_BACK)")
+	strictParamsFlag              = flag.String("strict-params", "", "Reject unknown query parameters on image endpoints, true or false (env STRICT_PARAMS)")
+	lowMemoryFlag                 = flag.String("low-memory", "", "Disable raster formats and brotli/zstd to cap memory, true or false (env LOW_MEMORY)")
+	selfTestFlag                  = flag.String("self-test", "", "Render a sample of every style and format at startup, true or false (env SELF_TEST)")
+	strictStartupFlag             = flag.String("strict-startup", "", "Refuse to start when the startup self-test fails, true or false (env STRICT_STARTUP)")
+	maxHeaderBytesFlag            = flag.Int("max-header-bytes", 0, "Largest accepted request header size in bytes (env MAX_HEADER_BYTES)")
+	maxBodyBytesFlag              = flag.String("max-body-bytes", "", "Largest accepted request body in bytes, 0 for no limit (env MAX_BODY_BYTES)")
+	bodyLimitsFlag                = flag.String("body-limits", "", "Per-route body limits as /prefix=bytes;... (env BODY_LIMITS)")
+	requestTimeoutFlag            = flag.String("request-timeout", "", "Longest time a request may take, e.g. 30s, 0 for no limit (env REQUEST_TIMEOUT)")
+	rasterEncodeWorkersFlag       = flag.Int("raster-encode-workers", 0, "Most raster images rendered at once, 0 for no limit (env RASTER_ENCODE_WORKERS)")
+	rasterEncodeWaitFlag          = flag.String("raster-encode-wait", "", "Longest wait for a raster encode slot before 503, e.g. 5s (env RASTER_ENCODE_WAIT)")
+	idempotencyTTLFlag            = flag.String("idempotency-ttl", "", "How long batch responses are replayed for an Idempotency-Key, e.g. 1h, 0 to disable (env IDEMPOTENCY_TTL)")
+	jpegQualityFlag               = flag.String("jpeg-quality", "", "JPEG encoder quality, 1-100 (env JPEG_QUALITY)")
+	webpQualityFlag               = flag.String("webp-quality", "", "Lossy WebP encoder quality, 1-100 (env WEBP_QUALITY)")
+	webpLosslessFlag              = flag.String("webp-lossless", "", "Encode WebP losslessly, true or false (env WEBP_LOSSLESS)")
+	gammaCorrectFlag              = flag.String("gamma-correct", "", "Blend raster text in linear light, true or false (env GAMMA_CORRECT)")
+	initialsSplitFlag             = flag.String("initials-split", "", "How names split into words for initials: words or camel-hump (env INITIALS_SPLIT)")
+	qrLevelFlag                   = flag.String("qr-level", "", "Error correction of QR code placeholders: L, M, Q or H (env QR_LEVEL)")
+	pngCompressionFlag            = flag.String("png-compression", "", "PNG compression: default, none, fast or best (env PNG_COMPRESSION)")
+	pictureFormatsFlag            = flag.String("picture-formats", "", "Comma-separated formats of format=picture snippets, most preferred first (env PICTURE_FORMATS)")
+	enabledStylesFlag             = flag.String("enabled-styles", "", "Comma-separated avatar styles that may be requested, all when empty (env ENABLED_STYLES)")
+	labelFontFlag                 = flag.String("label-font", "", "Font of placeholder labels as family or family:weight, e.g. go-mono (env LABEL_FONT)")
+	routeTimeoutsFlag             = flag.String("route-timeouts", "", "Per-route timeouts as /prefix=duration;... (env ROUTE_TIMEOUTS)")
+	maxCacheKeyLengthFlag         = flag.Int("max-cache-key-length", 0, "Longest cache key stored verbatim; longer keys are hashed (env MAX_CACHE_KEY_LENGTH)")
+	cacheSMaxAgeFlag              = flag.String("cache-s-maxage", "", "s-maxage in seconds added to image Cache-Control for CDNs (env CACHE_S_MAXAGE)")
+	cacheStaleIfErrorFlag         = flag.String("cache-stale-if-error", "", "stale-if-error in seconds added to image Cache-Control (env CACHE_STALE_IF_ERROR)")
+	hstsMaxAgeFlag                = flag.String("hsts-max-age", "", "Strict-Transport-Security max-age in seconds for HTTPS requests, 0 to disable (env HSTS_MAX_AGE)")
+	hstsIncludeSubDomainsFlag     = flag.String("hsts-include-subdomains", "", "Add includeSubDomains to HSTS, true or false (env HSTS_INCLUDE_SUBDOMAINS)")
+	hstsPreloadFlag               = flag.String("hsts-preload", "", "Add preload to HSTS, true or false (env HSTS_PRELOAD)")
+	hstsTrustProxyFlag            = flag.String("hsts-trust-proxy", "", "Send HSTS when X-Forwarded-Proto is https, true or false (env HSTS_TRUST_PROXY)")
+	hotlinkAllowedHostsFlag       = flag.String("hotlink-allowed-hosts", "", "Comma-separated Referer hosts allowed to embed images, enabling hotlink protection (env HOTLINK_ALLOWED_HOSTS)")
+	hotlinkAllowEmptyRefererFlag  = flag.String("hotlink-allow-empty-referer", "", "Serve images to requests without a Referer under hotlink protection, true or false (env HOTLINK_ALLOW_EMPTY_REFERER)")
+	securityHeadersFlag           = flag.String("security-headers", "", "Security headers scope: pages, all or off (env SECURITY_HEADERS)")
+	redirectsFlag                 = flag.String("redirects", "", "Legacy redirects as /old/{param}=/new/{param};... (env REDIRECTS)")
+	maintenanceModeFlag           = flag.String("maintenance", "", "Start in maintenance mode, answering generation requests with 503, true or false (env MAINTENANCE_MODE)")
+	maintenanceRetryAfterFlag     = flag.String("maintenance-retry-after", "", "Retry-After seconds sent in maintenance mode (env MAINTENANCE_RETRY_AFTER)")
+	adminTokenFlag                = flag.String("admin-token", "", "Bearer token enabling the /admin endpoints (env ADMIN_TOKEN)")
+	adminAddrFlag                 = flag.String("admin-addr", "", "Separate listen address for the /admin endpoints (env ADMIN_ADDR)")
 )
 
 // DefaultServerConfig returns sane defaults for local development.
@@ -56,6 +296,30 @@ func DefaultServerConfig() ServerConfig {
 		CacheSize:      CacheSize,
 		RateLimitRPM:   DefaultRateLimitRPM,
 		RateLimitBurst: DefaultRateLimitBurst,
+
+		CompressionLevelSmall:     DefaultCompressionLevelSmall,
+		CompressionLevelLarge:     DefaultCompressionLevelLarge,
+		CompressionLargeThreshold: DefaultCompressionLargeThreshold,
+		CompressionMinSize:        DefaultCompressionMinSize,
+		MaxNameLength:             DefaultMaxNameLength,
+		SecurityHeaders:           DefaultSecurityHeaders,
+		MaxCacheKeyLength:         DefaultMaxCacheKeyLength,
+		MaxHeaderBytes:            DefaultMaxHeaderBytes,
+		MaxBodyBytes:              DefaultMaxBodyBytes,
+		RequestTimeout:            DefaultRequestTimeout,
+		RasterEncodeWait:          DefaultRasterEncodeWait,
+		IdempotencyTTL:            DefaultIdempotencyTTL,
+		JPEGQuality:               DefaultJPEGQuality,
+		WebPQuality:               DefaultWebPQuality,
+		PNGCompression:            DefaultPNGCompression,
+		InitialsSplit:             DefaultInitialsSplit,
+		QRLevel:                   DefaultQRLevel,
+		GammaCorrect:              true,
+		CacheBypassWriteBack:      true,
+		PictureFormats:            strings.Split(DefaultPictureFormats, ","),
+		HSTSMaxAge:                DefaultHSTSMaxAge,
+		HotlinkAllowEmptyReferer:  true,
+		MaintenanceRetryAfter:     DefaultMaintenanceRetry,
 	}
 }
 
@@ -69,12 +333,18 @@ func LoadServerConfig() ServerConfig {
 	if domain := os.Getenv("DOMAIN"); domain != "" {
 		cfg.Domain = domain
 	}
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		cfg.BaseURL = loadBaseURL(&cfg.loadErrors, "BASE_URL", baseURL)
+	}
 	if staticDir := os.Getenv("STATIC_DIR"); staticDir != "" {
 		cfg.StaticDir = staticDir
 	}
+	// Remember an explicit cache size so low-memory mode only lowers the default
+	cacheSizeSet := false
 	if cacheEnv := os.Getenv("CACHE_SIZE"); cacheEnv != "" {
 		if n, err := strconv.Atoi(cacheEnv); err == nil && n > 0 {
 			cfg.CacheSize = n
+			cacheSizeSet = true
 		}
 	}
 	if rateLimitRPMEnv := os.Getenv("RATE_LIMIT_RPM"); rateLimitRPMEnv != "" {
@@ -88,6 +358,228 @@ func LoadServerConfig() ServerConfig {
 		}
 	}
 
+	if levelEnv := os.Getenv("COMPRESSION_LEVEL_SMALL"); levelEnv != "" {
+		if n, err := strconv.Atoi(levelEnv); err == nil && validGzipLevel(n) {
+			cfg.CompressionLevelSmall = n
+		}
+	}
+	if levelEnv := os.Getenv("COMPRESSION_LEVEL_LARGE"); levelEnv != "" {
+		if n, err := strconv.Atoi(levelEnv); err == nil && validGzipLevel(n) {
+			cfg.CompressionLevelLarge = n
+		}
+	}
+	if thresholdEnv := os.Getenv("COMPRESSION_LARGE_THRESHOLD"); thresholdEnv != "" {
+		if n, err := strconv.Atoi(thresholdEnv); err == nil && n > 0 {
+			cfg.CompressionLargeThreshold = n
+		}
+	}
+	if levelEnv := os.Getenv("COMPRESSION_BROTLI_LEVEL"); levelEnv != "" {
+		if n, err := strconv.Atoi(levelEnv); err == nil && validBrotliLevel(n) {
+			cfg.CompressionBrotliLevel = n
+		}
+	}
+	if minSizeEnv := os.Getenv("COMPRESSION_MIN_SIZE"); minSizeEnv != "" {
+		if n, err := strconv.Atoi(minSizeEnv); err == nil && n >= 0 {
+			cfg.CompressionMinSize = n
+		}
+	}
+	if debugEnv := os.Getenv("COMPRESSION_DEBUG"); debugEnv != "" {
+		if b, err := strconv.ParseBool(debugEnv); err == nil {
+			cfg.CompressionDebug = b
+		}
+	}
+	if adaptiveEnv := os.Getenv("COMPRESSION_ADAPTIVE_THRESHOLD"); adaptiveEnv != "" {
+		if n, err := strconv.Atoi(adaptiveEnv); err == nil && n >= 0 {
+			cfg.CompressionAdaptiveThreshold = n
+		}
+	}
+	if skipEnv := os.Getenv("COMPRESSION_SKIP_PATHS"); skipEnv != "" {
+		cfg.CompressionSkipPaths = loadSkipPaths(&cfg.loadErrors, "COMPRESSION_SKIP_PATHS", skipEnv, cfg.CompressionSkipPaths)
+	}
+	if typesEnv := os.Getenv("COMPRESSION_CONTENT_TYPES"); typesEnv != "" {
+		cfg.CompressionContentTypes = loadContentTypes(&cfg.loadErrors, "COMPRESSION_CONTENT_TYPES", typesEnv, cfg.CompressionContentTypes)
+	}
+	if maxNameEnv := os.Getenv("MAX_NAME_LENGTH"); maxNameEnv != "" {
+		if n, err := strconv.Atoi(maxNameEnv); err == nil && n > 0 {
+			cfg.MaxNameLength = n
+		}
+	}
+	if lowMemoryEnv := os.Getenv("LOW_MEMORY"); lowMemoryEnv != "" {
+		if b, err := strconv.ParseBool(lowMemoryEnv); err == nil {
+			cfg.LowMemory = b
+		}
+	}
+	if selfTestEnv := os.Getenv("SELF_TEST"); selfTestEnv != "" {
+		if b, err := strconv.ParseBool(selfTestEnv); err == nil {
+			cfg.SelfTest = b
+		}
+	}
+	if strictEnv := os.Getenv("STRICT_STARTUP"); strictEnv != "" {
+		if b, err := strconv.ParseBool(strictEnv); err == nil {
+			cfg.StrictStartup = b
+		}
+	}
+	if headerBytesEnv := os.Getenv("MAX_HEADER_BYTES"); headerBytesEnv != "" {
+		if n, err := strconv.Atoi(headerBytesEnv); err == nil && n > 0 {
+			cfg.MaxHeaderBytes = n
+		}
+	}
+	if bodyBytesEnv := os.Getenv("MAX_BODY_BYTES"); bodyBytesEnv != "" {
+		if n, err := strconv.ParseInt(bodyBytesEnv, 10, 64); err == nil && n >= 0 {
+			cfg.MaxBodyBytes = n
+		}
+	}
+	if bodyLimitsEnv := os.Getenv("BODY_LIMITS"); bodyLimitsEnv != "" {
+		cfg.BodyLimits = loadBodyLimits(&cfg.loadErrors, "BODY_LIMITS", bodyLimitsEnv)
+	}
+	if timeoutEnv := os.Getenv("REQUEST_TIMEOUT"); timeoutEnv != "" {
+		if d, err := time.ParseDuration(timeoutEnv); err == nil && d >= 0 {
+			cfg.RequestTimeout = d
+		}
+	}
+	if workersEnv := os.Getenv("RASTER_ENCODE_WORKERS"); workersEnv != "" {
+		if n, err := strconv.Atoi(workersEnv); err == nil && n >= 0 {
+			cfg.RasterEncodeWorkers = n
+		}
+	}
+	if waitEnv := os.Getenv("RASTER_ENCODE_WAIT"); waitEnv != "" {
+		if d, err := time.ParseDuration(waitEnv); err == nil && d > 0 {
+			cfg.RasterEncodeWait = d
+		}
+	}
+	if ttlEnv := os.Getenv("IDEMPOTENCY_TTL"); ttlEnv != "" {
+		if d, err := time.ParseDuration(ttlEnv); err == nil && d >= 0 {
+			cfg.IdempotencyTTL = d
+		}
+	}
+	if qualityEnv := os.Getenv("JPEG_QUALITY"); qualityEnv != "" {
+		cfg.JPEGQuality = loadQuality(&cfg.loadErrors, "JPEG_QUALITY", qualityEnv, cfg.JPEGQuality)
+	}
+	if qualityEnv := os.Getenv("WEBP_QUALITY"); qualityEnv != "" {
+		cfg.WebPQuality = loadQuality(&cfg.loadErrors, "WEBP_QUALITY", qualityEnv, cfg.WebPQuality)
+	}
+	if losslessEnv := os.Getenv("WEBP_LOSSLESS"); losslessEnv != "" {
+		if b, err := strconv.ParseBool(losslessEnv); err == nil {
+			cfg.WebPLossless = b
+		}
+	}
+	if gammaEnv := os.Getenv("GAMMA_CORRECT"); gammaEnv != "" {
+		if b, err := strconv.ParseBool(gammaEnv); err == nil {
+			cfg.GammaCorrect = b
+		}
+	}
+	if splitEnv := os.Getenv("INITIALS_SPLIT"); splitEnv != "" {
+		cfg.InitialsSplit = loadInitialsSplit(&cfg.loadErrors, "INITIALS_SPLIT", splitEnv, cfg.InitialsSplit)
+	}
+	if qrLevelEnv := os.Getenv("QR_LEVEL"); qrLevelEnv != "" {
+		cfg.QRLevel = loadQRLevel(&cfg.loadErrors, "QR_LEVEL", qrLevelEnv, cfg.QRLevel)
+	}
+	if compressionEnv := os.Getenv("PNG_COMPRESSION"); compressionEnv != "" {
+		cfg.PNGCompression = loadPNGCompression(&cfg.loadErrors, "PNG_COMPRESSION", compressionEnv, cfg.PNGCompression)
+	}
+	if pictureEnv := os.Getenv("PICTURE_FORMATS"); pictureEnv != "" {
+		cfg.PictureFormats = loadPictureFormats(&cfg.loadErrors, "PICTURE_FORMATS", pictureEnv, cfg.PictureFormats)
+	}
+	if stylesEnv := os.Getenv("ENABLED_STYLES"); stylesEnv != "" {
+		cfg.EnabledStyles = loadStyleList(stylesEnv)
+	}
+	if labelFontEnv := os.Getenv("LABEL_FONT"); labelFontEnv != "" {
+		cfg.LabelFont = loadLabelFont(&cfg.loadErrors, "LABEL_FONT", labelFontEnv, cfg.LabelFont)
+	}
+	if routeTimeoutsEnv := os.Getenv("ROUTE_TIMEOUTS"); routeTimeoutsEnv != "" {
+		cfg.RouteTimeouts = loadRouteTimeouts(&cfg.loadErrors, "ROUTE_TIMEOUTS", routeTimeoutsEnv)
+	}
+	if keyLengthEnv := os.Getenv("MAX_CACHE_KEY_LENGTH"); keyLengthEnv != "" {
+		if n, err := strconv.Atoi(keyLengthEnv); err == nil && n > 0 {
+			cfg.MaxCacheKeyLength = n
+		}
+	}
+	if sMaxAgeEnv := os.Getenv("CACHE_S_MAXAGE"); sMaxAgeEnv != "" {
+		cfg.CacheSMaxAge = loadCacheDirective(&cfg.loadErrors, "CACHE_S_MAXAGE", sMaxAgeEnv, cfg.CacheSMaxAge)
+	}
+	if staleEnv := os.Getenv("CACHE_STALE_IF_ERROR"); staleEnv != "" {
+		cfg.CacheStaleIfError = loadCacheDirective(&cfg.loadErrors, "CACHE_STALE_IF_ERROR", staleEnv, cfg.CacheStaleIfError)
+	}
+	if strictEnv := os.Getenv("STRICT_PARAMS"); strictEnv != "" {
+		if b, err := strconv.ParseBool(strictEnv); err == nil {
+			cfg.StrictParams = b
+		}
+	}
+	if bypassEnv := os.Getenv("ALLOW_CACHE_BYPASS"); bypassEnv != "" {
+		if b, err := strconv.ParseBool(bypassEnv); err == nil {
+			cfg.AllowCacheBypass = b
+		}
+	}
+	if writeBackEnv := os.Getenv("CACHE_BYPASS_WRITE_BACK"); writeBackEnv != "" {
+		if b, err := strconv.ParseBool(writeBackEnv); err == nil {
+			cfg.CacheBypassWriteBack = b
+		}
+	}
+	if palettesEnv := os.Getenv("PALETTES"); palettesEnv != "" {
+		cfg.Palettes = loadPalettes(&cfg.loadErrors, "PALETTES", palettesEnv)
+	}
+	if locale := os.Getenv("LOCALE"); locale != "" {
+		cfg.Locale = loadLocale(&cfg.loadErrors, "LOCALE", locale)
+	}
+	if colorSalt := os.Getenv("COLOR_SALT"); colorSalt != "" {
+		cfg.ColorSalt = colorSalt
+	}
+	if altTemplate := os.Getenv("ALT_TEMPLATE"); altTemplate != "" {
+		cfg.AltTemplate = altTemplate
+	}
+	if colorHash := os.Getenv("COLOR_HASH"); colorHash != "" {
+		cfg.ColorHash = loadColorHash(&cfg.loadErrors, "COLOR_HASH", colorHash, cfg.ColorHash)
+	}
+	if defaultPalette := os.Getenv("DEFAULT_PALETTE"); defaultPalette != "" {
+		cfg.DefaultPalette = defaultPalette
+	}
+	if scope := os.Getenv("SECURITY_HEADERS"); validSecurityHeadersScope(scope) {
+		cfg.SecurityHeaders = scope
+	}
+	if hstsMaxAgeEnv := os.Getenv("HSTS_MAX_AGE"); hstsMaxAgeEnv != "" {
+		cfg.HSTSMaxAge = loadHSTSMaxAge(&cfg.loadErrors, "HSTS_MAX_AGE", hstsMaxAgeEnv, cfg.HSTSMaxAge)
+	}
+	if subDomainsEnv := os.Getenv("HSTS_INCLUDE_SUBDOMAINS"); subDomainsEnv != "" {
+		if b, err := strconv.ParseBool(subDomainsEnv); err == nil {
+			cfg.HSTSIncludeSubDomains = b
+		}
+	}
+	if preloadEnv := os.Getenv("HSTS_PRELOAD"); preloadEnv != "" {
+		if b, err := strconv.ParseBool(preloadEnv); err == nil {
+			cfg.HSTSPreload = b
+		}
+	}
+	if trustProxyEnv := os.Getenv("HSTS_TRUST_PROXY"); trustProxyEnv != "" {
+		if b, err := strconv.ParseBool(trustProxyEnv); err == nil {
+			cfg.HSTSTrustProxy = b
+		}
+	}
+	if hostsEnv := os.Getenv("HOTLINK_ALLOWED_HOSTS"); hostsEnv != "" {
+		cfg.HotlinkAllowedHosts = loadHostList(hostsEnv)
+	}
+	if emptyRefererEnv := os.Getenv("HOTLINK_ALLOW_EMPTY_REFERER"); emptyRefererEnv != "" {
+		if b, err := strconv.ParseBool(emptyRefererEnv); err == nil {
+			cfg.HotlinkAllowEmptyReferer = b
+		}
+	}
+	if redirectsEnv := os.Getenv("REDIRECTS"); redirectsEnv != "" {
+		cfg.Redirects = loadRedirects(&cfg.loadErrors, "REDIRECTS", redirectsEnv)
+	}
+	if maintenanceEnv := os.Getenv("MAINTENANCE_MODE"); maintenanceEnv != "" {
+		if b, err := strconv.ParseBool(maintenanceEnv); err == nil {
+			cfg.MaintenanceMode = b
+		}
+	}
+	if retryAfterEnv := os.Getenv("MAINTENANCE_RETRY_AFTER"); retryAfterEnv != "" {
+		cfg.MaintenanceRetryAfter = loadRetryAfter(&cfg.loadErrors, "MAINTENANCE_RETRY_AFTER", retryAfterEnv, cfg.MaintenanceRetryAfter)
+	}
+	if adminTokenEnv := os.Getenv("ADMIN_TOKEN"); adminTokenEnv != "" {
+		cfg.AdminToken = adminTokenEnv
+	}
+	if adminAddrEnv := os.Getenv("ADMIN_ADDR"); adminAddrEnv != "" {
+		cfg.AdminAddr = adminAddrEnv
+	}
+
 	if !flag.Parsed() {
 		flag.Parse()
 	}
@@ -98,11 +590,15 @@ func LoadServerConfig() ServerConfig {
 	if domainFlag != nil && *domainFlag != "" {
 		cfg.Domain = *domainFlag
 	}
+	if baseURLFlag != nil && *baseURLFlag != "" {
+		cfg.BaseURL = loadBaseURL(&cfg.loadErrors, "-base-url", *baseURLFlag)
+	}
 	if staticDirFlag != nil && *staticDirFlag != "" {
 		cfg.StaticDir = *staticDirFlag
 	}
 	if cacheSizeFlag != nil && *cacheSizeFlag > 0 {
 		cfg.CacheSize = *cacheSizeFlag
+		cacheSizeSet = true
 	}
 	if rateLimitRPMFlag != nil && *rateLimitRPMFlag > 0 {
 		cfg.RateLimitRPM = *rateLimitRPMFlag
@@ -110,6 +606,582 @@ func LoadServerConfig() ServerConfig {
 	if rateLimitBurstFlag != nil && *rateLimitBurstFlag > 0 {
 		cfg.RateLimitBurst = *rateLimitBurstFlag
 	}
+	if compressionLevelSmallFlag != nil && validGzipLevel(*compressionLevelSmallFlag) {
+		cfg.CompressionLevelSmall = *compressionLevelSmallFlag
+	}
+	if compressionLevelLargeFlag != nil && validGzipLevel(*compressionLevelLargeFlag) {
+		cfg.CompressionLevelLarge = *compressionLevelLargeFlag
+	}
+	if compressionLargeThresholdFlag != nil && *compressionLargeThresholdFlag > 0 {
+		cfg.CompressionLargeThreshold = *compressionLargeThresholdFlag
+	}
+	if compressionBrotliLevelFlag != nil && validBrotliLevel(*compressionBrotliLevelFlag) {
+		cfg.CompressionBrotliLevel = *compressionBrotliLevelFlag
+	}
+	if compressionMinSizeFlag != nil && *compressionMinSizeFlag >= 0 {
+		cfg.CompressionMinSize = *compressionMinSizeFlag
+	}
+	if compressionDebugFlag != nil && *compressionDebugFlag != "" {
+		if b, err := strconv.ParseBool(*compressionDebugFlag); err == nil {
+			cfg.CompressionDebug = b
+		}
+	}
+	if compressionAdaptiveFlag != nil && *compressionAdaptiveFlag > 0 {
+		cfg.CompressionAdaptiveThreshold = *compressionAdaptiveFlag
+	}
+	if compressionSkipPathsFlag != nil && *compressionSkipPathsFlag != "" {
+		cfg.CompressionSkipPaths = loadSkipPaths(&cfg.loadErrors, "-compression-skip-paths", *compressionSkipPathsFlag, cfg.CompressionSkipPaths)
+	}
+	if compressionContentTypesFlag != nil && *compressionContentTypesFlag != "" {
+		cfg.CompressionContentTypes = loadContentTypes(&cfg.loadErrors, "-compression-content-types", *compressionContentTypesFlag, cfg.CompressionContentTypes)
+	}
+	if maxNameLengthFlag != nil && *maxNameLengthFlag > 0 {
+		cfg.MaxNameLength = *maxNameLengthFlag
+	}
+	if lowMemoryFlag != nil && *lowMemoryFlag != "" {
+		if b, err := strconv.ParseBool(*lowMemoryFlag); err == nil {
+			cfg.LowMemory = b
+		}
+	}
+	if cfg.LowMemory && !cacheSizeSet {
+		cfg.CacheSize = LowMemoryCacheSize
+	}
+	if selfTestFlag != nil && *selfTestFlag != "" {
+		if b, err := strconv.ParseBool(*selfTestFlag); err == nil {
+			cfg.SelfTest = b
+		}
+	}
+	if strictStartupFlag != nil && *strictStartupFlag != "" {
+		if b, err := strconv.ParseBool(*strictStartupFlag); err == nil {
+			cfg.StrictStartup = b
+		}
+	}
+	if maxHeaderBytesFlag != nil && *maxHeaderBytesFlag > 0 {
+		cfg.MaxHeaderBytes = *maxHeaderBytesFlag
+	}
+	if maxBodyBytesFlag != nil && *maxBodyBytesFlag != "" {
+		if n, err := strconv.ParseInt(*maxBodyBytesFlag, 10, 64); err == nil && n >= 0 {
+			cfg.MaxBodyBytes = n
+		}
+	}
+	if bodyLimitsFlag != nil && *bodyLimitsFlag != "" {
+		cfg.BodyLimits = loadBodyLimits(&cfg.loadErrors, "-body-limits", *bodyLimitsFlag)
+	}
+	if requestTimeoutFlag != nil && *requestTimeoutFlag != "" {
+		if d, err := time.ParseDuration(*requestTimeoutFlag); err == nil && d >= 0 {
+			cfg.RequestTimeout = d
+		}
+	}
+	if rasterEncodeWorkersFlag != nil && *rasterEncodeWorkersFlag > 0 {
+		cfg.RasterEncodeWorkers = *rasterEncodeWorkersFlag
+	}
+	if rasterEncodeWaitFlag != nil && *rasterEncodeWaitFlag != "" {
+		if d, err := time.ParseDuration(*rasterEncodeWaitFlag); err == nil && d > 0 {
+			cfg.RasterEncodeWait = d
+		}
+	}
+	if idempotencyTTLFlag != nil && *idempotencyTTLFlag != "" {
+		if d, err := time.ParseDuration(*idempotencyTTLFlag); err == nil && d >= 0 {
+			cfg.IdempotencyTTL = d
+		}
+	}
+	if jpegQualityFlag != nil && *jpegQualityFlag != "" {
+		cfg.JPEGQuality = loadQuality(&cfg.loadErrors, "-jpeg-quality", *jpegQualityFlag, cfg.JPEGQuality)
+	}
+	if webpQualityFlag != nil && *webpQualityFlag != "" {
+		cfg.WebPQuality = loadQuality(&cfg.loadErrors, "-webp-quality", *webpQualityFlag, cfg.WebPQuality)
+	}
+	if webpLosslessFlag != nil && *webpLosslessFlag != "" {
+		if b, err := strconv.ParseBool(*webpLosslessFlag); err == nil {
+			cfg.WebPLossless = b
+		}
+	}
+	if gammaCorrectFlag != nil && *gammaCorrectFlag != "" {
+		if b, err := strconv.ParseBool(*gammaCorrectFlag); err == nil {
+			cfg.GammaCorrect = b
+		}
+	}
+	if initialsSplitFlag != nil && *initialsSplitFlag != "" {
+		cfg.InitialsSplit = loadInitialsSplit(&cfg.loadErrors, "-initials-split", *initialsSplitFlag, cfg.InitialsSplit)
+	}
+	if qrLevelFlag != nil && *qrLevelFlag != "" {
+		cfg.QRLevel = loadQRLevel(&cfg.loadErrors, "-qr-level", *qrLevelFlag, cfg.QRLevel)
+	}
+	if pngCompressionFlag != nil && *pngCompressionFlag != "" {
+		cfg.PNGCompression = loadPNGCompression(&cfg.loadErrors, "-png-compression", *pngCompressionFlag, cfg.PNGCompression)
+	}
+	if pictureFormatsFlag != nil && *pictureFormatsFlag != "" {
+		cfg.PictureFormats = loadPictureFormats(&cfg.loadErrors, "-picture-formats", *pictureFormatsFlag, cfg.PictureFormats)
+	}
+	if enabledStylesFlag != nil && *enabledStylesFlag != "" {
+		cfg.EnabledStyles = loadStyleList(*enabledStylesFlag)
+	}
+	if labelFontFlag != nil && *labelFontFlag != "" {
+		cfg.LabelFont = loadLabelFont(&cfg.loadErrors, "-label-font", *labelFontFlag, cfg.LabelFont)
+	}
+	if routeTimeoutsFlag != nil && *routeTimeoutsFlag != "" {
+		cfg.RouteTimeouts = loadRouteTimeouts(&cfg.loadErrors, "-route-timeouts", *routeTimeoutsFlag)
+	}
+	if maxCacheKeyLengthFlag != nil && *maxCacheKeyLengthFlag > 0 {
+		cfg.MaxCacheKeyLength = *maxCacheKeyLengthFlag
+	}
+	if cacheSMaxAgeFlag != nil && *cacheSMaxAgeFlag != "" {
+		cfg.CacheSMaxAge = loadCacheDirective(&cfg.loadErrors, "-cache-s-maxage", *cacheSMaxAgeFlag, cfg.CacheSMaxAge)
+	}
+	if cacheStaleIfErrorFlag != nil && *cacheStaleIfErrorFlag != "" {
+		cfg.CacheStaleIfError = loadCacheDirective(&cfg.loadErrors, "-cache-stale-if-error", *cacheStaleIfErrorFlag, cfg.CacheStaleIfError)
+	}
+	if strictParamsFlag != nil && *strictParamsFlag != "" {
+		if b, err := strconv.ParseBool(*strictParamsFlag); err == nil {
+			cfg.StrictParams = b
+		}
+	}
+	if allowCacheBypassFlag != nil && *allowCacheBypassFlag != "" {
+		if b, err := strconv.ParseBool(*allowCacheBypassFlag); err == nil {
+			cfg.AllowCacheBypass = b
+		}
+	}
+	if cacheBypassWriteBackFlag != nil && *cacheBypassWriteBackFlag != "" {
+		if b, err := strconv.ParseBool(*cacheBypassWriteBackFlag); err == nil {
+			cfg.CacheBypassWriteBack = b
+		}
+	}
+	if palettesFlag != nil && *palettesFlag != "" {
+		cfg.Palettes = loadPalettes(&cfg.loadErrors, "-palettes", *palettesFlag)
+	}
+	if localeFlag != nil && *localeFlag != "" {
+		cfg.Locale = loadLocale(&cfg.loadErrors, "-locale", *localeFlag)
+	}
+	if colorSaltFlag != nil && *colorSaltFlag != "" {
+		cfg.ColorSalt = *colorSaltFlag
+	}
+	if altTemplateFlag != nil && *altTemplateFlag != "" {
+		cfg.AltTemplate = *altTemplateFlag
+	}
+	if colorHashFlag != nil && *colorHashFlag != "" {
+		cfg.ColorHash = loadColorHash(&cfg.loadErrors, "-color-hash", *colorHashFlag, cfg.ColorHash)
+	}
+	if defaultPaletteFlag != nil && *defaultPaletteFlag != "" {
+		cfg.DefaultPalette = *defaultPaletteFlag
+	}
+	if securityHeadersFlag != nil && validSecurityHeadersScope(*securityHeadersFlag) {
+		cfg.SecurityHeaders = *securityHeadersFlag
+	}
+	if hstsMaxAgeFlag != nil && *hstsMaxAgeFlag != "" {
+		cfg.HSTSMaxAge = loadHSTSMaxAge(&cfg.loadErrors, "-hsts-max-age", *hstsMaxAgeFlag, cfg.HSTSMaxAge)
+	}
+	if hstsIncludeSubDomainsFlag != nil && *hstsIncludeSubDomainsFlag != "" {
+		if b, err := strconv.ParseBool(*hstsIncludeSubDomainsFlag); err == nil {
+			cfg.HSTSIncludeSubDomains = b
+		}
+	}
+	if hstsPreloadFlag != nil && *hstsPreloadFlag != "" {
+		if b, err := strconv.ParseBool(*hstsPreloadFlag); err == nil {
+			cfg.HSTSPreload = b
+		}
+	}
+	if hstsTrustProxyFlag != nil && *hstsTrustProxyFlag != "" {
+		if b, err := strconv.ParseBool(*hstsTrustProxyFlag); err == nil {
+			cfg.HSTSTrustProxy = b
+		}
+	}
+	if hotlinkAllowedHostsFlag != nil && *hotlinkAllowedHostsFlag != "" {
+		cfg.HotlinkAllowedHosts = loadHostList(*hotlinkAllowedHostsFlag)
+	}
+	if hotlinkAllowEmptyRefererFlag != nil && *hotlinkAllowEmptyRefererFlag != "" {
+		if b, err := strconv.ParseBool(*hotlinkAllowEmptyRefererFlag); err == nil {
+			cfg.HotlinkAllowEmptyReferer = b
+		}
+	}
+	if redirectsFlag != nil && *redirectsFlag != "" {
+		cfg.Redirects = loadRedirects(&cfg.loadErrors, "-redirects", *redirectsFlag)
+	}
+	if maintenanceModeFlag != nil && *maintenanceModeFlag != "" {
+		if b, err := strconv.ParseBool(*maintenanceModeFlag); err == nil {
+			cfg.MaintenanceMode = b
+		}
+	}
+	if maintenanceRetryAfterFlag != nil && *maintenanceRetryAfterFlag != "" {
+		cfg.MaintenanceRetryAfter = loadRetryAfter(&cfg.loadErrors, "-maintenance-retry-after", *maintenanceRetryAfterFlag, cfg.MaintenanceRetryAfter)
+	}
+	if adminTokenFlag != nil && *adminTokenFlag != "" {
+		cfg.AdminToken = *adminTokenFlag
+	}
+	if adminAddrFlag != nil && *adminAddrFlag != "" {
+		cfg.AdminAddr = *adminAddrFlag
+	}
+	if cfg.AdminAddr != "" && cfg.AdminToken == "" {
+		log.Printf("config: admin listener %s has no endpoints without an admin token", cfg.AdminAddr)
+	}
+	if _, ok := cfg.Palettes[cfg.DefaultPalette]; cfg.DefaultPalette != "" && !ok {
+		log.Printf("config: default palette %q is not defined, using built-in colors", cfg.DefaultPalette)
+		cfg.DefaultPalette = ""
+	}
 
 	return cfg
 }
+
+// validGzipLevel reports whether n is an explicit gzip compression level
+func validGzipLevel(n int) bool {
+	return n >= 1 && n <= 9
+}
+
+// validBrotliLevel reports whether n is an explicit brotli quality
+func validBrotliLevel(n int) bool {
+	return n >= 1 && n <= 11
+}
+
+// validSecurityHeadersScope reports whether s names a security headers scope
+func validSecurityHeadersScope(s string) bool {
+	return s == "pages" || s == "all" || s == "off"
+}
+
+// loadCacheDirective parses a Cache-Control directive value in seconds. Values outside
+// 0..MaxCacheDirectiveSeconds are recorded in errs and ignored, keeping current.
+func loadCacheDirective(errs *ValidationErrors, name, raw string, current int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n < 0 || n > MaxCacheDirectiveSeconds {
+		errs.add("%s %q must be seconds between 0 and %d", name, raw, MaxCacheDirectiveSeconds)
+		return current
+	}
+	return n
+}
+
+// loadHSTSMaxAge parses the HSTS max-age in seconds, recording negative or malformed values
+// in errs and keeping current
+func loadHSTSMaxAge(errs *ValidationErrors, name, raw string, current int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n < 0 {
+		errs.add("%s %q must be seconds, 0 to disable", name, raw)
+		return current
+	}
+	return n
+}
+
+// loadRetryAfter parses a positive Retry-After in seconds, recording anything else in errs
+func loadRetryAfter(errs *ValidationErrors, name, raw string, current int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n <= 0 {
+		errs.add("%s %q must be a positive number of seconds", name, raw)
+		return current
+	}
+	return n
+}
+
+// loadHostList splits a comma-separated host list, dropping blank entries
+func loadHostList(raw string) []string {
+	var hosts []string
+	for _, host := range strings.Split(raw, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, strings.ToLower(host))
+		}
+	}
+	return hosts
+}
+
+// loadStyleList parses a comma-separated list of style names, dropping blanks and duplicates.
+// Names are not checked here, as styles can be registered after the config is loaded.
+func loadStyleList(raw string) []string {
+	var styles []string
+	for _, style := range strings.Split(raw, ",") {
+		if style = strings.ToLower(strings.TrimSpace(style)); style != "" && !slices.Contains(styles, style) {
+			styles = append(styles, style)
+		}
+	}
+	return styles
+}
+
+// loadSkipPaths parses a comma-separated list of path prefixes, dropping blanks and duplicates.
+// Lists with an entry not starting with / are recorded in errs and ignored.
+func loadSkipPaths(errs *ValidationErrors, name, raw string, current []string) []string {
+	var paths []string
+	for _, part := range strings.Split(raw, ",") {
+		path := strings.TrimSpace(part)
+		if path == "" || slices.Contains(paths, path) {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") {
+			errs.add("%s %q: %q does not start with /", name, raw, part)
+			return current
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// loadContentTypes parses a comma-separated list of media types such as application/json or
+// text/*, dropping blanks and duplicates. Lists with an entry lacking a subtype are recorded
+// in errs and ignored.
+func loadContentTypes(errs *ValidationErrors, name, raw string, current []string) []string {
+	var types []string
+	for _, part := range strings.Split(raw, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(part))
+		if mediaType == "" || slices.Contains(types, mediaType) {
+			continue
+		}
+		if major, minor, ok := strings.Cut(mediaType, "/"); !ok || major == "" || minor == "" || strings.Contains(minor, "/") {
+			errs.add("%s %q: expected type/subtype, got %q", name, raw, part)
+			return current
+		}
+		types = append(types, mediaType)
+	}
+	return types
+}
+
+// loadLabelFont parses a label font as family or family:weight, recording unknown weights in
+// errs. Families are checked when rendering, which falls back to the default family.
+func loadLabelFont(errs *ValidationErrors, name, raw, current string) string {
+	font := strings.ToLower(strings.TrimSpace(raw))
+	family, weight, _ := strings.Cut(font, ":")
+	switch weight {
+	case "", "light", "regular", "medium", "bold":
+	default:
+		errs.add("%s %q must use a weight of light, regular, medium or bold", name, raw)
+		return current
+	}
+	if family == "" {
+		errs.add("%s %q must name a font family", name, raw)
+		return current
+	}
+	return font
+}
+
+// loadQuality parses an encoder quality, recording values outside 1..100 in errs
+func loadQuality(errs *ValidationErrors, name, raw string, current int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n < 1 || n > 100 {
+		errs.add("%s %q must be between 1 and 100", name, raw)
+		return current
+	}
+	return n
+}
+
+// loadInitialsSplit validates an initials split mode, recording unknown ones in errs
+func loadInitialsSplit(errs *ValidationErrors, name, raw, current string) string {
+	switch mode := strings.ToLower(strings.TrimSpace(raw)); mode {
+	case "words", "camel-hump":
+		return mode
+	default:
+		errs.add("%s %q must be one of words, camel-hump", name, raw)
+		return current
+	}
+}
+
+// loadQRLevel validates a QR error correction level, recording unknown ones in errs
+func loadQRLevel(errs *ValidationErrors, name, raw, current string) string {
+	switch level := strings.ToUpper(strings.TrimSpace(raw)); level {
+	case "L", "M", "Q", "H":
+		return level
+	default:
+		errs.add("%s %q must be one of L, M, Q, H", name, raw)
+		return current
+	}
+}
+
+// loadPNGCompression validates a PNG compression name, recording unknown ones in errs
+func loadPNGCompression(errs *ValidationErrors, name, raw, current string) string {
+	switch compression := strings.ToLower(strings.TrimSpace(raw)); compression {
+	case "default", "none", "fast", "best":
+		return compression
+	default:
+		errs.add("%s %q must be one of default, none, fast, best", name, raw)
+		return current
+	}
+}
+
+// loadPictureFormats parses a comma-separated list of picture formats, dropping duplicates.
+// Lists naming an unknown format are recorded in errs and ignored.
+func loadPictureFormats(errs *ValidationErrors, name, raw string, current []string) []string {
+	var formats []string
+	for _, part := range strings.Split(raw, ",") {
+		format := strings.ToLower(strings.TrimSpace(part))
+		if !IsPictureFormat(format) {
+			errs.add("%s %q: %q must be one of svg, png, jpg, gif, webp", name, raw, part)
+			return current
+		}
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// IsPictureFormat reports whether format can be offered in a format=picture snippet
+func IsPictureFormat(format string) bool {
+	switch format {
+	case "svg", "png", "jpg", "gif", "webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// loadColorHash validates a color hash algorithm name, recording unknown ones in errs
+func loadColorHash(errs *ValidationErrors, name, raw, current string) string {
+	switch hash := strings.ToLower(strings.TrimSpace(raw)); hash {
+	case "md5", "fnv32", "fnv64", "sha256", "crc32":
+		return hash
+	default:
+		errs.add("%s %q must be one of md5, fnv32, fnv64, sha256, crc32", name, raw)
+		return current
+	}
+}
+
+// loadLocale validates a BCP 47 language tag, recording it in errs and dropping it when malformed
+func loadLocale(errs *ValidationErrors, name, raw string) string {
+	tag, err := language.Parse(raw)
+	if err != nil {
+		errs.add("%s %q is not a BCP 47 tag", name, raw)
+		return ""
+	}
+	return tag.String()
+}
+
+// loadBaseURL validates a base URL, recording it in errs and dropping it when it is not an
+// absolute http(s) URL. A trailing slash is removed so paths can be appended directly.
+func loadBaseURL(errs *ValidationErrors, name, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		errs.add("%s %q must be http(s)://host[/path]", name, raw)
+		return ""
+	}
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// loadPalettes parses a palette spec, recording it in errs and dropping it when invalid.
+func loadPalettes(errs *ValidationErrors, name, spec string) map[string][]string {
+	palettes, err := ParsePalettes(spec)
+	if err != nil {
+		errs.add("%s: %v", name, err)
+		return nil
+	}
+	return palettes
+}
+
+// ParsePalettes parses "name=hex,hex;name=hex,..." into named palettes.
+// Every color must be a 3 or 6 digit hex value (a leading '#' is allowed).
+func ParsePalettes(spec string) (map[string][]string, error) {
+	palettes := make(map[string][]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("palette %q: expected name=hex,hex", entry)
+		}
+		var colors []string
+		for _, c := range strings.Split(list, ",") {
+			c = strings.TrimPrefix(strings.TrimSpace(c), "#")
+			if !IsHexColor(c) {
+				return nil, fmt.Errorf("palette %q: invalid color %q", name, c)
+			}
+			colors = append(colors, strings.ToLower(c))
+		}
+		palettes[name] = colors
+	}
+	return palettes, nil
+}
+
+// loadRedirects parses a redirect spec, recording it in errs and dropping it when invalid.
+func loadRedirects(errs *ValidationErrors, name, spec string) []RedirectRule {
+	redirects, err := ParseRedirects(spec)
+	if err != nil {
+		errs.add("%s: %v", name, err)
+		return nil
+	}
+	return redirects
+}
+
+// loadBodyLimits parses per-route body limits, recording them in errs and dropping them when invalid.
+func loadBodyLimits(errs *ValidationErrors, name, spec string) map[string]int64 {
+	limits, err := ParseBodyLimits(spec)
+	if err != nil {
+		errs.add("%s: %v", name, err)
+		return nil
+	}
+	return limits
+}
+
+// ParseBodyLimits parses "/prefix=bytes;..." into body limits keyed by path prefix.
+func ParseBodyLimits(spec string) (map[string]int64, error) {
+	limits := make(map[string]int64)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, raw, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if !ok || !strings.HasPrefix(prefix, "/") || err != nil || n < 0 {
+			return nil, fmt.Errorf("body limit %q: expected /prefix=bytes", entry)
+		}
+		limits[prefix] = n
+	}
+	return limits, nil
+}
+
+// ParseRedirects parses "/old/{param}=/new/{param};..." into redirect rules, keeping their order.
+// Only the first '=' separates a rule, so targets may carry a query string.
+func ParseRedirects(spec string) ([]RedirectRule, error) {
+	var redirects []RedirectRule
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(entry, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || !strings.HasPrefix(from, "/") || to == "" {
+			return nil, fmt.Errorf("redirect %q: expected /old/path=/new/path", entry)
+		}
+		redirects = append(redirects, RedirectRule{From: from, To: to})
+	}
+	return redirects, nil
+}
+
+// IsHexColor reports whether s is a 3 or 6 digit hex color without a leading '#'.
+func IsHexColor(s string) bool {
+	if len(s) != 3 && len(s) != 6 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadRouteTimeouts parses per-route timeouts, recording them in errs and dropping them when invalid.
+func loadRouteTimeouts(errs *ValidationErrors, name, spec string) map[string]time.Duration {
+	timeouts, err := ParseRouteTimeouts(spec)
+	if err != nil {
+		errs.add("%s: %v", name, err)
+		return nil
+	}
+	return timeouts
+}
+
+// ParseRouteTimeouts parses "/prefix=duration;..." into timeouts keyed by path prefix.
+// Durations use time.ParseDuration syntax such as "90s"; 0 disables the timeout for the route.
+func ParseRouteTimeouts(spec string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, raw, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || !strings.HasPrefix(prefix, "/") || err != nil || d < 0 {
+			return nil, fmt.Errorf("route timeout %q: expected /prefix=duration", entry)
+		}
+		timeouts[prefix] = d
+	}
+	return timeouts, nil
+}
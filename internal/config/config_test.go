@@ -0,0 +1,485 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePalettes(t *testing.T) {
+	got, err := ParsePalettes("brand=FF0000,#00ff00, 00f ; mono=000000,ffffff")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"brand": {"ff0000", "00ff00", "00f"},
+		"mono":  {"000000", "ffffff"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v got %v", expected, got)
+	}
+}
+
+func TestParsePalettesInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"missing name", "=ff0000"},
+		{"missing separator", "brand"},
+		{"invalid color", "brand=ff0000,zzzzzz"},
+		{"wrong length", "brand=ff00"},
+		{"empty color", "brand=ff0000,"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePalettes(tt.spec); err == nil {
+				t.Fatalf("expected error for %q", tt.spec)
+			}
+		})
+	}
+}
+
+func TestParseRedirects(t *testing.T) {
+	got, err := ParseRedirects("/u/{name}/{size}=/avatar/{name}?size={size} ; /legacy/{name}=/avatar/{name}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []RedirectRule{
+		{From: "/u/{name}/{size}", To: "/avatar/{name}?size={size}"},
+		{From: "/legacy/{name}", To: "/avatar/{name}"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v got %v", expected, got)
+	}
+
+	for _, spec := range []string{"/u/{name}", "u/{name}=/avatar/{name}", "/u/{name}="} {
+		if _, err := ParseRedirects(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestLowMemoryDefaults(t *testing.T) {
+	t.Setenv("LOW_MEMORY", "true")
+	cfg := LoadServerConfig()
+	if !cfg.LowMemory {
+		t.Fatal("expected low-memory mode")
+	}
+	if cfg.CacheSize != LowMemoryCacheSize {
+		t.Fatalf("expected cache size %d got %d", LowMemoryCacheSize, cfg.CacheSize)
+	}
+
+	t.Setenv("CACHE_SIZE", "500")
+	if cfg := LoadServerConfig(); cfg.CacheSize != 500 {
+		t.Fatalf("expected an explicit cache size to be kept, got %d", cfg.CacheSize)
+	}
+}
+
+func TestCacheDirectives(t *testing.T) {
+	t.Setenv("CACHE_S_MAXAGE", "86400")
+	t.Setenv("CACHE_STALE_IF_ERROR", "604800")
+	cfg := LoadServerConfig()
+	if cfg.CacheSMaxAge != 86400 || cfg.CacheStaleIfError != 604800 {
+		t.Fatalf("expected s-maxage 86400 and stale-if-error 604800 got %d and %d", cfg.CacheSMaxAge, cfg.CacheStaleIfError)
+	}
+
+	for _, invalid := range []string{"-1", "abc", "1.5", "31536001"} {
+		t.Setenv("CACHE_S_MAXAGE", invalid)
+		if cfg := LoadServerConfig(); cfg.CacheSMaxAge != 0 {
+			t.Fatalf("expected invalid s-maxage %q to be ignored, got %d", invalid, cfg.CacheSMaxAge)
+		}
+	}
+}
+
+func TestHSTSSettings(t *testing.T) {
+	cfg := LoadServerConfig()
+	if cfg.HSTSMaxAge != DefaultHSTSMaxAge || cfg.HSTSIncludeSubDomains || cfg.HSTSPreload || cfg.HSTSTrustProxy {
+		t.Fatalf("expected max-age %d without directives or proxy trust, got %+v", DefaultHSTSMaxAge, cfg)
+	}
+
+	t.Setenv("HSTS_MAX_AGE", "63072000")
+	t.Setenv("HSTS_INCLUDE_SUBDOMAINS", "true")
+	t.Setenv("HSTS_PRELOAD", "true")
+	t.Setenv("HSTS_TRUST_PROXY", "true")
+	cfg = LoadServerConfig()
+	if cfg.HSTSMaxAge != 63072000 || !cfg.HSTSIncludeSubDomains || !cfg.HSTSPreload || !cfg.HSTSTrustProxy {
+		t.Fatalf("expected HSTS settings from env, got %+v", cfg)
+	}
+
+	t.Setenv("HSTS_MAX_AGE", "0")
+	if cfg := LoadServerConfig(); cfg.HSTSMaxAge != 0 {
+		t.Fatalf("expected max-age 0 to disable HSTS, got %d", cfg.HSTSMaxAge)
+	}
+	t.Setenv("HSTS_MAX_AGE", "-1")
+	if cfg := LoadServerConfig(); cfg.HSTSMaxAge != DefaultHSTSMaxAge {
+		t.Fatalf("expected a negative max-age to be ignored, got %d", cfg.HSTSMaxAge)
+	}
+}
+
+func TestHotlinkSettings(t *testing.T) {
+	cfg := LoadServerConfig()
+	if len(cfg.HotlinkAllowedHosts) != 0 || !cfg.HotlinkAllowEmptyReferer {
+		t.Fatalf("expected hotlink protection off and empty referers allowed, got %v %t", cfg.HotlinkAllowedHosts, cfg.HotlinkAllowEmptyReferer)
+	}
+
+	t.Setenv("HOTLINK_ALLOWED_HOSTS", " Example.com, ,*.partner.org ")
+	t.Setenv("HOTLINK_ALLOW_EMPTY_REFERER", "false")
+	cfg = LoadServerConfig()
+	if !reflect.DeepEqual(cfg.HotlinkAllowedHosts, []string{"example.com", "*.partner.org"}) || cfg.HotlinkAllowEmptyReferer {
+		t.Fatalf("expected hotlink settings from env, got %v %t", cfg.HotlinkAllowedHosts, cfg.HotlinkAllowEmptyReferer)
+	}
+}
+
+func TestMaintenanceSettings(t *testing.T) {
+	cfg := LoadServerConfig()
+	if cfg.MaintenanceMode || cfg.MaintenanceRetryAfter != DefaultMaintenanceRetry || cfg.AdminToken != "" {
+		t.Fatalf("expected maintenance off with defaults, got %t %d %q", cfg.MaintenanceMode, cfg.MaintenanceRetryAfter, cfg.AdminToken)
+	}
+
+	t.Setenv("MAINTENANCE_MODE", "true")
+	t.Setenv("MAINTENANCE_RETRY_AFTER", "600")
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	cfg = LoadServerConfig()
+	if !cfg.MaintenanceMode || cfg.MaintenanceRetryAfter != 600 || cfg.AdminToken != "s3cret" {
+		t.Fatalf("expected maintenance settings from env, got %t %d %q", cfg.MaintenanceMode, cfg.MaintenanceRetryAfter, cfg.AdminToken)
+	}
+
+	t.Setenv("MAINTENANCE_RETRY_AFTER", "0")
+	if cfg = LoadServerConfig(); cfg.MaintenanceRetryAfter != DefaultMaintenanceRetry {
+		t.Fatalf("expected a zero Retry-After to be ignored, got %d", cfg.MaintenanceRetryAfter)
+	}
+
+	if cfg.AdminAddr != "" {
+		t.Fatalf("expected admin endpoints on the public listener by default, got %q", cfg.AdminAddr)
+	}
+	t.Setenv("ADMIN_ADDR", "127.0.0.1:9090")
+	if cfg = LoadServerConfig(); cfg.AdminAddr != "127.0.0.1:9090" {
+		t.Fatalf("expected the admin address from env, got %q", cfg.AdminAddr)
+	}
+}
+
+func TestColorHashSetting(t *testing.T) {
+	t.Setenv("COLOR_HASH", "FNV64")
+	if cfg := LoadServerConfig(); cfg.ColorHash != "fnv64" {
+		t.Fatalf("expected fnv64 got %q", cfg.ColorHash)
+	}
+	t.Setenv("COLOR_HASH", "sha1")
+	if cfg := LoadServerConfig(); cfg.ColorHash != "" {
+		t.Fatalf("expected unknown hash to be ignored, got %q", cfg.ColorHash)
+	}
+}
+
+func TestAltTemplateSetting(t *testing.T) {
+	t.Setenv("ALT_TEMPLATE", "Avatar for {name}")
+	if cfg := LoadServerConfig(); cfg.AltTemplate != "Avatar for {name}" {
+		t.Fatalf("expected template from env got %q", cfg.AltTemplate)
+	}
+}
+
+func TestPictureFormatsSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); strings.Join(cfg.PictureFormats, ",") != DefaultPictureFormats {
+		t.Fatalf("expected default picture formats got %v", cfg.PictureFormats)
+	}
+	t.Setenv("PICTURE_FORMATS", " SVG, webp,png,webp")
+	if cfg := LoadServerConfig(); strings.Join(cfg.PictureFormats, ",") != "svg,webp,png" {
+		t.Fatalf("expected picture formats from env without duplicates got %v", cfg.PictureFormats)
+	}
+	t.Setenv("PICTURE_FORMATS", "avif,png")
+	if cfg := LoadServerConfig(); strings.Join(cfg.PictureFormats, ",") != DefaultPictureFormats {
+		t.Fatalf("expected a list with an unknown format to be ignored got %v", cfg.PictureFormats)
+	}
+}
+
+func TestGammaCorrectSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); !cfg.GammaCorrect {
+		t.Fatal("expected gamma-correct blending by default")
+	}
+	t.Setenv("GAMMA_CORRECT", "false")
+	if cfg := LoadServerConfig(); cfg.GammaCorrect {
+		t.Fatal("expected GAMMA_CORRECT=false to turn it off")
+	}
+	t.Setenv("GAMMA_CORRECT", "sometimes")
+	if cfg := LoadServerConfig(); !cfg.GammaCorrect {
+		t.Fatal("expected an invalid value to be ignored")
+	}
+}
+
+func TestCompressionSizeSettings(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.CompressionMinSize != DefaultCompressionMinSize || cfg.CompressionBrotliLevel != 0 {
+		t.Fatalf("expected default compression settings got %d %d", cfg.CompressionMinSize, cfg.CompressionBrotliLevel)
+	}
+	t.Setenv("COMPRESSION_MIN_SIZE", "0")
+	t.Setenv("COMPRESSION_BROTLI_LEVEL", "11")
+	if cfg := LoadServerConfig(); cfg.CompressionMinSize != 0 || cfg.CompressionBrotliLevel != 11 {
+		t.Fatalf("expected compression settings from env got %d %d", cfg.CompressionMinSize, cfg.CompressionBrotliLevel)
+	}
+	t.Setenv("COMPRESSION_MIN_SIZE", "-1")
+	t.Setenv("COMPRESSION_BROTLI_LEVEL", "12")
+	if cfg := LoadServerConfig(); cfg.CompressionMinSize != DefaultCompressionMinSize || cfg.CompressionBrotliLevel != 0 {
+		t.Fatalf("expected invalid values to be ignored got %d %d", cfg.CompressionMinSize, cfg.CompressionBrotliLevel)
+	}
+}
+
+func TestCompressionRouteSettings(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.CompressionSkipPaths != nil || cfg.CompressionContentTypes != nil {
+		t.Fatalf("expected no compression route settings by default got %v %v", cfg.CompressionSkipPaths, cfg.CompressionContentTypes)
+	}
+	t.Setenv("COMPRESSION_SKIP_PATHS", " /healthz, ,/metrics,/healthz")
+	t.Setenv("COMPRESSION_CONTENT_TYPES", "Text/*, application/octet-stream")
+	cfg := LoadServerConfig()
+	if !reflect.DeepEqual(cfg.CompressionSkipPaths, []string{"/healthz", "/metrics"}) {
+		t.Fatalf("expected skip paths from env got %v", cfg.CompressionSkipPaths)
+	}
+	if !reflect.DeepEqual(cfg.CompressionContentTypes, []string{"text/*", "application/octet-stream"}) {
+		t.Fatalf("expected content types from env got %v", cfg.CompressionContentTypes)
+	}
+	t.Setenv("COMPRESSION_SKIP_PATHS", "/healthz,metrics")
+	t.Setenv("COMPRESSION_CONTENT_TYPES", "application/json,octet-stream")
+	if cfg := LoadServerConfig(); cfg.CompressionSkipPaths != nil || cfg.CompressionContentTypes != nil {
+		t.Fatalf("expected invalid lists to be ignored got %v %v", cfg.CompressionSkipPaths, cfg.CompressionContentTypes)
+	}
+}
+
+func TestAllowCacheBypassSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.AllowCacheBypass {
+		t.Fatal("expected cache bypass to be disabled by default")
+	}
+	t.Setenv("ALLOW_CACHE_BYPASS", "true")
+	if cfg := LoadServerConfig(); !cfg.AllowCacheBypass {
+		t.Fatal("expected cache bypass to be enabled from env")
+	}
+	if cfg := LoadServerConfig(); !cfg.CacheBypassWriteBack {
+		t.Fatal("expected bypasses to write back by default")
+	}
+	t.Setenv("CACHE_BYPASS_WRITE_BACK", "false")
+	if cfg := LoadServerConfig(); cfg.CacheBypassWriteBack {
+		t.Fatal("expected write-back to be disabled from env")
+	}
+}
+
+func TestSelfTestSettings(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.SelfTest || cfg.StrictStartup {
+		t.Fatal("expected no startup self-test by default")
+	}
+	t.Setenv("SELF_TEST", "true")
+	t.Setenv("STRICT_STARTUP", "1")
+	if cfg := LoadServerConfig(); !cfg.SelfTest || !cfg.StrictStartup {
+		t.Fatalf("expected self-test settings from env got %t %t", cfg.SelfTest, cfg.StrictStartup)
+	}
+}
+
+func TestLabelFontSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.LabelFont != "" {
+		t.Fatalf("expected the default family by default got %q", cfg.LabelFont)
+	}
+	t.Setenv("LABEL_FONT", " Go-Mono:Regular ")
+	if cfg := LoadServerConfig(); cfg.LabelFont != "go-mono:regular" {
+		t.Fatalf("expected label font from env got %q", cfg.LabelFont)
+	}
+	for _, invalid := range []string{"go-mono:heavy", ":bold"} {
+		t.Setenv("LABEL_FONT", invalid)
+		if cfg := LoadServerConfig(); cfg.LabelFont != "" {
+			t.Fatalf("expected %q to be ignored got %q", invalid, cfg.LabelFont)
+		}
+	}
+}
+
+func TestInitialsSplitSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.InitialsSplit != DefaultInitialsSplit {
+		t.Fatalf("expected %q by default got %q", DefaultInitialsSplit, cfg.InitialsSplit)
+	}
+	t.Setenv("INITIALS_SPLIT", " Camel-Hump ")
+	if cfg := LoadServerConfig(); cfg.InitialsSplit != "camel-hump" {
+		t.Fatalf("expected camel-hump from env got %q", cfg.InitialsSplit)
+	}
+	t.Setenv("INITIALS_SPLIT", "snake")
+	if cfg := LoadServerConfig(); cfg.InitialsSplit != DefaultInitialsSplit {
+		t.Fatalf("expected unknown split to be ignored got %q", cfg.InitialsSplit)
+	}
+}
+
+func TestQRLevelSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.QRLevel != DefaultQRLevel {
+		t.Fatalf("expected %q by default got %q", DefaultQRLevel, cfg.QRLevel)
+	}
+	t.Setenv("QR_LEVEL", " h ")
+	if cfg := LoadServerConfig(); cfg.QRLevel != "H" {
+		t.Fatalf("expected H from env got %q", cfg.QRLevel)
+	}
+	t.Setenv("QR_LEVEL", "X")
+	if cfg := LoadServerConfig(); cfg.QRLevel != DefaultQRLevel {
+		t.Fatalf("expected unknown level to be ignored got %q", cfg.QRLevel)
+	}
+}
+
+func TestEnabledStylesSetting(t *testing.T) {
+	if cfg := LoadServerConfig(); cfg.EnabledStyles != nil {
+		t.Fatalf("expected every style enabled by default got %v", cfg.EnabledStyles)
+	}
+	t.Setenv("ENABLED_STYLES", " Tiles, ,wordmark,tiles")
+	if cfg := LoadServerConfig(); strings.Join(cfg.EnabledStyles, ",") != "tiles,wordmark" {
+		t.Fatalf("expected styles from env without blanks or duplicates got %v", cfg.EnabledStyles)
+	}
+}
+
+func TestEncodingSettings(t *testing.T) {
+	cfg := LoadServerConfig()
+	if cfg.JPEGQuality != DefaultJPEGQuality || cfg.WebPQuality != DefaultWebPQuality || cfg.WebPLossless || cfg.PNGCompression != DefaultPNGCompression {
+		t.Fatalf("expected default encoder settings, got %d %d %t %q", cfg.JPEGQuality, cfg.WebPQuality, cfg.WebPLossless, cfg.PNGCompression)
+	}
+
+	t.Setenv("JPEG_QUALITY", "75")
+	t.Setenv("WEBP_QUALITY", "60")
+	t.Setenv("WEBP_LOSSLESS", "true")
+	t.Setenv("PNG_COMPRESSION", "Best")
+	cfg = LoadServerConfig()
+	if cfg.JPEGQuality != 75 || cfg.WebPQuality != 60 || !cfg.WebPLossless || cfg.PNGCompression != "best" {
+		t.Fatalf("expected encoder settings from env, got %d %d %t %q", cfg.JPEGQuality, cfg.WebPQuality, cfg.WebPLossless, cfg.PNGCompression)
+	}
+
+	for _, invalid := range []string{"0", "101", "-5", "high"} {
+		t.Setenv("JPEG_QUALITY", invalid)
+		t.Setenv("WEBP_QUALITY", invalid)
+		cfg := LoadServerConfig()
+		if cfg.JPEGQuality != DefaultJPEGQuality || cfg.WebPQuality != DefaultWebPQuality {
+			t.Fatalf("expected invalid quality %q to be ignored, got %d %d", invalid, cfg.JPEGQuality, cfg.WebPQuality)
+		}
+		for _, name := range []string{"JPEG_QUALITY", "WEBP_QUALITY"} {
+			if want := fmt.Sprintf("%s %q must be between 1 and 100", name, invalid); !strings.Contains(fmt.Sprint(cfg.Validate()), want) {
+				t.Fatalf("expected validation to report %s, got %v", want, cfg.Validate())
+			}
+		}
+	}
+	t.Setenv("PNG_COMPRESSION", "9")
+	cfg = LoadServerConfig()
+	if cfg.PNGCompression != DefaultPNGCompression {
+		t.Fatalf("expected unknown PNG compression to be ignored, got %q", cfg.PNGCompression)
+	}
+	if want := `PNG_COMPRESSION "9" must be one of default, none, fast, best`; !strings.Contains(fmt.Sprint(cfg.Validate()), want) {
+		t.Fatalf("expected validation to report %s, got %v", want, cfg.Validate())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.StaticDir = t.TempDir()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the default config to be valid, got %v", err)
+	}
+
+	cfg.CacheSize = 0
+	cfg.StaticDir = filepath.Join(cfg.StaticDir, "missing")
+	cfg.Palettes = map[string][]string{"brand": {"ff0000", "nothex"}}
+	cfg.DefaultPalette = "ocean"
+	cfg.CompressionLevelLarge = 12
+	cfg.JPEGQuality = 0
+	cfg.AdminAddr = cfg.Addr
+	err := cfg.Validate()
+
+	var problems ValidationErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected ValidationErrors got %v", err)
+	}
+	want := ValidationErrors{
+		fmt.Sprintf("STATIC_DIR %q does not exist", cfg.StaticDir),
+		"CACHE_SIZE must be positive, got 0",
+		`PALETTES palette "brand" has invalid color "nothex"`,
+		`DEFAULT_PALETTE "ocean" is not defined in PALETTES`,
+		"COMPRESSION_LEVEL_LARGE must be between 1 and 9, got 12",
+		"JPEG_QUALITY must be between 1 and 100, got 0",
+		`ADMIN_ADDR ":8080" must differ from ADDR`,
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Fatalf("expected every problem reported together\nwant %q\ngot  %q", want, problems)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "invalid config: 7 problems\n  - STATIC_DIR") {
+		t.Fatalf("expected a readable list got %q", msg)
+	}
+}
+
+func TestValidateReportsLoadErrors(t *testing.T) {
+	t.Setenv("STATIC_DIR", t.TempDir())
+	t.Setenv("QR_LEVEL", "X")
+	t.Setenv("ROUTE_TIMEOUTS", "/batch=soon")
+	t.Setenv("CACHE_S_MAXAGE", "-1")
+	t.Setenv("PALETTES", "brand=nothex")
+	t.Setenv("LOCALE", "!!")
+	err := LoadServerConfig().Validate()
+
+	var problems ValidationErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected ValidationErrors got %v", err)
+	}
+	want := ValidationErrors{
+		`QR_LEVEL "X" must be one of L, M, Q, H`,
+		`ROUTE_TIMEOUTS: route timeout "/batch=soon": expected /prefix=duration`,
+		`CACHE_S_MAXAGE "-1" must be seconds between 0 and 31536000`,
+		`PALETTES: palette "brand": invalid color "nothex"`,
+		`LOCALE "!!" is not a BCP 47 tag`,
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Fatalf("expected the rejected env values reported\nwant %q\ngot  %q", want, problems)
+	}
+}
+
+func TestParseBodyLimits(t *testing.T) {
+	got, err := ParseBodyLimits(" /batch=262144 ; /fonts=10485760;")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]int64{"/batch": 262144, "/fonts": 10485760}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	for _, spec := range []string{"batch=10", "/batch", "/batch=-1", "/batch=lots"} {
+		if _, err := ParseBodyLimits(spec); err == nil {
+			t.Fatalf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	got, err := ParseRouteTimeouts(" /batch=90s ; /placeholder=0;")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]time.Duration{"/batch": 90 * time.Second, "/placeholder": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v got %v", want, got)
+	}
+
+	for _, spec := range []string{"batch=10s", "/batch", "/batch=-1s", "/batch=10"} {
+		if _, err := ParseRouteTimeouts(spec); err == nil {
+			t.Fatalf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestTimeoutSettings(t *testing.T) {
+	cfg := LoadServerConfig()
+	if cfg.RequestTimeout != DefaultRequestTimeout || cfg.RouteTimeouts != nil {
+		t.Fatalf("expected default timeouts, got %v %v", cfg.RequestTimeout, cfg.RouteTimeouts)
+	}
+
+	t.Setenv("REQUEST_TIMEOUT", "5s")
+	t.Setenv("ROUTE_TIMEOUTS", "/batch=1m")
+	cfg = LoadServerConfig()
+	if cfg.RequestTimeout != 5*time.Second || cfg.RouteTimeouts["/batch"] != time.Minute {
+		t.Fatalf("expected timeouts from env, got %v %v", cfg.RequestTimeout, cfg.RouteTimeouts)
+	}
+
+	if cfg.IdempotencyTTL != DefaultIdempotencyTTL {
+		t.Fatalf("expected default idempotency TTL got %v", cfg.IdempotencyTTL)
+	}
+	t.Setenv("IDEMPOTENCY_TTL", "0")
+	if cfg = LoadServerConfig(); cfg.IdempotencyTTL != 0 {
+		t.Fatalf("expected IDEMPOTENCY_TTL=0 to disable replays got %v", cfg.IdempotencyTTL)
+	}
+}
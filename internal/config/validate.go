@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+// ValidationErrors lists every problem Validate found, so a deployment can fix them in one go
+type ValidationErrors []string
+
+func (e *ValidationErrors) add(format string, args ...any) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func (e ValidationErrors) Error() string {
+	var b strings.Builder
+	if len(e) == 1 {
+		b.WriteString("invalid config: 1 problem")
+	} else {
+		fmt.Fprintf(&b, "invalid config: %d problems", len(e))
+	}
+	for _, problem := range e {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// Validate checks every setting and returns all problems together as ValidationErrors, or
+// nil when the config can be served. Env and flag values LoadServerConfig could not parse
+// come first; they were not applied, so the checks after them see the value kept instead.
+func (c ServerConfig) Validate() error {
+	errs := slices.Clone(c.loadErrors)
+
+	if c.Addr == "" {
+		errs.add("ADDR must not be empty")
+	}
+	if c.Domain == "" && c.BaseURL == "" {
+		errs.add("DOMAIN or BASE_URL must be set for example URLs")
+	}
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add("BASE_URL %q must be an absolute http(s) URL", c.BaseURL)
+		}
+	}
+	if info, err := os.Stat(c.StaticDir); err != nil {
+		errs.add("STATIC_DIR %q does not exist", c.StaticDir)
+	} else if !info.IsDir() {
+		errs.add("STATIC_DIR %q is not a directory", c.StaticDir)
+	}
+
+	if c.CacheSize <= 0 {
+		errs.add("CACHE_SIZE must be positive, got %d", c.CacheSize)
+	}
+	if c.MaxCacheKeyLength <= 0 {
+		errs.add("MAX_CACHE_KEY_LENGTH must be positive, got %d", c.MaxCacheKeyLength)
+	}
+	if c.RateLimitRPM <= 0 {
+		errs.add("RATE_LIMIT_RPM must be positive, got %d", c.RateLimitRPM)
+	}
+	if c.RateLimitBurst <= 0 {
+		errs.add("RATE_LIMIT_BURST must be positive, got %d", c.RateLimitBurst)
+	}
+	if c.MaxNameLength <= 0 {
+		errs.add("MAX_NAME_LENGTH must be positive, got %d", c.MaxNameLength)
+	}
+	if c.MaxHeaderBytes <= 0 {
+		errs.add("MAX_HEADER_BYTES must be positive, got %d", c.MaxHeaderBytes)
+	}
+
+	c.validatePalettes(&errs)
+	if c.Locale != "" {
+		if _, err := language.Parse(c.Locale); err != nil {
+			errs.add("LOCALE %q is not a BCP 47 tag", c.Locale)
+		}
+	}
+	if utf8.RuneCountInString(c.ColorSalt) > MaxSaltLength {
+		errs.add("COLOR_SALT must not exceed %d characters", MaxSaltLength)
+	}
+	if utf8.RuneCountInString(c.AltTemplate) > MaxAltLength {
+		errs.add("ALT_TEMPLATE must not exceed %d characters", MaxAltLength)
+	}
+	switch c.ColorHash {
+	case "", "md5", "fnv32", "fnv64", "sha256", "crc32":
+	default:
+		errs.add("COLOR_HASH %q must be one of md5, fnv32, fnv64, sha256, crc32", c.ColorHash)
+	}
+
+	if !validGzipLevel(c.CompressionLevelSmall) {
+		errs.add("COMPRESSION_LEVEL_SMALL must be between 1 and 9, got %d", c.CompressionLevelSmall)
+	}
+	if !validGzipLevel(c.CompressionLevelLarge) {
+		errs.add("COMPRESSION_LEVEL_LARGE must be between 1 and 9, got %d", c.CompressionLevelLarge)
+	}
+	if c.CompressionLargeThreshold <= 0 {
+		errs.add("COMPRESSION_LARGE_THRESHOLD must be positive, got %d", c.CompressionLargeThreshold)
+	}
+	if c.CompressionBrotliLevel != 0 && !validBrotliLevel(c.CompressionBrotliLevel) {
+		errs.add("COMPRESSION_BROTLI_LEVEL must be between 1 and 11, got %d", c.CompressionBrotliLevel)
+	}
+	if c.CompressionMinSize < 0 {
+		errs.add("COMPRESSION_MIN_SIZE must not be negative, got %d", c.CompressionMinSize)
+	}
+	if c.CompressionAdaptiveThreshold < 0 {
+		errs.add("COMPRESSION_ADAPTIVE_THRESHOLD must not be negative, got %d", c.CompressionAdaptiveThreshold)
+	}
+
+	if c.MaxBodyBytes < 0 {
+		errs.add("MAX_BODY_BYTES must not be negative, got %d", c.MaxBodyBytes)
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(c.BodyLimits)) {
+		if limit := c.BodyLimits[prefix]; !strings.HasPrefix(prefix, "/") || limit < 0 {
+			errs.add("BODY_LIMITS entry %s=%d needs a /prefix and a non-negative limit", prefix, limit)
+		}
+	}
+	if c.RequestTimeout < 0 {
+		errs.add("REQUEST_TIMEOUT must not be negative, got %s", c.RequestTimeout)
+	}
+	for _, prefix := range slices.Sorted(maps.Keys(c.RouteTimeouts)) {
+		if timeout := c.RouteTimeouts[prefix]; !strings.HasPrefix(prefix, "/") || timeout < 0 {
+			errs.add("ROUTE_TIMEOUTS entry %s=%s needs a /prefix and a non-negative timeout", prefix, timeout)
+		}
+	}
+	if c.RasterEncodeWorkers < 0 {
+		errs.add("RASTER_ENCODE_WORKERS must not be negative, got %d", c.RasterEncodeWorkers)
+	}
+	if c.RasterEncodeWorkers > 0 && c.RasterEncodeWait <= 0 {
+		errs.add("RASTER_ENCODE_WAIT must be positive when RASTER_ENCODE_WORKERS is set, got %s", c.RasterEncodeWait)
+	}
+	if c.IdempotencyTTL < 0 {
+		errs.add("IDEMPOTENCY_TTL must not be negative, got %s", c.IdempotencyTTL)
+	}
+
+	if c.JPEGQuality < 1 || c.JPEGQuality > 100 {
+		errs.add("JPEG_QUALITY must be between 1 and 100, got %d", c.JPEGQuality)
+	}
+	if c.WebPQuality < 1 || c.WebPQuality > 100 {
+		errs.add("WEBP_QUALITY must be between 1 and 100, got %d", c.WebPQuality)
+	}
+	switch c.InitialsSplit {
+	case "words", "camel-hump":
+	default:
+		errs.add("INITIALS_SPLIT %q must be one of words, camel-hump", c.InitialsSplit)
+	}
+	switch c.QRLevel {
+	case "L", "M", "Q", "H":
+	default:
+		errs.add("QR_LEVEL %q must be one of L, M, Q, H", c.QRLevel)
+	}
+	switch c.PNGCompression {
+	case "default", "none", "fast", "best":
+	default:
+		errs.add("PNG_COMPRESSION %q must be one of default, none, fast, best", c.PNGCompression)
+	}
+	if len(c.PictureFormats) == 0 {
+		errs.add("PICTURE_FORMATS must name at least one format")
+	}
+	for _, format := range c.PictureFormats {
+		if !IsPictureFormat(format) {
+			errs.add("PICTURE_FORMATS %q must be one of svg, png, jpg, gif, webp", format)
+		}
+	}
+
+	if c.CacheSMaxAge < 0 || c.CacheSMaxAge > MaxCacheDirectiveSeconds {
+		errs.add("CACHE_S_MAXAGE must be between 0 and %d, got %d", MaxCacheDirectiveSeconds, c.CacheSMaxAge)
+	}
+	if c.CacheStaleIfError < 0 || c.CacheStaleIfError > MaxCacheDirectiveSeconds {
+		errs.add("CACHE_STALE_IF_ERROR must be between 0 and %d, got %d", MaxCacheDirectiveSeconds, c.CacheStaleIfError)
+	}
+	if !validSecurityHeadersScope(c.SecurityHeaders) {
+		errs.add("SECURITY_HEADERS %q must be one of pages, all, off", c.SecurityHeaders)
+	}
+	if c.HSTSMaxAge < 0 {
+		errs.add("HSTS_MAX_AGE must not be negative, got %d", c.HSTSMaxAge)
+	}
+	for _, rule := range c.Redirects {
+		if !strings.HasPrefix(rule.From, "/") || rule.To == "" {
+			errs.add("REDIRECTS rule %s=%s needs a /path and a target", rule.From, rule.To)
+		}
+	}
+	if c.MaintenanceRetryAfter <= 0 {
+		errs.add("MAINTENANCE_RETRY_AFTER must be positive, got %d", c.MaintenanceRetryAfter)
+	}
+	if c.AdminAddr != "" && c.AdminAddr == c.Addr {
+		errs.add("ADMIN_ADDR %q must differ from ADDR", c.AdminAddr)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validatePalettes checks every palette color and that the default palette is defined
+func (c ServerConfig) validatePalettes(errs *ValidationErrors) {
+	for _, name := range slices.Sorted(maps.Keys(c.Palettes)) {
+		colors := c.Palettes[name]
+		if len(colors) == 0 {
+			errs.add("PALETTES palette %q has no colors", name)
+		}
+		for _, color := range colors {
+			if !IsHexColor(color) {
+				errs.add("PALETTES palette %q has invalid color %q", name, color)
+			}
+		}
+	}
+	if _, ok := c.Palettes[c.DefaultPalette]; c.DefaultPalette != "" && !ok {
+		errs.add("DEFAULT_PALETTE %q is not defined in PALETTES", c.DefaultPalette)
+	}
+}
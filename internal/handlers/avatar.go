@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"crypto/md5"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	"grout/internal/config"
 	"grout/internal/render"
@@ -11,42 +14,318 @@ import (
 )
 
 func (s *Service) handleAvatar(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get("name")
+	query := r.URL.Query()
+	name := query.Get("name")
 	format := render.FormatSVG // Default to SVG
 
 	if strings.HasPrefix(r.URL.Path, "/avatar/") {
 		parts := strings.Split(r.URL.Path, "/")
 		if len(parts) > 2 && parts[2] != "" {
-			format, name = extractFormat(parts[2])
+			// A bare extension such as /avatar/.png keeps the ?name= value
+			var pathName string
+			if format, pathName = extractFormat(parts[2]); pathName != "" {
+				name = pathName
+			}
 		}
 	}
 	if name == "" {
 		name = "John Doe"
 	}
 
-	size := utils.ParseIntOrDefault(r.URL.Query().Get("size"), config.DefaultSize)
-	rounded := r.URL.Query().Get("rounded") == "true"
-	bold := r.URL.Query().Get("bold") == "true"
+	var errs paramErrors
+	s.checkUnknownParams(&errs, query, avatarParams)
+	// format=picture returns an HTML snippet; its parameters are validated as for SVG
+	picture := strings.EqualFold(query.Get("format"), formatPicture)
+	if picture {
+		format = render.FormatSVG
+	} else {
+		format = parseFormat(&errs, "format", query.Get("format"), format)
+	}
+
+	if utf8.RuneCountInString(name) > s.cfg.MaxNameLength {
+		errs.add("name", "must not exceed %d characters", s.cfg.MaxNameLength)
+	}
+
+	width, height, cssWidth, cssHeight := avatarSize(&errs, w, r, format)
+	// weight picks the font weight; the legacy bold=true flag means weight=bold
+	weight := render.WeightRegular
+	if query.Get("bold") == "true" {
+		weight = render.WeightBold
+	}
+	if weightParam := query.Get("weight"); weightParam != "" {
+		parsed, ok := render.ParseFontWeight(weightParam)
+		if !ok {
+			errs.add("weight", "must be one of light, regular, medium, bold")
+		}
+		weight = parsed
+	}
+	// checker is a preview aid that makes transparent corners visible
+	checker := query.Get("checker") == "1" || query.Get("checker") == "true"
+	style, ok := s.renderer.ParseStyle(query.Get("style"))
+	if !ok {
+		errs.add("style", "must be one of %s", strings.Join(s.styleNames(), ", "))
+	}
+	// tile repeats the first initial across the background
+	tile := query.Get("tile") == "1" || query.Get("tile") == "true"
+	// blur softens the background layer while the initials stay sharp
+	blur := parseBlur(&errs, "blur", query.Get("blur"))
+	// vignette darkens the edges of the background and tile pattern, below the initials
+	vignette := parseVignette(&errs, query.Get("vignette"))
+	// ribbon labels non-production images, e.g. "DRAFT", with a diagonal corner banner
+	ribbon := parseRibbon(&errs, query.Get("ribbon"))
+	// tagline adds a line of smaller text below the initials, e.g. for profile headers
+	tagline := parseTagline(&errs, query.Get("tagline"))
+	// ring writes the full name around the edge, badge style
+	var ringText string
+	if isTrue(query.Get("ring")) {
+		ringText = name
+	}
+	// grayscale/saturation desaturate the whole image, e.g. for inactive users
+	grayscale := parseGrayscale(&errs, query.Get("grayscale"), query.Get("saturation"))
+	// pixelate reduces the avatar to a grid of flat blocks for a retro look
+	pixelate := parsePixelate(&errs, query.Get("pixelate"))
+	// opacity renders a translucent "ghost" avatar, e.g. for loading states
+	fade := parseOpacity(&errs, query.Get("opacity"))
+	// flip mirrors the avatar, e.g. for pairs facing each other; flipText=false keeps text readable
+	flip, flipSkipsText := parseFlip(&errs, query.Get("flip"), query.Get("flipText"))
+	standalone := wantsStandalone(r)
+	// colorProfile=none drops the sRGB tag from PNG and JPEG output for smaller files
+	colorProfile := parseColorProfile(&errs, query.Get("colorProfile"))
+	// symbol wraps the SVG in a <symbol> plus a <use>, for pages repeating the same avatar
+	symbol := isTrue(query.Get("symbol"))
+	// cssVars lets pages recolor an inline SVG through --grout-bg and --grout-fg
+	cssVars := isTrue(query.Get("cssVars"))
+	// gammaCorrect=false blends raster text like before, in sRGB instead of linear light
+	gammaCorrect := parseGammaCorrect(&errs, query.Get("gammaCorrect"), s.cfg.GammaCorrect)
+	// quality overrides the configured JPEG and WebP encoder quality for this request
+	quality := parseQuality(&errs, query.Get("quality"))
+	download, filename := parseDownload(&errs, query, format)
+	// animate is SVG-only; asking for it with a raster format is rejected as a conflict
+	animation, ok := render.ParseAnimation(query.Get("animate"))
+	if !ok {
+		errs.add("animate", "must be one of pulse, spin, fade")
+	}
+
+	// shape takes precedence over the legacy rounded=true flag
+	shape := render.ShapeSquare
+	if query.Get("rounded") == "true" {
+		shape = render.ShapeCircle
+	}
+	if shapeParam := query.Get("shape"); shapeParam != "" {
+		parsed, ok := render.ParseShape(shapeParam)
+		if !ok {
+			errs.add("shape", "must be one of square, circle, rounded, bubble")
+		}
+		shape = parsed
+	}
+	// tail points a speech bubble's tail to one side, e.g. for chat layouts
+	tail := parseTail(&errs, query.Get("tail"))
+	// radius only applies to rounded corners and scales with the image when given as a percentage
+	var radius float64
+	if shape == render.ShapeRounded {
+		radius = parseRadius(&errs, "radius", query.Get("radius"), width, height)
+	}
+
+	// initialsMode picks which words contribute; "all" defaults to the maximum number of initials
+	initialsMode, ok := render.ParseInitialsMode(query.Get("initialsMode"))
+	if !ok {
+		errs.add("initialsMode", "must be one of firstn, firstlast, all")
+	}
+	defaultMaxInitials := config.DefaultMaxInitials
+	if initialsMode == render.InitialsAll {
+		defaultMaxInitials = config.MaxInitials
+	}
+	maxInitials := utils.ParseIntOrDefault(query.Get("maxInitials"), defaultMaxInitials)
+	if maxInitials > config.MaxInitials {
+		maxInitials = config.MaxInitials
+	}
+	// locale selects the case rules for the initials, e.g. tr for the Turkish dotted İ
+	locale := parseLocale(&errs, "locale", query.Get("locale"), s.cfg.Locale)
+	// INITIALS_SPLIT=camel-hump also breaks handles like johnDoe or jane_doe into words
+	split, _ := render.ParseWordSplit(s.cfg.InitialsSplit)
+	initials := render.GetInitialsWithSplit(name, initialsMode, maxInitials, locale, split)
+	// wordmark renders the whole name, e.g. a product name, instead of its initials
+	if style == render.StyleWordmark {
+		initials = strings.TrimSpace(name)
+		if utf8.RuneCountInString(initials) > render.MaxWordmarkLength {
+			errs.add("name", "must not exceed %d characters for style=wordmark", render.MaxWordmarkLength)
+		}
+	}
+	letterSpacing := parseLetterSpacing(&errs, "letterSpacing", query.Get("letterSpacing"))
+	// initialsLayout=vertical stacks the initials for narrow, tall avatars
+	initialsLayout, ok := render.ParseInitialsLayout(query.Get("initialsLayout"))
+	if !ok {
+		errs.add("initialsLayout", "must be one of horizontal, vertical")
+	}
+	// caps=small draws small capitals when the font has them; caps=all capitalizes the text
+	caps, ok := render.ParseCaps(query.Get("caps"))
+	if !ok {
+		errs.add("caps", "must be one of normal, small, all")
+	}
 
 	// Accept both 'background' and 'bg' for consistency (background is primary)
-	bgHex := r.URL.Query().Get("background")
-	if bgHex == "" {
-		bgHex = r.URL.Query().Get("bg")
+	bgParam, bgValue := "background", query.Get("background")
+	if bgValue == "" {
+		bgParam, bgValue = "bg", query.Get("bg")
+	}
+	paletteName := query.Get("palette")
+	if _, ok := s.cfg.Palettes[paletteName]; paletteName != "" && !ok {
+		errs.add("palette", "unknown palette %q", paletteName)
 	}
-	if bgHex == "" {
-		bgHex = config.DefaultAvatarBg
+	salt := s.cfg.ColorSalt
+	if query.Has("salt") {
+		salt = query.Get("salt")
 	}
-	if strings.EqualFold(bgHex, "random") {
-		bgHex = render.GenerateColorHash(name)
+	if utf8.RuneCountInString(salt) > config.MaxSaltLength {
+		errs.add("salt", "must not exceed %d characters", config.MaxSaltLength)
 	}
+	// hueRange=min-max keeps random backgrounds within a band of hues, e.g. 180-240 for blues
+	var hueRange render.HueRange
+	if value := query.Get("hueRange"); value != "" {
+		if hueRange, ok = render.ParseHueRange(value); !ok {
+			errs.add("hueRange", "must be min-max in degrees from 0 to 360")
+		}
+	}
+	var bgHex string
+	if strings.EqualFold(bgValue, "random") && query.Has("hueRange") {
+		bgHex = render.ColorInHueRange(render.SaltedSeed(name, salt), s.palette(paletteName), s.colorHash(), hueRange)
+	} else if strings.EqualFold(bgValue, "random") {
+		bgHex = render.ColorFromPaletteWithHash(render.SaltedSeed(name, salt), s.palette(paletteName), s.colorHash())
+	} else {
+		bgHex = parseColor(&errs, bgParam, bgValue, config.DefaultAvatarBg, true)
+	}
+
+	var tileColors []string
+	if style == render.StyleTiles {
+		tileColors = render.TileColorsWithHash(render.SaltedSeed(name, salt), s.palette(paletteName), min(utf8.RuneCountInString(initials), render.MaxLetterTiles), s.colorHash())
+	}
+
+	fgHex := parseColor(&errs, "color", query.Get("color"), "", false)
+	// textGradient fills the initials with a gradient instead of the text color
+	textGradient := parseTextGradient(&errs, "textGradient", query.Get("textGradient"), bgHex)
 
-	fgHex := r.URL.Query().Get("color")
+	// badge draws a presence dot; badgeColor picks any color, overriding the status color
+	var badgeHex string
+	if status := query.Get("badge"); status != "" {
+		if badgeHex, ok = render.BadgeStatusColor(status); !ok {
+			errs.add("badge", "must be one of online, offline, busy, away")
+		}
+	}
+	badgeHex = parseColor(&errs, "badgeColor", query.Get("badgeColor"), badgeHex, false)
+	badgeCorner, ok := render.ParseCorner(query.Get("badgeCorner"))
+	if !ok {
+		errs.add("badgeCorner", "must be one of bottom-right, bottom-left, top-right, top-left")
+	}
+	meta := parseMeta(&errs, query.Get("meta"))
+	// alt labels SVG output for screen readers; without it the configured template is used
+	alt := s.altText(&errs, query.Get("alt"), format, name, initials, fmt.Sprintf("%d", width))
+
+	if len(errs) > 0 {
+		writeParamErrors(w, errs)
+		return
+	}
+	if !s.styleEnabled(style) {
+		writeJSONError(w, http.StatusNotAcceptable, fmt.Sprintf("style %s is disabled on this server", style))
+		return
+	}
+	if picture {
+		s.writeAvatarPicture(w, r, name, cssWidth, cssHeight, alt)
+		return
+	}
+	if conflicts := findConflicts(query, format, avatarConflicts); len(conflicts) > 0 {
+		writeParamConflicts(w, conflicts)
+		return
+	}
 	if fgHex == "" {
 		fgHex = render.GetContrastColor(bgHex)
 	}
+	if meta == metaColor {
+		writeColorMeta(w, bgHex, fgHex)
+		return
+	}
 
-	key := fmt.Sprintf("Avatar:%s:%d:%t:%t:%s:%s:%s", name, size, rounded, bold, bgHex, fgHex, format)
-	s.serveImage(w, r, key, format, func() ([]byte, error) {
-		return s.renderer.DrawImageWithFormat(size, size, bgHex, fgHex, render.GetInitials(name), rounded, bold, format)
+	// meta=1 records the request, so equivalent URLs spelled differently are cached separately
+	var provenanceReq string
+	if meta == metaEmbed {
+		provenanceReq = provenanceRequest(r)
+	}
+	// Free text is quoted, so a ':' inside it cannot shift the fields that follow
+	key := fmt.Sprintf("Avatar:%q:%dx%d:%s:%g:%s:%s:%s:%q:%q:%g:%t:%t:%s:%s:%s:%s:%t:%t:%g:%g:%q:%s:%t:%t:%s:%s:%q:%q:%s:%g:%q:%s:%t:%s:%d:%s:%t:%t:%g:%d", name, width, height, shape, radius, weight, bgHex, fgHex, textGradient, initials,
+		letterSpacing.Value, letterSpacing.Em, ringText != "", style, strings.Join(tileColors, ","), badgeHex, badgeCorner, checker, tile, blur, grayscale, ribbon, animation, symbol, standalone, format, colorProfile, provenanceReq, alt, initialsLayout, fade, tagline, flip, flipSkipsText, tail, pixelate, caps, cssVars, gammaCorrect, vignette, quality)
+	var symbolID string
+	if symbol {
+		symbolID = avatarSymbolID(key)
+	}
+	if filename != "" {
+		setAttachment(w, format, filename)
+	} else if standalone || download {
+		setAttachment(w, format, "avatar", name, fmt.Sprintf("%dx%d", width, height))
+	}
+	s.serveImage(w, r, key, format, func(dst io.Writer) error {
+		return s.renderer.Render(dst, render.Options{
+			Width:          width,
+			Height:         height,
+			Background:     bgHex,
+			Foreground:     fgHex,
+			Text:           initials,
+			TextGradient:   textGradient,
+			RingText:       ringText,
+			Tagline:        tagline,
+			Caps:           caps,
+			LetterSpacing:  letterSpacing,
+			Shape:          shape,
+			Radius:         radius,
+			Tail:           tail,
+			Weight:         weight,
+			Format:         format,
+			Style:          style,
+			InitialsLayout: initialsLayout,
+			TileColors:     tileColors,
+			BadgeColor:     badgeHex,
+			BadgeCorner:    badgeCorner,
+			Ribbon:         ribbon,
+			Checker:        checker,
+			Tile:           tile,
+			Blur:           blur,
+			Vignette:       vignette,
+			Grayscale:      grayscale,
+			Pixelate:       pixelate,
+			Fade:           fade,
+			Flip:           flip,
+			FlipSkipsText:  flipSkipsText,
+			Animate:        animation,
+			Provenance:     provenanceRecord(provenanceReq),
+			ColorProfile:   colorProfile,
+			SymbolID:       symbolID,
+			CSSVars:        cssVars,
+			GammaCorrect:   gammaCorrect,
+			Title:          alt,
+			Standalone:     standalone,
+			Encoding:       s.encoding(quality),
+		})
 	})
 }
+
+// avatarSize parses the pixel size an avatar renders at and the CSS size it is shown at.
+// size accepts "128" for a square or "256x128"; width/height override either dimension.
+func avatarSize(errs *paramErrors, w http.ResponseWriter, r *http.Request, format render.ImageFormat) (width, height, cssWidth, cssHeight int) {
+	query := r.URL.Query()
+	width, height = parseSize(errs, "size", query.Get("size"), config.DefaultSize)
+	width = parseDimension(errs, "width", query.Get("width"), width)
+	height = parseDimension(errs, "height", query.Get("height"), height)
+	// dpr renders raster output at a multiple of the requested size for high-density screens
+	cssWidth, cssHeight = width, height
+	width, height = scaleDPR(width, height, devicePixelRatio(errs, w, r, format))
+	// pot rounds raster dimensions to powers of two for GPU texture atlases
+	pot, ok := render.ParsePowerOfTwo(query.Get("pot"))
+	if !ok {
+		errs.add("pot", "must be one of up, down, nearest")
+	}
+	return pot.Round(width), pot.Round(height), cssWidth, cssHeight
+}
+
+// avatarSymbolID derives a stable <symbol> id from the cache key, so the same parameters
+// always produce the same id and different avatars on one page do not collide
+func avatarSymbolID(key string) string {
+	return fmt.Sprintf("avatar-%x", md5.Sum([]byte(key)))[:len("avatar-")+12]
+}
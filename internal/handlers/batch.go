@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF for manifest dimension decoding
+	_ "image/jpeg" // register JPEG for manifest dimension decoding
+	_ "image/png"  // register PNG for manifest dimension decoding
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+)
+
+// batchRequest is the JSON body accepted by POST /batch.
+type batchRequest struct {
+	Items []batchItem `json:"items"`
+}
+
+// batchItem references a single image by its avatar or placeholder URL.
+type batchItem struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// batchResult carries one generated image; Data is base64 encoded by encoding/json.
+type batchResult struct {
+	ID          string `json:"id"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// manifestEntry describes a generated image so tooling can index it without decoding.
+type manifestEntry struct {
+	ID     string `json:"id"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	Bytes  int    `json:"bytes"`
+	ETag   string `json:"etag"`
+}
+
+type batchResponse struct {
+	Items    []batchResult   `json:"items"`
+	Manifest []manifestEntry `json:"manifest,omitempty"`
+}
+
+// handleBatch renders several avatars/placeholders in one request.
+// With ?manifest=1 a manifest describing each successful image is returned alongside the images.
+// With ?archive=zip the images are streamed as a ZIP archive instead (see writeBatchZip).
+func (s *Service) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			middleware.WriteBodyTooLarge(w, maxErr.Limit)
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid batch body: "+err.Error())
+		return
+	}
+	if len(req.Items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "batch must contain at least one item")
+		return
+	}
+	if len(req.Items) > config.MaxBatchItems {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("batch exceeds %d items", config.MaxBatchItems))
+		return
+	}
+
+	switch r.URL.Query().Get("archive") {
+	case "":
+	case "zip":
+		s.writeBatchZip(w, r, req.Items)
+		return
+	default:
+		writeJSONError(w, http.StatusBadRequest, "archive must be zip")
+		return
+	}
+
+	withManifest := r.URL.Query().Get("manifest") == "1" || r.URL.Query().Get("manifest") == "true"
+
+	resp := batchResponse{Items: make([]batchResult, 0, len(req.Items))}
+	for _, item := range req.Items {
+		result := s.renderBatchItem(r, item)
+		resp.Items = append(resp.Items, result)
+		if withManifest && result.Status == http.StatusOK {
+			resp.Manifest = append(resp.Manifest, newManifestEntry(result))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// renderBatchItem dispatches a single item to the avatar or placeholder handler in-process.
+func (s *Service) renderBatchItem(r *http.Request, item batchItem) batchResult {
+	result := batchResult{ID: item.ID}
+
+	var handler http.HandlerFunc
+	switch {
+	case strings.HasPrefix(item.URL, "/avatar/"):
+		handler = s.handleAvatar
+	case strings.HasPrefix(item.URL, "/placeholder/"):
+		handler = s.handlePlaceholder
+	default:
+		result.Status = http.StatusBadRequest
+		result.Error = "url must start with /avatar/ or /placeholder/"
+		return result
+	}
+
+	sub, err := http.NewRequestWithContext(r.Context(), http.MethodGet, item.URL, nil)
+	if err != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = "invalid url"
+		return result
+	}
+
+	rec := newBufferedResponse()
+	handler(rec, sub)
+
+	result.Status = rec.status
+	if rec.status != http.StatusOK {
+		result.Error = http.StatusText(rec.status)
+		return result
+	}
+	result.ContentType = rec.header.Get("Content-Type")
+	result.ETag = rec.header.Get("ETag")
+	result.Data = rec.body.Bytes()
+	return result
+}
+
+// newManifestEntry derives the manifest metadata for a generated image.
+func newManifestEntry(result batchResult) manifestEntry {
+	entry := manifestEntry{
+		ID:     result.ID,
+		Format: formatFromContentType(result.ContentType),
+		Bytes:  len(result.Data),
+		ETag:   result.ETag,
+	}
+	switch entry.Format {
+	case "svg", "jsx":
+		entry.Width, entry.Height = svgDimensions(result.Data)
+	case "ico":
+		entry.Width, entry.Height = icoDimensions(result.Data)
+	case "png", "jpg", "gif", "webp":
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(result.Data)); err == nil {
+			entry.Width, entry.Height = cfg.Width, cfg.Height
+		}
+	}
+	// A <picture> snippet is HTML, not an image, and reports no dimensions
+	return entry
+}
+
+// svgDimensions reads the width and height attributes of the root <svg> element, wherever
+// they appear among its attributes and whatever precedes it (an XML prolog, comments). It
+// returns zeros when the root is not <svg> or a dimension is missing or not a number.
+func svgDimensions(data []byte) (int, int) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "svg" {
+			return 0, 0
+		}
+		var width, height int
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "width":
+				width = svgLength(attr.Value)
+			case "height":
+				height = svgLength(attr.Value)
+			}
+		}
+		if width == 0 || height == 0 {
+			return 0, 0
+		}
+		return width, height
+	}
+}
+
+// svgLength parses a pixel length such as "200" or "200px", returning 0 for anything else
+func svgLength(value string) int {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "px"), 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return int(math.Round(n))
+}
+
+// icoDimensions reads the size of the first image in an ICO directory, where 0 stands for 256
+func icoDimensions(data []byte) (int, int) {
+	if len(data) < 8 || binary.LittleEndian.Uint16(data[2:4]) != 1 || binary.LittleEndian.Uint16(data[4:6]) == 0 {
+		return 0, 0
+	}
+	dim := func(b byte) int {
+		if b == 0 {
+			return 256
+		}
+		return int(b)
+	}
+	return dim(data[6]), dim(data[7])
+}
+
+// formatFromContentType maps a response MIME type back to its short format name, which also
+// serves as the archive file extension. Types the handlers do not produce map to "bin".
+func formatFromContentType(contentType string) string {
+	switch contentType {
+	case "image/svg+xml":
+		return "svg"
+	case "image/png":
+		return "png"
+	case "image/jpeg":
+		return "jpg"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	case "image/x-icon":
+		return "ico"
+	case "text/plain; charset=utf-8":
+		return "jsx"
+	case "text/html; charset=utf-8":
+		return "html"
+	default:
+		return "bin"
+	}
+}
+
+// writeJSONError writes a JSON error body with the given status code
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// bufferedResponse captures a handler's response in memory
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) WriteHeader(statusCode int) { b.status = statusCode }
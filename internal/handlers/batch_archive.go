@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var archiveNameUnsafeRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// writeBatchZip streams a batch as a ZIP archive with one file per successful item, named
+// by its id and format. Items render concurrently on a bounded set of workers but are
+// written in request order, so the archive is the same for the same request. Each entry is
+// flushed to the client as soon as it is written, so a large archive never sits in memory.
+// Failed items are listed in a trailing errors.json instead.
+func (s *Service) writeBatchZip(w http.ResponseWriter, r *http.Request, items []batchItem) {
+	results := make([]chan batchResult, len(items))
+	for i := range results {
+		results[i] = make(chan batchResult, 1)
+	}
+	jobs := make(chan int)
+	for range min(runtime.GOMAXPROCS(0), len(items)) {
+		go func() {
+			for i := range jobs {
+				results[i] <- s.renderBatchItem(r, items[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range items {
+			select {
+			case jobs <- i:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="batch.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	zw := zip.NewWriter(w)
+	used := make(map[string]bool, len(items))
+	var failed []batchResult
+	for i := range items {
+		var result batchResult
+		select {
+		case result = <-results[i]:
+		case <-r.Context().Done():
+			return
+		}
+		if result.Status != http.StatusOK {
+			failed = append(failed, result)
+			continue
+		}
+		if err := writeZipEntry(zw, archiveFileName(result.ID, formatFromContentType(result.ContentType), i, used), result.Data); err != nil {
+			return
+		}
+		if err := zw.Flush(); err != nil {
+			return
+		}
+		_ = rc.Flush()
+	}
+	if len(failed) > 0 {
+		f, err := zw.Create(archiveFileName("errors", "json", len(items), used))
+		if err != nil {
+			return
+		}
+		_ = json.NewEncoder(f).Encode(failed)
+	}
+	_ = zw.Close()
+}
+
+// writeZipEntry adds a deflated file to zw with its sizes and checksum in the local header.
+// zip.Writer.Create only finishes an entry, with its trailing data descriptor, once the next
+// one starts, so entries written this way are complete as soon as they are flushed.
+func writeZipEntry(zw *zip.Writer, name string, data []byte) error {
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	f, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(compressed.Bytes())
+	return err
+}
+
+// archiveFileName turns an item id into a safe, unique file name with its format's
+// extension; ids that sanitize to nothing fall back to the item's position
+func archiveFileName(id, ext string, index int, used map[string]bool) string {
+	base := strings.TrimLeft(archiveNameUnsafeRegex.ReplaceAllString(id, "_"), ".")
+	if base == "" {
+		base = "item-" + strconv.Itoa(index+1)
+	}
+	name := base + "." + ext
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%s-%d.%s", base, n, ext)
+	}
+	used[name] = true
+	return name
+}
@@ -0,0 +1,538 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/hashicorp/golang-lru/v2"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+	"grout/internal/render"
+)
+
+func postBatch(t *testing.T, mux *http.ServeMux, path string, body string) (*httptest.ResponseRecorder, batchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	var resp batchResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode batch response: %v", err)
+		}
+	}
+	return rec, resp
+}
+
+func TestBatchHandler(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	body := `{"items":[
+		{"id":"jane","url":"/avatar/Jane%20Doe.png?size=64"},
+		{"id":"hero","url":"/placeholder/300x150.svg"},
+		{"id":"bad","url":"/health"}
+	]}`
+	rec, resp := postBatch(t, mux, "/batch", body)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json got %s", ct)
+	}
+	if len(resp.Items) != 3 {
+		t.Fatalf("expected 3 items got %d", len(resp.Items))
+	}
+	if resp.Items[0].Status != http.StatusOK || resp.Items[0].ContentType != "image/png" || len(resp.Items[0].Data) == 0 {
+		t.Fatalf("unexpected avatar result: %+v", resp.Items[0])
+	}
+	if resp.Items[2].Status != http.StatusBadRequest || resp.Items[2].Error == "" {
+		t.Fatalf("expected unsupported url to fail, got %+v", resp.Items[2])
+	}
+	if resp.Manifest != nil {
+		t.Fatal("expected no manifest without ?manifest=1")
+	}
+}
+
+func TestBatchHandlerManifest(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	body := `{"items":[
+		{"id":"jane","url":"/avatar/Jane%20Doe.png?size=64"},
+		{"id":"bob","url":"/avatar/Bob.webp?size=48"},
+		{"id":"hero","url":"/placeholder/300x150.svg"},
+		{"id":"banner","url":"/placeholder/320x100.jpg"}
+	]}`
+	rec, resp := postBatch(t, mux, "/batch?manifest=1", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	expected := []struct {
+		id            string
+		width, height int
+		format        string
+	}{
+		{"jane", 64, 64, "png"},
+		{"bob", 48, 48, "webp"},
+		{"hero", 300, 150, "svg"},
+		{"banner", 320, 100, "jpg"},
+	}
+	if len(resp.Manifest) != len(expected) {
+		t.Fatalf("expected %d manifest entries got %d", len(expected), len(resp.Manifest))
+	}
+
+	for i, exp := range expected {
+		entry := resp.Manifest[i]
+		item := resp.Items[i]
+		if entry.ID != exp.id || entry.Format != exp.format {
+			t.Errorf("entry %d: expected %s/%s got %s/%s", i, exp.id, exp.format, entry.ID, entry.Format)
+		}
+		if entry.Width != exp.width || entry.Height != exp.height {
+			t.Errorf("entry %s: expected %dx%d got %dx%d", exp.id, exp.width, exp.height, entry.Width, entry.Height)
+		}
+		if entry.Bytes != len(item.Data) {
+			t.Errorf("entry %s: expected %d bytes got %d", exp.id, len(item.Data), entry.Bytes)
+		}
+		if entry.ETag == "" || entry.ETag != item.ETag {
+			t.Errorf("entry %s: expected etag %q got %q", exp.id, item.ETag, entry.ETag)
+		}
+		if exp.format != "svg" {
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(item.Data))
+			if err != nil {
+				t.Fatalf("entry %s: decode: %v", exp.id, err)
+			}
+			if cfg.Width != entry.Width || cfg.Height != entry.Height {
+				t.Errorf("entry %s: manifest %dx%d does not match image %dx%d", exp.id, entry.Width, entry.Height, cfg.Width, cfg.Height)
+			}
+		}
+	}
+}
+
+func TestBatchManifestMarkup(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	body := `{"items":[
+		{"id":"standalone","url":"/avatar/Jane.svg?size=72&standalone=1"},
+		{"id":"jsx","url":"/avatar/Jane?size=40&format=jsx"},
+		{"id":"picture","url":"/avatar/Jane?size=40&format=picture"}
+	]}`
+	rec, resp := postBatch(t, mux, "/batch?manifest=1", body)
+	if rec.Code != http.StatusOK || len(resp.Manifest) != 3 {
+		t.Fatalf("expected 200 with 3 manifest entries got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !bytes.HasPrefix(resp.Items[0].Data, []byte("<?xml")) {
+		t.Fatalf("expected the standalone SVG to start with an XML prolog got %.40q", resp.Items[0].Data)
+	}
+
+	expected := []manifestEntry{
+		{ID: "standalone", Width: 72, Height: 72, Format: "svg"},
+		{ID: "jsx", Width: 40, Height: 40, Format: "jsx"},
+		{ID: "picture", Format: "html"},
+	}
+	for i, exp := range expected {
+		got := resp.Manifest[i]
+		if got.ID != exp.ID || got.Format != exp.Format || got.Width != exp.Width || got.Height != exp.Height {
+			t.Errorf("expected %s %s %dx%d got %s %s %dx%d", exp.ID, exp.Format, exp.Width, exp.Height, got.ID, got.Format, got.Width, got.Height)
+		}
+	}
+}
+
+func TestSVGDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		svg           string
+		width, height int
+	}{
+		{"Plain", `<svg xmlns="http://www.w3.org/2000/svg" width="200" height="100"></svg>`, 200, 100},
+		{"Height first", `<svg height="100" viewBox="0 0 200 100" width="200"/>`, 200, 100},
+		{"Prolog and comment", "<?xml version=\"1.0\"?>\n<!-- generated -->\n<svg width='64px' height='32'/>", 64, 32},
+		{"Missing height", `<svg width="200"/>`, 0, 0},
+		{"Percentage", `<svg width="100%" height="100%"/>`, 0, 0},
+		{"Not an SVG root", `<html><svg width="10" height="10"/></html>`, 0, 0},
+		{"Not XML", `{"width": 10}`, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w, h := svgDimensions([]byte(tt.svg)); w != tt.width || h != tt.height {
+				t.Fatalf("expected %dx%d got %dx%d", tt.width, tt.height, w, h)
+			}
+		})
+	}
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	tests := map[string]string{
+		"image/svg+xml":             "svg",
+		"image/x-icon":              "ico",
+		"text/html; charset=utf-8":  "html",
+		"text/plain; charset=utf-8": "jsx",
+		"application/json":          "bin",
+	}
+	for contentType, want := range tests {
+		if got := formatFromContentType(contentType); got != want {
+			t.Errorf("%s: expected %q got %q", contentType, want, got)
+		}
+	}
+}
+
+func TestBatchHandlerInvalid(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	var tooMany []string
+	for i := 0; i <= config.MaxBatchItems; i++ {
+		tooMany = append(tooMany, fmt.Sprintf(`{"id":"%d","url":"/avatar/A"}`, i))
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"Malformed JSON", `{"items":`},
+		{"Empty batch", `{"items":[]}`},
+		{"Too many items", `{"items":[` + strings.Join(tooMany, ",") + `]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := postBatch(t, mux, "/batch", tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestBatchHandlerBodyTooLarge(t *testing.T) {
+	_, mux := setupTestService(t)
+	handler := middleware.BodyLimitMiddleware(64, nil)(mux)
+
+	// Without a Content-Length the limit is only hit while decoding the JSON
+	body := `{"items":[{"id":"a","url":"/avatar/` + strings.Repeat("A", 100) + `"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "request body exceeds 64 bytes") {
+		t.Fatalf("expected the limit in the error, got %s", rec.Body.String())
+	}
+}
+
+func TestBatchZipArchive(t *testing.T) {
+	_, mux := setupTestService(t)
+	handler := middleware.CompressionMiddleware(middleware.CompressionConfig{SmallLevel: 1, LargeLevel: 9, LargeBodyThreshold: 4096})(mux)
+
+	body := `{"items":[
+		{"id":"jane","url":"/avatar/Jane%20Doe.png?size=64"},
+		{"id":"hero","url":"/placeholder/300x150.svg"},
+		{"id":"photo","url":"/placeholder/80x60.jpg"},
+		{"id":"jane","url":"/avatar/Jane.png?size=32"},
+		{"id":"../etc","url":"/avatar/Bob.svg"},
+		{"id":"bad","url":"/health"}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/batch?archive=zip", strings.NewReader(body))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("expected application/zip got %s", ct)
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+		t.Fatalf("expected no Content-Encoding for a ZIP got %s", ce)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	var names []string
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", f.Name, err)
+		}
+		names = append(names, f.Name)
+		files[f.Name] = data
+	}
+
+	expected := []string{"jane.png", "hero.svg", "photo.jpg", "jane-2.png", "_etc.svg", "errors.json"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected files %v in request order got %v", expected, names)
+	}
+	for _, name := range []string{"jane.png", "photo.jpg", "jane-2.png"} {
+		if _, _, err := image.Decode(bytes.NewReader(files[name])); err != nil {
+			t.Fatalf("expected %s to decode: %v", name, err)
+		}
+	}
+	for _, name := range []string{"hero.svg", "_etc.svg"} {
+		if !strings.HasPrefix(string(files[name]), "<svg") {
+			t.Fatalf("expected %s to be an SVG got %.40s", name, files[name])
+		}
+	}
+	var failed []batchResult
+	if err := json.Unmarshal(files["errors.json"], &failed); err != nil {
+		t.Fatalf("failed to decode errors.json: %v", err)
+	}
+	if len(failed) != 1 || failed[0].ID != "bad" || failed[0].Status != http.StatusBadRequest {
+		t.Fatalf("expected only the bad item in errors.json got %+v", failed)
+	}
+
+	t.Run("Unknown archive format", func(t *testing.T) {
+		rec, _ := postBatch(t, mux, "/batch?archive=tar", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 got %d", rec.Code)
+		}
+	})
+}
+
+// gatedStyle is a custom style whose SVG waits for release, to hold back one batch item
+type gatedStyle struct{ release <-chan struct{} }
+
+func (g gatedStyle) WriteSVG(w io.Writer, ctx render.StyleContext) error {
+	<-g.release
+	_, err := fmt.Fprintf(w, "<text>%s</text>", ctx.Text)
+	return err
+}
+
+func (gatedStyle) DrawRaster(dc *gg.Context, ctx render.StyleContext) error { return nil }
+
+// completeZipEntry reports whether archive holds the first entry's local header and all of its data
+func completeZipEntry(archive []byte) bool {
+	if len(archive) < 30 {
+		return false
+	}
+	size := binary.LittleEndian.Uint32(archive[18:])
+	nameLen := binary.LittleEndian.Uint16(archive[26:])
+	extraLen := binary.LittleEndian.Uint16(archive[28:])
+	return len(archive) >= 30+int(nameLen)+int(extraLen)+int(size)
+}
+
+func TestBatchZipStreamsEntries(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	release := make(chan struct{})
+	if err := renderer.RegisterStyle("gated", gatedStyle{release: release}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](10)
+	cfg := config.DefaultServerConfig()
+	mux := http.NewServeMux()
+	NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+	// The public chain's buffering layers, with the default timeout
+	handler := middleware.CompressionMiddleware(middleware.DefaultCompressionConfig())(middleware.TimeoutMiddleware(cfg.RequestTimeout, nil)(mux))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	body := `{"items":[{"id":"first","url":"/avatar/Jane%20Doe.png?size=64"},{"id":"last","url":"/avatar/Bob?style=gated"}]}`
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/batch?archive=zip", strings.NewReader(body))
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Idempotency-Key", "zip-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		close(release)
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The whole first entry, local header and data, arrives while the last is still rendering
+	received := make(chan []byte)
+	go func() {
+		var seen []byte
+		chunk := make([]byte, 512)
+		for !completeZipEntry(seen) {
+			n, err := resp.Body.Read(chunk)
+			seen = append(seen, chunk[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		received <- seen
+	}()
+	var seen []byte
+	select {
+	case seen = <-received:
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("expected the first entry before the last one was rendered")
+	}
+	close(release)
+	if !completeZipEntry(seen) || !bytes.Contains(seen, []byte("first.png")) {
+		t.Fatalf("expected the complete first entry, got %d bytes", len(seen))
+	}
+
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	archive := append(seen, rest...)
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "first.png" || zr.File[1].Name != "last.svg" {
+		t.Fatalf("expected first.png and last.svg in the archive")
+	}
+
+	t.Run("Archives are not stored for replays", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/batch?archive=zip", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "zip-1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected a fresh archive got %d %v", rec.Code, rec.Header())
+		}
+	})
+}
+
+func TestBatchIdempotencyKey(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	post := func(handler http.Handler, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	body := `{"items":[{"id":"jane","url":"/avatar/Jane.png?size=64"},{"id":"hero","url":"/placeholder/300x150.svg"}]}`
+
+	first := post(mux, "/batch", "retry-1", body)
+	if first.Code != http.StatusOK || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected a fresh 200 got %d %v", first.Code, first.Header())
+	}
+
+	t.Run("Retry replays the stored response", func(t *testing.T) {
+		rec := post(mux, "/batch", "retry-1", body)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "true" {
+			t.Fatalf("expected a replayed 200 got %d %v", rec.Code, rec.Header())
+		}
+		if rec.Header().Get("Content-Type") != "application/json" || !bytes.Equal(rec.Body.Bytes(), first.Body.Bytes()) {
+			t.Fatal("expected the replay to match the first response")
+		}
+	})
+
+	t.Run("Reuse with another body", func(t *testing.T) {
+		rec := post(mux, "/batch", "retry-1", `{"items":[{"id":"bob","url":"/avatar/Bob"}]}`)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("expected 409 got %d", rec.Code)
+		}
+	})
+
+	t.Run("Reuse with another query", func(t *testing.T) {
+		rec := post(mux, "/batch?manifest=1", "retry-1", body)
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("expected 409 got %d", rec.Code)
+		}
+	})
+
+	t.Run("Other keys are independent", func(t *testing.T) {
+		rec := post(mux, "/batch", "retry-2", `{"items":[{"id":"bob","url":"/avatar/Bob"}]}`)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected a fresh 200 got %d %v", rec.Code, rec.Header())
+		}
+	})
+
+	t.Run("Keys are scoped per client", func(t *testing.T) {
+		other := `{"items":[{"id":"bob","url":"/avatar/Bob"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(other))
+		req.Header.Set("Idempotency-Key", "retry-1")
+		req.RemoteAddr = "203.0.113.9:4711"
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected another client's key to be independent got %d %v", rec.Code, rec.Header())
+		}
+
+		req = httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(other))
+		req.Header.Set("Idempotency-Key", "retry-1")
+		req.Header.Set("Authorization", "Bearer token-b")
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected other credentials to be independent got %d %v", rec.Code, rec.Header())
+		}
+	})
+
+	t.Run("Key too long", func(t *testing.T) {
+		rec := post(mux, "/batch", strings.Repeat("k", 256), body)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 got %d", rec.Code)
+		}
+	})
+
+	t.Run("Disabled with a zero TTL", func(t *testing.T) {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](1)
+		cfg := config.DefaultServerConfig()
+		cfg.IdempotencyTTL = 0
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+
+		post(mux, "/batch", "retry-1", body)
+		rec := post(mux, "/batch", "retry-1", `{"items":[{"id":"bob","url":"/avatar/Bob"}]}`)
+		if rec.Code != http.StatusOK || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Fatalf("expected the key to be ignored got %d %v", rec.Code, rec.Header())
+		}
+	})
+}
+
+func TestIdempotencyCacheByteLimits(t *testing.T) {
+	c := newIdempotencyCache(time.Hour)
+	store := func(key string, size int) {
+		c.start(key)
+		c.finish(key, &idempotentResponse{status: http.StatusOK, body: make([]byte, size)})
+	}
+
+	store("too-large", config.MaxIdempotencyEntryBytes+1)
+	if _, ok := c.responses.Get("too-large"); ok || c.bytes.Load() != 0 {
+		t.Fatalf("expected a body over the entry cap not to be stored, holding %d bytes", c.bytes.Load())
+	}
+
+	entries := config.MaxIdempotencyBytes/config.MaxIdempotencyEntryBytes + 2
+	for i := range entries {
+		store(fmt.Sprintf("key-%d", i), config.MaxIdempotencyEntryBytes)
+	}
+	if got := c.bytes.Load(); got > config.MaxIdempotencyBytes {
+		t.Fatalf("expected at most %d bytes stored got %d", config.MaxIdempotencyBytes, got)
+	}
+	if _, ok := c.responses.Get("key-0"); ok {
+		t.Fatal("expected the oldest response to make room")
+	}
+	if _, ok := c.responses.Get(fmt.Sprintf("key-%d", entries-1)); !ok {
+		t.Fatal("expected the newest response to be kept")
+	}
+}
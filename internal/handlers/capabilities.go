@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"grout/internal/render"
+)
+
+// outputFormats are the values of the format param, in the order /capabilities lists them
+var outputFormats = []render.ImageFormat{render.FormatSVG, render.FormatPNG, render.FormatJPG, render.FormatGIF, render.FormatWebP, render.FormatJSX}
+
+// capabilities is the body of GET /capabilities
+type capabilities struct {
+	Styles  []string `json:"styles"`
+	Formats []string `json:"formats"`
+}
+
+// styleEnabled reports whether cfg.EnabledStyles allows style; the default style always is
+func (s *Service) styleEnabled(style render.Style) bool {
+	return style == render.StyleDefault || s.cfg.EnabledStyles == nil || slices.Contains(s.cfg.EnabledStyles, string(style))
+}
+
+// styleNames lists the registered styles that are enabled, "default" first
+func (s *Service) styleNames() []string {
+	var names []string
+	for _, name := range s.renderer.StyleNames() {
+		if name == "default" || s.styleEnabled(render.Style(name)) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// formats lists the output formats this deployment serves; low-memory mode has no raster output
+func (s *Service) formats() []render.ImageFormat {
+	var formats []render.ImageFormat
+	for _, format := range outputFormats {
+		if !s.cfg.LowMemory || !format.IsRaster() {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// handleCapabilities lists the styles and formats this deployment serves, so clients can
+// offer only what works: disabled styles and, in low-memory mode, raster formats are left out
+func (s *Service) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps := capabilities{Styles: s.styleNames()}
+	for _, format := range s.formats() {
+		caps.Formats = append(caps.Formats, string(format))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = json.NewEncoder(w).Encode(caps)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"grout/internal/config"
+	"grout/internal/render"
+)
+
+// acceptClientHints asks browsers to send their device pixel ratio and layout width on later
+// image requests. Sec-CH-DPR is the current spelling of the hint; DPR is the legacy one.
+const acceptClientHints = "DPR, Sec-CH-DPR, Width"
+
+// maxDPR is the largest device pixel ratio raster output is scaled by
+const maxDPR = 4
+
+// devicePixelRatio returns how much to scale raster output for high-density screens.
+// An explicit dpr parameter wins; otherwise the Sec-CH-DPR or DPR client hint is used and
+// the response varies on it. Invalid hints are ignored rather than rejected, since clients
+// send them unprompted. Vector output is resolution independent and always gets 1.
+func devicePixelRatio(errs *paramErrors, w http.ResponseWriter, r *http.Request, format render.ImageFormat) float64 {
+	if value := r.URL.Query().Get("dpr"); value != "" {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n < 1 || n > maxDPR || math.IsNaN(n) {
+			errs.add("dpr", "must be a number between 1 and %d", maxDPR)
+			return 1
+		}
+		return n
+	}
+	if !format.IsRaster() {
+		return 1
+	}
+
+	w.Header().Add("Vary", "Sec-CH-DPR, DPR")
+	hint := r.Header.Get("Sec-CH-DPR")
+	if hint == "" {
+		hint = r.Header.Get("DPR")
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(hint), 64)
+	if err != nil || math.IsNaN(n) {
+		return 1
+	}
+	return math.Max(1, math.Min(n, maxDPR))
+}
+
+// scaleDPR multiplies both dimensions by dpr, lowering the ratio if needed so neither
+// exceeds config.MaxImageSize and the aspect ratio is kept
+func scaleDPR(width, height int, dpr float64) (int, int) {
+	if dpr == 1 {
+		return width, height
+	}
+	dpr = math.Min(dpr, float64(config.MaxImageSize)/float64(max(width, height)))
+	return int(math.Round(float64(width) * dpr)), int(math.Round(float64(height) * dpr))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	_ "image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientHintsDPR(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		expectedWidth  int
+		expectedHeight int
+		vary           bool
+	}{
+		{"No hint", "/avatar/Jane%20Doe.png?size=64", nil, 64, 64, true},
+		{"DPR hint doubles", "/avatar/Jane%20Doe.png?size=64", map[string]string{"DPR": "2"}, 128, 128, true},
+		{"Sec-CH-DPR hint", "/placeholder/100x50.png", map[string]string{"Sec-CH-DPR": "2"}, 200, 100, true},
+		{"Fractional hint", "/avatar/Jane%20Doe.png?size=64", map[string]string{"Sec-CH-DPR": "1.5"}, 96, 96, true},
+		{"Sec-CH-DPR wins over DPR", "/avatar/Jane%20Doe.png?size=64", map[string]string{"Sec-CH-DPR": "3", "DPR": "2"}, 192, 192, true},
+		{"Explicit param wins", "/avatar/Jane%20Doe.png?size=64&dpr=1", map[string]string{"DPR": "2"}, 64, 64, false},
+		{"Explicit param without hint", "/avatar/Jane%20Doe.png?size=64&dpr=3", nil, 192, 192, false},
+		{"Invalid hint is ignored", "/avatar/Jane%20Doe.png?size=64", map[string]string{"DPR": "retina"}, 64, 64, true},
+		{"Hint is clamped", "/avatar/Jane%20Doe.png?size=64", map[string]string{"DPR": "10"}, 256, 256, true},
+		{"Capped at the maximum size", "/avatar/Jane%20Doe.png?size=4000x2000", map[string]string{"DPR": "2"}, 4096, 2048, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Accept-CH"); got != acceptClientHints {
+				t.Fatalf("expected Accept-CH %q got %q", acceptClientHints, got)
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if cfg.Width != tt.expectedWidth || cfg.Height != tt.expectedHeight {
+				t.Fatalf("expected %dx%d got %dx%d", tt.expectedWidth, tt.expectedHeight, cfg.Width, cfg.Height)
+			}
+			if got := strings.Contains(rec.Header().Get("Vary"), "Sec-CH-DPR"); got != tt.vary {
+				t.Fatalf("expected Vary on the hint %t, got %q", tt.vary, rec.Header().Get("Vary"))
+			}
+		})
+	}
+
+	t.Run("SVG ignores the hint", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?size=64", nil)
+		req.Header.Set("DPR", "2")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if !strings.Contains(rec.Body.String(), `width="64" height="64"`) {
+			t.Fatalf("expected a 64x64 SVG in %s", rec.Body.String())
+		}
+		if rec.Header().Get("Accept-CH") == "" || rec.Header().Get("Vary") != "" {
+			t.Fatalf("expected Accept-CH without Vary, got %v", rec.Header())
+		}
+	})
+
+	t.Run("Invalid param", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe.png?dpr=5", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"param":"dpr"`) {
+			t.Fatalf("expected 400 for dpr got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("Param with SVG conflicts", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/placeholder/100x50?dpr=2", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected 422 got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"grout/internal/render"
+)
+
+// paramConflict is a combination of parameters that are valid on their own but make no
+// sense together. Rather than silently ignoring one of them, the request is rejected with 422.
+type paramConflict struct {
+	param   string // Reported as the offending parameter
+	message string
+	applies func(query url.Values, format render.ImageFormat) bool
+}
+
+// isTrue matches the "1"/"true" spelling used by boolean query flags
+func isTrue(value string) bool {
+	return value == "1" || value == "true"
+}
+
+// metaEmbedConflict rejects meta=1 on formats that have nowhere to carry the provenance record
+var metaEmbedConflict = paramConflict{
+	param:   "meta",
+	message: "meta=1 embeds provenance in SVG and PNG output only",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		meta := q.Get("meta")
+		return (meta == metaEmbed || meta == "true") && format != render.FormatSVG && format != render.FormatPNG
+	},
+}
+
+// dprConflict rejects dpr on vector output, which is resolution independent
+var dprConflict = paramConflict{
+	param:   "dpr",
+	message: "dpr only applies to raster output; request .png, .jpg, .gif or .webp",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		return q.Get("dpr") != "" && !format.IsRaster()
+	},
+}
+
+// colorProfileConflict rejects colorProfile on SVG, which has no profile to tag
+var colorProfileConflict = paramConflict{
+	param:   "colorProfile",
+	message: "colorProfile only applies to raster output; request .png or .jpg",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		return q.Get("colorProfile") != "" && !format.IsRaster()
+	},
+}
+
+// opacityConflict rejects a reduced opacity on formats without partial transparency,
+// where the faded pixels would turn dark instead of see-through
+var opacityConflict = paramConflict{
+	param:   "opacity",
+	message: "opacity needs partial transparency; request .svg, .png or .webp",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		return q.Get("opacity") != "" && q.Get("opacity") != "100" &&
+			(format == render.FormatJPG || format == render.FormatJPEG || format == render.FormatGIF)
+	},
+}
+
+// tailConflict rejects tail on shapes without a speech bubble tail
+var tailConflict = paramConflict{
+	param:   "tail",
+	message: "tail only applies to shape=bubble",
+	applies: func(q url.Values, _ render.ImageFormat) bool {
+		return q.Get("tail") != "" && !strings.EqualFold(q.Get("shape"), string(render.ShapeBubble))
+	},
+}
+
+// cssVarsConflict rejects cssVars on raster output, whose colors are fixed once drawn
+var cssVarsConflict = paramConflict{
+	param:   "cssVars",
+	message: "cssVars only applies to SVG output; remove it or request .svg",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		return isTrue(q.Get("cssVars")) && format.IsRaster()
+	},
+}
+
+// gammaCorrectConflict rejects gammaCorrect on vector output, which the viewer blends
+var gammaCorrectConflict = paramConflict{
+	param:   "gammaCorrect",
+	message: "gammaCorrect only applies to raster output; request .png, .jpg, .gif or .webp",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		return q.Get("gammaCorrect") != "" && !format.IsRaster()
+	},
+}
+
+// qualityConflict rejects quality on formats without a lossy encoder to tune, e.g.
+// format=svg&quality=50
+var qualityConflict = paramConflict{
+	param:   "quality",
+	message: "quality only applies to JPEG and WebP output; request .jpg or .webp",
+	applies: func(q url.Values, format render.ImageFormat) bool {
+		return q.Get("quality") != "" && format != render.FormatJPG && format != render.FormatJPEG && format != render.FormatWebP
+	},
+}
+
+// flipTextConflict rejects flipText without a flip for it to apply to
+var flipTextConflict = paramConflict{
+	param:   "flipText",
+	message: "flipText only applies with flip",
+	applies: func(q url.Values, _ render.ImageFormat) bool {
+		return q.Get("flipText") != "" && q.Get("flip") == ""
+	},
+}
+
+var avatarConflicts = []paramConflict{
+	metaEmbedConflict,
+	dprConflict,
+	colorProfileConflict,
+	opacityConflict,
+	flipTextConflict,
+	tailConflict,
+	cssVarsConflict,
+	gammaCorrectConflict,
+	qualityConflict,
+	{
+		param:   "cssVars",
+		message: "pixelate draws blocks of fixed colors, which cssVars cannot recolor; remove one of them",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return isTrue(q.Get("cssVars")) && q.Get("pixelate") != ""
+		},
+	},
+	{
+		param:   "animate",
+		message: "animate only applies to SVG output; remove it or request .svg",
+		applies: func(q url.Values, format render.ImageFormat) bool {
+			return q.Get("animate") != "" && format.IsRaster()
+		},
+	},
+	{
+		param:   "symbol",
+		message: "symbol only applies to SVG output; remove it or request .svg",
+		applies: func(q url.Values, format render.ImageFormat) bool {
+			return isTrue(q.Get("symbol")) && format != render.FormatSVG
+		},
+	},
+	{
+		param:   "pot",
+		message: "pot only applies to raster output; request .png, .jpg, .gif or .webp",
+		applies: func(q url.Values, format render.ImageFormat) bool {
+			return q.Get("pot") != "" && !format.IsRaster()
+		},
+	},
+	{
+		param:   "radius",
+		message: "radius only applies to shape=rounded",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("radius") != "" && !strings.EqualFold(q.Get("shape"), string(render.ShapeRounded))
+		},
+	},
+	{
+		param:   "letterSpacing",
+		message: "letterSpacing does not apply to style=tiles, which places each initial in its own tile",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("letterSpacing") != "" && strings.EqualFold(q.Get("style"), string(render.StyleTiles))
+		},
+	},
+	{
+		param:   "textGradient",
+		message: "textGradient replaces the text color; remove color or style=tiles",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("textGradient") != "" && (q.Get("color") != "" || strings.EqualFold(q.Get("style"), string(render.StyleTiles)))
+		},
+	},
+	{
+		param:   "initialsMode",
+		message: "initialsMode and maxInitials do not apply to style=wordmark, which renders the whole name",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return (q.Get("initialsMode") != "" || q.Get("maxInitials") != "") && strings.EqualFold(q.Get("style"), string(render.StyleWordmark))
+		},
+	},
+	{
+		param:   "initialsLayout",
+		message: "initialsLayout only applies to the default style; remove it or style",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			style := strings.ToLower(q.Get("style"))
+			return q.Get("initialsLayout") != "" && style != "" && style != "default"
+		},
+	},
+	{
+		param:   "letterSpacing",
+		message: "letterSpacing spreads initials along a line and does not apply to initialsLayout=vertical",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("letterSpacing") != "" && strings.EqualFold(q.Get("initialsLayout"), string(render.InitialsVertical))
+		},
+	},
+	{
+		param:   "tagline",
+		message: "tagline sits below the initials and needs a square, rounded or bubble avatar without ring",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			circle := strings.EqualFold(q.Get("shape"), string(render.ShapeCircle)) || (q.Get("shape") == "" && q.Get("rounded") == "true")
+			return q.Get("tagline") != "" && (circle || isTrue(q.Get("ring")))
+		},
+	},
+	{
+		param:   "ring",
+		message: "ring follows a circle and does not apply to shape=bubble",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return isTrue(q.Get("ring")) && strings.EqualFold(q.Get("shape"), string(render.ShapeBubble))
+		},
+	},
+	{
+		param:   "ring",
+		message: "ring does not apply to style=tiles, which fills the avatar with tiles",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return isTrue(q.Get("ring")) && strings.EqualFold(q.Get("style"), string(render.StyleTiles))
+		},
+	},
+	{
+		param:   "saturation",
+		message: "grayscale=1 already sets saturation to 0; remove one of them",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("saturation") != "" && isTrue(q.Get("grayscale"))
+		},
+	},
+	{
+		param:   "hueRange",
+		message: "hueRange constrains the name-derived background; add background=random",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			bg := q.Get("background")
+			if bg == "" {
+				bg = q.Get("bg")
+			}
+			return q.Get("hueRange") != "" && !strings.EqualFold(bg, "random")
+		},
+	},
+	{
+		param:   "hueRange",
+		message: "hueRange does not apply to style=tiles, which colors each tile from the palette",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("hueRange") != "" && strings.EqualFold(q.Get("style"), string(render.StyleTiles))
+		},
+	},
+	{
+		param:   "badgeCorner",
+		message: "badgeCorner only applies with badge or badgeColor",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("badgeCorner") != "" && q.Get("badge") == "" && q.Get("badgeColor") == ""
+		},
+	},
+}
+
+var placeholderConflicts = []paramConflict{
+	metaEmbedConflict,
+	dprConflict,
+	colorProfileConflict,
+	opacityConflict,
+	flipTextConflict,
+	tailConflict,
+	cssVarsConflict,
+	gammaCorrectConflict,
+	qualityConflict,
+	{
+		param:   "icon",
+		message: "icon replaces the text; remove text, quote or joke",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("icon") != "" && (q.Get("text") != "" || isTrue(q.Get("quote")) || isTrue(q.Get("joke")))
+		},
+	},
+	{
+		param:   "qr",
+		message: "qr replaces the text; remove text, icon, quote or joke",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("qr") != "" && (q.Get("text") != "" || q.Get("icon") != "" || isTrue(q.Get("quote")) || isTrue(q.Get("joke")))
+		},
+	},
+	{
+		param:   "qrLevel",
+		message: "qrLevel only applies with qr",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("qrLevel") != "" && q.Get("qr") == ""
+		},
+	},
+	{
+		param:   "tile",
+		message: "tile repeats the text, but icon removes it",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return isTrue(q.Get("tile")) && q.Get("icon") != ""
+		},
+	},
+	{
+		param:   "labelRound",
+		message: "labelRound only applies to the default dimension label; remove text or icon",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("labelRound") != "" && (q.Get("text") != "" || q.Get("icon") != "")
+		},
+	},
+	{
+		param:   "category",
+		message: "category only applies with quote=1 or joke=1",
+		applies: func(q url.Values, _ render.ImageFormat) bool {
+			return q.Get("category") != "" && !isTrue(q.Get("quote")) && !isTrue(q.Get("joke"))
+		},
+	},
+}
+
+// findConflicts returns an error for every rule the request's parameters break
+func findConflicts(query url.Values, format render.ImageFormat, rules []paramConflict) paramErrors {
+	var errs paramErrors
+	for _, rule := range rules {
+		if rule.applies(query, format) {
+			errs.add(rule.param, "%s", rule.message)
+		}
+	}
+	return errs
+}
+
+// writeParamConflicts responds with 422 and all conflicting parameter combinations as JSON
+func writeParamConflicts(w http.ResponseWriter, errs paramErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":  "conflicting parameters",
+		"errors": errs,
+	})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParamConflicts(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedParam  string
+	}{
+		{"Animation on raster avatar", "/avatar/Jane%20Doe.png?animate=pulse", http.StatusUnprocessableEntity, "animate"},
+		{"Radius without rounded shape", "/avatar/Jane%20Doe?shape=circle&radius=12", http.StatusUnprocessableEntity, "radius"},
+		{"Letter spacing with tiles", "/avatar/Jane%20Doe?style=tiles&letterSpacing=2", http.StatusUnprocessableEntity, "letterSpacing"},
+		{"Icon with text", "/placeholder/300x200?icon=user&text=Hello", http.StatusUnprocessableEntity, "icon"},
+		{"Tile with icon", "/placeholder/300x200?icon=user&tile=1", http.StatusUnprocessableEntity, "tile"},
+		{"Category without quote or joke", "/placeholder/300x200?category=science", http.StatusUnprocessableEntity, "category"},
+		{"Quality on SVG", "/avatar/Jane%20Doe?format=svg&quality=50", http.StatusUnprocessableEntity, "quality"},
+		{"Quality on PNG placeholder", "/placeholder/300x200.png?quality=50", http.StatusUnprocessableEntity, "quality"},
+		{"Animation on SVG avatar", "/avatar/Jane%20Doe.svg?animate=pulse", http.StatusOK, ""},
+		{"Radius with rounded shape", "/avatar/Jane%20Doe?shape=rounded&radius=12", http.StatusOK, ""},
+		{"Category with quote", "/placeholder/400x200?quote=1&category=inspirational", http.StatusOK, ""},
+		{"Quality on JPEG", "/avatar/Jane%20Doe.jpg?quality=50", http.StatusOK, ""},
+		// Invalid values are reported as 400 before combinations are considered
+		{"Invalid value wins", "/avatar/Jane%20Doe.png?animate=wobble", http.StatusBadRequest, "animate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if tt.expectedParam == "" {
+				return
+			}
+			var body struct {
+				Errors []paramError `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if len(body.Errors) != 1 || body.Errors[0].Param != tt.expectedParam || body.Errors[0].Message == "" {
+				t.Fatalf("expected one error for %s got %+v", tt.expectedParam, body.Errors)
+			}
+		})
+	}
+}
@@ -1,18 +1,26 @@
 package handlers
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/golang-lru/v2"
 
 	"grout/internal/config"
 	"grout/internal/content"
+	"grout/internal/middleware"
 	"grout/internal/render"
 )
 
@@ -28,6 +36,16 @@ type Service struct {
 	cache          *lru.Cache[string, []byte]
 	cfg            config.ServerConfig
 	contentManager *content.Manager
+	staticFiles    *staticFileCache
+	precompressed  *precompressedCache
+	// compression mirrors the compression middleware's settings for precompressed responses
+	compression middleware.CompressionConfig
+	// idempotency replays batch responses by Idempotency-Key; nil when cfg.IdempotencyTTL is 0
+	idempotency *idempotencyCache
+	// encodeSlots bounds concurrent raster renders; nil when cfg.RasterEncodeWorkers is 0
+	encodeSlots chan struct{}
+	// maintenance starts from cfg.MaintenanceMode and can be switched through /admin/maintenance
+	maintenance atomic.Bool
 }
 
 // NewService wires the handler dependencies.
@@ -37,7 +55,12 @@ func NewService(renderer *render.Renderer, cache *lru.Cache[string, []byte], cfg
 		// Content manager is optional - quotes/jokes will be unavailable but service will still work
 		contentManager = nil
 	}
-	return &Service{renderer: renderer, cache: cache, cfg: cfg, contentManager: contentManager}
+	s := &Service{renderer: renderer, cache: cache, cfg: cfg, contentManager: contentManager, staticFiles: newStaticFileCache(), precompressed: newPrecompressedCache(), compression: middleware.NewCompressionConfig(cfg), idempotency: newIdempotencyCache(cfg.IdempotencyTTL)}
+	if cfg.RasterEncodeWorkers > 0 {
+		s.encodeSlots = make(chan struct{}, cfg.RasterEncodeWorkers)
+	}
+	s.maintenance.Store(cfg.MaintenanceMode)
+	return s
 }
 
 // RegisterRoutes attaches handlers to the provided mux.
@@ -55,16 +78,40 @@ func (s *Service) RegisterRoutes(mux *http.ServeMux, rateLimiter interface{}) {
 		applyRateLimit = func(h http.Handler) http.Handler { return h }
 	}
 
+	// Hotlink protection only covers the images other sites could embed
+	protectHotlinks := middleware.HotlinkMiddleware(middleware.HotlinkConfig{
+		AllowedHosts:      s.cfg.HotlinkAllowedHosts,
+		AllowEmptyReferer: s.cfg.HotlinkAllowEmptyReferer,
+	})
+
 	mux.HandleFunc("/", s.handleHome)
 	mux.HandleFunc("/play", s.handlePlay)
-	// Apply rate limiting to image generation endpoints
-	mux.Handle("/avatar/", applyRateLimit(http.HandlerFunc(s.handleAvatar)))
-	mux.Handle("/placeholder/", applyRateLimit(http.HandlerFunc(s.handlePlaceholder)))
-	// No rate limiting for health, favicon, robots.txt, sitemap.xml
+	// Apply rate limiting to image generation endpoints, which maintenance mode switches off
+	mux.Handle("/avatar/", applyRateLimit(s.unlessMaintenance(protectHotlinks(http.HandlerFunc(s.handleAvatar)))))
+	mux.Handle("/placeholder/", applyRateLimit(s.unlessMaintenance(protectHotlinks(http.HandlerFunc(s.handlePlaceholder)))))
+	mux.Handle("POST /batch", applyRateLimit(s.idempotent(s.unlessMaintenance(http.HandlerFunc(s.handleBatch)))))
+	mux.Handle("POST /batch/sprite", applyRateLimit(s.idempotent(s.unlessMaintenance(http.HandlerFunc(s.handleSprite)))))
+	// No rate limiting for health, capabilities, favicon, robots.txt, sitemap.xml and static assets
 	mux.HandleFunc("GET /health", s.HandleHealth)
+	mux.HandleFunc("GET /capabilities", s.handleCapabilities)
 	mux.HandleFunc("GET /favicon.ico", s.handleFavicon)
 	mux.HandleFunc("GET /robots.txt", s.handleRobotsTxt)
 	mux.HandleFunc("GET /sitemap.xml", s.handleSitemapXml)
+	mux.HandleFunc("GET /static/{path...}", s.handleStaticAsset)
+	// With a separate admin listener the admin endpoints are only registered there
+	if s.cfg.AdminAddr == "" {
+		s.RegisterAdminRoutes(mux)
+	}
+}
+
+// RegisterAdminRoutes wires the /admin endpoints into mux. They only exist when a token is
+// configured. RegisterRoutes calls it unless cfg.AdminAddr asks for a separate listener.
+func (s *Service) RegisterAdminRoutes(mux *http.ServeMux) {
+	if s.cfg.AdminToken == "" {
+		return
+	}
+	mux.Handle("GET /admin/maintenance", s.requireAdmin(http.HandlerFunc(s.handleMaintenance)))
+	mux.Handle("POST /admin/maintenance", s.requireAdmin(http.HandlerFunc(s.handleMaintenance)))
 }
 
 var placeholderRegex = regexp.MustCompile(`^(\d+)x(\d+)$`)
@@ -105,42 +152,232 @@ func getContentType(format render.ImageFormat) string {
 		return "image/webp"
 	case render.FormatSVG:
 		return "image/svg+xml"
+	case render.FormatJSX:
+		return "text/plain; charset=utf-8"
+	case render.FormatICO:
+		return "image/x-icon"
 	default:
 		return "image/svg+xml"
 	}
 }
 
-func (s *Service) serveImage(w http.ResponseWriter, r *http.Request, cacheKey string, format render.ImageFormat, generator func() ([]byte, error)) {
+// imageCacheControl composes the Cache-Control for image responses, appending the
+// configured shared-cache directives to the browser max-age
+func (s *Service) imageCacheControl() string {
+	cc := "public, max-age=31536000, immutable"
+	if s.cfg.CacheSMaxAge > 0 {
+		cc += fmt.Sprintf(", s-maxage=%d", s.cfg.CacheSMaxAge)
+	}
+	if s.cfg.CacheStaleIfError > 0 {
+		cc += fmt.Sprintf(", stale-if-error=%d", s.cfg.CacheStaleIfError)
+	}
+	return cc
+}
+
+func (s *Service) serveImage(w http.ResponseWriter, r *http.Request, cacheKey string, format render.ImageFormat, generator func(io.Writer) error) {
+	if s.cfg.LowMemory && format.IsRaster() {
+		writeJSONError(w, http.StatusNotAcceptable, fmt.Sprintf("%s output is disabled in low-memory mode; request SVG instead", format))
+		return
+	}
+
 	etag := fmt.Sprintf("\"%x\"", md5.Sum([]byte(cacheKey)))
 
 	w.Header().Set("Content-Type", getContentType(format))
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Cache-Control", s.imageCacheControl())
 	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-CH", acceptClientHints)
+
+	// A cache bypass forces a fresh render, so conditional requests are not short-circuited either
+	bypass := s.cfg.AllowCacheBypass && wantsCacheBypass(r)
 
-	if r.Header.Get("If-None-Match") == etag {
+	if bypass {
+		// Drop the validator so writeImage cannot turn the fresh render into a 304 either
+		r = r.Clone(r.Context())
+		r.Header.Del("If-None-Match")
+	} else if r.Header.Get("If-None-Match") == etag {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	if imgData, ok := s.cache.Get(cacheKey); ok {
-		w.Header().Set("X-Cache", "HIT")
-		_, _ = w.Write(imgData)
+	storeKey := s.cacheStoreKey(cacheKey)
+	if !bypass {
+		if imgData, ok := s.cache.Get(storeKey); ok {
+			w.Header().Set("X-Cache", "HIT")
+			writeImage(w, r, format, imgData)
+			return
+		}
+	}
+
+	xCache := "MISS"
+	if bypass {
+		xCache = "BYPASS"
+	}
+	// A bypass refreshes the cached copy unless write-back is off, leaving the cache as it was
+	writeBack := !bypass || s.cfg.CacheBypassWriteBack
+
+	// The pooled buffer is reused once this request returns, so the cache gets a copy
+	buf := getRenderBuffer()
+	defer putRenderBuffer(buf)
+	// HEAD needs the length up front, so its SVG is rendered into the cache like raster output
+	// and the GET that usually follows is a hit
+	if format == render.FormatSVG && r.Method != http.MethodHead {
+		// SVG is streamed to the client as it is generated, keeping a copy for the cache.
+		// RenderSVG buffers its first few kilobytes, so most errors arrive before anything
+		// is written and still become an error page.
+		w.Header().Set("X-Cache", xCache)
+		sw := &countingWriter{w: w}
+		if err := generator(io.MultiWriter(sw, buf)); err != nil {
+			log.Printf("stream %s: %v", storeKey, err)
+			if sw.n > 0 {
+				// The 200 is already out; abort the connection so the truncated document
+				// is not cached as a complete image
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Del("Content-Type")
+			w.Header().Del("Cache-Control")
+			w.Header().Del("ETag")
+			w.Header().Del("X-Cache")
+			w.Header().Del("Content-Disposition")
+			s.serveErrorPage(w, http.StatusInternalServerError, "Failed to generate image. Please try again later or contact support if the problem persists.")
+			return
+		}
+		if writeBack {
+			s.cache.Add(storeKey, bytes.Clone(buf.Bytes()))
+		}
 		return
 	}
 
-	imgData, err := generator()
+	// Raster renders take an encode slot so a burst of large images cannot exhaust memory
+	release, ok := s.acquireEncodeSlot(r.Context(), format)
+	if !ok {
+		w.Header().Del("Cache-Control")
+		w.Header().Del("ETag")
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Retry-After", strconv.Itoa(encodeRetryAfter))
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Sprintf("too many %s images are being rendered; retry shortly or request SVG", format))
+		return
+	}
+	err := generator(buf)
+	release()
 	if err != nil {
 		// Clear headers set earlier since we're serving HTML now
 		w.Header().Del("Content-Type")
 		w.Header().Del("Cache-Control")
 		w.Header().Del("ETag")
+		w.Header().Del("Content-Disposition")
 		s.serveErrorPage(w, http.StatusInternalServerError, "Failed to generate image. Please try again later or contact support if the problem persists.")
 		return
 	}
 
-	s.cache.Add(cacheKey, imgData)
-	w.Header().Set("X-Cache", "MISS")
-	_, _ = w.Write(imgData)
+	data := bytes.Clone(buf.Bytes())
+	if writeBack {
+		s.cache.Add(storeKey, data)
+	}
+	w.Header().Set("X-Cache", xCache)
+	writeImage(w, r, format, data)
+}
+
+// writeImage writes a rendered image. Raster images go through http.ServeContent, which
+// answers Range requests with 206 Partial Content; with If-Range the range is only served
+// while the validator still matches the ETag, otherwise the full image is sent.
+// HEAD requests get the same headers, Content-Length included, without the body.
+func writeImage(w http.ResponseWriter, r *http.Request, format render.ImageFormat, data []byte) {
+	if format == render.FormatSVG {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(data)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// countingWriter counts the bytes written through it, to tell whether a response has started
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// hashedKeyPrefix marks LRU keys that are a hash of the real cache key
+const hashedKeyPrefix = "sha256:"
+
+// cacheStoreKey returns the LRU key for cacheKey: the key itself when short, otherwise its
+// SHA-256 hex digest so very long parameters cannot bloat the cache's memory. Verbatim keys
+// never start with hashedKeyPrefix, so a hash cannot collide with a short key.
+func (s *Service) cacheStoreKey(cacheKey string) string {
+	if len(cacheKey) <= s.cfg.MaxCacheKeyLength && !strings.HasPrefix(cacheKey, hashedKeyPrefix) {
+		return cacheKey
+	}
+	return fmt.Sprintf("%s%x", hashedKeyPrefix, sha256.Sum256([]byte(cacheKey)))
+}
+
+// wantsStandalone reports whether the request asks for a downloadable standalone file
+func wantsStandalone(r *http.Request) bool {
+	v := r.URL.Query().Get("standalone")
+	return v == "1" || v == "true"
+}
+
+var filenameUnsafeRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// setAttachment marks the response as a download named from the given parts and format,
+// e.g. "avatar-jane-doe-128x128.svg".
+func setAttachment(w http.ResponseWriter, format render.ImageFormat, parts ...string) {
+	var clean []string
+	for _, part := range parts {
+		part = strings.Trim(filenameUnsafeRegex.ReplaceAllString(strings.ToLower(part), "-"), "-")
+		if part != "" {
+			clean = append(clean, part)
+		}
+	}
+	filename := strings.Join(clean, "-") + "." + string(format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+}
+
+// wantsCacheBypass reports whether the request asks to skip the cache via ?nocache= or ?fresh=
+func wantsCacheBypass(r *http.Request) bool {
+	for _, param := range []string{"nocache", "fresh"} {
+		if v := r.URL.Query().Get(param); v == "1" || v == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// palette returns the colors of the named palette, falling back to the configured default.
+// A nil result selects the built-in hash-derived colors.
+func (s *Service) palette(name string) []string {
+	if colors, ok := s.cfg.Palettes[name]; ok {
+		return colors
+	}
+	return s.cfg.Palettes[s.cfg.DefaultPalette]
+}
+
+// colorHash returns the configured hash for name-derived colors; config only keeps known names
+func (s *Service) colorHash() render.HashAlgorithm {
+	algo, _ := render.ParseHashAlgorithm(s.cfg.ColorHash)
+	return algo
+}
+
+// encoding returns the configured raster encoder settings. A quality of 1-100 from the
+// request replaces the configured JPEG and WebP qualities; 0 keeps them.
+func (s *Service) encoding(quality int) render.Encoding {
+	enc := render.Encoding{
+		JPEGQuality:    s.cfg.JPEGQuality,
+		WebPQuality:    s.cfg.WebPQuality,
+		WebPLossless:   s.cfg.WebPLossless,
+		PNGCompression: render.PNGCompression(s.cfg.PNGCompression),
+	}
+	if quality > 0 {
+		enc.JPEGQuality, enc.WebPQuality = quality, quality
+	}
+	return enc
 }
 
 // setSecurityHeaders applies security headers to HTML responses
@@ -154,9 +391,15 @@ func setSecurityHeaders(w http.ResponseWriter) {
 func (s *Service) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(map[string]string{
-		"status":  "healthy",
-		"version": "1.0.0",
+	// Maintenance keeps the 200 so orchestrators do not restart instances that are shedding load
+	status := "healthy"
+	if s.maintenance.Load() {
+		status = "maintenance"
+	}
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"status":      status,
+		"version":     "1.0.0",
+		"maintenance": s.maintenance.Load(),
 	})
 	if err != nil {
 		return
@@ -1,15 +1,28 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/chai2010/webp"
+	"github.com/fogleman/gg"
 	"github.com/hashicorp/golang-lru/v2"
 
 	"grout/internal/config"
+	"grout/internal/middleware"
 	"grout/internal/render"
+	"grout/pkg/urlbuilder"
 )
 
 func TestAvatarHandlerDefaults(t *testing.T) {
@@ -205,6 +218,48 @@ func TestHomeHandler(t *testing.T) {
 	}
 }
 
+func TestHomeHandlerBaseURL(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.BaseURL = "https://img.example.com/grout"
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("expected content-type text/html; charset=utf-8 got %s", ct)
+	}
+
+	body := rec.Body.String()
+	expectedStrings := []string{
+		`<link rel="canonical" href="https://img.example.com/grout/">`,
+		"<code>https://img.example.com/grout/avatar/John+Doe?size=128</code>",
+		"<code>https://img.example.com/grout/placeholder/300x200</code>",
+		`<img src="/grout/avatar/John+Doe?size=128`,
+		`<img src="/grout/placeholder/300x200?bg=cccccc"`,
+		`href="/grout/play"`,
+	}
+	for _, expected := range expectedStrings {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected body to contain %q", expected)
+		}
+	}
+	if strings.Contains(body, "{{BASE_") {
+		t.Fatal("expected all base URL placeholders to be replaced")
+	}
+}
+
 func TestHomeHandlerNotFound(t *testing.T) {
 	renderer, err := render.New()
 	if err != nil {
@@ -659,6 +714,277 @@ func TestPlaceholderHandlerBackgroundParamConsistency(t *testing.T) {
 	}
 }
 
+func TestAvatarHandlerInitialsMode(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"Default takes first two words", "/avatar/Mary%20Jane%20Watson", ">MJ<"},
+		{"firstlast", "/avatar/Mary%20Jane%20Watson?initialsMode=firstlast", ">MW<"},
+		{"firstn", "/avatar/Mary%20Jane%20Watson?initialsMode=firstn", ">MJ<"},
+		{"all", "/avatar/Mary%20Jane%20Watson?initialsMode=all", ">MJW<"},
+		{"all with maxInitials", "/avatar/Mary%20Jane%20Watson?initialsMode=all&maxInitials=2", ">MJ<"},
+		{"firstn with maxInitials", "/avatar/Mary%20Jane%20Watson?initialsMode=firstn&maxInitials=3", ">MJW<"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected body to contain %q, got: %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAvatarHandlerCustomPalette(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](10)
+	cfg := config.DefaultServerConfig()
+	cfg.Palettes = map[string][]string{
+		"brand": {"aa0000", "00aa00", "0000aa"},
+	}
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	fetch := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	for _, name := range []string{"Alice", "Bob", "Carol", "Dave"} {
+		body := fetch("/avatar/" + name + "?bg=random&palette=brand")
+		expected := render.ColorFromPalette(name, cfg.Palettes["brand"])
+		if !strings.Contains(body, `fill="#`+expected+`"`) {
+			t.Fatalf("expected %s to use palette color %s, got: %s", name, expected, body)
+		}
+		if again := fetch("/avatar/" + name + "?bg=random&palette=brand"); again != body {
+			t.Fatalf("expected stable output for %s", name)
+		}
+	}
+
+	// Without a palette the built-in hash colors are used
+	body := fetch("/avatar/Alice?bg=random")
+	if !strings.Contains(body, `fill="#`+render.GenerateColorHash("Alice")+`"`) {
+		t.Fatalf("expected built-in color, got: %s", body)
+	}
+
+	// A configured default palette applies when the request omits ?palette=
+	cfg.DefaultPalette = "brand"
+	svc = NewService(renderer, cache, cfg)
+	mux = http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+	body = fetch("/avatar/Alice?bg=random")
+	if !strings.Contains(body, `fill="#`+render.ColorFromPalette("Alice", cfg.Palettes["brand"])+`"`) {
+		t.Fatalf("expected default palette color, got: %s", body)
+	}
+}
+
+func TestCacheBypass(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowBypass   bool
+		writeBack     bool
+		path          string
+		expectedCache string
+		expectFresh   bool
+	}{
+		{"No bypass param reads cache", true, true, "/avatar/Jane", "HIT", false},
+		{"nocache skips cache read", true, true, "/avatar/Jane?nocache=1", "BYPASS", true},
+		{"fresh skips cache read", true, true, "/avatar/Jane?fresh=true", "BYPASS", true},
+		{"Config disables bypass", false, true, "/avatar/Jane?nocache=1", "HIT", false},
+		{"Bypass without write-back", true, false, "/avatar/Jane?nocache=1", "BYPASS", true},
+		{"Raster bypass without write-back", true, false, "/avatar/Jane.png?nocache=1", "BYPASS", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer, err := render.New()
+			if err != nil {
+				t.Fatalf("renderer init: %v", err)
+			}
+			cache, _ := lru.New[string, []byte](10)
+			cfg := config.DefaultServerConfig()
+			cfg.AllowCacheBypass = tt.allowBypass
+			cfg.CacheBypassWriteBack = tt.writeBack
+			svc := NewService(renderer, cache, cfg)
+			mux := http.NewServeMux()
+			svc.RegisterRoutes(mux, nil)
+
+			// Populate the cache, then replace the entry with a sentinel to detect cache reads
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, strings.Split(tt.path, "?")[0], nil))
+			keys := cache.Keys()
+			if len(keys) != 1 {
+				t.Fatalf("expected one cached entry, got %d", len(keys))
+			}
+			sentinel := []byte("cached-sentinel")
+			cache.Add(keys[0], sentinel)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			if xc := rec.Header().Get("X-Cache"); xc != tt.expectedCache {
+				t.Fatalf("expected X-Cache %s got %s", tt.expectedCache, xc)
+			}
+			gotSentinel := rec.Body.String() == string(sentinel)
+			if gotSentinel == tt.expectFresh {
+				t.Fatalf("expected fresh render %t, body: %s", tt.expectFresh, rec.Body.String())
+			}
+			cached, _ := cache.Get(keys[0])
+			switch {
+			case tt.expectFresh && tt.writeBack && string(cached) != rec.Body.String():
+				t.Fatal("expected fresh render to be written back to the cache")
+			case !tt.writeBack && string(cached) != string(sentinel):
+				t.Fatal("expected the cached copy to be left alone without write-back")
+			}
+			if cache.Len() != 1 {
+				t.Fatalf("expected the bypass to reuse the cache key, got %d entries", cache.Len())
+			}
+		})
+	}
+}
+
+func TestValidationErrorsAggregated(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		params []string
+	}{
+		{"Avatar size, bg and shape", "/avatar/Jane?size=abc&bg=zzz&shape=hexagon", []string{"size", "bg", "shape"}},
+		{"Avatar oversized and bad color", "/avatar/Jane?size=99999&color=ff0000,00ff00", []string{"size", "color"}},
+		{"Placeholder query dimensions and background", "/placeholder/?w=0&h=-5&background=nothex", []string{"w", "h", "background"}},
+		{"Placeholder oversized path dimensions", "/placeholder/5000x5000", []string{"width", "height"}},
+		{"Avatar name over the length cap", "/avatar/?name=" + strings.Repeat("a", config.DefaultMaxNameLength+1), []string{"name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 got %d", rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("expected application/json got %s", ct)
+			}
+
+			var body struct {
+				Errors []paramError `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if len(body.Errors) != len(tt.params) {
+				t.Fatalf("expected %d errors got %d: %+v", len(tt.params), len(body.Errors), body.Errors)
+			}
+			reported := make(map[string]bool)
+			for _, e := range body.Errors {
+				if e.Message == "" {
+					t.Errorf("expected a message for %q", e.Param)
+				}
+				reported[e.Param] = true
+			}
+			for _, param := range tt.params {
+				if !reported[param] {
+					t.Errorf("expected an error for %q, got %+v", param, body.Errors)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationAcceptsValidParams(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	for _, path := range []string{
+		"/avatar/Jane?size=64&bg=%23ff0000&shape=circle",
+		"/avatar/Jane?bg=ff0000,0000ff&color=fff&shape=square",
+		"/avatar/Jane?bg=random",
+		"/placeholder/4096x10",
+		"/avatar/?name=" + strings.Repeat("a", config.DefaultMaxNameLength),
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 got %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestAvatarSizeShorthand(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	valid := []struct {
+		name          string
+		path          string
+		width, height string
+	}{
+		{"WxH", "/avatar/Jane?size=128x256", "128", "256"},
+		{"Plain size is square", "/avatar/Jane?size=128", "128", "128"},
+		{"Uppercase separator", "/avatar/Jane?size=64X32", "64", "32"},
+		{"width overrides size", "/avatar/Jane?size=100&width=50", "50", "100"},
+	}
+	for _, tt := range valid {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+			}
+			expected := `width="` + tt.width + `" height="` + tt.height + `"`
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Fatalf("expected SVG with %s, got: %s", expected, rec.Body.String())
+			}
+		})
+	}
+
+	for _, size := range []string{"128x", "x128", "xx", "-128x64", "128x-64", "0x10", "128x5000", "12ax34", "1x2x3"} {
+		t.Run("Malformed "+size, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/avatar/Jane?size="+size, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), `"param":"size"`) {
+				t.Fatalf("expected size error, got: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
 // rateLimiterWrapper is a test helper that wraps a middleware function
 type rateLimiterWrapper struct {
 	middleware func(http.Handler) http.Handler
@@ -850,3 +1176,2485 @@ func TestSecurityHeadersNotPresentOnImageEndpoints(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamedSVGMatchesCachedCopy(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	var bodies []string
+	for _, expectedCache := range []string{"MISS", "HIT"} {
+		req := httptest.NewRequest(http.MethodGet, "/avatar/Stream%20Test?size=96", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		if got := rec.Header().Get("X-Cache"); got != expectedCache {
+			t.Fatalf("expected X-Cache %s got %s", expectedCache, got)
+		}
+		bodies = append(bodies, rec.Body.String())
+	}
+
+	if bodies[0] != bodies[1] {
+		t.Fatalf("expected cached SVG to equal the streamed response:\n%s\n---\n%s", bodies[0], bodies[1])
+	}
+	if !strings.HasSuffix(bodies[0], "</svg>") {
+		t.Fatalf("expected a complete SVG document, got %q", bodies[0])
+	}
+}
+
+func TestAvatarRoundedRadius(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		expectedRx string
+	}{
+		{"Percentage at 100px", "size=100&radius=25%25", `rx="25"`},
+		{"Percentage at 200px", "size=200&radius=25%25", `rx="50"`},
+		{"Percentage of smaller dimension", "size=300x200&radius=10%25", `rx="20"`},
+		{"Absolute pixels", "size=200&radius=12", `rx="12"`},
+		{"Over-large value clamped", "size=100&radius=1000", `rx="50"`},
+		{"Over-large percentage clamped", "size=100&radius=80%25", `rx="50"`},
+		{"Default radius", "size=100", `rx="15"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/avatar/JD?shape=rounded&"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.expectedRx) {
+				t.Fatalf("expected %s in %s", tt.expectedRx, rec.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/avatar/JD?shape=rounded&radius=-5", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for negative radius got %d", rec.Code)
+	}
+}
+
+func TestDownloadOption(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name                string
+		path                string
+		expectedCode        int
+		expectedDisposition string
+	}{
+		{"Derived avatar name", "/avatar/Jane%20Doe.png?download=1&size=64", http.StatusOK, `attachment; filename="avatar-jane-doe-64x64.png"`},
+		{"Derived placeholder name", "/placeholder/300x200.webp?download=true", http.StatusOK, `attachment; filename="placeholder-300x200.webp"`},
+		{"Format param picks the extension", "/avatar/Jane%20Doe?download=1&format=jpg", http.StatusOK, `attachment; filename="avatar-jane-doe-128x128.jpg"`},
+		{"Custom filename", "/avatar/Jane%20Doe.png?filename=Team%20Photo", http.StatusOK, `attachment; filename="team-photo.png"`},
+		{"Custom filename sanitized", "/avatar/Jane.png?download=1&filename=..%2F..%2Fetc%2F%22pass%22wd.svg", http.StatusOK, `attachment; filename="etc-pass-wd.png"`},
+		{"Matching extension kept once", "/placeholder/80x80?format=jsx&filename=hero.JSX", http.StatusOK, `attachment; filename="hero.jsx"`},
+		{"Inline without download", "/avatar/Jane%20Doe.png?download=0", http.StatusOK, ""},
+		{"Nothing left after sanitizing", "/avatar/Jane.png?filename=%2F%2F%2F", http.StatusBadRequest, ""},
+		{"Filename too long", "/avatar/Jane.png?filename=" + strings.Repeat("a", 101), http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tt.expectedDisposition {
+				t.Fatalf("expected Content-Disposition %q got %q", tt.expectedDisposition, got)
+			}
+		})
+	}
+}
+
+func TestStandaloneOption(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name                string
+		path                string
+		expectedDisposition string
+		expectDeclaration   bool
+	}{
+		{"Avatar SVG", "/avatar/Jane%20Doe?standalone=1", `attachment; filename="avatar-jane-doe-128x128.svg"`, true},
+		{"Placeholder SVG", "/placeholder/300x200?standalone=true", `attachment; filename="placeholder-300x200.svg"`, true},
+		{"Placeholder PNG", "/placeholder/300x200.png?standalone=1", `attachment; filename="placeholder-300x200.png"`, false},
+		{"Inline by default", "/avatar/Jane%20Doe", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != tt.expectedDisposition {
+				t.Fatalf("expected Content-Disposition %q got %q", tt.expectedDisposition, got)
+			}
+			body := rec.Body.String()
+			hasDeclaration := strings.HasPrefix(body, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`) && strings.Contains(body, "<!DOCTYPE svg")
+			if hasDeclaration != tt.expectDeclaration {
+				t.Fatalf("expected XML declaration %t, body starts with %q", tt.expectDeclaration, body[:min(len(body), 60)])
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersMiddlewareOnRoutes(t *testing.T) {
+	_, mux := setupTestService(t)
+	handler := middleware.SecurityHeadersMiddleware(middleware.SecurityHeadersPages, middleware.DefaultSecurityHeaders())(mux)
+
+	tests := []struct {
+		name          string
+		path          string
+		expectPageCSP bool
+	}{
+		{"Home page", "/", true},
+		{"Preview page", "/play", true},
+		{"Not found page", "/missing", false},
+		{"Health", "/health", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			for _, name := range []string{"Content-Security-Policy", "Referrer-Policy", "X-Content-Type-Options", "X-Frame-Options"} {
+				if rec.Header().Get(name) == "" {
+					t.Errorf("expected %s on %s", name, tt.path)
+				}
+			}
+			if tt.expectPageCSP && rec.Header().Get("Content-Security-Policy") != expectedSecurityHeaders()["Content-Security-Policy"] {
+				t.Errorf("expected the page CSP to be kept, got %q", rec.Header().Get("Content-Security-Policy"))
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/JD", nil))
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatal("expected nosniff on SVG avatar")
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "" {
+		t.Fatalf("did not expect CSP on image, got %q", csp)
+	}
+}
+
+func TestAvatarAnimateParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expected       string
+	}{
+		{"Pulse", "/avatar/JD?animate=pulse", http.StatusOK, "<animate "},
+		{"Spin", "/avatar/JD?animate=spin", http.StatusOK, "<animateTransform "},
+		{"Fade", "/avatar/JD?animate=fade", http.StatusOK, `fill="freeze"`},
+		{"Raster rejects animation", "/avatar/JD.png?animate=spin", http.StatusUnprocessableEntity, "animate"},
+		{"Unknown animation", "/avatar/JD?animate=wobble", http.StatusBadRequest, "animate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %q in response body", tt.expected)
+			}
+		})
+	}
+}
+
+func TestPlaceholderIcon(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	for _, name := range render.IconNames() {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/placeholder/200x100?icon="+name, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), `fill-rule="evenodd" d="`) {
+				t.Fatalf("expected an icon path in %s", rec.Body.String())
+			}
+			if strings.Contains(rec.Body.String(), "200 x 100") {
+				t.Fatal("expected the icon to replace the dimension text")
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/placeholder/200x100?icon=rocket", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown icon got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "must be one of file, image, photo, user") {
+		t.Fatalf("expected the icon names in the error, got %s", rec.Body.String())
+	}
+}
+
+func TestPlaceholderQR(t *testing.T) {
+	_, mux := setupTestService(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"SVG", "/placeholder/200x200?qr=https%3A%2F%2Fexample.org&bg=ffffff&color=000000", http.StatusOK, `fill="#000000" shape-rendering="crispEdges"`},
+		{"PNG", "/placeholder/200x200.png?qr=hello", http.StatusOK, "PNG"},
+		{"Level", "/placeholder/200x200?qr=hello&qrLevel=h", http.StatusOK, "crispEdges"},
+		{"Unknown level", "/placeholder/200x200?qr=hello&qrLevel=X", http.StatusBadRequest, "must be one of L, M, Q, H"},
+		{"Too long", "/placeholder/2000x2000?qrLevel=H&qr=" + strings.Repeat("x", 1274), http.StatusBadRequest, "too long for a QR code at error correction level H"},
+		{"Image too small", "/placeholder/20x20?qr=hello", http.StatusBadRequest, "needs an image of at least 29x29 pixels"},
+		{"With text", "/placeholder/200x200?qr=hello&text=hi", http.StatusUnprocessableEntity, "qr replaces the text"},
+		{"Level without qr", "/placeholder/200x200?qrLevel=L", http.StatusUnprocessableEntity, "qrLevel only applies with qr"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.path)
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %.200s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("Text and level are part of the cache key", func(t *testing.T) {
+		first := get("/placeholder/200x200?qr=hello").Body.String()
+		if get("/placeholder/200x200?qr=hello2").Body.String() == first {
+			t.Fatal("expected other text to render another code")
+		}
+		if get("/placeholder/200x200?qr=hello&qrLevel=H").Body.String() == first {
+			t.Fatal("expected another level to render another code")
+		}
+		if strings.Contains(first, "200 x 200") {
+			t.Fatal("expected the QR code to replace the dimension text")
+		}
+	})
+}
+
+func TestCacheKeyHashing(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](10)
+	cfg := config.DefaultServerConfig()
+	cfg.MaxCacheKeyLength = 64
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	t.Run("Short keys are used verbatim", func(t *testing.T) {
+		key := "PH:100:100:cccccc:000000:short"
+		if got := svc.cacheStoreKey(key); got != key {
+			t.Fatalf("expected %q got %q", key, got)
+		}
+	})
+
+	t.Run("Long keys are hashed", func(t *testing.T) {
+		key := "PH:100:100:cccccc:000000:" + strings.Repeat("x", 100)
+		got := svc.cacheStoreKey(key)
+		if !strings.HasPrefix(got, "sha256:") || len(got) != len("sha256:")+64 {
+			t.Fatalf("expected a sha256 hex key got %q", got)
+		}
+		if svc.cacheStoreKey(key) != got {
+			t.Fatal("expected hashing to be deterministic")
+		}
+	})
+
+	t.Run("Verbatim keys cannot look like hashes", func(t *testing.T) {
+		if got := svc.cacheStoreKey("sha256:abc"); got == "sha256:abc" {
+			t.Fatal("expected a key with the hash prefix to be hashed")
+		}
+	})
+
+	t.Run("Different long inputs do not collide", func(t *testing.T) {
+		cache.Purge()
+		textA := strings.Repeat("a", 200)
+		textB := strings.Repeat("a", 199) + "b"
+
+		var bodies []string
+		for _, text := range []string{textA, textB, textA} {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/placeholder/400x100?text="+text, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			bodies = append(bodies, rec.Body.String())
+		}
+
+		if cache.Len() != 2 {
+			t.Fatalf("expected 2 cache entries got %d", cache.Len())
+		}
+		for _, key := range cache.Keys() {
+			if !strings.HasPrefix(key, "sha256:") {
+				t.Fatalf("expected hashed LRU key got %q", key)
+			}
+		}
+		if bodies[0] == bodies[1] {
+			t.Fatal("expected different images for different text")
+		}
+		if bodies[2] != bodies[0] {
+			t.Fatal("expected the cached image for the first text")
+		}
+	})
+}
+
+func TestAvatarWeightParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expected       string
+	}{
+		{"Bold weight", "weight=bold", http.StatusOK, `font-weight="bold"`},
+		{"Regular weight", "weight=regular", http.StatusOK, `font-weight="normal"`},
+		{"Legacy bold flag", "bold=true", http.StatusOK, `font-weight="bold"`},
+		{"Weight overrides bold flag", "bold=true&weight=regular", http.StatusOK, `font-weight="normal"`},
+		{"Unregistered weight falls back to regular", "weight=light", http.StatusOK, `font-weight="normal"`},
+		{"Unknown weight", "weight=heavy", http.StatusBadRequest, "weight"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/JD?"+tt.query, nil))
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %q in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLowMemoryRefusesRaster(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.LowMemory = true
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	for _, path := range []string{"/avatar/JD.png", "/placeholder/200x100.webp"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotAcceptable {
+			t.Fatalf("%s: expected 406 got %d", path, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "disabled in low-memory mode") {
+			t.Fatalf("%s: expected a clear message, got %s", path, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/JD.svg", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected SVG to be served, got %d", rec.Code)
+	}
+}
+
+func TestRasterRangeRequests(t *testing.T) {
+	_, mux := setupTestService(t)
+	const path = "/avatar/JD.png?size=64"
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	full := rec.Body.Bytes()
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with ETag got %d", rec.Code)
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Fatal("expected Accept-Ranges: bytes on raster images")
+	}
+
+	tests := []struct {
+		name           string
+		ifRange        string
+		expectedStatus int
+		expectedBody   []byte
+	}{
+		{"Range without If-Range", "", http.StatusPartialContent, full[:10]},
+		{"Matching If-Range", etag, http.StatusPartialContent, full[:10]},
+		{"Stale If-Range", `"stale"`, http.StatusOK, full},
+		{"Date If-Range without Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT", http.StatusOK, full},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Range", "bytes=0-9")
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), tt.expectedBody) {
+				t.Fatalf("expected %d body bytes got %d", len(tt.expectedBody), rec.Body.Len())
+			}
+			if tt.expectedStatus == http.StatusPartialContent {
+				expectedRange := fmt.Sprintf("bytes 0-9/%d", len(full))
+				if got := rec.Header().Get("Content-Range"); got != expectedRange {
+					t.Fatalf("expected Content-Range %q got %q", expectedRange, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAvatarLetterSpacingParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		query          string
+		expectedStatus int
+		expected       string
+	}{
+		{"letterSpacing=3", http.StatusOK, `letter-spacing="3"`},
+		{"letterSpacing=3px", http.StatusOK, `letter-spacing="3"`},
+		{"letterSpacing=0.05em", http.StatusOK, `letter-spacing="3.2"`},
+		{"letterSpacing=wide", http.StatusBadRequest, "letterSpacing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/John%20Doe?"+tt.query, nil))
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %q in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestImageCacheControlDirectives(t *testing.T) {
+	tests := []struct {
+		name         string
+		sMaxAge      int
+		staleIfError int
+		expected     string
+	}{
+		{"Defaults", 0, 0, "public, max-age=31536000, immutable"},
+		{"s-maxage only", 86400, 0, "public, max-age=31536000, immutable, s-maxage=86400"},
+		{"stale-if-error only", 0, 3600, "public, max-age=31536000, immutable, stale-if-error=3600"},
+		{"Both directives", 86400, 604800, "public, max-age=31536000, immutable, s-maxage=86400, stale-if-error=604800"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer, err := render.New()
+			if err != nil {
+				t.Fatalf("renderer init: %v", err)
+			}
+			cache, _ := lru.New[string, []byte](1)
+			cfg := config.DefaultServerConfig()
+			cfg.CacheSMaxAge = tt.sMaxAge
+			cfg.CacheStaleIfError = tt.staleIfError
+			svc := NewService(renderer, cache, cfg)
+			mux := http.NewServeMux()
+			svc.RegisterRoutes(mux, nil)
+
+			for _, path := range []string{"/avatar/Jane%20Doe", "/placeholder/200x100.png"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s: expected 200 got %d", path, rec.Code)
+				}
+				if cc := rec.Header().Get("Cache-Control"); cc != tt.expected {
+					t.Fatalf("%s: expected Cache-Control %q got %q", path, tt.expected, cc)
+				}
+			}
+		})
+	}
+}
+
+func TestAvatarColorSalt(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	colorFor := func(query string) string {
+		return getColorMeta(t, mux, "/avatar/Jane%20Doe?background=random&meta=color"+query).Background
+	}
+
+	unsalted := colorFor("")
+	tenantA := colorFor("&salt=tenant-a")
+	tenantB := colorFor("&salt=tenant-b")
+
+	if tenantA != colorFor("&salt=tenant-a") {
+		t.Fatal("expected the same salt to yield the same color")
+	}
+	if tenantA == tenantB {
+		t.Fatalf("expected different salts to yield different colors, both got %s", tenantA)
+	}
+	if tenantA == unsalted {
+		t.Fatalf("expected a salted color to differ from the unsalted one, both got %s", tenantA)
+	}
+	if unsalted != "#"+render.ColorFromPalette("Jane Doe", nil) {
+		t.Fatalf("expected an empty salt to keep the unsalted color, got %s", unsalted)
+	}
+
+	t.Run("Configured salt", func(t *testing.T) {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](1)
+		cfg := config.DefaultServerConfig()
+		cfg.ColorSalt = "tenant-a"
+		svc := NewService(renderer, cache, cfg)
+		saltedMux := http.NewServeMux()
+		svc.RegisterRoutes(saltedMux, nil)
+
+		if got := getColorMeta(t, saltedMux, "/avatar/Jane%20Doe?background=random&meta=color").Background; got != tenantA {
+			t.Fatalf("expected configured salt to match ?salt=tenant-a (%s), got %s", tenantA, got)
+		}
+		if got := getColorMeta(t, saltedMux, "/avatar/Jane%20Doe?background=random&meta=color&salt=tenant-b").Background; got != tenantB {
+			t.Fatalf("expected ?salt= to override the configured salt (%s), got %s", tenantB, got)
+		}
+	})
+
+	t.Run("Salt too long", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?background=random&salt="+strings.Repeat("s", config.MaxSaltLength+1), nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 got %d", rec.Code)
+		}
+	})
+}
+
+func TestTileParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"Avatar", "/avatar/Jane%20Doe?tile=1", `<rect width="128" height="128" fill="url(#tile)" />`},
+		{"Placeholder emoji", "/placeholder/600x200?text=%F0%9F%8E%89&tile=true", ">🎉</text></pattern>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+
+	// The untiled image must not be served from the tiled image's cache entry
+	req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), "pattern") {
+		t.Fatalf("expected no tile without ?tile=1: %s", rec.Body.String())
+	}
+}
+
+func TestStrictParams(t *testing.T) {
+	newMux := func(strict bool) *http.ServeMux {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](1)
+		cfg := config.DefaultServerConfig()
+		cfg.StrictParams = strict
+		svc := NewService(renderer, cache, cfg)
+		mux := http.NewServeMux()
+		svc.RegisterRoutes(mux, nil)
+		return mux
+	}
+
+	tests := []struct {
+		name           string
+		strict         bool
+		path           string
+		expectedStatus int
+	}{
+		{"Lenient ignores unknown avatar param", false, "/avatar/Jane%20Doe?size=64&utm_source=mail", http.StatusOK},
+		{"Lenient ignores unknown placeholder param", false, "/placeholder/200x100?cb=123", http.StatusOK},
+		{"Strict rejects unknown avatar param", true, "/avatar/Jane%20Doe?size=64&utm_source=mail", http.StatusBadRequest},
+		{"Strict rejects unknown placeholder param", true, "/placeholder/200x100?cb=123", http.StatusBadRequest},
+		{"Strict rejects params of the other endpoint", true, "/avatar/Jane%20Doe?text=hi", http.StatusBadRequest},
+		{"Strict accepts known avatar params", true, "/avatar/Jane%20Doe?size=64&shape=rounded&radius=8&background=random&palette=&salt=a&tile=1&nocache=1", http.StatusOK},
+		{"Strict accepts known placeholder params", true, "/placeholder/200x100?text=Hero&bg=2c3e50&color=ecf0f1&standalone=1", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			newMux(tt.strict).ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("Every unknown param is reported", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/placeholder/200x100?zeta=1&alpha=2", nil)
+		rec := httptest.NewRecorder()
+		newMux(true).ServeHTTP(rec, req)
+
+		var body struct {
+			Errors []paramError `json:"errors"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(body.Errors) != 2 || body.Errors[0].Param != "alpha" || body.Errors[1].Param != "zeta" {
+			t.Fatalf("expected errors for alpha and zeta got %+v", body.Errors)
+		}
+		if body.Errors[0].Message != "unknown parameter" {
+			t.Fatalf("expected message %q got %q", "unknown parameter", body.Errors[0].Message)
+		}
+	})
+}
+
+func TestURLBuilderRoundTrip(t *testing.T) {
+	_, mux := setupTestService(t)
+	handler := http.StripPrefix("/grout", mux)
+	builder, err := urlbuilder.New("/grout")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200 got %d: %s", path, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	tests := []struct {
+		name        string
+		built       string
+		handWritten string
+		contentType string
+	}{
+		{"Avatar", builder.Avatar(urlbuilder.AvatarOptions{Name: "Jane Doe", Size: 96, Background: "#3498db", Color: "#ffffff", Shape: urlbuilder.ShapeCircle}),
+			"/grout/avatar/Jane%20Doe?size=96&shape=circle&background=3498db&color=ffffff", "image/svg+xml"},
+		{"Avatar raster", builder.Avatar(urlbuilder.AvatarOptions{Name: "Jane Doe", Size: 32, Format: urlbuilder.FormatPNG}),
+			"/grout/avatar/Jane%20Doe.png?size=32", "image/png"},
+		{"Avatar name with reserved characters", builder.Avatar(urlbuilder.AvatarOptions{Name: "Ren & Stimpy #2"}),
+			"/grout/avatar/?name=Ren%20%26%20Stimpy%20%232", "image/svg+xml"},
+		{"Avatar name with a slash", builder.Avatar(urlbuilder.AvatarOptions{Name: "AC/DC", Size: 32}),
+			"/grout/avatar/?name=AC%2FDC&size=32", "image/svg+xml"},
+		{"Avatar name ending in an extension", builder.Avatar(urlbuilder.AvatarOptions{Name: "logo.png"}),
+			"/grout/avatar/?name=logo.png", "image/svg+xml"},
+		{"Placeholder", builder.Placeholder(urlbuilder.PlaceholderOptions{Width: 300, Height: 200, Text: "50% off & more", Background: "#e74c3c,#3498db"}),
+			"/grout/placeholder/300x200?text=50%25%20off%20%26%20more&bg=e74c3c,3498db", "image/svg+xml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			built := serve(tt.built)
+			if ct := built.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Fatalf("expected Content-Type %s got %s", tt.contentType, ct)
+			}
+			if !bytes.Equal(built.Body.Bytes(), serve(tt.handWritten).Body.Bytes()) {
+				t.Fatalf("expected %s to render the same image as %s", tt.built, tt.handWritten)
+			}
+		})
+	}
+
+	t.Run("Raster avatar with the name in the query", func(t *testing.T) {
+		built := serve(builder.Avatar(urlbuilder.AvatarOptions{Name: "AC/DC", Size: 32, Format: urlbuilder.FormatPNG}))
+		if ct := built.Header().Get("Content-Type"); ct != "image/png" {
+			t.Fatalf("expected Content-Type image/png got %s", ct)
+		}
+		if bytes.Equal(built.Body.Bytes(), serve("/grout/avatar/.png?size=32").Body.Bytes()) {
+			t.Fatal("expected ?name= to be used rather than the default name")
+		}
+	})
+}
+
+func TestAvatarStyleTiles(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name          string
+		path          string
+		expectedTiles int
+	}{
+		{"Two initials", "/avatar/Jane%20Doe?style=tiles", 2},
+		{"One initial", "/avatar/Jane?style=tiles", 1},
+		{"Three initials", "/avatar/Jane%20Ann%20Doe?style=tiles&initialsMode=all&maxInitials=3", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+			}
+			svg := rec.Body.String()
+			if got := strings.Count(svg, "<text"); got != tt.expectedTiles {
+				t.Fatalf("expected %d tiles got %d: %s", tt.expectedTiles, got, svg)
+			}
+			colors := map[string]bool{}
+			for _, c := range render.DefaultTilePalette {
+				if strings.Contains(svg, `fill="#`+c+`" />`) {
+					colors[c] = true
+				}
+			}
+			if len(colors) != tt.expectedTiles {
+				t.Fatalf("expected %d distinct palette colors got %d: %s", tt.expectedTiles, len(colors), svg)
+			}
+		})
+	}
+
+	t.Run("Unknown style", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?style=bubbles", nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 got %d", rec.Code)
+		}
+	})
+}
+
+func TestAvatarLocaleParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedText   string
+	}{
+		{"Default uppercasing", "/avatar/istanbul", http.StatusOK, ">I</text>"},
+		{"Turkish locale", "/avatar/istanbul?locale=tr", http.StatusOK, ">İ</text>"},
+		{"German locale", "/avatar/%C3%9Fmith?locale=de-DE", http.StatusOK, ">SS</text>"},
+		{"Invalid locale", "/avatar/istanbul?locale=not_a_locale!", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedText != "" && !strings.Contains(rec.Body.String(), tt.expectedText) {
+				t.Fatalf("expected %s in %s", tt.expectedText, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("Configured default locale", func(t *testing.T) {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](1)
+		cfg := config.DefaultServerConfig()
+		cfg.Locale = "tr"
+		svc := NewService(renderer, cache, cfg)
+		trMux := http.NewServeMux()
+		svc.RegisterRoutes(trMux, nil)
+
+		rec := httptest.NewRecorder()
+		trMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/istanbul", nil))
+		if !strings.Contains(rec.Body.String(), ">İ</text>") {
+			t.Fatalf("expected the configured locale to apply: %s", rec.Body.String())
+		}
+	})
+}
+
+func TestBlurParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Avatar", "/avatar/Jane%20Doe?blur=8", http.StatusOK, `<feGaussianBlur stdDeviation="8"`},
+		{"Clamped", "/avatar/Jane%20Doe?blur=1000", http.StatusOK, `<feGaussianBlur stdDeviation="50"`},
+		{"Placeholder", "/placeholder/600x200?blur=4.5", http.StatusOK, `<feGaussianBlur stdDeviation="4.5"`},
+		{"Raster", "/avatar/Jane%20Doe.png?blur=8", http.StatusOK, ""},
+		{"Negative", "/avatar/Jane%20Doe?blur=-1", http.StatusBadRequest, `"param":"blur"`},
+		{"Not a number", "/placeholder/600x200?blur=soft", http.StatusBadRequest, `"param":"blur"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+
+	// The sharp image must not be served from the blurred image's cache entry
+	req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), "filter") {
+		t.Fatalf("expected no blur without ?blur: %s", rec.Body.String())
+	}
+}
+
+func TestAvatarBadgeParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Online", "/avatar/Jane%20Doe?badge=online", http.StatusOK, `<circle cx="108" cy="108" r="18" fill="#2ecc71"`},
+		{"Corner", "/avatar/Jane%20Doe?badge=busy&badgeCorner=top-left", http.StatusOK, `<circle cx="20" cy="20" r="18" fill="#e74c3c"`},
+		{"Custom color", "/avatar/Jane%20Doe?badgeColor=%23123456&badgeCorner=top-right", http.StatusOK, `<circle cx="108" cy="20" r="18" fill="#123456"`},
+		{"Color overrides status", "/avatar/Jane%20Doe?badge=away&badgeColor=abcdef", http.StatusOK, `fill="#abcdef"`},
+		{"Raster", "/avatar/Jane%20Doe.png?badge=offline&shape=circle", http.StatusOK, ""},
+		{"Unknown status", "/avatar/Jane%20Doe?badge=dnd", http.StatusBadRequest, `"param":"badge"`},
+		{"Unknown corner", "/avatar/Jane%20Doe?badge=online&badgeCorner=middle", http.StatusBadRequest, `"param":"badgeCorner"`},
+		{"Corner without badge", "/avatar/Jane%20Doe?badgeCorner=top-left", http.StatusUnprocessableEntity, `"param":"badgeCorner"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestFormatParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		contentType  string
+		expected     string
+	}{
+		{"Avatar JSX", "/avatar/Jane%20Doe?format=jsx&badge=online", http.StatusOK, "text/plain; charset=utf-8", `strokeWidth="4"`},
+		{"Placeholder JSX", "/placeholder/300x150?format=JSX", http.StatusOK, "text/plain; charset=utf-8", `fontFamily="sans-serif"`},
+		{"Overrides extension", "/avatar/Jane%20Doe.svg?format=png", http.StatusOK, "image/png", ""},
+		{"Animated JSX", "/avatar/Jane%20Doe?format=jsx&animate=pulse", http.StatusOK, "text/plain; charset=utf-8", `attributeName="opacity"`},
+		{"Unknown format", "/avatar/Jane%20Doe?format=bmp", http.StatusBadRequest, "application/json", `"param":"format"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Fatalf("expected content type %s got %s", tt.contentType, got)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPlaceholderLabelRound(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Exact by default", "/placeholder/800x451", http.StatusOK, ">800 x 451</text>"},
+		{"Rounded", "/placeholder/800x451?labelRound=10", http.StatusOK, ">800 x 450</text>"},
+		{"Rounded up", "/placeholder/805x455?labelRound=10", http.StatusOK, ">810 x 460</text>"},
+		{"Never below the step", "/placeholder/4x451?labelRound=10", http.StatusOK, ">10 x 450</text>"},
+		{"Invalid step", "/placeholder/800x451?labelRound=0", http.StatusBadRequest, `"param":"labelRound"`},
+		{"With text", "/placeholder/800x451?labelRound=10&text=Hi", http.StatusUnprocessableEntity, `"param":"labelRound"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+
+	// Rounding only changes the label, never the image size
+	req := httptest.NewRequest(http.MethodGet, "/placeholder/800x451?labelRound=10", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `width="800" height="451" viewBox="0 0 800 451"`) {
+		t.Fatalf("expected an 800x451 image: %s", rec.Body.String())
+	}
+}
+
+func TestHotlinkProtection(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.HotlinkAllowedHosts = []string{"example.com"}
+	mux := http.NewServeMux()
+	NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+
+	tests := []struct {
+		path         string
+		referer      string
+		expectedCode int
+	}{
+		{"/avatar/Jane%20Doe", "https://example.com/team", http.StatusOK},
+		{"/avatar/Jane%20Doe", "https://leech.example.net/", http.StatusForbidden},
+		{"/placeholder/300x150", "https://leech.example.net/", http.StatusForbidden},
+		{"/health", "https://leech.example.net/", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" from "+tt.referer, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Referer", tt.referer)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestFaviconICO(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		images       int
+	}{
+		{"Default sizes", "/favicon.ico?format=ico", http.StatusOK, 3},
+		{"Custom sizes", "/favicon.ico?format=ico&sizes=16,24,32,64", http.StatusOK, 4},
+		{"Duplicates dropped", "/favicon.ico?format=ico&sizes=32,32", http.StatusOK, 1},
+		{"Too large", "/favicon.ico?format=ico&sizes=16,512", http.StatusBadRequest, 0},
+		{"Too many", "/favicon.ico?format=ico&sizes=1,2,3,4,5,6,7,8,9", http.StatusBadRequest, 0},
+		{"Unknown format", "/favicon.ico?format=bmp", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "image/x-icon" {
+				t.Fatalf("expected content-type image/x-icon got %s", ct)
+			}
+			body := rec.Body.Bytes()
+			if len(body) < 6 || body[2] != 1 || int(body[4]) != tt.images {
+				t.Fatalf("expected an icon with %d images, got header % x", tt.images, body[:min(len(body), 6)])
+			}
+		})
+	}
+}
+
+func TestAvatarTextGradientParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Gradient", "/avatar/Jane%20Doe?bg=222222&textGradient=ff6b6b,%23feca57", http.StatusOK, `fill="url(#text_grad_ff6b6b_feca57)"`},
+		{"Raster", "/avatar/Jane%20Doe.png?bg=222222&textGradient=ff6b6b,feca57", http.StatusOK, ""},
+		{"Single color", "/avatar/Jane%20Doe?bg=222222&textGradient=ff6b6b", http.StatusBadRequest, "two comma-separated hex colors"},
+		{"Low contrast", "/avatar/Jane%20Doe?bg=ffffff&textGradient=000000,eeeeee", http.StatusBadRequest, "eeeeee has a contrast of 1.2:1"},
+		{"With color", "/avatar/Jane%20Doe?bg=222222&textGradient=ff6b6b,feca57&color=ffffff", http.StatusUnprocessableEntity, `"param":"textGradient"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAvatarPowerOfTwoParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedCode   int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"Up", "/avatar/Jane%20Doe.png?size=200&pot=up", http.StatusOK, 256, 256},
+		{"Down", "/avatar/Jane%20Doe.png?size=200&pot=down", http.StatusOK, 128, 128},
+		{"Nearest", "/avatar/Jane%20Doe.png?size=100x40&pot=nearest", http.StatusOK, 128, 32},
+		{"Already a power of two", "/avatar/Jane%20Doe.webp?size=64&pot=up", http.StatusOK, 64, 64},
+		{"Unknown rounding", "/avatar/Jane%20Doe.png?size=200&pot=sideways", http.StatusBadRequest, 0, 0},
+		{"SVG", "/avatar/Jane%20Doe.svg?size=200&pot=up", http.StatusUnprocessableEntity, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if tt.expectedCode != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), `"param":"pot"`) {
+					t.Fatalf("expected pot to be reported, got %s", rec.Body.String())
+				}
+				return
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if cfg.Width != tt.expectedWidth || cfg.Height != tt.expectedHeight {
+				t.Fatalf("expected %dx%d got %dx%d", tt.expectedWidth, tt.expectedHeight, cfg.Width, cfg.Height)
+			}
+		})
+	}
+}
+
+func TestAvatarColorHash(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+
+	tests := []struct {
+		colorHash string
+		expected  string
+	}{
+		{"", `fill="#1c2720"`},
+		{"md5", `fill="#1c2720"`},
+		{"fnv32", `fill="#a0e569"`},
+		{"crc32", `fill="#9b8eb8"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.colorHash, func(t *testing.T) {
+			cache, _ := lru.New[string, []byte](1)
+			cfg := config.DefaultServerConfig()
+			cfg.ColorHash = tt.colorHash
+			mux := http.NewServeMux()
+			NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?bg=random", nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAvatarGrayscaleParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Grayscale", "/avatar/Jane%20Doe?grayscale=1", http.StatusOK, `<feColorMatrix type="saturate" values="0" />`},
+		{"Saturation", "/avatar/Jane%20Doe?saturation=40", http.StatusOK, `values="0.4"`},
+		{"Full saturation", "/avatar/Jane%20Doe?saturation=100", http.StatusOK, `<svg`},
+		{"Raster", "/avatar/Jane%20Doe.png?grayscale=true", http.StatusOK, ""},
+		{"Out of range", "/avatar/Jane%20Doe?saturation=150", http.StatusBadRequest, `"param":"saturation"`},
+		{"Not a number", "/avatar/Jane%20Doe?saturation=half", http.StatusBadRequest, `"param":"saturation"`},
+		{"Both", "/avatar/Jane%20Doe?grayscale=1&saturation=50", http.StatusUnprocessableEntity, `"param":"saturation"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAvatarRingParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Ring", "/avatar/Jane%20Doe?ring=1&shape=circle", http.StatusOK, `<textPath href="#ring-path" startOffset="25%" text-anchor="middle">Jane Doe</textPath>`},
+		{"Raster", "/avatar/Jane%20Doe.png?ring=true", http.StatusOK, ""},
+		{"Off", "/avatar/Jane%20Doe?ring=0", http.StatusOK, `<svg`},
+		{"Tiles", "/avatar/Jane%20Doe?ring=1&style=tiles", http.StatusUnprocessableEntity, `"param":"ring"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAvatarWordmarkParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Wordmark", "/avatar/GROUT?style=wordmark&size=400x120", http.StatusOK, ">GROUT</text>"},
+		{"Raster", "/avatar/Grout%20Image%20Service.png?style=wordmark&size=400x120", http.StatusOK, ""},
+		{"Too long", "/avatar/" + strings.Repeat("a", 33) + "?style=wordmark", http.StatusBadRequest, `"param":"name"`},
+		{"Initials mode", "/avatar/GROUT?style=wordmark&initialsMode=firstlast", http.StatusUnprocessableEntity, `"param":"initialsMode"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestInitialsLayoutParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Vertical", "/avatar/Jane%20Doe?size=64x256&initialsLayout=vertical", http.StatusOK, ">J</text>\n<text"},
+		{"Horizontal", "/avatar/Jane%20Doe?size=64x256&initialsLayout=horizontal", http.StatusOK, ">JD</text>"},
+		{"Raster", "/avatar/Jane%20Doe.png?size=64x256&initialsLayout=vertical", http.StatusOK, ""},
+		{"Invalid", "/avatar/Jane?initialsLayout=diagonal", http.StatusBadRequest, `"param":"initialsLayout"`},
+		{"Tiles", "/avatar/Jane?initialsLayout=vertical&style=tiles", http.StatusUnprocessableEntity, `"param":"initialsLayout"`},
+		{"Letter spacing", "/avatar/Jane?initialsLayout=vertical&letterSpacing=4", http.StatusUnprocessableEntity, `"param":"letterSpacing"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestOpacityParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+		absent       string
+	}{
+		{"Avatar", "/avatar/Jane?opacity=40", http.StatusOK, `<g opacity="0.4">`, ""},
+		{"Placeholder", "/placeholder/300x200?opacity=25", http.StatusOK, `<g opacity="0.25">`, ""},
+		{"Opaque by default", "/avatar/Jane", http.StatusOK, "", `<g opacity`},
+		{"Fully opaque", "/avatar/Jane?opacity=100", http.StatusOK, "", `<g opacity`},
+		{"PNG", "/avatar/Jane.png?opacity=40", http.StatusOK, "", ""},
+		{"Full opacity on JPEG", "/avatar/Jane.jpg?opacity=100", http.StatusOK, "", ""},
+		{"Out of range", "/avatar/Jane?opacity=101", http.StatusBadRequest, `"param":"opacity"`, ""},
+		{"JPEG", "/avatar/Jane.jpg?opacity=40", http.StatusUnprocessableEntity, `"param":"opacity"`, ""},
+		{"GIF placeholder", "/placeholder/300x200.gif?opacity=40", http.StatusUnprocessableEntity, `"param":"opacity"`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+			if tt.absent != "" && strings.Contains(rec.Body.String(), tt.absent) {
+				t.Fatalf("expected no %s in %s", tt.absent, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestEncodingDefaults(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	get := func(cfg config.ServerConfig, path string) []byte {
+		cache, _ := lru.New[string, []byte](16)
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		return rec.Body.Bytes()
+	}
+
+	low := config.DefaultServerConfig()
+	low.JPEGQuality, low.WebPQuality, low.PNGCompression = 10, 10, "none"
+	high := config.DefaultServerConfig()
+	high.JPEGQuality, high.WebPQuality, high.PNGCompression = 100, 100, "best"
+
+	for _, path := range []string{"/avatar/Jane%20Doe.jpg?size=256", "/placeholder/400x300.webp"} {
+		if l, h := len(get(low, path)), len(get(high, path)); l >= h {
+			t.Fatalf("expected %s at quality 10 to be smaller than at 100 got %d and %d bytes", path, l, h)
+		}
+	}
+	if none, best := len(get(low, "/avatar/Jane.png")), len(get(high, "/avatar/Jane.png")); none <= best {
+		t.Fatalf("expected uncompressed PNG to be larger than best compression got %d and %d bytes", none, best)
+	}
+
+	lossless := config.DefaultServerConfig()
+	lossless.WebPLossless = true
+	img, err := webp.Decode(bytes.NewReader(get(lossless, "/avatar/Jane.webp?background=2c3e50")))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if r, g, b, _ := img.At(1, 1).RGBA(); r>>8 != 0x2c || g>>8 != 0x3e || b>>8 != 0x50 {
+		t.Fatalf("expected exact background from lossless WebP got %02x%02x%02x", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestQualityParam(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	newMux := func(cfg config.ServerConfig) *http.ServeMux {
+		cache, _ := lru.New[string, []byte](16)
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		return mux
+	}
+	get := func(mux *http.ServeMux, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	low := config.DefaultServerConfig()
+	low.JPEGQuality, low.WebPQuality = 10, 10
+	lowMux, mux := newMux(low), newMux(config.DefaultServerConfig())
+
+	for name, path := range map[string]string{"Avatar JPEG": "/avatar/Jane%20Doe.jpg?size=256", "Placeholder WebP": "/placeholder/400x300.webp?text=Hi"} {
+		t.Run(name, func(t *testing.T) {
+			configured := get(lowMux, path).Body.Bytes()
+			// The default quality is cached first; quality=10 must not be served from that entry
+			def := get(mux, path)
+			rec := get(mux, path+"&quality=10")
+			if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+				t.Fatalf("expected a fresh 200 for another quality got %d %s", rec.Code, rec.Header().Get("X-Cache"))
+			}
+			if !bytes.Equal(rec.Body.Bytes(), configured) {
+				t.Fatal("expected quality=10 to encode like a configured quality of 10")
+			}
+			if bytes.Equal(rec.Body.Bytes(), def.Body.Bytes()) {
+				t.Fatal("expected quality=10 to differ from the configured default")
+			}
+			if again := get(mux, path+"&quality=10"); again.Header().Get("X-Cache") != "HIT" || !bytes.Equal(again.Body.Bytes(), configured) {
+				t.Fatalf("expected the quality=10 render to be cached got %s", again.Header().Get("X-Cache"))
+			}
+		})
+	}
+
+	for _, value := range []string{"0", "101", "high", "50.5"} {
+		rec := get(mux, "/avatar/Jane.jpg?quality="+value)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"param":"quality"`) {
+			t.Fatalf("expected quality=%s to be rejected got %d %s", value, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestFlipParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Horizontal", "/avatar/Jane%20Doe?size=128&flip=horizontal", http.StatusOK, `<g transform="matrix(-1 0 0 1 128 0)">`},
+		{"Both", "/placeholder/200x100?flip=both", http.StatusOK, `<g transform="matrix(-1 0 0 -1 200 100)">`},
+		{"Keep text", "/avatar/Jane%20Doe?size=128&flip=vertical&flipText=false", http.StatusOK, `<g transform="matrix(1 0 0 -1 0 128)">`},
+		{"Raster", "/avatar/Jane%20Doe.png?flip=horizontal&flipText=true", http.StatusOK, ""},
+		{"Unknown flip", "/avatar/Jane?flip=diagonal", http.StatusBadRequest, `"param":"flip"`},
+		{"Invalid flipText", "/avatar/Jane?flip=both&flipText=maybe", http.StatusBadRequest, `"param":"flipText"`},
+		{"flipText without flip", "/placeholder/200x100?flipText=false", http.StatusUnprocessableEntity, `"param":"flipText"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+
+	// Text left out of the flip comes after the mirrored background group
+	req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?flip=horizontal&flipText=false", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if body := rec.Body.String(); strings.Index(body, "</g>") > strings.Index(body, ">JD</text>") {
+		t.Fatalf("expected the initials outside the flipped group got %s", body)
+	}
+}
+
+func TestBubbleShape(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Avatar", "/avatar/Jane%20Doe?size=128&shape=bubble", http.StatusOK, "L79.5 108L64 128L48.5 108"},
+		{"Avatar tail", "/avatar/Jane%20Doe?size=128&shape=bubble&tail=right", http.StatusOK, "L108 48.5L128 64L108 79.5"},
+		{"Placeholder", "/placeholder/300x200?shape=bubble&tail=top", http.StatusOK, `<clipPath id="bubble-clip">`},
+		{"Raster", "/avatar/Jane.png?shape=bubble&tail=left", http.StatusOK, ""},
+		{"Unknown tail", "/avatar/Jane?shape=bubble&tail=up", http.StatusBadRequest, `"param":"tail"`},
+		{"Placeholder circle", "/placeholder/300x200?shape=circle", http.StatusBadRequest, `"param":"shape"`},
+		{"Tail without bubble", "/avatar/Jane?tail=left", http.StatusUnprocessableEntity, `"param":"tail"`},
+		{"Ring", "/avatar/Jane?shape=bubble&ring=1", http.StatusUnprocessableEntity, `"param":"ring"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTaglineParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+		absent       string
+	}{
+		{"Tagline", "/avatar/Jane%20Doe?size=256&tagline=Staff+engineer", http.StatusOK, ">Staff engineer</text>", ""},
+		{"Escaped", "/avatar/Jane?size=256&tagline=R%26D+%3Clead%3E", http.StatusOK, ">R&amp;D &lt;lead&gt;</text>", "<lead>"},
+		{"Small avatar", "/avatar/Jane%20Doe?size=64&tagline=Staff+engineer", http.StatusOK, ">JD</text>", "Staff engineer"},
+		{"Raster", "/avatar/Jane%20Doe.png?size=256&tagline=Staff+engineer", http.StatusOK, "", ""},
+		{"Too long", "/avatar/Jane?tagline=" + strings.Repeat("a", 81), http.StatusBadRequest, `"param":"tagline"`, ""},
+		{"Circle", "/avatar/Jane?size=256&tagline=Hi&shape=circle", http.StatusUnprocessableEntity, `"param":"tagline"`, ""},
+		{"Ring", "/avatar/Jane?size=256&tagline=Hi&ring=1", http.StatusUnprocessableEntity, `"param":"tagline"`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+			if tt.absent != "" && strings.Contains(rec.Body.String(), tt.absent) {
+				t.Fatalf("expected no %s in %s", tt.absent, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRibbonParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+		absent       string
+	}{
+		{"Placeholder", "/placeholder/400x250?ribbon=DRAFT", http.StatusOK, `<g transform="rotate(45`, ""},
+		{"Avatar", "/avatar/Jane%20Doe?size=128&ribbon=DEMO", http.StatusOK, ">DEMO</text></g>", ""},
+		{"Raster", "/placeholder/400x250.png?ribbon=DRAFT", http.StatusOK, "", ""},
+		{"Tiny image", "/avatar/Jane%20Doe?size=32&ribbon=DEMO", http.StatusOK, "<svg", "DEMO"},
+		{"Too long", "/placeholder/400x250?ribbon=" + strings.Repeat("X", 17), http.StatusBadRequest, `"param":"ribbon"`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+			if tt.absent != "" && strings.Contains(rec.Body.String(), tt.absent) {
+				t.Fatalf("expected no %s in %s", tt.absent, rec.Body.String())
+			}
+		})
+	}
+}
+
+// badgeStyle is a custom avatar style, registered the way a fork would at startup
+type badgeStyle struct{}
+
+func (badgeStyle) WriteSVG(w io.Writer, ctx render.StyleContext) error {
+	_, err := fmt.Fprintf(w, `<text class="custom-badge" font-size="%g">%s</text>`, ctx.FontSize, ctx.Text)
+	return err
+}
+
+func (badgeStyle) DrawRaster(dc *gg.Context, ctx render.StyleContext) error {
+	dc.DrawCircle(float64(ctx.Width)/2, float64(ctx.Height)/2, float64(ctx.Width)/4)
+	dc.SetHexColor(ctx.Foreground)
+	dc.Fill()
+	return nil
+}
+
+func TestCustomStyle(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	if err := renderer.RegisterStyle("badge", badgeStyle{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](10)
+	mux := http.NewServeMux()
+	NewService(renderer, cache, config.DefaultServerConfig()).RegisterRoutes(mux, nil)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"SVG", "/avatar/Jane%20Doe?style=badge", http.StatusOK, `<text class="custom-badge" font-size="64">JD</text>`},
+		{"Case insensitive", "/avatar/Jane%20Doe?style=Badge", http.StatusOK, `class="custom-badge"`},
+		{"Raster", "/avatar/Jane%20Doe.png?style=badge", http.StatusOK, "PNG"},
+		{"Built-in still works", "/avatar/Jane%20Doe?style=tiles", http.StatusOK, `rx=`},
+		{"Unknown style lists custom ones", "/avatar/Jane%20Doe?style=stripes", http.StatusBadRequest, "must be one of default, badge, tiles, wordmark"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+// brokenStyle is a custom style that fails after writing pad bytes of SVG
+type brokenStyle struct{ pad int }
+
+func (b brokenStyle) WriteSVG(w io.Writer, ctx render.StyleContext) error {
+	if _, err := io.WriteString(w, strings.Repeat(" ", b.pad)); err != nil {
+		return err
+	}
+	return fmt.Errorf("broken style")
+}
+
+func (brokenStyle) DrawRaster(dc *gg.Context, ctx render.StyleContext) error {
+	return fmt.Errorf("broken style")
+}
+
+func TestFailingStyleIsNotCached(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	if err := renderer.RegisterStyle("broken", brokenStyle{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := renderer.RegisterStyle("broken-late", brokenStyle{pad: 64 << 10}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](10)
+	mux := http.NewServeMux()
+	NewService(renderer, cache, config.DefaultServerConfig()).RegisterRoutes(mux, nil)
+
+	for _, path := range []string{"/avatar/Jane%20Doe?style=broken", "/avatar/Jane%20Doe.png?style=broken"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("expected 500 got %d", rec.Code)
+			}
+			for _, header := range []string{"Cache-Control", "ETag", "X-Cache"} {
+				if got := rec.Header().Get(header); got != "" {
+					t.Fatalf("expected no %s on a failed render, got %q", header, got)
+				}
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Fatalf("expected the error page got %s", ct)
+			}
+		})
+	}
+
+	t.Run("Failure after streaming started", func(t *testing.T) {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Fatalf("expected the handler to abort the response, got %v", p)
+			}
+		}()
+		req := httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?style=broken-late", nil)
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	})
+	if cache.Len() != 0 {
+		t.Fatalf("expected no failed render in the cache, got %d entries", cache.Len())
+	}
+}
+
+func TestAvatarSymbolParam(t *testing.T) {
+	_, mux := setupTestService(t)
+	symbolPattern := regexp.MustCompile(`<symbol id="(avatar-[0-9a-f]{12})" viewBox="0 0 128 128">`)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	symbolOf := func(path string) string {
+		t.Helper()
+		rec := get(path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		m := symbolPattern.FindStringSubmatch(rec.Body.String())
+		if m == nil {
+			t.Fatalf("expected a symbol in %s", rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `</symbol>`+"\n"+`<use href="#`+m[1]+`" width="128" height="128" />`) {
+			t.Fatalf("expected a <use> of %s in %s", m[1], rec.Body.String())
+		}
+		return m[1]
+	}
+
+	id := symbolOf("/avatar/Jane%20Doe?symbol=1")
+	if again := symbolOf("/avatar/Jane%20Doe?symbol=true"); again != id {
+		t.Fatalf("expected a stable id, got %s and %s", id, again)
+	}
+	if other := symbolOf("/avatar/John%20Smith?symbol=1"); other == id {
+		t.Fatalf("expected different avatars to get different ids, both got %s", id)
+	}
+	if rec := get("/avatar/Jane%20Doe"); strings.Contains(rec.Body.String(), "<symbol") {
+		t.Fatalf("expected no symbol by default in %s", rec.Body.String())
+	}
+	if rec := get("/avatar/Jane%20Doe.png?symbol=1"); rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"param":"symbol"`) {
+		t.Fatalf("expected 422 for a raster symbol got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	paths := []string{
+		"/avatar/Jane%20Doe",
+		"/avatar/Jane%20Doe.png?size=64",
+		"/placeholder/300x200",
+		"/placeholder/300x200.webp",
+	}
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			head := httptest.NewRecorder()
+			mux.ServeHTTP(head, httptest.NewRequest(http.MethodHead, path, nil))
+			if head.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d", head.Code)
+			}
+			if head.Body.Len() != 0 {
+				t.Fatalf("expected no body got %d bytes", head.Body.Len())
+			}
+			if head.Header().Get("Content-Length") == "" {
+				t.Fatal("expected a Content-Length on HEAD")
+			}
+
+			// The HEAD rendered into the cache, so the GET is served from it
+			get := httptest.NewRecorder()
+			mux.ServeHTTP(get, httptest.NewRequest(http.MethodGet, path, nil))
+			if get.Header().Get("X-Cache") != "HIT" {
+				t.Fatalf("expected the GET to hit the cache got %q", get.Header().Get("X-Cache"))
+			}
+			for _, header := range []string{"Content-Type", "Content-Length", "ETag", "Cache-Control"} {
+				if got, want := head.Header().Get(header), get.Header().Get(header); got != want {
+					t.Fatalf("expected %s %q to match GET %q", header, got, want)
+				}
+			}
+			if got := head.Header().Get("Content-Length"); got != fmt.Sprint(get.Body.Len()) {
+				t.Fatalf("expected Content-Length %s to match the GET body of %d bytes", got, get.Body.Len())
+			}
+		})
+	}
+
+	t.Run("Conditional HEAD", func(t *testing.T) {
+		first := httptest.NewRecorder()
+		mux.ServeHTTP(first, httptest.NewRequest(http.MethodHead, "/avatar/Jane%20Doe", nil))
+		req := httptest.NewRequest(http.MethodHead, "/avatar/Jane%20Doe", nil)
+		req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("expected 304 got %d", rec.Code)
+		}
+	})
+}
+
+func TestColorProfileParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		tagged       bool
+	}{
+		{"Avatar PNG default", "/avatar/Jane%20Doe.png", http.StatusOK, true},
+		{"Avatar PNG none", "/avatar/Jane%20Doe.png?colorProfile=none", http.StatusOK, false},
+		{"Placeholder PNG srgb", "/placeholder/300x200.png?colorProfile=srgb", http.StatusOK, true},
+		{"Placeholder PNG none", "/placeholder/300x200.png?colorProfile=none", http.StatusOK, false},
+		{"Invalid", "/avatar/Jane%20Doe.png?colorProfile=p3", http.StatusBadRequest, false},
+		{"SVG", "/placeholder/300x200?colorProfile=none", http.StatusUnprocessableEntity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if got := bytes.Contains(rec.Body.Bytes(), []byte("\x00\x00\x00\x01sRGB")); got != tt.tagged {
+				t.Fatalf("expected sRGB chunk %t got %t", tt.tagged, got)
+			}
+			if tt.expectedCode != http.StatusOK && !strings.Contains(rec.Body.String(), `"param":"colorProfile"`) {
+				t.Fatalf("expected a colorProfile error in %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPlaceholderVignetteParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+	}{
+		{"Vignette", "/placeholder/300x200?vignette=40", http.StatusOK, `stop-opacity="0.4"`},
+		{"Raster", "/placeholder/300x200.png?vignette=100", http.StatusOK, ""},
+		{"Zero is off", "/placeholder/300x200?vignette=0", http.StatusOK, `<text`},
+		{"Out of range", "/placeholder/300x200?vignette=120", http.StatusBadRequest, `"param":"vignette"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAltTemplate(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](10)
+	cfg := config.DefaultServerConfig()
+	cfg.AltTemplate = "Avatar for {name} ({initials}, {size}px)"
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		expected     string
+		unexpected   string
+	}{
+		{"Template", "/avatar/Jane%20Doe?size=64", http.StatusOK, `<title>Avatar for Jane Doe (JD, 64px)</title>`, ""},
+		{"Aria label", "/avatar/Jane%20Doe?size=64", http.StatusOK, `role="img" aria-label="Avatar for Jane Doe (JD, 64px)"`, ""},
+		{"Escaped", "/avatar/%3Cb%3E%26", http.StatusOK, `<title>Avatar for &lt;b&gt;&amp;`, "<b>"},
+		{"Explicit alt wins", "/avatar/Jane%20Doe?alt=Team+lead", http.StatusOK, `<title>Team lead</title>`, "Avatar for"},
+		{"Placeholder", "/placeholder/300x200", http.StatusOK, `<title>Avatar for 300 x 200 (300 x 200, 300x200px)</title>`, ""},
+		{"Raster has no label", "/avatar/Jane%20Doe.png", http.StatusOK, "", "Avatar for"},
+		{"Too long", "/avatar/Jane?alt=" + strings.Repeat("a", config.MaxAltLength+1), http.StatusBadRequest, `"param":"alt"`, ""},
+		{"Control characters", "/avatar/Jane?alt=a%00b", http.StatusBadRequest, `"param":"alt"`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, body)
+			}
+			if tt.unexpected != "" && strings.Contains(body, tt.unexpected) {
+				t.Fatalf("expected no %s in %s", tt.unexpected, body)
+			}
+		})
+	}
+
+	// Without a template only an explicit alt adds a label
+	_, defaultMux := setupTestService(t)
+	req := httptest.NewRequest(http.MethodGet, "/avatar/Jane", nil)
+	rec := httptest.NewRecorder()
+	defaultMux.ServeHTTP(rec, req)
+	if strings.Contains(rec.Body.String(), "<title>") {
+		t.Fatalf("expected no title by default, got %s", rec.Body.String())
+	}
+}
+
+func TestPixelateParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		rects        int
+	}{
+		{"SVG grid", "/avatar/Jane%20Doe?size=128&pixelate=8", http.StatusOK, 64},
+		{"Clamped up", "/avatar/Jane%20Doe?size=128&pixelate=1", http.StatusOK, 4},
+		{"Clamped down", "/avatar/Jane%20Doe?size=128&pixelate=500", http.StatusOK, 64 * 64},
+		{"Raster", "/avatar/Jane%20Doe.png?pixelate=12", http.StatusOK, 0},
+		{"Zero", "/avatar/Jane?pixelate=0", http.StatusBadRequest, 0},
+		{"Not a number", "/avatar/Jane?pixelate=big", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if n := strings.Count(rec.Body.String(), "<rect"); tt.rects > 0 && n != tt.rects {
+				t.Fatalf("expected %d rects got %d", tt.rects, n)
+			}
+		})
+	}
+}
+
+func TestCapsParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		contains     string
+		excludes     string
+	}{
+		{"All capitals", "/avatar/jane?style=wordmark&caps=all", http.StatusOK, ">JANE</text>", ""},
+		{"Normal", "/avatar/jane?style=wordmark&caps=normal", http.StatusOK, ">jane</text>", ""},
+		// The bundled fonts have no small capitals, so the text is drawn as is
+		{"Small without smcp", "/avatar/jane?style=wordmark&caps=small", http.StatusOK, ">jane</text>", "font-variant-caps"},
+		{"Raster", "/avatar/Jane%20Doe.png?caps=small", http.StatusOK, "", ""},
+		{"Invalid", "/avatar/jane?caps=petite", http.StatusBadRequest, "caps", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Fatalf("expected body to contain %q got %s", tt.contains, body)
+			}
+			if body := rec.Body.String(); tt.excludes != "" && strings.Contains(body, tt.excludes) {
+				t.Fatalf("expected body without %q got %s", tt.excludes, body)
+			}
+		})
+	}
+}
+
+func TestCSSVarsParam(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		contains     string
+	}{
+		{"Avatar", "/avatar/Jane%20Doe?background=2c3e50&color=ffffff&cssVars=1", http.StatusOK, `fill="var(--grout-bg, #2c3e50)"`},
+		{"Avatar text", "/avatar/Jane%20Doe?background=2c3e50&color=ffffff&cssVars=1", http.StatusOK, `fill="var(--grout-fg, #ffffff)"`},
+		{"Placeholder", "/placeholder/200x100?cssVars=true", http.StatusOK, `fill="var(--grout-bg, #cccccc)"`},
+		// Cached separately from the same request with cssVars
+		{"Off", "/avatar/Jane%20Doe?background=2c3e50&color=ffffff", http.StatusOK, `fill="#2c3e50"`},
+		{"Raster", "/avatar/Jane%20Doe.png?cssVars=1", http.StatusUnprocessableEntity, "cssVars only applies to SVG"},
+		{"Placeholder raster", "/placeholder/200x100.webp?cssVars=1", http.StatusUnprocessableEntity, "cssVars only applies to SVG"},
+		{"Pixelate", "/avatar/Jane%20Doe?cssVars=1&pixelate=8", http.StatusUnprocessableEntity, "cssVars cannot recolor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Fatalf("expected body to contain %q got %s", tt.contains, body)
+			}
+		})
+	}
+}
+
+func TestGammaCorrectParam(t *testing.T) {
+	newMux := func(gammaCorrect bool) *http.ServeMux {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](16)
+		cfg := config.DefaultServerConfig()
+		cfg.GammaCorrect = gammaCorrect
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		return mux
+	}
+	get := func(mux *http.ServeMux, path string, expectedCode int) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != expectedCode {
+			t.Fatalf("%s: expected %d got %d", path, expectedCode, rec.Code)
+		}
+		return rec.Body.String()
+	}
+	const avatar = "/avatar/Jane%20Doe.png?background=2c3e50&color=ffffff"
+
+	on, off := newMux(true), newMux(false)
+	if get(on, avatar, http.StatusOK) == get(on, avatar+"&gammaCorrect=false", http.StatusOK) {
+		t.Fatal("expected gammaCorrect=false to change the raster")
+	}
+	if get(on, avatar, http.StatusOK) != get(on, avatar+"&gammaCorrect=true", http.StatusOK) {
+		t.Fatal("expected gamma correction by default")
+	}
+	if get(off, avatar, http.StatusOK) != get(on, avatar+"&gammaCorrect=0", http.StatusOK) {
+		t.Fatal("expected GammaCorrect=false in the config to turn it off by default")
+	}
+	if get(off, avatar+"&gammaCorrect=1", http.StatusOK) != get(on, avatar, http.StatusOK) {
+		t.Fatal("expected the param to override the config")
+	}
+	if get(on, "/placeholder/200x100.png", http.StatusOK) == get(on, "/placeholder/200x100.png?gammaCorrect=false", http.StatusOK) {
+		t.Fatal("expected gammaCorrect to apply to placeholders")
+	}
+
+	get(on, "/avatar/Jane?gammaCorrect=maybe", http.StatusBadRequest)
+	if body := get(on, "/avatar/Jane.svg?gammaCorrect=false", http.StatusUnprocessableEntity); !strings.Contains(body, "gammaCorrect only applies to raster") {
+		t.Fatalf("expected a conflict for SVG got %s", body)
+	}
+}
+
+func TestAvatarPicture(t *testing.T) {
+	newMux := func(formats []string, lowMemory bool) *http.ServeMux {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](1)
+		cfg := config.DefaultServerConfig()
+		cfg.BaseURL = "https://img.example.com"
+		cfg.PictureFormats = formats
+		cfg.LowMemory = lowMemory
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		return mux
+	}
+	get := func(mux *http.ServeMux, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	imgRegex := regexp.MustCompile(`<img src="([^"]+)" alt="([^"]*)" width="(\d+)" height="(\d+)">`)
+
+	t.Run("Default formats", func(t *testing.T) {
+		mux := newMux(strings.Split(config.DefaultPictureFormats, ","), false)
+		rec := get(mux, "/avatar/Jane%20Doe?size=96&shape=circle&alt=Jane&format=picture")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Fatalf("expected text/html got %s", ct)
+		}
+		body := rec.Body.String()
+		want := `<source type="image/webp" srcset="https://img.example.com/avatar/Jane%20Doe.webp?alt=Jane&amp;shape=circle&amp;size=96">`
+		if !strings.HasPrefix(body, "<picture>\n") || !strings.Contains(body, want) || strings.Count(body, "<source") != 1 {
+			t.Fatalf("expected one WebP source got %s", body)
+		}
+
+		m := imgRegex.FindStringSubmatch(body)
+		if m == nil || m[2] != "Jane" || m[3] != "96" || m[4] != "96" {
+			t.Fatalf("expected a sized, labeled <img> fallback got %s", body)
+		}
+		// The fallback URL serves the PNG variant
+		fallback := strings.TrimPrefix(html.UnescapeString(m[1]), "https://img.example.com")
+		img := get(mux, fallback)
+		if img.Code != http.StatusOK || img.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("expected the fallback %s to serve a PNG got %d %s", fallback, img.Code, img.Header().Get("Content-Type"))
+		}
+		if _, err := png.Decode(img.Body); err != nil {
+			t.Fatalf("expected the fallback to decode: %v", err)
+		}
+	})
+
+	t.Run("Configured order", func(t *testing.T) {
+		mux := newMux([]string{"svg", "webp", "png"}, false)
+		body := get(mux, "/avatar/Jane?format=picture").Body.String()
+		svg := strings.Index(body, `<source type="image/svg+xml" srcset="https://img.example.com/avatar/Jane.svg">`)
+		webp := strings.Index(body, `<source type="image/webp" srcset="https://img.example.com/avatar/Jane.webp">`)
+		if svg < 0 || webp < svg || !strings.Contains(body, `<img src="https://img.example.com/avatar/Jane.png"`) {
+			t.Fatalf("expected SVG, then WebP sources and a PNG fallback got %s", body)
+		}
+	})
+
+	t.Run("Low memory keeps vector output", func(t *testing.T) {
+		mux := newMux([]string{"webp", "png"}, true)
+		body := get(mux, "/avatar/Jane?format=picture").Body.String()
+		if strings.Contains(body, "<source") || !strings.Contains(body, `<img src="https://img.example.com/avatar/Jane.svg"`) {
+			t.Fatalf("expected only an SVG <img> got %s", body)
+		}
+	})
+
+	t.Run("Invalid params", func(t *testing.T) {
+		mux := newMux(strings.Split(config.DefaultPictureFormats, ","), false)
+		if rec := get(mux, "/avatar/Jane?format=picture&shape=hexagon"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 got %d", rec.Code)
+		}
+		// animate cannot apply to the raster variants
+		if rec := get(mux, "/avatar/Jane?format=picture&animate=pulse"); rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected 422 got %d", rec.Code)
+		}
+	})
+}
+
+func TestEnabledStyles(t *testing.T) {
+	newMux := func(styles []string, lowMemory bool) *http.ServeMux {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](1)
+		cfg := config.DefaultServerConfig()
+		cfg.EnabledStyles = styles
+		cfg.LowMemory = lowMemory
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		return mux
+	}
+	get := func(mux *http.ServeMux, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	capabilitiesOf := func(mux *http.ServeMux) capabilities {
+		rec := get(mux, "/capabilities")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		var caps capabilities
+		if err := json.Unmarshal(rec.Body.Bytes(), &caps); err != nil {
+			t.Fatalf("decode capabilities: %v", err)
+		}
+		return caps
+	}
+
+	t.Run("All enabled by default", func(t *testing.T) {
+		mux := newMux(nil, false)
+		for _, path := range []string{"/avatar/Jane?style=tiles", "/avatar/Jane?style=wordmark", "/avatar/Jane"} {
+			if rec := get(mux, path); rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200 got %d", path, rec.Code)
+			}
+		}
+		caps := capabilitiesOf(mux)
+		if got := strings.Join(caps.Styles, ","); got != "default,tiles,wordmark" {
+			t.Fatalf("expected every style got %s", got)
+		}
+		if got := strings.Join(caps.Formats, ","); got != "svg,png,jpg,gif,webp,jsx" {
+			t.Fatalf("expected every format got %s", got)
+		}
+	})
+
+	t.Run("Disabled style refused", func(t *testing.T) {
+		mux := newMux([]string{"wordmark"}, false)
+		rec := get(mux, "/avatar/Jane?style=tiles")
+		if rec.Code != http.StatusNotAcceptable {
+			t.Fatalf("expected 406 got %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "style tiles is disabled") {
+			t.Fatalf("expected the disabled style named got %s", rec.Body.String())
+		}
+		for _, path := range []string{"/avatar/Jane?style=wordmark", "/avatar/Jane", "/avatar/Jane?style=default"} {
+			if rec := get(mux, path); rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200 got %d", path, rec.Code)
+			}
+		}
+		rec = get(mux, "/avatar/Jane?style=bogus")
+		if rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "tiles") {
+			t.Fatalf("expected 400 listing only enabled styles got %d %s", rec.Code, rec.Body.String())
+		}
+		if got := strings.Join(capabilitiesOf(mux).Styles, ","); got != "default,wordmark" {
+			t.Fatalf("expected the disabled style left out of capabilities got %s", got)
+		}
+	})
+
+	t.Run("Low memory formats", func(t *testing.T) {
+		if got := strings.Join(capabilitiesOf(newMux(nil, true)).Formats, ","); got != "svg,jsx" {
+			t.Fatalf("expected only vector formats in low-memory mode got %s", got)
+		}
+	})
+}
+
+func TestLabelFont(t *testing.T) {
+	newMux := func(labelFont string) *http.ServeMux {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](16)
+		cfg := config.DefaultServerConfig()
+		cfg.LabelFont = labelFont
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		return mux
+	}
+
+	tests := []struct {
+		name         string
+		labelFont    string // Config value
+		path         string
+		expectedCode int
+		contains     string
+	}{
+		{"Default", "", "/placeholder/200x100", http.StatusOK, `font-family="sans-serif" font-size="15" font-weight="bold"`},
+		{"Param family", "", "/placeholder/200x100?labelFont=go-mono", http.StatusOK, `font-family="monospace" font-size="15" font-weight="bold"`},
+		{"Param weight", "", "/placeholder/200x100?labelFont=go-mono:regular", http.StatusOK, `font-family="monospace" font-size="15" font-weight="normal"`},
+		{"Custom text", "", "/placeholder/200x100?labelFont=go-mono&text=Hero", http.StatusOK, `font-family="monospace"`},
+		{"Config", "go-mono", "/placeholder/200x100", http.StatusOK, `font-family="monospace"`},
+		{"Param over config", "go-mono", "/placeholder/200x100?labelFont=go", http.StatusOK, `font-family="sans-serif"`},
+		{"Unknown config family", "missing", "/placeholder/200x100", http.StatusOK, `font-family="sans-serif"`},
+		// Initials keep the main font
+		{"Avatar unaffected", "go-mono", "/avatar/Jane%20Doe", http.StatusOK, `font-family="sans-serif"`},
+		{"Unknown family", "", "/placeholder/200x100?labelFont=comic", http.StatusBadRequest, "go, go-mono"},
+		{"Unknown weight", "", "/placeholder/200x100?labelFont=go-mono:heavy", http.StatusBadRequest, "labelFont"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			newMux(tt.labelFont).ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Fatalf("expected body to contain %q got %s", tt.contains, body)
+			}
+		})
+	}
+}
+
+func TestInitialsSplit(t *testing.T) {
+	newMux := func(split string) *http.ServeMux {
+		renderer, err := render.New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		cache, _ := lru.New[string, []byte](16)
+		cfg := config.DefaultServerConfig()
+		cfg.InitialsSplit = split
+		mux := http.NewServeMux()
+		NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+		return mux
+	}
+
+	tests := []struct {
+		name     string
+		split    string // Config value
+		path     string
+		contains string
+	}{
+		{"Default camelCase", "words", "/avatar/johnDoe", ">J</text>"},
+		{"Default spaces", "words", "/avatar/Jane%20Doe", ">JD</text>"},
+		{"camelCase", "camel-hump", "/avatar/johnDoe", ">JD</text>"},
+		{"snake_case", "camel-hump", "/avatar/john_doe", ">JD</text>"},
+		{"kebab-case", "camel-hump", "/avatar/john-doe", ">JD</text>"},
+		{"Dotted", "camel-hump", "/avatar/john.doe", ">JD</text>"},
+		{"Spaces", "camel-hump", "/avatar/Jane%20Doe", ">JD</text>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			newMux(tt.split).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Fatalf("expected body to contain %q got %s", tt.contains, body)
+			}
+		})
+	}
+}
+
+func TestHueRange(t *testing.T) {
+	_, mux := setupTestService(t)
+	fill := regexp.MustCompile(`<rect width="\d+" height="\d+" fill="#([0-9a-f]{6})"`)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, name := range []string{"Jane%20Doe", "John%20Smith", "Ada%20Lovelace", "Grace%20Hopper"} {
+		for _, hueRange := range []string{"180-240", "330-30"} {
+			path := "/avatar/" + name + "?background=random&hueRange=" + hueRange
+			rec := get(path)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200 got %d: %s", path, rec.Code, rec.Body.String())
+			}
+			match := fill.FindStringSubmatch(rec.Body.String())
+			if match == nil {
+				t.Fatalf("%s: expected a background fill got %s", path, rec.Body.String())
+			}
+			want, _ := render.ParseHueRange(hueRange)
+			if hue, ok := render.Hue(match[1]); !ok || !want.Contains(hue) {
+				t.Fatalf("%s: expected a hue in %s got #%s", path, hueRange, match[1])
+			}
+			if again := fill.FindStringSubmatch(get(path).Body.String()); again == nil || again[1] != match[1] {
+				t.Fatalf("%s: expected the same background every time got #%s and %v", path, match[1], again)
+			}
+		}
+	}
+
+	errorTests := []struct {
+		name         string
+		path         string
+		expectedCode int
+		contains     string
+	}{
+		{"Malformed", "/avatar/Jane?background=random&hueRange=blue", http.StatusBadRequest, "hueRange"},
+		{"Out of range", "/avatar/Jane?background=random&hueRange=0-400", http.StatusBadRequest, "hueRange"},
+		{"Without random", "/avatar/Jane?hueRange=180-240", http.StatusUnprocessableEntity, "background=random"},
+		{"Fixed background", "/avatar/Jane?bg=ff0000&hueRange=180-240", http.StatusUnprocessableEntity, "background=random"},
+		{"Tiles", "/avatar/Jane?background=random&hueRange=180-240&style=tiles", http.StatusUnprocessableEntity, "style=tiles"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.path)
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d: %s", tt.expectedCode, rec.Code, rec.Body.String())
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.contains) {
+				t.Fatalf("expected body to contain %q got %s", tt.contains, body)
+			}
+		})
+	}
+}
+
+func TestAvatarLayers(t *testing.T) {
+	svc, mux := setupTestService(t)
+	svc.cfg.AllowCacheBypass = true
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/avatar/Jane%20Doe?vignette=40"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `stop-opacity="0.4"`) {
+		t.Fatalf("expected a vignette got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/avatar/Jane%20Doe?vignette=120"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 got %d", rec.Code)
+	}
+
+	// Background, pattern, gradient overlay and initials, rendered afresh every time
+	const params = "background=random&hueRange=180-240&tile=1&vignette=50&textGradient=ffffff,fdebd0&shape=rounded&badge=online&fresh=1"
+	for _, ext := range []string{"", ".png", ".webp"} {
+		path := "/avatar/Jane%20Doe" + ext + "?" + params
+		first := get(path)
+		if first.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200 got %d: %s", path, first.Code, first.Body.String())
+		}
+		for range 10 {
+			if rec := get(path); !bytes.Equal(rec.Body.Bytes(), first.Body.Bytes()) {
+				t.Fatalf("%s: expected byte-identical output on every render", path)
+			}
+		}
+	}
+}
+
+// brokenEncoder renders like the real renderer except for one format, whose encoder fails
+type brokenEncoder struct {
+	*render.Renderer
+	format render.ImageFormat
+}
+
+func (b brokenEncoder) Render(w io.Writer, opts render.Options) error {
+	if opts.Format == b.format {
+		return fmt.Errorf("encode %s: encoder unavailable", b.format)
+	}
+	return b.Renderer.Render(w, opts)
+}
+
+func TestSelfTest(t *testing.T) {
+	svc, _ := setupTestService(t)
+	if err := svc.SelfTest(); err != nil {
+		t.Fatalf("expected every style and format to render got %v", err)
+	}
+
+	styles := svc.styleNames()
+	formats := svc.formats()
+	err := selfTest(brokenEncoder{svc.renderer, render.FormatWebP}, styles, formats, svc.encoding(0), true, time.Minute)
+	if err == nil {
+		t.Fatal("expected the broken encoder to fail the self-test")
+	}
+	for _, style := range styles {
+		if want := fmt.Sprintf("self-test: %s webp: encode webp", style); !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), " png:") {
+		t.Fatalf("expected only webp to fail got %v", err)
+	}
+
+	// An exhausted budget reports the samples it did not reach instead of running on
+	if err := selfTest(svc.renderer, styles, formats, svc.encoding(0), true, 0); err == nil || !strings.Contains(err.Error(), "not reached") {
+		t.Fatalf("expected unreached samples to fail got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+)
+
+// maxIdempotencyKeyLength caps the Idempotency-Key header, as keys are stored verbatim
+const maxIdempotencyKeyLength = 255
+
+// idempotentResponse is a stored batch response together with the request it answered
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// idempotencyCache keeps batch responses by client and Idempotency-Key, so a client retrying
+// after a timeout gets the original response instead of regenerating every image. Stored
+// bodies are capped per entry and in total, see config.MaxIdempotencyBytes.
+type idempotencyCache struct {
+	responses *expirable.LRU[string, idempotentResponse]
+	bytes     atomic.Int64 // Body bytes held by responses; expiry evicts from another goroutine
+	mu        sync.Mutex
+	inFlight  map[string]bool // Keys whose first request is still being handled
+}
+
+// newIdempotencyCache returns a cache keeping responses for ttl, or nil when ttl is 0
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	if ttl <= 0 {
+		return nil
+	}
+	c := &idempotencyCache{inFlight: make(map[string]bool)}
+	c.responses = expirable.NewLRU[string, idempotentResponse](config.MaxIdempotencyEntries, func(_ string, resp idempotentResponse) {
+		c.bytes.Add(-int64(len(resp.body)))
+	}, ttl)
+	return c
+}
+
+// start claims key for a new request, reporting false while another request holds it
+func (c *idempotencyCache) start(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight[key] {
+		return false
+	}
+	c.inFlight[key] = true
+	return true
+}
+
+// finish releases key, storing resp for replays unless it is nil or its body is larger than
+// config.MaxIdempotencyEntryBytes. The oldest responses make room within config.MaxIdempotencyBytes.
+func (c *idempotencyCache) finish(key string, resp *idempotentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resp != nil && len(resp.body) <= config.MaxIdempotencyEntryBytes {
+		// An expired entry may linger under key; removing it keeps the byte count right
+		c.responses.Remove(key)
+		c.responses.Add(key, *resp)
+		c.bytes.Add(int64(len(resp.body)))
+		for c.bytes.Load() > config.MaxIdempotencyBytes {
+			if _, _, ok := c.responses.RemoveOldest(); !ok {
+				break
+			}
+		}
+	}
+	delete(c.inFlight, key)
+}
+
+// idempotencyScope names the client a key belongs to, so one client cannot replay, or block,
+// another's response by guessing its key: the Authorization credentials when sent, otherwise
+// the client IP as the rate limiter sees it
+func idempotencyScope(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return fmt.Sprintf("auth:%x", sha256.Sum256([]byte(auth)))
+	}
+	return "ip:" + middleware.ClientIP(r)
+}
+
+// idempotent replays the stored response when a client repeats an Idempotency-Key. Reusing
+// a key with a different path, query or body, or while its first request is still running,
+// is answered with 409. Server errors are not stored, so a retry can still succeed, and
+// neither are ZIP archives, which stream through (see uncapturedTypes).
+// Requests without the header, or with replays disabled, go straight to next.
+func (s *Service) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || s.idempotency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeJSONError(w, http.StatusBadRequest, "Idempotency-Key must not exceed 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				middleware.WriteBodyTooLarge(w, maxErr.Limit)
+				return
+			}
+			writeJSONError(w, http.StatusBadRequest, "could not read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256([]byte(r.URL.Path + "?" + r.URL.RawQuery + "\n" + string(body)))
+		key = idempotencyScope(r) + "\n" + key
+
+		if !s.idempotency.start(key) {
+			writeJSONError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+			return
+		}
+		if stored, ok := s.idempotency.responses.Get(key); ok {
+			s.idempotency.finish(key, nil)
+			if stored.fingerprint != fingerprint {
+				writeJSONError(w, http.StatusConflict, "Idempotency-Key was already used for a different request")
+				return
+			}
+			for name, values := range stored.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.status)
+			_, _ = w.Write(stored.body)
+			return
+		}
+
+		rec := &capturedResponse{w: w, header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		var resp *idempotentResponse
+		if !rec.passthrough && rec.status < http.StatusInternalServerError {
+			resp = &idempotentResponse{fingerprint: fingerprint, status: rec.status, header: rec.header.Clone(), body: rec.body.Bytes()}
+		}
+		s.idempotency.finish(key, resp)
+		if rec.passthrough {
+			return
+		}
+
+		for name, values := range rec.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.status)
+		_, _ = w.Write(rec.body.Bytes())
+	})
+}
+
+// uncapturedTypes are streamed to the client instead of being kept for replays: archives
+// are too large to hold, and a retry simply streams a new one
+var uncapturedTypes = []string{"application/zip"}
+
+// capturedResponse buffers a response for idempotent replays. A response of one of
+// uncapturedTypes is passed through to w as it is written and not stored.
+type capturedResponse struct {
+	w           http.ResponseWriter
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+	passthrough bool
+}
+
+func (c *capturedResponse) Header() http.Header { return c.header }
+
+func (c *capturedResponse) WriteHeader(statusCode int) {
+	if c.wroteHeader {
+		return
+	}
+	c.status, c.wroteHeader = statusCode, true
+	if slices.Contains(uncapturedTypes, c.header.Get("Content-Type")) {
+		c.passthrough = true
+		for name, values := range c.header {
+			c.w.Header()[name] = values
+		}
+		c.w.WriteHeader(statusCode)
+	}
+}
+
+func (c *capturedResponse) Write(p []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+	if c.passthrough {
+		return c.w.Write(p)
+	}
+	return c.body.Write(p)
+}
+
+// Flush passes flushes of a streamed response on; captured ones are sent whole at the end
+func (c *capturedResponse) Flush() {
+	if c.passthrough {
+		_ = http.NewResponseController(c.w).Flush()
+	}
+}
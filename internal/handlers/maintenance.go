@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maintenanceImage is served in place of generated images while in maintenance mode
+const maintenanceImage = `<svg xmlns="http://www.w3.org/2000/svg" width="200" height="60" viewBox="0 0 200 60">` +
+	`<rect width="200" height="60" fill="#f1c40f"/>` +
+	`<text x="100" y="35" font-family="sans-serif" font-size="12" fill="#2c3e50" text-anchor="middle">Temporarily unavailable</text>` +
+	`</svg>`
+
+// unlessMaintenance answers with 503 and Retry-After while the service is in maintenance mode.
+// JSON endpoints get a JSON error, image endpoints a small SVG.
+func (s *Service) unlessMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.maintenance.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(s.cfg.MaintenanceRetryAfter))
+		w.Header().Set("Cache-Control", "no-store")
+		if strings.HasPrefix(r.URL.Path, "/batch") {
+			writeJSONError(w, http.StatusServiceUnavailable, "temporarily unavailable for maintenance")
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(maintenanceImage))
+	})
+}
+
+// requireAdmin only lets requests with the configured bearer token through
+func (s *Service) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "admin token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMaintenance reports maintenance mode on GET and switches it with POST ?enabled=true|false
+func (s *Service) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if err != nil {
+			var errs paramErrors
+			errs.add("enabled", "must be true or false")
+			writeParamErrors(w, errs)
+			return
+		}
+		s.maintenance.Store(enabled)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"maintenance": s.maintenance.Load()})
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/golang-lru/v2"
+
+	"grout/internal/config"
+	"grout/internal/render"
+)
+
+func setupMaintenanceService(t *testing.T, maintenance bool, adminToken string) *http.ServeMux {
+	t.Helper()
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.MaintenanceMode = maintenance
+	cfg.MaintenanceRetryAfter = 300
+	cfg.AdminToken = adminToken
+	mux := http.NewServeMux()
+	NewService(renderer, cache, cfg).RegisterRoutes(mux, nil)
+	return mux
+}
+
+func healthStatus(t *testing.T, mux *http.ServeMux) (string, bool) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected health 200 got %d", rec.Code)
+	}
+	var health struct {
+		Status      string `json:"status"`
+		Maintenance bool   `json:"maintenance"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	return health.Status, health.Maintenance
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	mux := setupMaintenanceService(t, true, "")
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		contentType string
+	}{
+		{"Avatar", http.MethodGet, "/avatar/Jane.png", "", "image/svg+xml"},
+		{"Placeholder", http.MethodGet, "/placeholder/300x200", "", "image/svg+xml"},
+		{"Batch", http.MethodPost, "/batch", `{"items":[{"id":"a","url":"/avatar/A"}]}`, "application/json"},
+		{"Sprite", http.MethodPost, "/batch/sprite", `{"items":[{"id":"a","url":"/avatar/A"}]}`, "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503 got %d", rec.Code)
+			}
+			if ra := rec.Header().Get("Retry-After"); ra != "300" {
+				t.Fatalf("expected Retry-After 300 got %q", ra)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Fatalf("expected %s got %s", tt.contentType, ct)
+			}
+		})
+	}
+
+	if status, maintenance := healthStatus(t, mux); status != "maintenance" || !maintenance {
+		t.Fatalf("expected health to report maintenance, got %s %t", status, maintenance)
+	}
+
+	// Static routes keep working
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected robots.txt 200 got %d", rec.Code)
+	}
+}
+
+func TestMaintenanceToggle(t *testing.T) {
+	mux := setupMaintenanceService(t, false, "s3cret")
+
+	toggle := func(token, enabled string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/maintenance?enabled="+enabled, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	avatarCode := func() int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/Jane", nil))
+		return rec.Code
+	}
+
+	if code := avatarCode(); code != http.StatusOK {
+		t.Fatalf("expected 200 before maintenance got %d", code)
+	}
+	if rec := toggle("", "true"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token got %d", rec.Code)
+	}
+	if rec := toggle("wrong", "true"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token got %d", rec.Code)
+	}
+	if rec := toggle("s3cret", "maybe"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid enabled got %d", rec.Code)
+	}
+
+	if rec := toggle("s3cret", "true"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"maintenance":true`) {
+		t.Fatalf("expected maintenance on, got %d %s", rec.Code, rec.Body.String())
+	}
+	if code := avatarCode(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 in maintenance got %d", code)
+	}
+	if _, maintenance := healthStatus(t, mux); !maintenance {
+		t.Fatal("expected health to report maintenance")
+	}
+
+	if rec := toggle("s3cret", "false"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rec.Code)
+	}
+	if code := avatarCode(); code != http.StatusOK {
+		t.Fatalf("expected 200 after maintenance got %d", code)
+	}
+	if status, _ := healthStatus(t, mux); status != "healthy" {
+		t.Fatalf("expected healthy got %s", status)
+	}
+}
+
+func TestAdminRoutesDisabledWithoutToken(t *testing.T) {
+	mux := setupMaintenanceService(t, false, "")
+	req := httptest.NewRequest(http.MethodPost, "/admin/maintenance?enabled=true", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Fatal("expected admin routes to be unavailable without a token")
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.AdminToken = "s3cret"
+	cfg.AdminAddr = "127.0.0.1:0"
+	svc := NewService(renderer, cache, cfg)
+
+	publicMux, adminMux := http.NewServeMux(), http.NewServeMux()
+	svc.RegisterRoutes(publicMux, nil)
+	svc.RegisterAdminRoutes(adminMux)
+	public, admin := httptest.NewServer(publicMux), httptest.NewServer(adminMux)
+	t.Cleanup(public.Close)
+	t.Cleanup(admin.Close)
+
+	do := func(base, method, path string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, base+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tests := []struct {
+		name         string
+		base         string
+		method       string
+		path         string
+		expectedCode int
+	}{
+		{"Public serves avatars", public.URL, http.MethodGet, "/avatar/Jane", http.StatusOK},
+		{"Public serves health", public.URL, http.MethodGet, "/health", http.StatusOK},
+		{"Public hides admin GET", public.URL, http.MethodGet, "/admin/maintenance", http.StatusNotFound},
+		{"Public hides admin POST", public.URL, http.MethodPost, "/admin/maintenance?enabled=true", http.StatusNotFound},
+		{"Admin serves maintenance", admin.URL, http.MethodGet, "/admin/maintenance", http.StatusOK},
+		{"Admin does not serve avatars", admin.URL, http.MethodGet, "/avatar/Jane", http.StatusNotFound},
+		{"Admin does not serve the home page", admin.URL, http.MethodGet, "/", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := do(tt.base, tt.method, tt.path); code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, code)
+			}
+		})
+	}
+
+	t.Run("Admin toggle applies to the public listener", func(t *testing.T) {
+		if code := do(admin.URL, http.MethodPost, "/admin/maintenance?enabled=true"); code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", code)
+		}
+		if code := do(public.URL, http.MethodGet, "/avatar/Jane"); code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 on the public listener got %d", code)
+		}
+	})
+}
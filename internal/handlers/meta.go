@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"grout/internal/render"
+)
+
+// meta parameter values: metaColor asks an image endpoint for the colors it would render
+// instead of the image; metaEmbed embeds a provenance record in the image
+const (
+	metaColor = "color"
+	metaEmbed = "1"
+)
+
+// provenanceIgnoredParams do not change the image, so they are left out of the provenance record
+var provenanceIgnoredParams = []string{"meta", "nocache", "fresh"}
+
+// provenance is the record meta=1 embeds in SVG and PNG output
+type provenance struct {
+	Request   string `json:"request"`   // Path and normalized (sorted) query of the generating request
+	Generated string `json:"generated"` // RFC 3339 UTC time the image was rendered
+}
+
+// colorMeta is the ?meta=color response; colors are '#'-prefixed hex values
+type colorMeta struct {
+	Background string `json:"bg"`
+	Foreground string `json:"fg"`
+	Dominant   string `json:"dominant"`
+}
+
+// parseMeta validates the meta parameter; empty means the image itself is wanted
+func parseMeta(errs *paramErrors, value string) string {
+	switch value {
+	case "", metaColor, metaEmbed:
+		return value
+	case "true":
+		return metaEmbed
+	default:
+		errs.add("meta", "must be %s or %s", metaColor, metaEmbed)
+		return ""
+	}
+}
+
+// provenanceRequest returns the request's path and query with parameters sorted and
+// non-rendering ones dropped, so equivalent requests are recorded identically
+func provenanceRequest(r *http.Request) string {
+	query := r.URL.Query()
+	for _, param := range provenanceIgnoredParams {
+		query.Del(param)
+	}
+	if len(query) == 0 {
+		return r.URL.EscapedPath()
+	}
+	return r.URL.EscapedPath() + "?" + query.Encode()
+}
+
+// provenanceRecord serializes the provenance of an image rendered now for request;
+// an empty request (meta=1 not asked for) yields no record
+func provenanceRecord(request string) string {
+	if request == "" {
+		return ""
+	}
+	data, _ := json.Marshal(provenance{Request: request, Generated: time.Now().UTC().Format(time.RFC3339)})
+	return string(data)
+}
+
+// writeColorMeta responds with the colors an image with the given background and text color uses.
+// A gradient background is reported as both stops, comma-separated.
+func writeColorMeta(w http.ResponseWriter, bgHex, fgHex string) {
+	stops := strings.Split(bgHex, ",")
+	for i, stop := range stops {
+		stops[i] = "#" + stop
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// The colors are derived deterministically from the parameters, just like the image
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(colorMeta{
+		Background: strings.Join(stops, ","),
+		Foreground: "#" + fgHex,
+		Dominant:   "#" + render.DominantColor(bgHex),
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"grout/internal/render"
+)
+
+func getColorMeta(t *testing.T, mux *http.ServeMux, path string) colorMeta {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json got %q", ct)
+	}
+	var meta colorMeta
+	if err := json.Unmarshal(rec.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return meta
+}
+
+func TestColorMetaMatchesImage(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	meta := getColorMeta(t, mux, "/avatar/Jane%20Doe?background=random&meta=color")
+
+	expectedBg := "#" + render.ColorFromPalette("Jane Doe", nil)
+	if meta.Background != expectedBg {
+		t.Fatalf("expected bg %s got %s", expectedBg, meta.Background)
+	}
+	if meta.Dominant != expectedBg {
+		t.Fatalf("expected dominant %s got %s", expectedBg, meta.Dominant)
+	}
+
+	// The image rendered from the same parameters uses exactly these colors
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?background=random", nil))
+	svg := rec.Body.String()
+	for _, fill := range []string{`fill="` + meta.Background + `"`, `fill="` + meta.Foreground + `"`} {
+		if !strings.Contains(svg, fill) {
+			t.Fatalf("expected %s in image %s", fill, svg)
+		}
+	}
+}
+
+func TestColorMetaGradientAndErrors(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	meta := getColorMeta(t, mux, "/placeholder/200x100?bg=ff0000,0000ff&meta=color")
+	if meta.Background != "#ff0000,#0000ff" {
+		t.Fatalf("expected both gradient stops got %s", meta.Background)
+	}
+	if meta.Dominant != "#7f007f" {
+		t.Fatalf("expected the averaged dominant color got %s", meta.Dominant)
+	}
+	if meta.Foreground != "#ffffff" {
+		t.Fatalf("expected contrast text color #ffffff got %s", meta.Foreground)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/JD?meta=size", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown meta mode got %d", rec.Code)
+	}
+}
+
+// pngTextChunks returns the keyword/text pairs of every tEXt chunk in a PNG
+func pngTextChunks(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Fatal("expected a PNG signature")
+	}
+	chunks := map[string]string{}
+	for pos := 8; pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		kind := string(data[pos+4 : pos+8])
+		body := data[pos+8 : pos+8+length]
+		if crc32.ChecksumIEEE(data[pos+4:pos+8+length]) != binary.BigEndian.Uint32(data[pos+8+length:]) {
+			t.Fatalf("bad CRC on %s chunk", kind)
+		}
+		if kind == "tEXt" {
+			keyword, text, _ := bytes.Cut(body, []byte{0})
+			chunks[string(keyword)] = string(text)
+		}
+		pos += 12 + length
+	}
+	return chunks
+}
+
+func TestProvenanceEmbedding(t *testing.T) {
+	svc, mux := setupTestService(t)
+	svc.cfg.AllowCacheBypass = true
+
+	parse := func(t *testing.T, record string) provenance {
+		t.Helper()
+		var p provenance
+		if err := json.Unmarshal([]byte(record), &p); err != nil {
+			t.Fatalf("invalid provenance %q: %v", record, err)
+		}
+		if _, err := time.Parse(time.RFC3339, p.Generated); err != nil {
+			t.Fatalf("expected an RFC 3339 timestamp got %q", p.Generated)
+		}
+		return p
+	}
+
+	t.Run("SVG metadata", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?size=64&meta=1&bg=ff0000&nocache=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		svg := rec.Body.String()
+		start := strings.Index(svg, `<metadata id="grout:provenance">`)
+		end := strings.Index(svg, "</metadata>")
+		if start < 0 || end < start {
+			t.Fatalf("expected a provenance <metadata> element in %s", svg)
+		}
+		record := strings.ReplaceAll(svg[start+len(`<metadata id="grout:provenance">`):end], "&quot;", `"`)
+		if p := parse(t, record); p.Request != "/avatar/Jane%20Doe?bg=ff0000&size=64" {
+			t.Fatalf("expected the normalized request got %q", p.Request)
+		}
+
+		// The metadata does not change what is drawn
+		plain := httptest.NewRecorder()
+		mux.ServeHTTP(plain, httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe?size=64&bg=ff0000", nil))
+		withoutMeta := svg[:start] + svg[end+len("</metadata>\n"):]
+		if withoutMeta != plain.Body.String() {
+			t.Fatalf("expected identical drawing with and without meta=1:\n%s\n%s", withoutMeta, plain.Body.String())
+		}
+	})
+
+	t.Run("PNG tEXt chunk", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/placeholder/120x60.png?text=Hi&meta=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		record, ok := pngTextChunks(t, rec.Body.Bytes())[render.ProvenanceKey]
+		if !ok {
+			t.Fatalf("expected a tEXt chunk with key %s", render.ProvenanceKey)
+		}
+		if p := parse(t, record); p.Request != "/placeholder/120x60.png?text=Hi" {
+			t.Fatalf("expected the normalized request got %q", p.Request)
+		}
+		if _, err := png.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+			t.Fatalf("expected a valid PNG: %v", err)
+		}
+	})
+
+	t.Run("Without meta=1 nothing is embedded", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/placeholder/120x60.png?text=Hi", nil))
+		if len(pngTextChunks(t, rec.Body.Bytes())) != 0 {
+			t.Fatal("expected no tEXt chunks")
+		}
+	})
+
+	t.Run("Unsupported format", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/Jane%20Doe.jpg?meta=1", nil))
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("expected 422 got %d", rec.Code)
+		}
+	})
+}
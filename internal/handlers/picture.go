@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"grout/internal/render"
+	"grout/pkg/urlbuilder"
+)
+
+// formatPicture asks for an HTML <picture> snippet embedding the avatar instead of the image
+const formatPicture = "picture"
+
+// pictureDroppedParams only make sense for the snippet itself, not for the image URLs in it
+var pictureDroppedParams = []string{"format", "name", "download", "filename"}
+
+// pictureFormats returns the configured snippet formats, without raster ones in low-memory
+// mode where they are disabled
+func (s *Service) pictureFormats() []render.ImageFormat {
+	var formats []render.ImageFormat
+	for _, name := range s.cfg.PictureFormats {
+		if format := render.ImageFormat(name); !s.cfg.LowMemory || !format.IsRaster() {
+			formats = append(formats, format)
+		}
+	}
+	if len(formats) == 0 {
+		return []render.ImageFormat{render.FormatSVG}
+	}
+	return formats
+}
+
+// pictureConflicts checks the avatar parameters against every format of the snippet,
+// reporting each conflict once
+func pictureConflicts(query url.Values, formats []render.ImageFormat) paramErrors {
+	var conflicts paramErrors
+	for _, format := range formats {
+		for _, conflict := range findConflicts(query, format, avatarConflicts) {
+			if !slices.Contains(conflicts, conflict) {
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	return conflicts
+}
+
+// writeAvatarPicture responds with a <picture> element offering the avatar in the configured
+// formats, most preferred first: a <source> for each but the last and an <img> of the last as
+// fallback. URLs carry the request's other parameters, so every variant shows the same avatar.
+// width and height are the CSS size, before any dpr scaling.
+func (s *Service) writeAvatarPicture(w http.ResponseWriter, r *http.Request, name string, width, height int, alt string) {
+	query := r.URL.Query()
+	formats := s.pictureFormats()
+	if conflicts := pictureConflicts(query, formats); len(conflicts) > 0 {
+		writeParamConflicts(w, conflicts)
+		return
+	}
+	builder, err := urlbuilder.New(s.baseURL())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "invalid base URL")
+		return
+	}
+	for _, param := range pictureDroppedParams {
+		query.Del(param)
+	}
+	avatarURL := func(format render.ImageFormat) string {
+		return html.EscapeString(builder.Avatar(urlbuilder.AvatarOptions{Name: name, Format: urlbuilder.Format(format), Query: query}))
+	}
+
+	var b strings.Builder
+	b.WriteString("<picture>\n")
+	for _, format := range formats[:len(formats)-1] {
+		fmt.Fprintf(&b, "  <source type=\"%s\" srcset=\"%s\">\n", getContentType(format), avatarURL(format))
+	}
+	fmt.Fprintf(&b, "  <img src=\"%s\" alt=\"%s\" width=\"%d\" height=\"%d\">\n", avatarURL(formats[len(formats)-1]), html.EscapeString(alt), width, height)
+	b.WriteString("</picture>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", s.imageCacheControl())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(b.String()))
+}
@@ -2,29 +2,27 @@ package handlers
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"grout/internal/config"
 	"grout/internal/content"
 	"grout/internal/render"
-	"grout/internal/utils"
 )
 
 func (s *Service) handlePlaceholder(w http.ResponseWriter, r *http.Request) {
-	width, height := config.DefaultSize, config.DefaultSize
 	pathMetric := strings.TrimPrefix(r.URL.Path, "/placeholder/")
 
 	// Extract format from path
 	format, pathMetric := extractFormat(pathMetric)
 
-	if matches := placeholderRegex.FindStringSubmatch(pathMetric); len(matches) == 3 {
-		width = utils.ParseIntOrDefault(matches[1], config.DefaultSize)
-		height = utils.ParseIntOrDefault(matches[2], config.DefaultSize)
-	} else {
-		width = utils.ParseIntOrDefault(r.URL.Query().Get("w"), config.DefaultSize)
-		height = utils.ParseIntOrDefault(r.URL.Query().Get("h"), config.DefaultSize)
-	}
+	var errs paramErrors
+	s.checkUnknownParams(&errs, r.URL.Query(), placeholderParams)
+	format = parseFormat(&errs, "format", r.URL.Query().Get("format"), format)
+
+	width, height := placeholderSize(&errs, pathMetric, r.URL.Query())
 
 	// Check for quote or joke parameter
 	quoteParam := r.URL.Query().Get("quote")
@@ -33,6 +31,9 @@ func (s *Service) handlePlaceholder(w http.ResponseWriter, r *http.Request) {
 
 	text := r.URL.Query().Get("text")
 	isQuoteOrJoke := false
+	// labelRound rounds the numbers of the default dimension label, e.g. 451 to 450 with labelRound=10
+	labelRound := parseDimension(&errs, "labelRound", r.URL.Query().Get("labelRound"), 1)
+	label := dimensionLabel(width, height, labelRound)
 
 	// Priority: quote > joke > text > default
 	// Only render quote/joke if minimum width requirement is met
@@ -45,7 +46,7 @@ func (s *Service) handlePlaceholder(w http.ResponseWriter, r *http.Request) {
 			} else {
 				// If error (e.g., invalid category), fall back to text or default
 				if text == "" {
-					text = fmt.Sprintf("%d x %d", width, height)
+					text = label
 				}
 			}
 		}
@@ -58,29 +59,154 @@ func (s *Service) handlePlaceholder(w http.ResponseWriter, r *http.Request) {
 			} else {
 				// If error (e.g., invalid category), fall back to text or default
 				if text == "" {
-					text = fmt.Sprintf("%d x %d", width, height)
+					text = label
 				}
 			}
 		}
 	} else if text == "" {
-		text = fmt.Sprintf("%d x %d", width, height)
+		text = label
+	}
+
+	// dpr renders raster output at a multiple of the requested size; the label keeps the requested size
+	width, height = scaleDPR(width, height, devicePixelRatio(&errs, w, r, format))
+
+	// icon draws a bundled icon instead of any text
+	icon := r.URL.Query().Get("icon")
+	if icon != "" {
+		if !render.IsIcon(icon) {
+			errs.add("icon", "must be one of %s", strings.Join(render.IconNames(), ", "))
+		}
+		text, isQuoteOrJoke = "", false
+	}
+	// qr draws the given text as a QR code instead of the label, e.g. a URL for demos
+	qr := r.URL.Query().Get("qr")
+	qrLevel := s.parseQR(&errs, qr, r.URL.Query().Get("qrLevel"), width, height)
+	if qr != "" {
+		text, isQuoteOrJoke = "", false
 	}
 
 	// Accept both 'background' and 'bg' for consistency (background is primary)
-	bgHex := r.URL.Query().Get("background")
-	if bgHex == "" {
-		bgHex = r.URL.Query().Get("bg")
+	bgParam, bgValue := "background", r.URL.Query().Get("background")
+	if bgValue == "" {
+		bgParam, bgValue = "bg", r.URL.Query().Get("bg")
+	}
+	bgHex := parseColor(&errs, bgParam, bgValue, config.DefaultBgColor, true)
+	fgHex := parseColor(&errs, "color", r.URL.Query().Get("color"), "", false)
+	meta := parseMeta(&errs, r.URL.Query().Get("meta"))
+	// blur softens the background layer while the text stays sharp
+	blur := parseBlur(&errs, "blur", r.URL.Query().Get("blur"))
+	// ribbon labels non-production images, e.g. "DRAFT", with a diagonal corner banner
+	ribbon := parseRibbon(&errs, r.URL.Query().Get("ribbon"))
+	// vignette darkens the edges of the background for depth; the text stays on top
+	vignette := parseVignette(&errs, r.URL.Query().Get("vignette"))
+	// opacity renders a translucent placeholder, e.g. for skeleton states
+	fade := parseOpacity(&errs, r.URL.Query().Get("opacity"))
+	// shape=bubble draws a speech bubble for chat and testimonial mockups; square is the default
+	shape := render.ShapeSquare
+	if shapeParam := r.URL.Query().Get("shape"); shapeParam != "" {
+		if parsed, ok := render.ParseShape(shapeParam); ok && (parsed == render.ShapeSquare || parsed == render.ShapeBubble) {
+			shape = parsed
+		} else {
+			errs.add("shape", "must be one of square, bubble")
+		}
+	}
+	tail := parseTail(&errs, r.URL.Query().Get("tail"))
+	// flip mirrors the placeholder; flipText=false keeps the label readable
+	flip, flipSkipsText := parseFlip(&errs, r.URL.Query().Get("flip"), r.URL.Query().Get("flipText"))
+	// gammaCorrect=false blends raster text like before, in sRGB instead of linear light
+	gammaCorrect := parseGammaCorrect(&errs, r.URL.Query().Get("gammaCorrect"), s.cfg.GammaCorrect)
+	// quality overrides the configured JPEG and WebP encoder quality for this request
+	quality := parseQuality(&errs, r.URL.Query().Get("quality"))
+	// labelFont sets the label's own font, e.g. go-mono for a technical look
+	labelFamily, labelWeight := s.labelFont(&errs, r.URL.Query().Get("labelFont"))
+	// colorProfile=none drops the sRGB tag from PNG and JPEG output for smaller files
+	colorProfile := parseColorProfile(&errs, r.URL.Query().Get("colorProfile"))
+	download, filename := parseDownload(&errs, r.URL.Query(), format)
+	// alt labels SVG output for screen readers; {name} and {initials} both stand for the label text
+	alt := s.altText(&errs, r.URL.Query().Get("alt"), format, text, text, fmt.Sprintf("%dx%d", width, height))
+
+	if len(errs) > 0 {
+		writeParamErrors(w, errs)
+		return
 	}
-	if bgHex == "" {
-		bgHex = config.DefaultBgColor
+	if conflicts := findConflicts(r.URL.Query(), format, placeholderConflicts); len(conflicts) > 0 {
+		writeParamConflicts(w, conflicts)
+		return
 	}
-	fgHex := r.URL.Query().Get("color")
 	if fgHex == "" {
 		fgHex = render.GetContrastColor(bgHex)
 	}
+	if meta == metaColor {
+		writeColorMeta(w, bgHex, fgHex)
+		return
+	}
 
-	key := fmt.Sprintf("PH:%d:%d:%s:%s:%s:%s", width, height, bgHex, fgHex, text, format)
-	s.serveImage(w, r, key, format, func() ([]byte, error) {
-		return s.renderer.DrawPlaceholderImage(width, height, bgHex, fgHex, text, isQuoteOrJoke, format)
+	// tile repeats the first character of the text (e.g. an emoji) across the background
+	tile := r.URL.Query().Get("tile") == "1" || r.URL.Query().Get("tile") == "true"
+	standalone := wantsStandalone(r)
+	// cssVars lets pages recolor an inline SVG through --grout-bg and --grout-fg
+	cssVars := isTrue(r.URL.Query().Get("cssVars"))
+	var provenanceReq string
+	if meta == metaEmbed {
+		provenanceReq = provenanceRequest(r)
+	}
+	// text, ribbon, the request and alt are quoted like qr, keeping the key's ':' separators unambiguous
+	key := fmt.Sprintf("PH:%d:%d:%s:%s:%q:%s:%q:%s:%t:%g:%g:%q:%t:%s:%s:%q:%q:%g:%s:%t:%s:%s:%s:%s:%t:%t:%d", width, height, bgHex, fgHex, text, icon, qr, qrLevel, tile, blur, vignette, ribbon, standalone, format, colorProfile, provenanceReq, alt, fade, flip, flipSkipsText, shape, tail, labelFamily, labelWeight, cssVars, gammaCorrect, quality)
+	if filename != "" {
+		setAttachment(w, format, filename)
+	} else if standalone || download {
+		setAttachment(w, format, "placeholder", fmt.Sprintf("%dx%d", width, height))
+	}
+	s.serveImage(w, r, key, format, func(dst io.Writer) error {
+		return s.renderer.Render(dst, render.Options{
+			Width:         width,
+			Height:        height,
+			Background:    bgHex,
+			Foreground:    fgHex,
+			Text:          text,
+			Icon:          icon,
+			QR:            qr,
+			QRLevel:       qrLevel,
+			Shape:         shape,
+			Tail:          tail,
+			Weight:        labelWeight,
+			FontFamily:    labelFamily,
+			Format:        format,
+			QuoteOrJoke:   isQuoteOrJoke,
+			Tile:          tile,
+			Blur:          blur,
+			Vignette:      vignette,
+			Fade:          fade,
+			Flip:          flip,
+			FlipSkipsText: flipSkipsText,
+			Ribbon:        ribbon,
+			ColorProfile:  colorProfile,
+			Provenance:    provenanceRecord(provenanceReq),
+			Standalone:    standalone,
+			CSSVars:       cssVars,
+			GammaCorrect:  gammaCorrect,
+			Title:         alt,
+			Encoding:      s.encoding(quality),
+		})
 	})
 }
+
+// placeholderSize parses the requested size from a WIDTHxHEIGHT path, else from ?w= and ?h=,
+// before any dpr scaling
+func placeholderSize(errs *paramErrors, pathMetric string, query url.Values) (width, height int) {
+	if matches := placeholderRegex.FindStringSubmatch(pathMetric); len(matches) == 3 {
+		return parseDimension(errs, "width", matches[1], config.DefaultSize),
+			parseDimension(errs, "height", matches[2], config.DefaultSize)
+	}
+	return parseDimension(errs, "w", query.Get("w"), config.DefaultSize),
+		parseDimension(errs, "h", query.Get("h"), config.DefaultSize)
+}
+
+// dimensionLabel is the default placeholder text, e.g. "800 x 450". Each dimension is
+// rounded to the nearest multiple of step, but never below step; the image keeps its exact size.
+func dimensionLabel(width, height, step int) string {
+	round := func(n int) int {
+		return max(step, (n+step/2)/step*step)
+	}
+	return fmt.Sprintf("%d x %d", round(width), round(height))
+}
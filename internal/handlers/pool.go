@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"grout/internal/render"
+)
+
+// maxPooledBufferSize keeps buffers grown by unusually large renders out of the pool,
+// so one huge image does not pin its memory for the life of the process
+const maxPooledBufferSize = 1 << 20
+
+// renderBufferPool recycles the buffers images are rendered into. Their bytes must be
+// copied before they outlive the request, e.g. when stored in the cache.
+var renderBufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// getRenderBuffer returns an empty buffer from the pool
+func getRenderBuffer() *bytes.Buffer {
+	return renderBufferPool.Get().(*bytes.Buffer)
+}
+
+// putRenderBuffer resets buf and returns it to the pool
+func putRenderBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	renderBufferPool.Put(buf)
+}
+
+// encodeRetryAfter is the Retry-After, in seconds, of raster requests that found no encode slot
+const encodeRetryAfter = 1
+
+// acquireEncodeSlot waits up to cfg.RasterEncodeWait for one of the raster encode slots.
+// It reports false when none freed up in time or the request was canceled; otherwise the
+// caller must call release once the image is encoded. SVG output and services without an
+// encode cap never wait.
+func (s *Service) acquireEncodeSlot(ctx context.Context, format render.ImageFormat) (release func(), ok bool) {
+	if s.encodeSlots == nil || !format.IsRaster() {
+		return func() {}, true
+	}
+	release = func() { <-s.encodeSlots }
+	// A free slot is taken without arming a timer
+	select {
+	case s.encodeSlots <- struct{}{}:
+		return release, true
+	default:
+	}
+	timer := time.NewTimer(s.cfg.RasterEncodeWait)
+	defer timer.Stop()
+	select {
+	case s.encodeSlots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2"
+
+	"grout/internal/config"
+	"grout/internal/render"
+)
+
+func newPoolTestMux(tb testing.TB) *http.ServeMux {
+	renderer, err := render.New()
+	if err != nil {
+		tb.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](16)
+	cfg := config.DefaultServerConfig()
+	cfg.AllowCacheBypass = true // BenchmarkServeImageMiss renders every request with nocache=1
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+	return mux
+}
+
+func TestPooledBuffersDoNotCorruptCache(t *testing.T) {
+	mux := newPoolTestMux(t)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d for %s", rec.Code, path)
+		}
+		return rec
+	}
+
+	for _, ext := range []string{"", ".png"} {
+		t.Run("format"+ext, func(t *testing.T) {
+			first := get("/avatar/Jane%20Doe" + ext).Body.Bytes()
+
+			// Later renders reuse the pooled buffer the first image was rendered into
+			for _, name := range []string{"Bob", "Alice%20Smith", "Zed"} {
+				get("/avatar/" + name + ext + "?background=123456")
+			}
+
+			rec := get("/avatar/Jane%20Doe" + ext)
+			if rec.Header().Get("X-Cache") != "HIT" {
+				t.Fatalf("expected a cache hit got %s", rec.Header().Get("X-Cache"))
+			}
+			if !bytes.Equal(rec.Body.Bytes(), first) {
+				t.Fatal("expected the cached image to be unchanged by later renders")
+			}
+		})
+	}
+}
+
+func TestPutRenderBufferDropsLargeBuffers(t *testing.T) {
+	buf := getRenderBuffer()
+	buf.Grow(maxPooledBufferSize + 1)
+	putRenderBuffer(buf)
+
+	// sync.Pool may drop items at any time, so only the reset of small buffers is checked
+	small := getRenderBuffer()
+	small.WriteString("data")
+	putRenderBuffer(small)
+	if small.Len() != 0 {
+		t.Fatalf("expected a reset buffer got %d bytes", small.Len())
+	}
+}
+
+// BenchmarkRenderBuffer compares rendering into a fresh buffer per request with the
+// pooled buffer plus an exactly sized copy for the cache, as serveImage does
+func BenchmarkRenderBuffer(b *testing.B) {
+	renderer, err := render.New()
+	if err != nil {
+		b.Fatalf("renderer init: %v", err)
+	}
+	// A long quote streams many small writes, growing an unpooled buffer step by step
+	opts := render.Options{Width: 1200, Height: 800, Background: "3366cc", Foreground: "ffffff",
+		Text: strings.Repeat("pooled buffers keep allocations down ", 100), QuoteOrJoke: true, Format: render.FormatSVG}
+
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			if err := renderer.Render(&buf, opts); err != nil {
+				b.Fatal(err)
+			}
+			_ = buf.Bytes()
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := getRenderBuffer()
+			if err := renderer.Render(buf, opts); err != nil {
+				b.Fatal(err)
+			}
+			_ = bytes.Clone(buf.Bytes())
+			putRenderBuffer(buf)
+		}
+	})
+}
+
+func BenchmarkServeImageMiss(b *testing.B) {
+	mux := newPoolTestMux(b)
+	for _, path := range []string{"/avatar/Jane%20Doe?nocache=1", "/avatar/Jane%20Doe.png?nocache=1"} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				_, _ = io.Copy(io.Discard, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRasterEncodePool(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](16)
+	cfg := config.DefaultServerConfig()
+	cfg.RasterEncodeWorkers = 1
+	cfg.RasterEncodeWait = 20 * time.Millisecond
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Warm the cache before the pool is saturated; hits never need a slot
+	if rec := get("/avatar/Cached.png"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rec.Code)
+	}
+
+	// Hold the only slot, as a long-running encode would
+	svc.encodeSlots <- struct{}{}
+
+	t.Run("Raster gets 503", func(t *testing.T) {
+		rec := get("/avatar/Jane.png")
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 got %d", rec.Code)
+		}
+		if rec.Header().Get("Retry-After") != "1" {
+			t.Fatalf("expected Retry-After 1 got %q", rec.Header().Get("Retry-After"))
+		}
+		if rec.Header().Get("ETag") != "" || rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("expected a JSON error without ETag got %v", rec.Header())
+		}
+		if cache.Len() != 1 {
+			t.Fatalf("expected only the warmed image to be cached got %d entries", cache.Len())
+		}
+	})
+
+	t.Run("SVG is not queued", func(t *testing.T) {
+		for _, path := range []string{"/avatar/Jane", "/placeholder/300x200"} {
+			if rec := get(path); rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 for %s got %d", path, rec.Code)
+			}
+		}
+	})
+
+	t.Run("Cache hits are served", func(t *testing.T) {
+		rec := get("/avatar/Cached.png")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+			t.Fatalf("expected a 200 cache hit got %d %s", rec.Code, rec.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("Queued raster renders once a slot frees up", func(t *testing.T) {
+		cfg.RasterEncodeWait = time.Second
+		queued := NewService(renderer, cache, cfg)
+		queuedMux := http.NewServeMux()
+		queued.RegisterRoutes(queuedMux, nil)
+		queued.encodeSlots <- struct{}{}
+
+		done := make(chan int)
+		go func() {
+			rec := httptest.NewRecorder()
+			queuedMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/Queued.png", nil))
+			done <- rec.Code
+		}()
+		select {
+		case code := <-done:
+			t.Fatalf("expected the request to wait for a slot, got %d", code)
+		case <-time.After(20 * time.Millisecond):
+		}
+		<-queued.encodeSlots
+		if code := <-done; code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", code)
+		}
+		if len(queued.encodeSlots) != 0 {
+			t.Fatalf("expected the slot to be released")
+		}
+	})
+
+	<-svc.encodeSlots
+	if rec := get("/avatar/Jane.png"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 after the slot is released got %d", rec.Code)
+	}
+}
+
+func TestRasterEncodePoolDisabledByDefault(t *testing.T) {
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	svc := NewService(renderer, cache, config.DefaultServerConfig())
+	if svc.encodeSlots != nil {
+		t.Fatalf("expected no encode slots without RasterEncodeWorkers")
+	}
+}
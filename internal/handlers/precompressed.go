@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"grout/internal/middleware"
+)
+
+// precompressedEncodings are the codings generated text assets are precompressed in,
+// in the order they are preferred on equal client weights
+var precompressedEncodings = []string{"br", "gzip"}
+
+// precompressedCache keeps the compressed variants of generated text assets such as
+// robots.txt, so they are compressed once rather than on every request. An entry is
+// rebuilt whenever the generated body changes, e.g. after the static file was edited.
+type precompressedCache struct {
+	mu           sync.Mutex
+	entries      map[string]precompressedEntry
+	compressions int // Number of bodies compressed, one per coding
+}
+
+type precompressedEntry struct {
+	body     string
+	variants map[string][]byte // Compressed body by content coding
+}
+
+func newPrecompressedCache() *precompressedCache {
+	return &precompressedCache{entries: make(map[string]precompressedEntry)}
+}
+
+// variant returns body compressed with encoding, compressing name in every one of encodings
+// the first time body is seen. It reports false when the coding failed to compress or is
+// not among encodings.
+func (c *precompressedCache) variant(name, body, encoding string, encodings []string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[name]
+	if !ok || entry.body != body {
+		entry = precompressedEntry{body: body, variants: make(map[string][]byte)}
+		for _, enc := range encodings {
+			// Compressed once per change, so the best ratio is worth its CPU
+			data, err := middleware.CompressBody(enc, gzip.BestCompression, []byte(body))
+			c.compressions++
+			if err == nil {
+				entry.variants[enc] = data
+			}
+		}
+		c.entries[name] = entry
+	}
+	data, ok := entry.variants[encoding]
+	return data, ok
+}
+
+// writePrecompressed writes body, the generated text asset name, as a 200 response with the
+// Content-Type already set. Clients accepting brotli or gzip get its cached compressed
+// variant, unless the compression settings would not compress the response: low-memory
+// mode offers gzip only, and skipped paths, other content types and no-transform requests
+// get the plain body.
+func (s *Service) writePrecompressed(w http.ResponseWriter, r *http.Request, name, body string) {
+	middleware.AddVary(w.Header(), "Accept-Encoding")
+	allowed := s.compression.Allowed(r, w.Header(), precompressedEncodings...)
+	encoding := middleware.NegotiateEncoding(r.Header.Get("Accept-Encoding"), allowed...)
+	if encoding != "" {
+		if data, ok := s.precompressed.variant(name, body, encoding, allowed); ok {
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(body))
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"grout/internal/render"
+)
+
+// selfTestSize is the edge of the startup samples in pixels: enough to exercise fonts and
+// encoders while keeping a full run to milliseconds
+const selfTestSize = 16
+
+// selfTestBudget bounds a whole self-test run; samples not reached in time count as failed
+const selfTestBudget = 10 * time.Second
+
+// sampleRenderer renders an image; *render.Renderer is one
+type sampleRenderer interface {
+	Render(w io.Writer, opts render.Options) error
+}
+
+// SelfTest renders a tiny sample of every enabled style in every served format, logging
+// each failure. It returns the failures joined, or nil when everything rendered.
+func (s *Service) SelfTest() error {
+	return selfTest(s.renderer, s.styleNames(), s.formats(), s.encoding(0), s.cfg.GammaCorrect, selfTestBudget)
+}
+
+// selfTest renders one sample per style and format with renderer, giving up once budget is spent
+func selfTest(renderer sampleRenderer, styles []string, formats []render.ImageFormat, enc render.Encoding, gammaCorrect bool, budget time.Duration) error {
+	deadline := time.Now().Add(budget)
+	var failures []error
+	for _, style := range styles {
+		for _, format := range formats {
+			if time.Now().After(deadline) {
+				err := fmt.Errorf("self-test: %s %s: not reached within %s", style, format, budget)
+				log.Print(err)
+				failures = append(failures, err)
+				continue
+			}
+			err := renderer.Render(io.Discard, render.Options{
+				Width:        selfTestSize,
+				Height:       selfTestSize,
+				Background:   "cccccc",
+				Foreground:   "333333",
+				Text:         "GR",
+				Shape:        render.ShapeSquare,
+				Weight:       render.WeightBold,
+				Style:        render.Style(style),
+				Format:       format,
+				GammaCorrect: gammaCorrect && format.IsRaster(),
+				Encoding:     enc,
+			})
+			if err != nil {
+				err = fmt.Errorf("self-test: %s %s: %w", style, format, err)
+				log.Print(err)
+				failures = append(failures, err)
+			}
+		}
+	}
+	return errors.Join(failures...)
+}
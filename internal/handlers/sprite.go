@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+	"grout/internal/render"
+)
+
+// spriteRect is where one image sits in the sprite sheet and the CSS class showing it
+type spriteRect struct {
+	ID     string `json:"id"`
+	Class  string `json:"class"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// spriteResponse is the combined sprite sheet, its stylesheet and the layout both follow.
+// Image is base64 encoded by encoding/json; items that failed to render are left out of it.
+type spriteResponse struct {
+	Image  []byte        `json:"image"`
+	CSS    string        `json:"css"`
+	Rects  []spriteRect  `json:"rects"`
+	Errors []batchResult `json:"errors,omitempty"`
+}
+
+var spriteClassUnsafeRegex = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// handleSprite renders a batch of avatars/placeholders as PNG into one sprite sheet plus a
+// stylesheet with a class per id. ?format=png or ?format=css returns just that part, so the
+// same request body can back both an <img> URL and a <link>. ?spriteUrl= sets the image URL
+// used in the CSS (default sprite.png).
+func (s *Service) handleSprite(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			middleware.WriteBodyTooLarge(w, maxErr.Limit)
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid sprite body: "+err.Error())
+		return
+	}
+	if len(req.Items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "sprite must contain at least one item")
+		return
+	}
+	if len(req.Items) > config.MaxBatchItems {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("sprite exceeds %d items", config.MaxBatchItems))
+		return
+	}
+
+	query := r.URL.Query()
+	var errs paramErrors
+	part := query.Get("format")
+	if part != "" && part != "png" && part != "css" {
+		errs.add("format", "must be png or css")
+	}
+	spriteURL := query.Get("spriteUrl")
+	if spriteURL == "" {
+		spriteURL = "sprite.png"
+	}
+	if strings.ContainsAny(spriteURL, "\"\\\n\r") {
+		errs.add("spriteUrl", "must not contain quotes, backslashes or line breaks")
+	}
+	classes := make(map[string]string, len(req.Items))
+	for _, item := range req.Items {
+		class := spriteClass(item.ID)
+		if other, ok := classes[class]; ok {
+			errs.add("id", "%q and %q both map to the class %s", other, item.ID, class)
+		}
+		classes[class] = item.ID
+	}
+	if len(errs) > 0 {
+		writeParamErrors(w, errs)
+		return
+	}
+	// The sheet's size follows from the item URLs, so an oversized sprite is refused before
+	// anything is rendered; the check after rendering stays as the authority
+	planned := make([]spriteRect, len(req.Items))
+	for i, item := range req.Items {
+		planned[i].Width, planned[i].Height = spriteItemSize(withPNGFormat(item.URL))
+	}
+	if width, height := layoutSprite(planned); width > config.MaxImageSize || height > config.MaxImageSize {
+		writeSpriteTooLarge(w, width, height)
+		return
+	}
+
+	var images []image.Image
+	var resp spriteResponse
+	for _, item := range req.Items {
+		result := s.renderBatchItem(r, batchItem{ID: item.ID, URL: withPNGFormat(item.URL)})
+		if result.Status != http.StatusOK {
+			resp.Errors = append(resp.Errors, result)
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(result.Data))
+		if err != nil {
+			resp.Errors = append(resp.Errors, batchResult{ID: item.ID, Status: http.StatusInternalServerError, Error: "decode png: " + err.Error()})
+			continue
+		}
+		b := img.Bounds()
+		images = append(images, img)
+		resp.Rects = append(resp.Rects, spriteRect{
+			ID:     item.ID,
+			Class:  spriteClass(item.ID),
+			Width:  b.Dx(),
+			Height: b.Dy(),
+		})
+	}
+	if len(images) == 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, "no sprite item rendered")
+		return
+	}
+
+	width, height := layoutSprite(resp.Rects)
+	if width > config.MaxImageSize || height > config.MaxImageSize {
+		writeSpriteTooLarge(w, width, height)
+		return
+	}
+	sheet := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i, img := range images {
+		rect := resp.Rects[i]
+		draw.Draw(sheet, image.Rect(rect.X, rect.Y, rect.X+rect.Width, rect.Y+rect.Height), img, img.Bounds().Min, draw.Src)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, sheet); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "encode sprite: "+err.Error())
+		return
+	}
+	resp.Image = buf.Bytes()
+	resp.CSS = spriteCSS(spriteURL, resp.Rects)
+
+	switch part {
+	case "png":
+		w.Header().Set("Content-Type", getContentType(render.FormatPNG))
+		_, _ = w.Write(resp.Image)
+	case "css":
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		_, _ = w.Write([]byte(resp.CSS))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// writeSpriteTooLarge refuses a sprite sheet over config.MaxImageSize in either dimension
+func writeSpriteTooLarge(w http.ResponseWriter, width, height int) {
+	writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("sprite would be %dx%d; it must not exceed %d in either dimension", width, height, config.MaxImageSize))
+}
+
+// spriteItemSize works out the pixel size a PNG avatar or placeholder URL renders at, with the
+// handlers' own parsing. URLs the render will reject count as 0x0 and end up in the errors.
+func spriteItemSize(itemURL string) (width, height int) {
+	r, err := http.NewRequest(http.MethodGet, itemURL, nil)
+	if err != nil {
+		return 0, 0
+	}
+	var errs paramErrors
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/avatar/"):
+		width, height, _, _ = avatarSize(&errs, newBufferedResponse(), r, render.FormatPNG)
+	case strings.HasPrefix(r.URL.Path, "/placeholder/"):
+		_, pathMetric := extractFormat(strings.TrimPrefix(r.URL.Path, "/placeholder/"))
+		width, height = placeholderSize(&errs, pathMetric, r.URL.Query())
+		width, height = scaleDPR(width, height, devicePixelRatio(&errs, newBufferedResponse(), r, render.FormatPNG))
+	default:
+		return 0, 0
+	}
+	if len(errs) > 0 {
+		return 0, 0
+	}
+	return width, height
+}
+
+// spriteClass turns// spriteClass turns an item id into the CSS class selecting its rect
+func spriteClass(id string) string {
+	return "sprite-" + strings.Trim(spriteClassUnsafeRegex.ReplaceAllString(id, "-"), "-")
+}
+
+// withPNGFormat forces PNG output for a batch item URL, whatever its extension says
+func withPNGFormat(itemURL string) string {
+	u, err := url.Parse(itemURL)
+	if err != nil {
+		return itemURL
+	}
+	q := u.Query()
+	q.Set("format", string(render.FormatPNG))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// layoutSprite places the rects on a near-square grid of equal cells sized to the largest
+// image, in order, and returns the sheet's size
+func layoutSprite(rects []spriteRect) (width, height int) {
+	var cellW, cellH int
+	for _, rect := range rects {
+		cellW, cellH = max(cellW, rect.Width), max(cellH, rect.Height)
+	}
+	columns := int(math.Ceil(math.Sqrt(float64(len(rects)))))
+	for i := range rects {
+		rects[i].X, rects[i].Y = i%columns*cellW, i/columns*cellH
+	}
+	rows := (len(rects) + columns - 1) / columns
+	return columns * cellW, rows * cellH
+}
+
+// spriteCSS writes a shared rule for the sheet and one rule per image with its size and
+// background-position
+func spriteCSS(spriteURL string, rects []spriteRect) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[class^=\"sprite-\"] { display: inline-block; background-image: url(\"%s\"); background-repeat: no-repeat; }\n", spriteURL)
+	for _, rect := range rects {
+		fmt.Fprintf(&b, ".%s { width: %dpx; height: %dpx; background-position: %s %s; }\n",
+			rect.Class, rect.Width, rect.Height, cssOffset(rect.X), cssOffset(rect.Y))
+	}
+	return b.String()
+}
+
+// cssOffset renders a background-position offset, which is negative to shift the sheet left or up
+func cssOffset(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("-%dpx", n)
+}
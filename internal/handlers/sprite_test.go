@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"grout/internal/config"
+)
+
+func postSprite(t *testing.T, mux *http.ServeMux, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSpriteHandler(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	body := `{"items":[
+		{"id":"jane","url":"/avatar/Jane%20Doe.svg?size=64&bg=ff0000"},
+		{"id":"bob","url":"/avatar/Bob?size=32&bg=00ff00"},
+		{"id":"hero","url":"/placeholder/80x40?bg=0000ff&text=%20"},
+		{"id":"bad","url":"/health"}
+	]}`
+	rec := postSprite(t, mux, "/batch/sprite?spriteUrl=/static/team.png", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp spriteResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode sprite response: %v", err)
+	}
+	if len(resp.Rects) != 3 {
+		t.Fatalf("expected 3 rects got %d", len(resp.Rects))
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].ID != "bad" {
+		t.Fatalf("expected the unsupported url in errors, got %+v", resp.Errors)
+	}
+	if !strings.Contains(resp.CSS, `url("/static/team.png")`) {
+		t.Fatalf("expected the sprite url in the css, got %s", resp.CSS)
+	}
+
+	sheet, err := png.Decode(bytes.NewReader(resp.Image))
+	if err != nil {
+		t.Fatalf("failed to decode sprite: %v", err)
+	}
+
+	// The sample points sit in each rect's top-left corner, which is background for both
+	// avatars and the placeholder
+	colors := map[string]string{"jane": "ff0000", "bob": "00ff00", "hero": "0000ff"}
+	sizes := map[string][2]int{"jane": {64, 64}, "bob": {32, 32}, "hero": {80, 40}}
+	ruleRegex := regexp.MustCompile(`\.(sprite-\w+) \{ width: (\d+)px; height: (\d+)px; background-position: (0|-\d+px) (0|-\d+px); \}`)
+	rules := make(map[string][]string)
+	for _, m := range ruleRegex.FindAllStringSubmatch(resp.CSS, -1) {
+		rules[m[1]] = m[2:]
+	}
+
+	for _, rect := range resp.Rects {
+		t.Run(rect.ID, func(t *testing.T) {
+			if size := sizes[rect.ID]; rect.Width != size[0] || rect.Height != size[1] {
+				t.Fatalf("expected %dx%d got %dx%d", size[0], size[1], rect.Width, rect.Height)
+			}
+			rule, ok := rules[rect.Class]
+			if !ok {
+				t.Fatalf("expected a css rule for %s in %s", rect.Class, resp.CSS)
+			}
+			want := []string{strconv.Itoa(rect.Width), strconv.Itoa(rect.Height), cssOffset(rect.X), cssOffset(rect.Y)}
+			if strings.Join(rule, " ") != strings.Join(want, " ") {
+				t.Fatalf("expected rule %v got %v", want, rule)
+			}
+			if rect.X+rect.Width > sheet.Bounds().Dx() || rect.Y+rect.Height > sheet.Bounds().Dy() {
+				t.Fatalf("rect %+v outside sprite %v", rect, sheet.Bounds())
+			}
+			r, g, b, _ := sheet.At(rect.X+1, rect.Y+1).RGBA()
+			if got := fmt.Sprintf("%02x%02x%02x", r>>8, g>>8, b>>8); got != colors[rect.ID] {
+				t.Fatalf("expected %s at %d,%d got %s", colors[rect.ID], rect.X+1, rect.Y+1, got)
+			}
+		})
+	}
+
+	// Rects must not overlap
+	for i, a := range resp.Rects {
+		for _, b := range resp.Rects[i+1:] {
+			if a.X < b.X+b.Width && b.X < a.X+a.Width && a.Y < b.Y+b.Height && b.Y < a.Y+a.Height {
+				t.Fatalf("rects %+v and %+v overlap", a, b)
+			}
+		}
+	}
+}
+
+func TestSpriteHandlerParts(t *testing.T) {
+	_, mux := setupTestService(t)
+	body := `{"items":[{"id":"a","url":"/avatar/A?size=16"},{"id":"b","url":"/avatar/B?size=16"}]}`
+
+	tests := []struct {
+		query       string
+		contentType string
+	}{
+		{"?format=png", "image/png"},
+		{"?format=css", "text/css; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := postSprite(t, mux, "/batch/sprite"+tt.query, body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200 got %d: %s", rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Fatalf("expected %s got %s", tt.contentType, ct)
+			}
+		})
+	}
+
+	rec := postSprite(t, mux, "/batch/sprite?format=css", body)
+	if want := ".sprite-b { width: 16px; height: 16px; background-position: -16px 0; }"; !strings.Contains(rec.Body.String(), want) {
+		t.Fatalf("expected %q in %s", want, rec.Body.String())
+	}
+}
+
+func TestSpriteHandlerInvalid(t *testing.T) {
+	_, mux := setupTestService(t)
+
+	var tooMany []string
+	for i := 0; i <= config.MaxBatchItems; i++ {
+		tooMany = append(tooMany, fmt.Sprintf(`{"id":"%d","url":"/avatar/A"}`, i))
+	}
+	var tooLarge []string
+	for i := 0; i < 5; i++ {
+		tooLarge = append(tooLarge, fmt.Sprintf(`{"id":"%d","url":"/placeholder/2000x100"}`, i))
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		code  int
+	}{
+		{"Malformed JSON", "", `{"items":`, http.StatusBadRequest},
+		{"Empty sprite", "", `{"items":[]}`, http.StatusBadRequest},
+		{"Too many items", "", `{"items":[` + strings.Join(tooMany, ",") + `]}`, http.StatusBadRequest},
+		{"Sheet too large", "", `{"items":[` + strings.Join(tooLarge, ",") + `]}`, http.StatusBadRequest},
+		{"Colliding classes", "", `{"items":[{"id":"a b","url":"/avatar/A"},{"id":"a.b","url":"/avatar/B"}]}`, http.StatusBadRequest},
+		{"Unknown format", "?format=gif", `{"items":[{"id":"a","url":"/avatar/A"}]}`, http.StatusBadRequest},
+		{"Quote in sprite url", `?spriteUrl=a%22b`, `{"items":[{"id":"a","url":"/avatar/A"}]}`, http.StatusBadRequest},
+		{"Nothing rendered", "", `{"items":[{"id":"a","url":"/health"}]}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postSprite(t, mux, "/batch/sprite"+tt.query, tt.body)
+			if rec.Code != tt.code {
+				t.Fatalf("expected %d got %d: %s", tt.code, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestSpriteHandlerRejectsOversizedBeforeRendering(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"Avatar", "/avatar/A?size=2000&dpr=2"},
+		{"Placeholder", "/placeholder/2000x100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, mux := setupTestService(t)
+			items := make([]string, 5)
+			for i := range items {
+				items[i] = fmt.Sprintf(`{"id":"%d","url":%q}`, i, tt.url)
+			}
+			rec := postSprite(t, mux, "/batch/sprite", `{"items":[`+strings.Join(items, ",")+`]}`)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400 got %d: %s", rec.Code, rec.Body.String())
+			}
+			// Every render passes through the cache, which must still be empty
+			if n := svc.cache.Len(); n != 0 {
+				t.Fatalf("expected no item rendered got %d cached", n)
+			}
+		})
+	}
+}
@@ -2,10 +2,26 @@ package handlers
 
 import (
 	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+	"grout/internal/render"
 )
 
 //go:embed web/index.html
@@ -23,14 +39,55 @@ var fallbackRobotsTxt string
 //go:embed web/sitemap.xml
 var fallbackSitemapXml string
 
+// buildTime is used as the modification time of embedded assets. It is taken from the
+// executable's mtime, falling back to process start when that is unavailable.
+var buildTime = executableModTime()
+
+func executableModTime() time.Time {
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			return info.ModTime().UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
+// checkNotModified sets Last-Modified and reports whether the request's If-Modified-Since
+// allows a 304 response. When it returns true the 304 has already been written.
+func checkNotModified(w http.ResponseWriter, r *http.Request, modTime time.Time) bool {
+	// HTTP dates have second precision
+	modTime = modTime.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil || modTime.After(t) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
 func (s *Service) handleHome(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		s.handle404(w, r)
 		return
 	}
 
-	// Replace {{DOMAIN}} placeholder with actual configured domain
-	html := strings.ReplaceAll(homePageTemplate, "{{DOMAIN}}", s.cfg.Domain)
+	// Example URLs and links are built from the configured base URL so crawlers index real paths
+	baseURL := s.baseURL()
+	basePath := ""
+	if u, err := url.Parse(baseURL); err == nil {
+		basePath = u.Path
+	}
+	html := strings.NewReplacer(
+		"{{BASE_URL}}", template.HTMLEscapeString(baseURL),
+		"{{BASE_PATH}}", template.HTMLEscapeString(basePath),
+		"{{DOMAIN}}", template.HTMLEscapeString(s.cfg.Domain),
+	).Replace(homePageTemplate)
 
 	setSecurityHeaders(w)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -41,6 +98,14 @@ func (s *Service) handleHome(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// baseURL returns the public URL of the service without a trailing slash
+func (s *Service) baseURL() string {
+	if s.cfg.BaseURL != "" {
+		return s.cfg.BaseURL
+	}
+	return "https://" + s.cfg.Domain
+}
+
 func (s *Service) handlePlay(w http.ResponseWriter, r *http.Request) {
 	// Replace {{DOMAIN}} placeholder with actual configured domain
 	html := strings.ReplaceAll(playPageTemplate, "{{DOMAIN}}", s.cfg.Domain)
@@ -54,9 +119,22 @@ func (s *Service) handlePlay(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// The ICO favicon is rendered to match the embedded PNG: a white "G" on the brand blue
+const (
+	faviconBackground = "667eea"
+	faviconText       = "G"
+)
+
 func (s *Service) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		s.handleFaviconICO(w, r, format)
+		return
+	}
 	w.Header().Set("Content-Type", "image/png")
 	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if checkNotModified(w, r, buildTime) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	_, err := w.Write(faviconData)
 	if err != nil {
@@ -64,48 +142,346 @@ func (s *Service) handleFavicon(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleFaviconICO serves the favicon as a multi-resolution ICO, with the sizes picked by
+// ?sizes=16,32,48 for Windows and older browsers
+func (s *Service) handleFaviconICO(w http.ResponseWriter, r *http.Request, format string) {
+	var errs paramErrors
+	if format != string(render.FormatICO) {
+		errs.add("format", "must be ico")
+	}
+	sizes := parseICOSizes(&errs, "sizes", r.URL.Query().Get("sizes"))
+	if len(errs) > 0 {
+		writeParamErrors(w, errs)
+		return
+	}
+
+	key := fmt.Sprintf("ICO:%v", sizes)
+	s.serveImage(w, r, key, render.FormatICO, func(dst io.Writer) error {
+		return s.renderer.RenderICO(dst, render.Options{
+			Background: faviconBackground,
+			Foreground: "ffffff",
+			Text:       faviconText,
+			Weight:     render.WeightBold,
+		}, sizes)
+	})
+}
+
 func (s *Service) handleRobotsTxt(w http.ResponseWriter, r *http.Request) {
 	// Try to read from static directory first
-	content := s.readStaticFile("robots.txt", fallbackRobotsTxt)
+	content, modTime := s.readStaticFileWithModTime("robots.txt", fallbackRobotsTxt)
 
 	// Replace {{DOMAIN}} placeholder with actual configured domain
 	content = strings.ReplaceAll(content, "{{DOMAIN}}", s.cfg.Domain)
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Header().Set("Cache-Control", "public, max-age=86400")
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte(content))
-	if err != nil {
+	// A 304 varies like the 200 it revalidates
+	middleware.AddVary(w.Header(), "Accept-Encoding")
+	if checkNotModified(w, r, modTime) {
 		return
 	}
+	s.writePrecompressed(w, r, "robots.txt", content)
 }
 
 func (s *Service) handleSitemapXml(w http.ResponseWriter, r *http.Request) {
 	// Try to read from static directory first
-	content := s.readStaticFile("sitemap.xml", fallbackSitemapXml)
+	content, modTime := s.readStaticFileWithModTime("sitemap.xml", fallbackSitemapXml)
 
 	// Replace {{DOMAIN}} placeholder with actual configured domain
 	content = strings.ReplaceAll(content, "{{DOMAIN}}", s.cfg.Domain)
 
 	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 	w.Header().Set("Cache-Control", "public, max-age=86400")
-	w.WriteHeader(http.StatusOK)
-	_, err := w.Write([]byte(content))
+	// A 304 varies like the 200 it revalidates
+	middleware.AddVary(w.Header(), "Accept-Encoding")
+	if checkNotModified(w, r, modTime) {
+		return
+	}
+	s.writePrecompressed(w, r, "sitemap.xml", content)
+}
+
+// precompressedSuffixes maps the content codings of precompressed siblings to their file
+// suffixes, in the order they are preferred on equal client weights
+var precompressedSuffixes = []struct{ encoding, suffix string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// handleStaticAsset serves any file under the static directory at /static/<path>. When the
+// compression settings allow a coding the client accepts and a foo.svg.br or foo.svg.gz
+// sibling exists, that file is sent as is with the matching Content-Encoding; otherwise the
+// plain file is served and the compression middleware compresses it on the fly. Siblings
+// older than the plain file are ignored, so editing an asset without rebuilding its siblings
+// cannot serve stale content. Dotfiles, the directory's README.md and siblings requested on
+// their own are not served.
+func (s *Service) handleStaticAsset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("path")
+	absFilePath, ok := s.resolveStaticPath(name)
+	if !ok || hiddenStaticPath(name) || isPrecompressedSibling(absFilePath) {
+		s.handle404(w, r)
+		return
+	}
+	content, modTime, err := s.staticFiles.open(absFilePath)
 	if err != nil {
+		s.handle404(w, r)
+		return
+	}
+	defer content.Close()
+
+	// The type comes from the plain file's name so compressed variants are not sniffed
+	contentType := mime.TypeByExtension(filepath.Ext(absFilePath))
+	if contentType == "" {
+		var head [512]byte
+		n, _ := io.ReadFull(content, head[:])
+		contentType = http.DetectContentType(head[:n])
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	middleware.AddVary(w.Header(), "Accept-Encoding")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	var body io.ReadSeeker = content
+	if encoding, variant, variantModTime, ok := s.precompressedVariant(w, r, absFilePath, modTime); ok {
+		defer variant.Close()
+		w.Header().Set("Content-Encoding", encoding)
+		body, modTime = variant, variantModTime
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		s.handle404(w, r)
 		return
 	}
+	http.ServeContent(w, r, "", modTime, body)
+}
+
+// hiddenStaticPath reports whether name, relative to the static directory, is not an asset:
+// a dotfile, anything in a dot directory, or the README.md documenting the directory itself
+func hiddenStaticPath(name string) bool {
+	if path.Clean(name) == "README.md" {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrecompressedSibling reports whether absFilePath is the .br or .gz sibling of an existing
+// plain file, which is only ever sent in its place
+func isPrecompressedSibling(absFilePath string) bool {
+	for _, p := range precompressedSuffixes {
+		if plain, ok := strings.CutSuffix(absFilePath, p.suffix); ok {
+			if _, err := statFile(plain); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// precompressedVariant opens the precompressed sibling of absFilePath in the coding the
+// client prefers among those the compression settings allow for the response w describes,
+// reporting false when there is none to use. Siblings last modified before modTime,
+// compared in whole seconds like Last-Modified, are stale.
+func (s *Service) precompressedVariant(w http.ResponseWriter, r *http.Request, absFilePath string, modTime time.Time) (string, io.ReadSeekCloser, time.Time, bool) {
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	var offered []string
+	for _, p := range precompressedSuffixes {
+		offered = append(offered, p.encoding)
+	}
+	offered = s.compression.Allowed(r, w.Header(), offered...)
+	// Fall through to less preferred codings when the preferred sibling does not exist
+	for len(offered) > 0 {
+		encoding := middleware.NegotiateEncoding(acceptEncoding, offered...)
+		if encoding == "" {
+			return "", nil, time.Time{}, false
+		}
+		for _, p := range precompressedSuffixes {
+			if p.encoding != encoding {
+				continue
+			}
+			variant, variantModTime, err := s.staticFiles.open(absFilePath + p.suffix)
+			if err != nil {
+				continue
+			}
+			if !variantModTime.Truncate(time.Second).Before(modTime.Truncate(time.Second)) {
+				return encoding, variant, variantModTime, true
+			}
+			variant.Close()
+		}
+		offered = slices.DeleteFunc(offered, func(e string) bool { return e == encoding })
+	}
+	return "", nil, time.Time{}, false
 }
 
 // readStaticFile attempts to read a file from the static directory.
 // If the file doesn't exist or can't be read, it returns the fallback content.
 // The function validates that the resolved path is within the static directory to prevent directory traversal attacks.
 func (s *Service) readStaticFile(filename string, fallback string) string {
+	content, _ := s.readStaticFileWithModTime(filename, fallback)
+	return content
+}
+
+// readStaticFileWithModTime behaves like readStaticFile and additionally returns the file's
+// modification time, or the build time when the fallback content is used.
+func (s *Service) readStaticFileWithModTime(filename string, fallback string) (string, time.Time) {
+	absFilePath, ok := s.resolveStaticPath(filename)
+	if !ok {
+		return fallback, buildTime
+	}
+
+	data, modTime, err := s.staticFiles.read(absFilePath)
+	if err != nil {
+		// File doesn't exist or can't be read, use fallback
+		return fallback, buildTime
+	}
+
+	return data, modTime
+}
+
+// staticFileCache keeps static file contents in memory. Every read re-stats the file and
+// reloads it only when its modification time or size changed. Files over
+// config.MaxStaticCacheFileBytes are never kept, and the least recently used files are
+// dropped once the total passes config.MaxStaticCacheBytes.
+type staticFileCache struct {
+	mu       sync.Mutex
+	entries  *simplelru.LRU[string, staticFileEntry]
+	loading  map[string]*staticFileLoad // Reads from disk in progress, by path
+	bytes    int64                      // Total size of the kept contents
+	loads    int                        // Number of reads that went to disk
+	readFile func(string) ([]byte, error)
+}
+
+type staticFileEntry struct {
+	data    string
+	modTime time.Time
+	size    int64
+}
+
+// staticFileLoad is one read of a file version from disk, shared by the concurrent misses
+// waiting on done
+type staticFileLoad struct {
+	done    chan struct{}
+	modTime time.Time
+	size    int64
+	data    string
+	err     error
+}
+
+func newStaticFileCache() *staticFileCache {
+	c := &staticFileCache{loading: make(map[string]*staticFileLoad), readFile: os.ReadFile}
+	c.entries, _ = simplelru.NewLRU(config.MaxStaticCacheEntries, func(_ string, entry staticFileEntry) {
+		c.bytes -= int64(len(entry.data))
+	})
+	return c
+}
+
+// statFile returns the file info of absPath, failing for directories
+func statFile(absPath string) (os.FileInfo, error) {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	return info, nil
+}
+
+// read returns the contents of the already validated absolute path, from memory when unchanged.
+// Concurrent misses for the same file version share one read from disk; the lock is only
+// held around the cache bookkeeping, so other files are served while a file loads.
+func (c *staticFileCache) read(absPath string) (string, time.Time, error) {
+	info, err := statFile(absPath)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	c.mu.Lock()
+	if entry, ok := c.entries.Get(absPath); ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		c.mu.Unlock()
+		return entry.data, entry.modTime, nil
+	}
+	if load, ok := c.loading[absPath]; ok && load.modTime.Equal(info.ModTime()) && load.size == info.Size() {
+		c.mu.Unlock()
+		<-load.done
+		return load.data, load.modTime, load.err
+	}
+	c.entries.Remove(absPath)
+	load := &staticFileLoad{done: make(chan struct{}), modTime: info.ModTime(), size: info.Size()}
+	c.loading[absPath] = load
+	c.mu.Unlock()
+
+	data, err := c.readFile(absPath)
+	load.data, load.err = string(data), err
+
+	c.mu.Lock()
+	// A newer version may have started loading meanwhile; only the latest load is kept
+	if c.loading[absPath] == load {
+		delete(c.loading, absPath)
+		if err == nil && len(data) <= config.MaxStaticCacheFileBytes {
+			c.entries.Remove(absPath)
+			c.entries.Add(absPath, staticFileEntry{data: load.data, modTime: load.modTime, size: load.size})
+			c.bytes += int64(len(data))
+			for c.bytes > config.MaxStaticCacheBytes {
+				c.entries.RemoveOldest()
+			}
+		}
+	}
+	if err == nil {
+		c.loads++
+	}
+	c.mu.Unlock()
+	close(load.done)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return load.data, load.modTime, nil
+}
+
+// open returns a reader over the already validated absolute path. Files up to
+// config.MaxStaticCacheFileBytes are read through the cache; larger ones are opened on disk
+// so they can be streamed. The caller closes the reader.
+func (c *staticFileCache) open(absPath string) (io.ReadSeekCloser, time.Time, error) {
+	info, err := statFile(absPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if info.Size() <= config.MaxStaticCacheFileBytes {
+		data, modTime, err := c.read(absPath)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return nopCloser{strings.NewReader(data)}, modTime, nil
+	}
+
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// Stat the open file so the modification time matches what is served
+	if info, err = f.Stat(); err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, info.ModTime(), nil
+}
+
+// nopCloser adds a no-op Close to an in-memory reader
+type nopCloser struct{ *strings.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+// resolveStaticPath returns the absolute path of filename inside the static directory.
+// It reports false for paths that would escape the static directory.
+func (s *Service) resolveStaticPath(filename string) (string, bool) {
 	// Clean the filename to prevent directory traversal
 	cleanFilename := filepath.Clean(filename)
 
 	// Prevent directory traversal by rejecting paths that start with ".." or are absolute
 	if strings.HasPrefix(cleanFilename, "..") || filepath.IsAbs(cleanFilename) {
-		return fallback
+		return "", false
 	}
 
 	// Construct the full path
@@ -114,12 +490,12 @@ func (s *Service) readStaticFile(filename string, fallback string) string {
 	// Resolve absolute paths and verify the file is within the static directory
 	absStaticDir, err := filepath.Abs(s.cfg.StaticDir)
 	if err != nil {
-		return fallback
+		return "", false
 	}
 
 	absFilePath, err := filepath.Abs(filePath)
 	if err != nil {
-		return fallback
+		return "", false
 	}
 
 	// Ensure the static directory ends with a path separator for proper prefix checking
@@ -129,14 +505,8 @@ func (s *Service) readStaticFile(filename string, fallback string) string {
 
 	// Ensure the resolved path is within the static directory (must be a file, not the directory itself)
 	if !strings.HasPrefix(absFilePath, absStaticDir) {
-		return fallback
-	}
-
-	data, err := os.ReadFile(absFilePath)
-	if err != nil {
-		// File doesn't exist or can't be read, use fallback
-		return fallback
+		return "", false
 	}
 
-	return string(data)
+	return absFilePath, true
 }
@@ -0,0 +1,602 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/hashicorp/golang-lru/v2"
+
+	"grout/internal/config"
+	"grout/internal/middleware"
+	"grout/internal/render"
+)
+
+// setupStaticTestService creates a service whose static directory is a fresh temp dir
+func setupStaticTestService(t *testing.T) (string, *http.ServeMux) {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.StaticDir = tmpDir
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+	return tmpDir, mux
+}
+
+func TestStaticFileLastModified(t *testing.T) {
+	tmpDir, mux := setupStaticTestService(t)
+
+	robotsPath := filepath.Join(tmpDir, "robots.txt")
+	if err := os.WriteFile(robotsPath, []byte("User-agent: *"), 0644); err != nil {
+		t.Fatalf("failed to write robots.txt: %v", err)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(robotsPath, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+
+	// Without If-Modified-Since the file is served with Last-Modified
+	req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rec.Code)
+	}
+	if lm := rec.Header().Get("Last-Modified"); lm != modTime.Format(http.TimeFormat) {
+		t.Fatalf("expected Last-Modified %q got %q", modTime.Format(http.TimeFormat), lm)
+	}
+
+	tests := []struct {
+		name           string
+		ifModified     string
+		expectedStatus int
+	}{
+		{"Matching If-Modified-Since", modTime.Format(http.TimeFormat), http.StatusNotModified},
+		{"Newer If-Modified-Since", modTime.Add(time.Hour).Format(http.TimeFormat), http.StatusNotModified},
+		{"Older If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{"Malformed If-Modified-Since", "yesterday", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+			req.Header.Set("If-Modified-Since", tt.ifModified)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Fatalf("expected empty body for 304, got %q", rec.Body.String())
+			}
+			if rec.Header().Get("Last-Modified") == "" {
+				t.Fatal("expected Last-Modified header")
+			}
+		})
+	}
+}
+
+func TestStaticFallbackUsesBuildTime(t *testing.T) {
+	_, mux := setupStaticTestService(t)
+
+	for _, path := range []string{"/sitemap.xml", "/favicon.ico"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			lm := rec.Header().Get("Last-Modified")
+			if lm != buildTime.Truncate(time.Second).Format(http.TimeFormat) {
+				t.Fatalf("expected Last-Modified to be build time, got %q", lm)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("If-Modified-Since", lm)
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotModified {
+				t.Fatalf("expected 304 got %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestStaticFileCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.StaticDir = tmpDir
+	svc := NewService(renderer, cache, cfg)
+
+	robotsPath := filepath.Join(tmpDir, "robots.txt")
+	if err := os.WriteFile(robotsPath, []byte("first"), 0644); err != nil {
+		t.Fatalf("failed to write robots.txt: %v", err)
+	}
+
+	if got := svc.readStaticFile("robots.txt", "fallback"); got != "first" {
+		t.Fatalf("expected %q got %q", "first", got)
+	}
+	if got := svc.readStaticFile("robots.txt", "fallback"); got != "first" {
+		t.Fatalf("expected %q got %q", "first", got)
+	}
+	if svc.staticFiles.loads != 1 {
+		t.Fatalf("expected second read to be served from memory, got %d disk loads", svc.staticFiles.loads)
+	}
+
+	// Modifying the file invalidates the cached copy
+	if err := os.WriteFile(robotsPath, []byte("second version"), 0644); err != nil {
+		t.Fatalf("failed to rewrite robots.txt: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(robotsPath, later, later); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+	if got := svc.readStaticFile("robots.txt", "fallback"); got != "second version" {
+		t.Fatalf("expected reloaded content, got %q", got)
+	}
+	if svc.staticFiles.loads != 2 {
+		t.Fatalf("expected a reload after modification, got %d disk loads", svc.staticFiles.loads)
+	}
+
+	// Removing the file falls back and does not serve the stale copy
+	if err := os.Remove(robotsPath); err != nil {
+		t.Fatalf("failed to remove robots.txt: %v", err)
+	}
+	if got := svc.readStaticFile("robots.txt", "fallback"); got != "fallback" {
+		t.Fatalf("expected fallback after removal, got %q", got)
+	}
+
+	// Traversal protection still applies before the cache is consulted
+	if got := svc.readStaticFile("../robots.txt", "fallback"); got != "fallback" {
+		t.Fatalf("expected traversal to be blocked, got %q", got)
+	}
+}
+
+func TestStaticFileCacheLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.StaticDir = tmpDir
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	t.Run("Large files are streamed from disk", func(t *testing.T) {
+		large := bytes.Repeat([]byte("0123456789abcdef"), config.MaxStaticCacheFileBytes/16+1)
+		if err := os.WriteFile(filepath.Join(tmpDir, "large.bin"), large, 0644); err != nil {
+			t.Fatalf("failed to write large.bin: %v", err)
+		}
+		for range 2 {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/static/large.bin", nil))
+			if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), large) {
+				t.Fatalf("expected the whole file with 200 got %d and %d bytes", rr.Code, rr.Body.Len())
+			}
+			if rr.Header().Get("Last-Modified") == "" {
+				t.Fatal("expected Last-Modified on a streamed file")
+			}
+		}
+		if svc.staticFiles.entries.Contains(filepath.Join(tmpDir, "large.bin")) || svc.staticFiles.bytes != 0 {
+			t.Fatalf("expected the large file not to be kept, %d bytes cached", svc.staticFiles.bytes)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/static/large.bin", nil)
+		req.Header.Set("Range", "bytes=16-31")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusPartialContent || rr.Body.String() != "0123456789abcdef" {
+			t.Fatalf("expected a range of the streamed file got %d %q", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Total bytes are bounded", func(t *testing.T) {
+		data := bytes.Repeat([]byte("x"), config.MaxStaticCacheFileBytes)
+		files := config.MaxStaticCacheBytes/config.MaxStaticCacheFileBytes + 2
+		for i := range files {
+			name := filepath.Join(tmpDir, fmt.Sprintf("file-%d.txt", i))
+			if err := os.WriteFile(name, data, 0644); err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+			if _, _, err := svc.staticFiles.read(name); err != nil {
+				t.Fatalf("read %s: %v", name, err)
+			}
+		}
+		if svc.staticFiles.bytes > config.MaxStaticCacheBytes {
+			t.Fatalf("expected at most %d cached bytes got %d", config.MaxStaticCacheBytes, svc.staticFiles.bytes)
+		}
+		if svc.staticFiles.entries.Contains(filepath.Join(tmpDir, "file-0.txt")) {
+			t.Fatal("expected the least recently used file to be dropped")
+		}
+		if !svc.staticFiles.entries.Contains(filepath.Join(tmpDir, fmt.Sprintf("file-%d.txt", files-1))) {
+			t.Fatal("expected the latest file to be kept")
+		}
+	})
+}
+
+func TestStaticFileCacheConcurrentLoads(t *testing.T) {
+	tmpDir := t.TempDir()
+	slow := filepath.Join(tmpDir, "slow.txt")
+	fast := filepath.Join(tmpDir, "fast.txt")
+	for _, name := range []string{slow, fast} {
+		if err := os.WriteFile(name, []byte(filepath.Base(name)), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	c := newStaticFileCache()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var slowReads atomic.Int32
+	c.readFile = func(name string) ([]byte, error) {
+		if name == slow {
+			if slowReads.Add(1) == 1 {
+				close(started)
+			}
+			<-release
+		}
+		return os.ReadFile(name)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, _, err := c.read(slow); err != nil || data != "slow.txt" {
+				t.Errorf("expected %q got %q, %v", "slow.txt", data, err)
+			}
+		}()
+	}
+	<-started
+
+	// Other files are served while the slow file is still loading
+	if data, _, err := c.read(fast); err != nil || data != "fast.txt" {
+		t.Fatalf("expected %q got %q, %v", "fast.txt", data, err)
+	}
+
+	// Give the remaining readers time to join the load in progress
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := slowReads.Load(); n != 1 {
+		t.Fatalf("expected concurrent misses to share one read, got %d", n)
+	}
+	if c.loads != 2 || len(c.loading) != 0 {
+		t.Fatalf("expected 2 disk loads and none in progress, got %d and %d", c.loads, len(c.loading))
+	}
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	tmpDir, mux := setupStaticTestService(t)
+	// The sample files are tiny, so the minimum size is lifted to compress them on the fly
+	compression := middleware.DefaultCompressionConfig()
+	compression.MinSize = 0
+	handler := middleware.CompressionMiddleware(compression)(mux)
+
+	plain := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10"/></svg>`)
+	// The sibling contents only need to be distinguishable, they are served without decoding
+	prebuiltBr := []byte("prebuilt brotli")
+	prebuiltGz := []byte("prebuilt gzip")
+	files := map[string][]byte{
+		"logo.svg":      plain,
+		"logo.svg.br":   prebuiltBr,
+		"both.svg":      plain,
+		"both.svg.br":   prebuiltBr,
+		"both.svg.gz":   prebuiltGz,
+		"plain.svg":     plain,
+		"icons/dot.svg": plain,
+		".env":          []byte("SECRET=1"),
+		".git/config":   []byte("[core]"),
+		"README.md":     []byte("# Static Files Directory"),
+	}
+	for _, dir := range []string{"icons", ".git"} {
+		if err := os.Mkdir(filepath.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("failed to create %s dir: %v", dir, err)
+		}
+	}
+	// Siblings count as current when they are as new as their plain file
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), data, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(filepath.Join(tmpDir, name), modTime, modTime); err != nil {
+			t.Fatalf("failed to set mtime of %s: %v", name, err)
+		}
+	}
+	// stale.svg was edited after its brotli sibling was built; its gzip sibling is current
+	staleFiles := []struct {
+		name string
+		data []byte
+		age  time.Duration // Relative to modTime
+	}{
+		{"stale.svg", plain, 0},
+		{"stale.svg.br", prebuiltBr, -time.Hour},
+		{"stale.svg.gz", prebuiltGz, time.Minute},
+	}
+	for _, f := range staleFiles {
+		path := filepath.Join(tmpDir, f.name)
+		if err := os.WriteFile(path, f.data, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", f.name, err)
+		}
+		if err := os.Chtimes(path, modTime.Add(f.age), modTime.Add(f.age)); err != nil {
+			t.Fatalf("failed to set mtime of %s: %v", f.name, err)
+		}
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		acceptEncoding   string
+		expectedStatus   int
+		expectedEncoding string
+		expectedBody     []byte // nil skips the body check
+	}{
+		{"Brotli sibling", "/static/logo.svg", "gzip, br", http.StatusOK, "br", prebuiltBr},
+		{"Brotli not accepted", "/static/logo.svg", "identity", http.StatusOK, "", plain},
+		{"Gzip sibling", "/static/both.svg", "gzip", http.StatusOK, "gzip", prebuiltGz},
+		{"Client weights win", "/static/both.svg", "br;q=0.5, gzip", http.StatusOK, "gzip", prebuiltGz},
+		{"Missing sibling falls back to next coding", "/static/logo.svg", "br;q=0.5, gzip", http.StatusOK, "br", prebuiltBr},
+		{"No sibling compresses on the fly", "/static/plain.svg", "gzip", http.StatusOK, "gzip", nil},
+		{"Stale sibling falls back to next coding", "/static/stale.svg", "br, gzip", http.StatusOK, "gzip", prebuiltGz},
+		{"Stale sibling compresses on the fly", "/static/stale.svg", "br", http.StatusOK, "br", nil},
+		{"No sibling and no compression", "/static/plain.svg", "", http.StatusOK, "", plain},
+		{"Subdirectory", "/static/icons/dot.svg", "", http.StatusOK, "", plain},
+		{"Missing file", "/static/missing.svg", "br", http.StatusNotFound, "", nil},
+		{"Directory", "/static/icons", "", http.StatusNotFound, "", nil},
+		{"Traversal", "/static/..%2f..%2fetc%2fpasswd", "", http.StatusNotFound, "", nil},
+		{"Sibling requested directly", "/static/logo.svg.br", "br", http.StatusNotFound, "", nil},
+		{"Stale sibling requested directly", "/static/stale.svg.gz", "", http.StatusNotFound, "", nil},
+		{"Dotfile", "/static/.env", "", http.StatusNotFound, "", nil},
+		{"Dot directory", "/static/.git/config", "", http.StatusNotFound, "", nil},
+		{"Static directory readme", "/static/README.md", "", http.StatusNotFound, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if ce := rec.Header().Get("Content-Encoding"); ce != tt.expectedEncoding {
+				t.Fatalf("expected Content-Encoding %q got %q", tt.expectedEncoding, ce)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+				t.Fatalf("expected Content-Type image/svg+xml got %q", ct)
+			}
+			if vary := rec.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Fatalf("expected Vary Accept-Encoding got %q", vary)
+			}
+			if tt.expectedBody != nil && !bytes.Equal(rec.Body.Bytes(), tt.expectedBody) {
+				t.Fatalf("expected body %q got %q", tt.expectedBody, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestPrecompressedGeneratedAssets(t *testing.T) {
+	tmpDir := t.TempDir()
+	renderer, err := render.New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	cache, _ := lru.New[string, []byte](1)
+	cfg := config.DefaultServerConfig()
+	cfg.StaticDir = tmpDir
+	cfg.Domain = "img.example.com"
+	svc := NewService(renderer, cache, cfg)
+	mux := http.NewServeMux()
+	svc.RegisterRoutes(mux, nil)
+
+	robotsPath := filepath.Join(tmpDir, "robots.txt")
+	writeRobots := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(robotsPath, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write robots.txt: %v", err)
+		}
+		if err := os.Chtimes(robotsPath, modTime, modTime); err != nil {
+			t.Fatalf("failed to set mtime: %v", err)
+		}
+	}
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 got %d", rec.Code)
+		}
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var r io.Reader
+		switch rec.Header().Get("Content-Encoding") {
+		case "br":
+			r = brotli.NewReader(rec.Body)
+		case "gzip":
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("gzip: %v", err)
+			}
+			r = gz
+		default:
+			return rec.Body.String()
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return string(data)
+	}
+
+	writeRobots("Sitemap: https://{{DOMAIN}}/sitemap.xml\n", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	first := get("/robots.txt", "br")
+	firstBody := bytes.Clone(first.Body.Bytes())
+	if first.Header().Get("Content-Encoding") != "br" || first.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a brotli response varying on Accept-Encoding got %v", first.Header())
+	}
+	if got := decode(first); got != "Sitemap: https://img.example.com/sitemap.xml\n" {
+		t.Fatalf("expected the substituted robots.txt got %q", got)
+	}
+	if svc.precompressed.compressions != 2 {
+		t.Fatalf("expected brotli and gzip variants to be built once got %d compressions", svc.precompressed.compressions)
+	}
+
+	t.Run("Repeat requests reuse the variants", func(t *testing.T) {
+		again := get("/robots.txt", "br")
+		if !bytes.Equal(again.Body.Bytes(), firstBody) {
+			t.Fatalf("expected the cached brotli bytes")
+		}
+		if rec := get("/robots.txt", "gzip"); rec.Header().Get("Content-Encoding") != "gzip" || decode(rec) != decode(again) {
+			t.Fatalf("expected the cached gzip variant got %v", rec.Header())
+		}
+		if svc.precompressed.compressions != 2 {
+			t.Fatalf("expected no further compression got %d", svc.precompressed.compressions)
+		}
+	})
+
+	t.Run("Uncompressed when not accepted", func(t *testing.T) {
+		rec := get("/robots.txt", "identity")
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "Sitemap: https://img.example.com/sitemap.xml\n" {
+			t.Fatalf("expected the plain body got %v %q", rec.Header(), rec.Body.String())
+		}
+	})
+
+	t.Run("Edits rebuild the variants", func(t *testing.T) {
+		writeRobots("User-agent: *\nDisallow: /avatar/\n", time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+		if got := decode(get("/robots.txt", "br")); got != "User-agent: *\nDisallow: /avatar/\n" {
+			t.Fatalf("expected the edited robots.txt got %q", got)
+		}
+		if svc.precompressed.compressions != 4 {
+			t.Fatalf("expected the variants to be rebuilt once got %d compressions", svc.precompressed.compressions)
+		}
+	})
+
+	t.Run("Embedded sitemap", func(t *testing.T) {
+		rec := get("/sitemap.xml", "gzip, br")
+		if rec.Header().Get("Content-Encoding") != "br" || !strings.Contains(decode(rec), "img.example.com") {
+			t.Fatalf("expected the brotli sitemap got %v", rec.Header())
+		}
+	})
+}
+
+func TestPrecompressedFollowsCompressionSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		configure      func(*config.ServerConfig)
+		path           string
+		acceptEncoding string
+		cacheControl   string
+		wantEncoding   string
+	}{
+		{name: "Default", path: "/robots.txt", acceptEncoding: "gzip, br", wantEncoding: "br"},
+		{name: "Low memory offers gzip only", configure: func(c *config.ServerConfig) { c.LowMemory = true }, path: "/robots.txt", acceptEncoding: "gzip, br", wantEncoding: "gzip"},
+		{name: "Low memory brotli-only client", configure: func(c *config.ServerConfig) { c.LowMemory = true }, path: "/robots.txt", acceptEncoding: "br"},
+		{name: "Skipped path", configure: func(c *config.ServerConfig) { c.CompressionSkipPaths = []string{"/sitemap"} }, path: "/sitemap.xml", acceptEncoding: "br"},
+		{name: "Content type not compressed", configure: func(c *config.ServerConfig) { c.CompressionContentTypes = []string{"application/json"} }, path: "/robots.txt", acceptEncoding: "br"},
+		{name: "Request no-transform", path: "/robots.txt", acceptEncoding: "br", cacheControl: "no-transform"},
+		{name: "Static sibling", path: "/static/logo.svg", acceptEncoding: "gzip, br", wantEncoding: "br"},
+		{name: "Static low memory offers gzip only", configure: func(c *config.ServerConfig) { c.LowMemory = true }, path: "/static/logo.svg", acceptEncoding: "gzip, br", wantEncoding: "gzip"},
+		{name: "Static skipped path", configure: func(c *config.ServerConfig) { c.CompressionSkipPaths = []string{"/static"} }, path: "/static/logo.svg", acceptEncoding: "br"},
+		{name: "Static content type not compressed", configure: func(c *config.ServerConfig) { c.CompressionContentTypes = []string{"application/json"} }, path: "/static/logo.svg", acceptEncoding: "br"},
+		{name: "Static request no-transform", path: "/static/logo.svg", acceptEncoding: "br", cacheControl: "no-transform"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer, err := render.New()
+			if err != nil {
+				t.Fatalf("renderer init: %v", err)
+			}
+			cache, _ := lru.New[string, []byte](1)
+			cfg := config.DefaultServerConfig()
+			cfg.StaticDir = t.TempDir()
+			if tt.configure != nil {
+				tt.configure(&cfg)
+			}
+			// The siblings are written after the plain file, so they are current
+			staticFiles := []struct{ name, data string }{
+				{"logo.svg", `<svg xmlns="http://www.w3.org/2000/svg"><text>localhost</text></svg>`},
+				{"logo.svg.br", "prebuilt brotli"},
+				{"logo.svg.gz", "prebuilt gzip"},
+			}
+			for _, f := range staticFiles {
+				if err := os.WriteFile(filepath.Join(cfg.StaticDir, f.name), []byte(f.data), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", f.name, err)
+				}
+			}
+			svc := NewService(renderer, cache, cfg)
+			mux := http.NewServeMux()
+			svc.RegisterRoutes(mux, nil)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			if tt.cacheControl != "" {
+				req.Header.Set("Cache-Control", tt.cacheControl)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != tt.wantEncoding {
+				t.Fatalf("expected 200 with coding %q got %d %v", tt.wantEncoding, rec.Code, rec.Header())
+			}
+			if tt.wantEncoding == "" && !strings.Contains(rec.Body.String(), "localhost") {
+				t.Fatalf("expected the plain body got %q", rec.Body.String())
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("expected Vary: Accept-Encoding got %v", rec.Header())
+			}
+			if cfg.LowMemory && svc.precompressed.compressions > 1 {
+				t.Fatalf("expected only the gzip variant to be built got %d compressions", svc.precompressed.compressions)
+			}
+
+			// The 304 for the same request varies like the 200
+			req.Header.Set("If-Modified-Since", rec.Header().Get("Last-Modified"))
+			rec = httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNotModified || rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("expected a 304 varying on Accept-Encoding got %d %v", rec.Code, rec.Header())
+			}
+		})
+	}
+}
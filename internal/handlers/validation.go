@@ -0,0 +1,530 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+
+	"grout/internal/config"
+	"grout/internal/render"
+)
+
+// paramError describes a single invalid query parameter
+type paramError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+// paramErrors collects every invalid parameter of a request so they can be reported together
+type paramErrors []paramError
+
+func (e *paramErrors) add(param, format string, args ...any) {
+	*e = append(*e, paramError{Param: param, Message: fmt.Sprintf(format, args...)})
+}
+
+// writeParamErrors responds with 400 and all collected validation errors as JSON
+func writeParamErrors(w http.ResponseWriter, errs paramErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":  "invalid parameters",
+		"errors": errs,
+	})
+}
+
+// paramSet lists the query parameters an endpoint understands
+type paramSet map[string]bool
+
+func newParamSet(params ...string) paramSet {
+	set := make(paramSet, len(params))
+	for _, param := range params {
+		set[param] = true
+	}
+	return set
+}
+
+// imageParams are understood by every image endpoint
+var imageParams = []string{"background", "bg", "color", "meta", "tile", "blur", "ribbon", "dpr", "colorProfile", "alt", "opacity", "flip", "flipText", "cssVars", "gammaCorrect", "quality", "format", "standalone", "download", "filename", "nocache", "fresh"}
+
+var (
+	avatarParams = newParamSet(append([]string{
+		"name", "size", "width", "height", "bold", "weight", "checker", "animate", "rounded", "shape", "radius",
+		"initialsMode", "maxInitials", "letterSpacing", "palette", "salt", "style", "locale",
+		"badge", "badgeColor", "badgeCorner", "textGradient", "pot", "grayscale", "saturation", "ring", "symbol", "initialsLayout", "tagline", "tail", "pixelate", "caps", "hueRange", "vignette",
+	}, imageParams...)...)
+	placeholderParams = newParamSet(append([]string{
+		"w", "h", "text", "quote", "joke", "category", "icon", "qr", "qrLevel", "labelRound", "labelFont", "vignette", "shape", "tail",
+	}, imageParams...)...)
+)
+
+// checkUnknownParams records every query parameter missing from allowed, in name order.
+// It only applies in strict mode; lenient mode ignores unknown parameters.
+func (s *Service) checkUnknownParams(errs *paramErrors, query url.Values, allowed paramSet) {
+	if !s.cfg.StrictParams {
+		return
+	}
+	var unknown []string
+	for param := range query {
+		if !allowed[param] {
+			unknown = append(unknown, param)
+		}
+	}
+	sort.Strings(unknown)
+	for _, param := range unknown {
+		errs.add(param, "unknown parameter")
+	}
+}
+
+// parseDimension parses a positive pixel dimension no larger than config.MaxImageSize.
+// Empty values yield def; invalid values are recorded in errs.
+func parseDimension(errs *paramErrors, param, value string, def int) int {
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		errs.add(param, "must be a positive integer")
+		return def
+	}
+	if n > config.MaxImageSize {
+		errs.add(param, "must not exceed %d", config.MaxImageSize)
+		return def
+	}
+	return n
+}
+
+// parseRadius parses a corner radius in pixels ("12") or as a percentage of the smaller
+// dimension ("25%"). The result is clamped to half the smaller dimension.
+func parseRadius(errs *paramErrors, param, value string, w, h int) float64 {
+	minDim := float64(min(w, h))
+	radius := minDim * config.DefaultCornerRadiusPct / 100
+	if value != "" {
+		number, isPercent := strings.CutSuffix(value, "%")
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+			errs.add(param, "must be a non-negative number of pixels or a percentage")
+		} else if isPercent {
+			radius = minDim * n / 100
+		} else {
+			radius = n
+		}
+	}
+	return math.Min(radius, minDim/2)
+}
+
+// parseLetterSpacing parses a letter spacing in pixels ("2", "2px") or em ("0.1em").
+// Negative values tighten the text; the renderer clamps extremes relative to the font size.
+func parseLetterSpacing(errs *paramErrors, param, value string) render.LetterSpacing {
+	if value == "" {
+		return render.LetterSpacing{}
+	}
+	number, isEm := strings.CutSuffix(value, "em")
+	if !isEm {
+		number = strings.TrimSuffix(value, "px")
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		errs.add(param, "must be a number of pixels (2, 2px) or em (0.1em)")
+		return render.LetterSpacing{}
+	}
+	return render.LetterSpacing{Value: n, Em: isEm}
+}
+
+// parseColor validates a hex color, or a comma-separated gradient when allowGradient is set.
+// A leading '#' is accepted and stripped. Empty values yield def.
+func parseColor(errs *paramErrors, param, value, def string, allowGradient bool) string {
+	if value == "" {
+		return def
+	}
+	colors := strings.Split(value, ",")
+	if len(colors) > 1 && !allowGradient {
+		errs.add(param, "must be a single hex color")
+		return def
+	}
+	for i, c := range colors {
+		c = strings.TrimPrefix(strings.TrimSpace(c), "#")
+		if !config.IsHexColor(c) {
+			errs.add(param, "%q is not a valid hex color", c)
+			return def
+		}
+		colors[i] = c
+	}
+	return strings.Join(colors, ",")
+}
+
+// parseFormat parses an output format name such as "png" or "jsx", overriding the format
+// taken from the path's extension. Empty values yield def.
+func parseFormat(errs *paramErrors, param, value string, def render.ImageFormat) render.ImageFormat {
+	if value == "" {
+		return def
+	}
+	format := render.ImageFormat(strings.ToLower(value))
+	if _, ok := formatExtensions["."+string(format)]; ok || format == render.FormatJSX {
+		return format
+	}
+	errs.add(param, "must be one of svg, png, jpg, jpeg, gif, webp, jsx")
+	return def
+}
+
+// parseICOSizes parses a comma-separated list of icon sizes such as "16,32,48". Empty values
+// yield render.DefaultICOSizes; duplicates are dropped.
+func parseICOSizes(errs *paramErrors, param, value string) []int {
+	if value == "" {
+		return render.DefaultICOSizes
+	}
+	var sizes []int
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 || n > render.MaxICOSize {
+			errs.add(param, "sizes must be whole numbers between 1 and %d", render.MaxICOSize)
+			return nil
+		}
+		if !slices.Contains(sizes, n) {
+			sizes = append(sizes, n)
+		}
+	}
+	if len(sizes) > render.MaxICOImages {
+		errs.add(param, "must not list more than %d sizes", render.MaxICOImages)
+		return nil
+	}
+	return sizes
+}
+
+// parseTextGradient parses two comma-separated hex colors for gradient-filled initials. Each
+// color must reach render.MinTextGradientContrast against the background so the text stays legible.
+func parseTextGradient(errs *paramErrors, param, value, bgHex string) string {
+	if value == "" {
+		return ""
+	}
+	before := len(*errs)
+	gradient := parseColor(errs, param, value, "", true)
+	if len(*errs) > before {
+		return ""
+	}
+	colors := strings.Split(gradient, ",")
+	if len(colors) != 2 {
+		errs.add(param, "must be two comma-separated hex colors")
+		return ""
+	}
+	background := render.DominantColor(bgHex)
+	for _, c := range colors {
+		if ratio := render.ContrastRatio(c, background); ratio < render.MinTextGradientContrast {
+			errs.add(param, "%s has a contrast of %.1f:1 against the background; at least %g:1 is needed", c, ratio, render.MinTextGradientContrast)
+		}
+	}
+	return gradient
+}
+
+// parseSize parses either a single dimension ("128") or a WxH pair ("256x128").
+// Empty values yield a def x def square; invalid values are recorded in errs.
+func parseSize(errs *paramErrors, param, value string, def int) (int, int) {
+	if value == "" {
+		return def, def
+	}
+	wStr, hStr, isPair := strings.Cut(strings.ToLower(value), "x")
+	if !isPair {
+		n := parseDimension(errs, param, value, def)
+		return n, n
+	}
+
+	w, wErr := strconv.Atoi(wStr)
+	h, hErr := strconv.Atoi(hStr)
+	if wErr != nil || hErr != nil || w <= 0 || h <= 0 || w > config.MaxImageSize || h > config.MaxImageSize {
+		errs.add(param, "must be a size like 128 or 256x128 with dimensions between 1 and %d", config.MaxImageSize)
+		return def, def
+	}
+	return w, h
+}
+
+// parseLocale parses a BCP 47 language tag such as "tr" or "de-DE", falling back to def
+// (the configured locale) when value is empty. An empty def means language.Und.
+func parseLocale(errs *paramErrors, param, value, def string) language.Tag {
+	if value == "" {
+		value = def
+	}
+	if value == "" {
+		return language.Und
+	}
+	tag, err := language.Parse(value)
+	if err != nil {
+		errs.add(param, "must be a BCP 47 language tag such as tr or de-DE")
+		return language.Und
+	}
+	return tag
+}
+
+// parseBlur parses the background blur radius in pixels. Values above render.MaxBlur are clamped.
+func parseBlur(errs *paramErrors, param, value string) float64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		errs.add(param, "must be a non-negative number of pixels")
+		return 0
+	}
+	return math.Min(n, render.MaxBlur)
+}
+
+// parseQuality parses the encoder quality of a request, 1-100. It returns 0, meaning the
+// configured per-format default, when the value is empty.
+func parseQuality(errs *paramErrors, value string) int {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 100 {
+		errs.add("quality", "must be an integer from 1 to 100")
+		return 0
+	}
+	return n
+}
+
+// parsePixelate parses the number of blocks per side of a pixelated avatar.
+// Values outside render.MinPixelate..render.MaxPixelate are clamped.
+func parsePixelate(errs *paramErrors, value string) int {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		errs.add("pixelate", "must be a positive integer")
+		return 0
+	}
+	return min(max(n, render.MinPixelate), render.MaxPixelate)
+}
+
+// parseVignette returns how strongly to darken the edges, from a 0-100 percentage to 0-1
+func parseVignette(errs *paramErrors, value string) float64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 100 {
+		errs.add("vignette", "must be an integer between 0 and 100")
+		return 0
+	}
+	return float64(n) / 100
+}
+
+// parseOpacity returns how far to fade the whole image, from a 0-100 opacity percentage
+// (100, fully opaque, by default) to a 0-1 render.Options.Fade
+func parseOpacity(errs *paramErrors, value string) float64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 100 {
+		errs.add("opacity", "must be an integer between 0 and 100")
+		return 0
+	}
+	return 1 - float64(n)/100
+}
+
+// parseFlip returns how the image is mirrored, and whether flipText=false leaves text out of it
+func parseFlip(errs *paramErrors, flip, flipText string) (render.Flip, bool) {
+	f, ok := render.ParseFlip(flip)
+	if !ok {
+		errs.add("flip", "must be one of horizontal, vertical, both")
+	}
+	switch flipText {
+	case "", "1", "true":
+		return f, false
+	case "0", "false":
+		return f, true
+	default:
+		errs.add("flipText", "must be true or false")
+		return f, false
+	}
+}
+
+// parseTail returns the side a shape=bubble tail points to, bottom by default
+func parseTail(errs *paramErrors, value string) render.Tail {
+	tail, ok := render.ParseTail(value)
+	if !ok {
+		errs.add("tail", "must be one of bottom, top, left, right")
+	}
+	return tail
+}
+
+// parseColorProfile returns how raster output declares its color space, sRGB by default
+func parseColorProfile(errs *paramErrors, value string) render.ColorProfile {
+	profile, ok := render.ParseColorProfile(value)
+	if !ok {
+		errs.add("colorProfile", "must be one of srgb, none")
+	}
+	return profile
+}
+
+// parseRibbon returns the trimmed ribbon text, which must be short enough to fit across a corner
+func parseRibbon(errs *paramErrors, value string) string {
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > render.MaxRibbonLength {
+		errs.add("ribbon", "must not exceed %d characters", render.MaxRibbonLength)
+		return ""
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		errs.add("ribbon", "must not contain control characters")
+		return ""
+	}
+	return value
+}
+
+// altText returns the accessible label of SVG output: the alt param when given, otherwise
+// cfg.AltTemplate with {name}, {initials} and {size} substituted. Raster formats carry no
+// label, so they get "" and keep sharing cache entries.
+func (s *Service) altText(errs *paramErrors, value string, format render.ImageFormat, name, initials, size string) string {
+	if value != "" {
+		if utf8.RuneCountInString(value) > config.MaxAltLength {
+			errs.add("alt", "must not exceed %d characters", config.MaxAltLength)
+			return ""
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			errs.add("alt", "must not contain control characters")
+			return ""
+		}
+	} else {
+		value = strings.NewReplacer("{name}", name, "{initials}", initials, "{size}", size).Replace(s.cfg.AltTemplate)
+		// Names may carry characters XML does not allow
+		value = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, value)
+	}
+	if format != render.FormatSVG {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// labelFont returns the family and weight of placeholder labels from the labelFont param,
+// written family or family:weight, or from cfg.LabelFont when the param is absent. The weight
+// defaults to bold, the usual label weight. An unknown family in the param is an error; one in
+// the config falls back to the default family when rendering.
+func (s *Service) labelFont(errs *paramErrors, value string) (string, render.FontWeight) {
+	fromConfig := value == ""
+	if fromConfig {
+		value = s.cfg.LabelFont
+	}
+	family, weightName, _ := strings.Cut(strings.ToLower(value), ":")
+	weight := render.WeightBold
+	if weightName != "" {
+		parsed, ok := render.ParseFontWeight(weightName)
+		if !ok {
+			errs.add("labelFont", "weight must be one of light, regular, medium, bold")
+		}
+		weight = parsed
+	}
+	if !fromConfig && !s.renderer.HasFontFamily(family) {
+		errs.add("labelFont", "family must be one of %s", strings.Join(s.renderer.FontFamilies(), ", "))
+	}
+	return family, weight
+}
+
+// parseQR checks that text fits a QR code at level, qrLevel or cfg.QRLevel, and that the
+// code with its quiet zone fits a w x h image at one pixel per module
+func (s *Service) parseQR(errs *paramErrors, text, levelValue string, w, h int) render.QRLevel {
+	if levelValue == "" {
+		levelValue = s.cfg.QRLevel
+	}
+	level, ok := render.ParseQRLevel(levelValue)
+	if !ok {
+		errs.add("qrLevel", "must be one of L, M, Q, H")
+		return level
+	}
+	if text == "" {
+		return level
+	}
+	modules, err := render.QRModules(text, level)
+	switch {
+	case err != nil:
+		errs.add("qr", "is too long for a QR code at error correction level %s", level)
+	case min(w, h) < modules:
+		errs.add("qr", "needs an image of at least %dx%d pixels", modules, modules)
+	}
+	return level
+}
+
+// parseGammaCorrect parses the gammaCorrect flag, falling back to cfg.GammaCorrect when absent
+func parseGammaCorrect(errs *paramErrors, value string, def bool) bool {
+	switch value {
+	case "":
+		return def
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	default:
+		errs.add("gammaCorrect", "must be true or false")
+		return def
+	}
+}
+
+// parseTagline returns the trimmed tagline drawn below the initials
+func parseTagline(errs *paramErrors, value string) string {
+	value = strings.TrimSpace(value)
+	if utf8.RuneCountInString(value) > render.MaxTaglineLength {
+		errs.add("tagline", "must not exceed %d characters", render.MaxTaglineLength)
+		return ""
+	}
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		errs.add("tagline", "must not contain control characters")
+		return ""
+	}
+	return value
+}
+
+// parseGrayscale returns how far to desaturate, from 0 (unchanged) to 1 (fully gray).
+// grayscale=1 is shorthand for saturation=0; saturation is a percentage kept.
+func parseGrayscale(errs *paramErrors, grayscale, saturation string) float64 {
+	amount := 0.0
+	if isTrue(grayscale) {
+		amount = 1
+	}
+	if saturation == "" {
+		return amount
+	}
+	n, err := strconv.Atoi(saturation)
+	if err != nil || n < 0 || n > 100 {
+		errs.add("saturation", "must be an integer between 0 and 100")
+		return 0
+	}
+	return float64(100-n) / 100
+}
+
+// maxFilenameLength bounds the ?filename= download name, before its extension
+const maxFilenameLength = 100
+
+// parseDownload reports whether the image should be served as an attachment and the
+// sanitized custom filename, if any. A filename implies download=1; an image extension
+// in it is dropped since the one matching format is appended.
+func parseDownload(errs *paramErrors, query url.Values, format render.ImageFormat) (bool, string) {
+	filename := query.Get("filename")
+	if filename == "" {
+		return isTrue(query.Get("download")), ""
+	}
+	if len(filename) > maxFilenameLength {
+		errs.add("filename", "must not exceed %d characters", maxFilenameLength)
+		return false, ""
+	}
+	_, base := extractFormat(strings.ToLower(filename))
+	base = strings.TrimSuffix(base, "."+string(format))
+	base = strings.Trim(filenameUnsafeRegex.ReplaceAllString(base, "-"), "-")
+	if base == "" {
+		errs.add("filename", "must contain letters or digits")
+		return false, ""
+	}
+	return true, base
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readAllHandler reads the whole body, answering 413 when the limit cuts it short
+var readAllHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			WriteBodyTooLarge(w, maxErr.Limit)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	handler := BodyLimitMiddleware(16, map[string]int64{"/batch": 32, "/batch/large": 64})(readAllHandler)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		size          int
+		knownLength   bool
+		expectedCode  int
+		expectedError string
+	}{
+		{"At limit", http.MethodPost, "/upload", 16, true, http.StatusOK, ""},
+		{"Over limit", http.MethodPost, "/upload", 17, true, http.StatusRequestEntityTooLarge, "request body exceeds 16 bytes"},
+		{"Over limit without length", http.MethodPost, "/upload", 17, false, http.StatusRequestEntityTooLarge, "request body exceeds 16 bytes"},
+		{"Route override at limit", http.MethodPost, "/batch", 32, true, http.StatusOK, ""},
+		{"Route override over limit", http.MethodPut, "/batch", 33, false, http.StatusRequestEntityTooLarge, "request body exceeds 32 bytes"},
+		{"Longest prefix wins", http.MethodPost, "/batch/large", 64, true, http.StatusOK, ""},
+		{"GET unaffected", http.MethodGet, "/upload", 100, true, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(strings.Repeat("x", tt.size)))
+			if !tt.knownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.expectedError) {
+				t.Fatalf("expected %q in %s", tt.expectedError, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestBodyLimitMiddlewareDisabled(t *testing.T) {
+	handler := BodyLimitMiddleware(0, map[string]int64{"/batch": 8})(readAllHandler)
+
+	for path, expected := range map[string]int{"/upload": http.StatusOK, "/batch": http.StatusRequestEntityTooLarge} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(strings.Repeat("x", 100)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Fatalf("expected %d for %s got %d", expected, path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,742 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"grout/internal/config"
+)
+
+// Supported content codings, in server preference order for equal client weights
+const (
+	encodingZstd   = "zstd"
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+var supportedEncodings = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// DefaultCompressibleTypes are the media types compressed when CompressionConfig.ContentTypes
+// is empty. Raster images are already compressed and are left alone.
+var DefaultCompressibleTypes = []string{"text/*", "image/svg+xml", "application/json", "application/xml", "application/javascript"}
+
+// maxAcceptEncodingEntries bounds how many Accept-Encoding entries negotiateEncoding parses
+const maxAcceptEncodingEntries = 32
+
+// CompressionConfig controls how responses are compressed.
+// Levels use the gzip 1-9 scale; brotli and zstd map them onto their own ranges.
+type CompressionConfig struct {
+	SmallLevel         int // Level for bodies below LargeBodyThreshold
+	LargeLevel         int // Level for bodies at or above LargeBodyThreshold
+	LargeBodyThreshold int // Body size in bytes from which LargeLevel is used
+	// BrotliLevel is the brotli quality (1-11) for every body; 0 uses SmallLevel and LargeLevel
+	BrotliLevel int
+	// MinSize is the body size in bytes below which responses are sent uncompressed: the
+	// coding's framing would make tiny bodies larger. 0 compresses any non-empty body.
+	MinSize int
+	// Encodings restricts the offered content codings ("zstd", "br", "gzip"); empty offers all
+	Encodings []string
+	// SkipPaths lists path prefixes whose responses are never compressed, e.g. "/healthz"
+	SkipPaths []string
+	// ContentTypes lists the media types to compress, "text/*" covering a whole type;
+	// empty uses DefaultCompressibleTypes
+	ContentTypes []string
+	// Debug adds an X-Compression-Debug header explaining each decision; keep it off in production
+	Debug bool
+	// AdaptiveThreshold enables adaptive mode: while more requests than this are in flight,
+	// brotli is downgraded to gzip and bodies of at least LargeBodyThreshold are sent
+	// uncompressed to protect latency. 0 disables it.
+	AdaptiveThreshold int
+}
+
+// compressionDebug is the JSON reported in X-Compression-Debug
+type compressionDebug struct {
+	Encoding        string `json:"encoding"` // Negotiated coding, empty when the client accepts none
+	ContentType     string `json:"content_type"`
+	Compressible    bool   `json:"compressible"`
+	Bytes           int    `json:"bytes"`
+	LargeThreshold  int    `json:"large_threshold"`
+	Level           int    `json:"level,omitempty"`
+	Compressed      bool   `json:"compressed"`
+	CompressedBytes int    `json:"compressed_bytes,omitempty"`
+	Reason          string `json:"reason,omitempty"` // Why the body was sent uncompressed
+	UnderLoad       bool   `json:"under_load,omitempty"`
+	// Streamed is set when the handler flushed or the body reached the large threshold; Bytes
+	// counts what was buffered until then, or the size that reached the threshold
+	Streamed bool `json:"streamed,omitempty"`
+}
+
+// defaultGzipLevel is the level gzip.DefaultCompression stands for. It is spelled out because
+// brotli and zstd read -1 as their fastest setting rather than their default.
+const defaultGzipLevel = 6
+
+// DefaultCompressionConfig favors latency for small bodies and ratio for large ones
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		SmallLevel:         gzip.BestSpeed,
+		LargeLevel:         defaultGzipLevel,
+		LargeBodyThreshold: 32 * 1024,
+		MinSize:            256,
+	}
+}
+
+// NewCompressionConfig returns the compression settings of cfg, shared by the middleware and
+// handlers that serve precompressed bodies so both make the same decisions
+func NewCompressionConfig(cfg config.ServerConfig) CompressionConfig {
+	c := CompressionConfig{
+		SmallLevel:         cfg.CompressionLevelSmall,
+		LargeLevel:         cfg.CompressionLevelLarge,
+		LargeBodyThreshold: cfg.CompressionLargeThreshold,
+		BrotliLevel:        cfg.CompressionBrotliLevel,
+		MinSize:            cfg.CompressionMinSize,
+		Debug:              cfg.CompressionDebug,
+		AdaptiveThreshold:  cfg.CompressionAdaptiveThreshold,
+		SkipPaths:          cfg.CompressionSkipPaths,
+		ContentTypes:       cfg.CompressionContentTypes,
+	}
+	if cfg.LowMemory {
+		// brotli and zstd encoders keep large windows; gzip alone keeps memory flat
+		c.Encodings = []string{encodingGzip}
+	}
+	return c
+}
+
+// Allowed returns the codings among offered that a 200 response to r with headers h may use
+// under this config, keeping their order. It returns none when the path is skipped, the
+// request or response carries Cache-Control: no-transform or the type is not compressible.
+// Handlers serving precompressed bodies negotiate among these with NegotiateEncoding.
+func (c CompressionConfig) Allowed(r *http.Request, h http.Header, offered ...string) []string {
+	if c.skipsPath(r.URL.Path) || hasNoTransform(r.Header) || responseSkipReason(c, http.StatusOK, h) != "" {
+		return nil
+	}
+	var allowed []string
+	for _, encoding := range offered {
+		if slices.Contains(c.offered(), encoding) {
+			allowed = append(allowed, encoding)
+		}
+	}
+	return allowed
+}
+
+// offered returns the content codings this config may use, in server preference order
+func (c CompressionConfig) offered() []string {
+	if len(c.Encodings) == 0 {
+		return supportedEncodings
+	}
+	var offered []string
+	for _, encoding := range supportedEncodings {
+		for _, allowed := range c.Encodings {
+			if encoding == allowed {
+				offered = append(offered, encoding)
+			}
+		}
+	}
+	return offered
+}
+
+// skipsPath reports whether path starts with one of SkipPaths
+func (c CompressionConfig) skipsPath(path string) bool {
+	for _, prefix := range c.SkipPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible reports whether responses of contentType are compressed under this config
+func (c CompressionConfig) compressible(contentType string) bool {
+	if len(c.ContentTypes) == 0 {
+		return shouldCompress(contentType)
+	}
+	return matchesMediaType(contentType, c.ContentTypes)
+}
+
+// levelFor returns the level to use for a body of the given size in encoding: BrotliLevel
+// for brotli when set, otherwise the gzip-scale level for the size
+func (c CompressionConfig) levelFor(encoding string, size int) int {
+	if encoding == encodingBrotli && c.BrotliLevel > 0 {
+		return c.BrotliLevel
+	}
+	if size >= c.LargeBodyThreshold {
+		return c.LargeLevel
+	}
+	return c.SmallLevel
+}
+
+// CompressionMiddleware compresses compressible responses with zstd, brotli or gzip,
+// whichever the client's Accept-Encoding weights highest (see negotiateEncoding).
+// The response is buffered first so the compression level can be chosen from its size; one
+// reaching LargeBodyThreshold is compressed at the large level as it is written instead,
+// and one whose status or headers already rule compression out is passed through unbuffered.
+// Requests or responses carrying Cache-Control: no-transform are passed through unchanged.
+// Every response gets Vary: Accept-Encoding, and the strong ETag of a compressed one is
+// tagged with its coding, so caches never serve one coding's bytes to another client.
+// With cfg.Debug every response is buffered so X-Compression-Debug can describe it.
+// A handler that flushes switches its response to streaming (see compressionResponseWriter.Flush).
+// With cfg.AdaptiveThreshold the in-flight request count decides whether to shed work
+// (see adaptiveOffered); compression returns to normal as soon as the count drops.
+func CompressionMiddleware(cfg CompressionConfig) func(http.Handler) http.Handler {
+	var inFlight atomic.Int64
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Opted-out routes are never compressed, so their responses do not vary either
+			if cfg.skipsPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			underLoad := false
+			if cfg.AdaptiveThreshold > 0 {
+				underLoad = inFlight.Add(1) > int64(cfg.AdaptiveThreshold)
+				defer inFlight.Add(-1)
+			}
+			offered := cfg.offered()
+			if underLoad {
+				offered = adaptiveOffered(offered)
+			}
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), offered)
+			AddVary(w.Header(), "Accept-Encoding")
+			var skip string
+			switch {
+			case encoding == "":
+				skip = "no accepted encoding"
+			case hasNoTransform(r.Header):
+				skip = "request no-transform"
+			}
+			if skip != "" && !cfg.Debug {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressionResponseWriter{ResponseWriter: w, cfg: cfg, encoding: encoding, skip: skip, status: http.StatusOK, underLoad: underLoad}
+			// The client revalidates the coded ETag it was sent; the handler knows its own
+			if inm := r.Header.Get("If-None-Match"); skip == "" && inm != "" {
+				if stripped, ok := stripETagCoding(inm, encoding); ok {
+					r = r.Clone(r.Context())
+					r.Header.Set("If-None-Match", stripped)
+					cw.codedValidator = true
+				}
+			}
+			// A HEAD must carry the headers of the matching GET, coding, length and ETag
+			// included, which depend on the body; the handler produces it as for GET and it
+			// is dropped on the way out
+			if r.Method == http.MethodHead && skip == "" {
+				r = r.Clone(r.Context())
+				r.Method = http.MethodGet
+				cw.ResponseWriter = headResponseWriter{w}
+			}
+			next.ServeHTTP(cw, r)
+			cw.finish()
+		})
+	}
+}
+
+// headResponseWriter discards the body of a HEAD response that was produced as for GET
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (hw headResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (hw headResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// adaptiveOffered drops brotli, the most CPU-hungry coding at our levels, while under load.
+// Clients that only accept brotli get an uncompressed response instead.
+func adaptiveOffered(offered []string) []string {
+	var cheaper []string
+	for _, encoding := range offered {
+		if encoding != encodingBrotli {
+			cheaper = append(cheaper, encoding)
+		}
+	}
+	return cheaper
+}
+
+// compressionResponseWriter buffers the handler's response until it can decide whether to
+// compress. It passes Flush, Hijack and Push through, unwrapping any writers other middleware
+// put in between, so streaming handlers keep working.
+type compressionResponseWriter struct {
+	http.ResponseWriter
+	cfg      CompressionConfig
+	encoding string // Negotiated coding, empty when the client accepts none
+	skip     string // Request-side reason not to compress, known before the handler runs
+
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	underLoad   bool // Set when adaptive mode saw more in-flight requests than its threshold
+	// codedValidator is set when If-None-Match named the negotiated coding's ETag, so a 304
+	// confirms that coded representation
+	codedValidator bool
+	// streaming is set once the handler flushes: the rest of the response goes straight to
+	// the client, through stream when it is compressed
+	streaming bool
+	stream    streamEncoder
+	hijacked  bool
+	// size is the body size streaming started for once it reached LargeBodyThreshold, by
+	// Content-Length or by what was written; 0 when a flush started it
+	size int
+}
+
+// streamEncoder is a content coding writer that can push out what it has compressed so far
+type streamEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// codecLevel returns a gzip-scale level as the encoders take it. Levels 1-9 carry over
+// directly: brotli's 0-11 range covers them and zstd maps them onto its speed presets.
+// gzip.DefaultCompression becomes defaultGzipLevel so it does not select the fastest
+// brotli and zstd settings.
+func codecLevel(level int) int {
+	if level == gzip.DefaultCompression {
+		return defaultGzipLevel
+	}
+	return level
+}
+
+// newStreamEncoder returns a streaming encoder for the content coding at a gzip-scale level
+func newStreamEncoder(w io.Writer, encoding string, level int) (streamEncoder, error) {
+	level = codecLevel(level)
+	switch encoding {
+	case encodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	case encodingBrotli:
+		return brotli.NewWriterLevel(w, level), nil
+	case encodingGzip:
+		return gzip.NewWriterLevel(w, level)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+func (cw *compressionResponseWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.status = statusCode
+	cw.wroteHeader = true
+}
+
+func (cw *compressionResponseWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	// A response that will not be compressed, e.g. a ZIP download, gains nothing from
+	// buffering, so it goes straight through; debug mode still buffers to report its size
+	if !cw.streaming && cw.buf.Len() == 0 && !cw.cfg.Debug && responseSkipReason(cw.cfg, cw.status, cw.ResponseWriter.Header()) != "" {
+		cw.startStream()
+	}
+	// A large body, e.g. a static file streamed from disk, is compressed as it is written at
+	// the large level rather than held whole in memory first
+	if !cw.streaming {
+		if cw.size = cw.largeBodySize(len(p)); cw.size > 0 {
+			cw.startStream()
+		}
+	}
+	switch {
+	case cw.stream != nil:
+		return cw.stream.Write(p)
+	case cw.streaming:
+		return cw.ResponseWriter.Write(p)
+	default:
+		return cw.buf.Write(p)
+	}
+}
+
+// largeBodySize returns the body size once it is known to reach LargeBodyThreshold, from
+// Content-Length or from what was written including the next n bytes, and 0 before that
+func (cw *compressionResponseWriter) largeBodySize(n int) int {
+	size := cw.buf.Len() + n
+	if declared, err := strconv.Atoi(cw.ResponseWriter.Header().Get("Content-Length")); err == nil {
+		size = max(size, declared)
+	}
+	if size < cw.cfg.LargeBodyThreshold {
+		return 0
+	}
+	return size
+}
+
+// Flush sends what the handler has written so far. The first call commits the response to
+// streaming: the size is unknown from then on, so the minimum size and the large level do
+// not apply, and a compressible response is compressed as it is written instead.
+func (cw *compressionResponseWriter) Flush() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.streaming {
+		cw.startStream()
+	}
+	if cw.stream != nil {
+		_ = cw.stream.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// startStream writes the headers and the buffered body, choosing the streaming encoder
+func (cw *compressionResponseWriter) startStream() {
+	cw.streaming, cw.wroteHeader = true, true
+	h := cw.ResponseWriter.Header()
+	debug := compressionDebug{
+		Encoding:       cw.encoding,
+		ContentType:    h.Get("Content-Type"),
+		Compressible:   cw.cfg.compressible(h.Get("Content-Type")),
+		Bytes:          max(cw.buf.Len(), cw.size),
+		LargeThreshold: cw.cfg.LargeBodyThreshold,
+		Reason:         cw.skip,
+		UnderLoad:      cw.underLoad,
+		Streamed:       true,
+	}
+	if debug.Reason == "" {
+		debug.Reason = responseSkipReason(cw.cfg, cw.status, h)
+	}
+	if debug.Reason == "" && cw.underLoad && cw.size > 0 {
+		debug.Reason = "under load"
+	}
+	if debug.Reason == "" {
+		debug.Level = cw.cfg.levelFor(cw.encoding, cw.size)
+		stream, err := newStreamEncoder(cw.ResponseWriter, cw.encoding, debug.Level)
+		if err != nil {
+			debug.Reason = "compression failed: " + err.Error()
+		} else {
+			cw.stream, debug.Compressed = stream, true
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			setCodedETag(h, cw.encoding)
+		}
+	} else if cw.status == http.StatusNotModified && cw.codedValidator {
+		setCodedETag(h, cw.encoding)
+	}
+	if cw.cfg.Debug {
+		setCompressionDebug(h, debug)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buffered := cw.buf.Bytes()
+	cw.buf = bytes.Buffer{}
+	if len(buffered) > 0 {
+		_, _ = cw.Write(buffered)
+	}
+}
+
+// Hijack hands the connection to the handler, e.g. for WebSockets. Nothing buffered is sent
+// and the middleware writes nothing afterwards.
+func (cw *compressionResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("compression: hijack: %w", err)
+	}
+	cw.hijacked = true
+	return conn, rw, nil
+}
+
+// Push initiates an HTTP/2 server push when the underlying writer supports it
+func (cw *compressionResponseWriter) Push(target string, opts *http.PushOptions) error {
+	w := cw.ResponseWriter
+	for {
+		if pusher, ok := w.(http.Pusher); ok {
+			return pusher.Push(target, opts)
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return http.ErrNotSupported
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *compressionResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish writes the buffered response, compressed with the negotiated encoding unless skip
+// already names a reason not to or the response itself gives one. A streamed response only
+// has its encoder closed.
+func (cw *compressionResponseWriter) finish() {
+	switch {
+	case cw.hijacked:
+		return
+	case cw.streaming:
+		if cw.stream != nil {
+			_ = cw.stream.Close()
+		}
+		return
+	}
+	cfg, encoding, skip := cw.cfg, cw.encoding, cw.skip
+	h := cw.ResponseWriter.Header()
+	body := cw.buf.Bytes()
+	debug := compressionDebug{
+		Encoding:       encoding,
+		ContentType:    h.Get("Content-Type"),
+		Compressible:   cfg.compressible(h.Get("Content-Type")),
+		Bytes:          len(body),
+		LargeThreshold: cfg.LargeBodyThreshold,
+		Reason:         skip,
+		UnderLoad:      cw.underLoad,
+	}
+	if debug.Reason == "" {
+		debug.Reason = skipReason(cfg, cw.status, h, len(body))
+	}
+	if debug.Reason == "" && cw.underLoad && len(body) >= cfg.LargeBodyThreshold {
+		debug.Reason = "under load"
+	}
+
+	var compressed []byte
+	if debug.Reason == "" {
+		debug.Level = cfg.levelFor(encoding, len(body))
+		var err error
+		if compressed, err = compressBody(encoding, debug.Level, body); err != nil {
+			debug.Reason = "compression failed: " + err.Error()
+		}
+	}
+	if debug.Reason != "" {
+		if cfg.Debug {
+			setCompressionDebug(h, debug)
+		}
+		if cw.status == http.StatusNotModified && cw.codedValidator {
+			setCodedETag(h, encoding)
+		}
+		cw.ResponseWriter.WriteHeader(cw.status)
+		_, _ = cw.ResponseWriter.Write(body)
+		return
+	}
+
+	debug.Compressed, debug.CompressedBytes = true, len(compressed)
+	if cfg.Debug {
+		setCompressionDebug(h, debug)
+	}
+	h.Set("Content-Encoding", encoding)
+	h.Set("Content-Length", strconv.Itoa(len(compressed)))
+	setCodedETag(h, encoding)
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, _ = cw.ResponseWriter.Write(compressed)
+}
+
+// skipReason explains why a response must be sent as is, or returns "" when it can be compressed
+func skipReason(cfg CompressionConfig, status int, h http.Header, size int) string {
+	switch {
+	case size == 0:
+		return "empty body"
+	case size < cfg.MinSize:
+		return "below minimum size"
+	default:
+		return responseSkipReason(cfg, status, h)
+	}
+}
+
+// responseSkipReason is skipReason for the status and headers alone, as streamed responses
+// are judged before their size is known
+func responseSkipReason(cfg CompressionConfig, status int, h http.Header) string {
+	switch {
+	case status != http.StatusOK:
+		return "status " + strconv.Itoa(status)
+	case h.Get("Content-Encoding") != "":
+		return "already encoded"
+	case hasNoTransform(h):
+		return "response no-transform"
+	case !cfg.compressible(h.Get("Content-Type")):
+		return "content type not compressible"
+	default:
+		return ""
+	}
+}
+
+// AddVary adds field to the Vary header unless it is already listed, so handlers and
+// middleware can each declare what they vary on without repeating it
+func AddVary(h http.Header, field string) {
+	for _, value := range h.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			listed = strings.TrimSpace(listed)
+			if listed == "*" || strings.EqualFold(listed, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// setCodedETag tags a strong ETag with the content coding of the response, e.g. "abc"
+// becomes "abc-gzip": a strong validator must differ between byte-different representations.
+// Weak ETags already only promise equivalent content and are left alone.
+func setCodedETag(h http.Header, encoding string) {
+	etag := h.Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return
+	}
+	h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`)
+}
+
+// stripETagCoding removes the "-encoding" tag setCodedETag adds from each entity tag of an
+// If-None-Match value, reporting whether any was removed. Tags of other codings stay as
+// they are and so will not match the handler's ETag.
+func stripETagCoding(ifNoneMatch, encoding string) (string, bool) {
+	suffix := "-" + encoding + `"`
+	tags := strings.Split(ifNoneMatch, ",")
+	stripped := false
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if strings.HasSuffix(tag, suffix) && len(tag) > len(suffix) {
+			tag = strings.TrimSuffix(tag, suffix) + `"`
+			stripped = true
+		}
+		tags[i] = tag
+	}
+	return strings.Join(tags, ", "), stripped
+}
+
+// setCompressionDebug reports the decision as JSON in X-Compression-Debug
+func setCompressionDebug(h http.Header, debug compressionDebug) {
+	data, err := json.Marshal(debug)
+	if err != nil {
+		return
+	}
+	h.Set("X-Compression-Debug", string(data))
+}
+
+// CompressBody encodes body with the given content coding ("zstd", "br" or "gzip") at a
+// gzip-scale level, as the middleware does. Handlers use it to precompress assets once.
+func CompressBody(encoding string, level int, body []byte) ([]byte, error) {
+	return compressBody(encoding, level, body)
+}
+
+// compressBody encodes body with the given content coding at a gzip-scale level
+func compressBody(encoding string, level int, body []byte) ([]byte, error) {
+	level = codecLevel(level)
+	var buf bytes.Buffer
+	switch encoding {
+	case encodingZstd:
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(body, nil), nil
+	case encodingBrotli:
+		bw := brotli.NewWriterLevel(&buf, level)
+		if _, err := bw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := bw.Close(); err != nil {
+			return nil, err
+		}
+	case encodingGzip:
+		gz, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := gz.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// hasNoTransform reports whether the Cache-Control headers include the no-transform directive,
+// which forbids intermediaries (and us) from changing the content coding
+func hasNoTransform(h http.Header) bool {
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-transform") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shouldCompress reports whether the content type is one of DefaultCompressibleTypes
+func shouldCompress(contentType string) bool {
+	return matchesMediaType(contentType, DefaultCompressibleTypes)
+}
+
+// matchesMediaType reports whether the media type of contentType, parameters aside, is one of
+// types; an entry like "text/*" matches every subtype
+func matchesMediaType(contentType string, types []string) bool {
+	mediaType := strings.TrimSpace(strings.ToLower(strings.Split(contentType, ";")[0]))
+	if mediaType == "" {
+		return false
+	}
+	for _, t := range types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateEncoding picks one of the offered content codings for an Accept-Encoding header
+// using the same rules as the middleware. Handlers serving precompressed files use it to
+// choose a variant; it returns "" when the client accepts none of them.
+func NegotiateEncoding(acceptEncoding string, offered ...string) string {
+	return negotiateEncoding(acceptEncoding, offered)
+}
+
+// negotiateEncoding picks one of the offered content codings for an Accept-Encoding header, or "" for none.
+// The coding with the highest q-value wins; a missing q means 1 and "*" covers codings not
+// listed explicitly. Equal weights are broken by server preference: zstd, then br, then gzip.
+// Entries past maxAcceptEncodingEntries are ignored.
+func negotiateEncoding(acceptEncoding string, offered []string) string {
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	// Only the first entries are considered so an oversized header cannot cost unbounded work
+	for i := 0; i < maxAcceptEncodingEntries && acceptEncoding != ""; i++ {
+		var part string
+		part, acceptEncoding, _ = strings.Cut(acceptEncoding, ",")
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			q = parsed
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range offered {
+		q, ok := weights[encoding]
+		if !ok {
+			q = wildcard
+		}
+		// Strictly greater keeps the earlier, preferred coding on ties
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,930 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressibleBody returns n bytes of text that compresses noticeably better at higher levels
+func compressibleBody(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="10" fill="#%06x"/>`, i%97, i%89, i%13, (i*7919)%0xffffff)
+	}
+	return buf.Bytes()[:n]
+}
+
+func serveCompressed(t *testing.T, cfg CompressionConfig, contentType string, body []byte, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := CompressionMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gzip read: %v", err)
+	}
+	return out
+}
+
+func gzipSize(t *testing.T, data []byte, level int) int {
+	t.Helper()
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, level)
+	_, _ = gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Len()
+}
+
+func TestCompressionLevelBySize(t *testing.T) {
+	cfg := CompressionConfig{SmallLevel: gzip.BestSpeed, LargeLevel: gzip.BestCompression, LargeBodyThreshold: 4096}
+
+	t.Run("Large body uses the higher level", func(t *testing.T) {
+		body := compressibleBody(64 * 1024)
+		rec := serveCompressed(t, cfg, "image/svg+xml", body, "gzip")
+
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatal("expected gzip Content-Encoding")
+		}
+		if !bytes.Equal(gunzip(t, rec.Body.Bytes()), body) {
+			t.Fatal("decompressed body does not match")
+		}
+		if rec.Body.Len() != gzipSize(t, body, gzip.BestCompression) {
+			t.Fatalf("expected output of BestCompression size %d, got %d", gzipSize(t, body, gzip.BestCompression), rec.Body.Len())
+		}
+		if rec.Body.Len() >= gzipSize(t, body, gzip.BestSpeed) {
+			t.Fatalf("expected large body to compress smaller than BestSpeed (%d), got %d", gzipSize(t, body, gzip.BestSpeed), rec.Body.Len())
+		}
+	})
+
+	t.Run("Small body uses the fast level", func(t *testing.T) {
+		body := compressibleBody(2048)
+		rec := serveCompressed(t, cfg, "image/svg+xml", body, "gzip")
+
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatal("expected gzip Content-Encoding")
+		}
+		if !bytes.Equal(gunzip(t, rec.Body.Bytes()), body) {
+			t.Fatal("decompressed body does not match")
+		}
+		if rec.Body.Len() != gzipSize(t, body, gzip.BestSpeed) {
+			t.Fatalf("expected output of BestSpeed size %d, got %d", gzipSize(t, body, gzip.BestSpeed), rec.Body.Len())
+		}
+		// The gzip header's XFL byte is 4 for the fastest algorithm
+		if xfl := rec.Body.Bytes()[8]; xfl != 4 {
+			t.Fatalf("expected XFL 4 (fastest) got %d", xfl)
+		}
+	})
+}
+
+func TestCompressionMinSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		minSize    int
+		size       int
+		compressed bool
+	}{
+		{"Below minimum", 256, 255, false},
+		{"At minimum", 256, 256, true},
+		{"Tiny SVG", 256, 80, false},
+		{"No minimum", 0, 80, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultCompressionConfig()
+			cfg.MinSize = tt.minSize
+			cfg.Debug = true
+			body := compressibleBody(tt.size)
+			rec := serveCompressed(t, cfg, "image/svg+xml", body, "gzip")
+
+			if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+				t.Fatalf("expected compressed %t got %t", tt.compressed, compressed)
+			}
+			if !tt.compressed {
+				if !bytes.Equal(rec.Body.Bytes(), body) {
+					t.Fatal("expected body to pass through unchanged")
+				}
+				if debug := rec.Header().Get("X-Compression-Debug"); !strings.Contains(debug, `"reason":"below minimum size"`) {
+					t.Fatalf("expected the minimum size as reason got %s", debug)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionLargeLevelPerCodec(t *testing.T) {
+	body := compressibleBody(64 * 1024)
+	brotliAt := func(quality int) []byte {
+		var buf bytes.Buffer
+		bw := brotli.NewWriterLevel(&buf, quality)
+		_, _ = bw.Write(body)
+		if err := bw.Close(); err != nil {
+			t.Fatalf("brotli: %v", err)
+		}
+		return buf.Bytes()
+	}
+	zstdAt := func(level zstd.EncoderLevel) []byte {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+		if err != nil {
+			t.Fatalf("zstd: %v", err)
+		}
+		defer enc.Close()
+		return enc.EncodeAll(body, nil)
+	}
+
+	cfg := DefaultCompressionConfig()
+	if cfg.LargeLevel != 6 {
+		t.Fatalf("expected the default large level to be 6 got %d", cfg.LargeLevel)
+	}
+	tests := []struct {
+		encoding string
+		want     []byte
+		fastest  []byte
+	}{
+		{encoding: "br", want: brotliAt(6), fastest: brotliAt(0)},
+		{encoding: "zstd", want: zstdAt(zstd.SpeedBetterCompression), fastest: zstdAt(zstd.SpeedFastest)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			if bytes.Equal(tt.want, tt.fastest) {
+				t.Fatal("expected the test body to compress differently at the fastest level")
+			}
+			rec := serveCompressed(t, cfg, "image/svg+xml", body, tt.encoding)
+			if rec.Header().Get("Content-Encoding") != tt.encoding || !bytes.Equal(rec.Body.Bytes(), tt.want) {
+				t.Fatalf("expected the level 6 output of %d bytes got %d", len(tt.want), rec.Body.Len())
+			}
+
+			// gzip.DefaultCompression means gzip's default to every codec, streamed or not
+			out, err := compressBody(tt.encoding, gzip.DefaultCompression, body)
+			if err != nil || !bytes.Equal(out, tt.want) {
+				t.Fatalf("expected -1 to compress like level 6 got %d bytes: %v", len(out), err)
+			}
+			var streamed, expected bytes.Buffer
+			for level, buf := range map[int]*bytes.Buffer{gzip.DefaultCompression: &streamed, 6: &expected} {
+				enc, err := newStreamEncoder(buf, tt.encoding, level)
+				if err != nil {
+					t.Fatalf("stream encoder: %v", err)
+				}
+				_, _ = enc.Write(body)
+				if err := enc.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+			}
+			if !bytes.Equal(streamed.Bytes(), expected.Bytes()) {
+				t.Fatalf("expected a streamed -1 to match level 6, got %d and %d bytes", streamed.Len(), expected.Len())
+			}
+		})
+	}
+}
+
+func TestCompressionBrotliLevel(t *testing.T) {
+	body := compressibleBody(64 * 1024)
+	brotliSize := func(level int) int {
+		out, err := compressBody(encodingBrotli, level, body)
+		if err != nil {
+			t.Fatalf("brotli: %v", err)
+		}
+		return len(out)
+	}
+
+	cfg := DefaultCompressionConfig()
+	// Without BrotliLevel the gzip-scale large level, 6, is used as the brotli quality
+	if rec := serveCompressed(t, cfg, "image/svg+xml", body, "br"); rec.Body.Len() != brotliSize(6) || brotliSize(6) == brotliSize(0) {
+		t.Fatalf("expected the brotli quality 6 output of %d bytes without BrotliLevel, got %d", brotliSize(6), rec.Body.Len())
+	}
+	// So is it when the large level is gzip.DefaultCompression
+	cfg.LargeLevel = gzip.DefaultCompression
+	if rec := serveCompressed(t, cfg, "image/svg+xml", body, "br"); rec.Body.Len() != brotliSize(6) {
+		t.Fatalf("expected gzip.DefaultCompression to map to brotli quality 6, got %d bytes", rec.Body.Len())
+	}
+	cfg.LargeLevel = 6
+	cfg.BrotliLevel = 11
+	rec := serveCompressed(t, cfg, "image/svg+xml", body, "br")
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Body.Len() != brotliSize(11) {
+		t.Fatalf("expected brotli quality 11 output of %d bytes, got %d", brotliSize(11), rec.Body.Len())
+	}
+	// gzip keeps the gzip-scale levels
+	if rec := serveCompressed(t, cfg, "image/svg+xml", body, "gzip"); rec.Body.Len() != gzipSize(t, body, cfg.LargeLevel) {
+		t.Fatalf("expected gzip to ignore BrotliLevel, got %d bytes", rec.Body.Len())
+	}
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	body := compressibleBody(8192)
+
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+	}{
+		{"Client does not accept gzip", "image/svg+xml", ""},
+		{"Client refuses gzip", "image/svg+xml", "gzip;q=0"},
+		{"Raster image", "image/png", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveCompressed(t, cfg, tt.contentType, body, tt.acceptEncoding)
+			if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+				t.Fatalf("expected no Content-Encoding got %q", enc)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), body) {
+				t.Fatal("expected body to pass through unchanged")
+			}
+		})
+	}
+}
+
+func TestShouldCompress(t *testing.T) {
+	tests := map[string]bool{
+		"image/svg+xml":                  true,
+		"text/html; charset=utf-8":       true,
+		"application/json":               true,
+		"application/xml; charset=utf-8": true,
+		"image/png":                      false,
+		"image/webp":                     false,
+		"image/avif":                     false,
+		"application/zip":                false,
+		"":                               false,
+	}
+	for contentType, expected := range tests {
+		if got := shouldCompress(contentType); got != expected {
+			t.Errorf("shouldCompress(%q): expected %t got %t", contentType, expected, got)
+		}
+	}
+}
+
+func TestCompressionSkipPaths(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.SkipPaths = []string{"/healthz"}
+	body := compressibleBody(4096)
+	handler := CompressionMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+
+	tests := []struct {
+		path       string
+		compressed bool
+	}{
+		{"/healthz", false},
+		{"/healthz/ready", false},
+		{"/avatar/John", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Fatalf("%s: expected compressed %t got %t", tt.path, tt.compressed, compressed)
+		}
+		if !tt.compressed {
+			if !bytes.Equal(rec.Body.Bytes(), body) {
+				t.Fatalf("%s: expected body to pass through unchanged", tt.path)
+			}
+			if vary := rec.Header().Get("Vary"); vary != "" {
+				t.Fatalf("%s: expected no Vary on a skipped path got %q", tt.path, vary)
+			}
+		}
+	}
+}
+
+func TestCompressionContentTypes(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.ContentTypes = []string{"application/octet-stream", "image/*"}
+	body := compressibleBody(4096)
+
+	tests := []struct {
+		contentType string
+		compressed  bool
+	}{
+		{"application/octet-stream", true},
+		{"image/svg+xml", true},
+		{"image/png", true},
+		{"text/html; charset=utf-8", false},
+		{"application/json", false},
+	}
+	for _, tt := range tests {
+		rec := serveCompressed(t, cfg, tt.contentType, body, "gzip")
+		if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tt.compressed {
+			t.Fatalf("%s: expected compressed %t got %t", tt.contentType, tt.compressed, compressed)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"gzip", "gzip"},
+		{"deflate, gzip;q=0.5", "gzip"},
+		{"GZIP", "gzip"},
+		{"br", "br"},
+		{"zstd", "zstd"},
+		{"zstd;q=1.0, br;q=0.9, gzip;q=0.8", "zstd"},
+		{"br;q=0.9, gzip;q=1.0", "gzip"},
+		{"gzip;q=0.8, br;q=0.9, zstd;q=0.1", "br"},
+		// Equal weights fall back to server preference: zstd > br > gzip
+		{"gzip, br", "br"},
+		{"gzip, br, zstd", "zstd"},
+		{"gzip;q=0.5, br;q=0.5", "br"},
+		// The wildcard covers codings that are not listed
+		{"*", "zstd"},
+		{"zstd;q=0, *;q=0.5", "br"},
+		{"gzip;q=0.9, *;q=0.1", "gzip"},
+		{"gzip;q=0", ""},
+		{"zstd;q=0, br;q=0, gzip;q=0", ""},
+		{"*;q=0", ""},
+		{"gzip;q=abc", ""},
+		{"deflate, identity", ""},
+		{"", ""},
+		{strings.Repeat("x,", 5), ""},
+		// Only the first maxAcceptEncodingEntries entries are parsed
+		{strings.Repeat("x,", maxAcceptEncodingEntries-1) + "gzip", "gzip"},
+		{strings.Repeat("x,", maxAcceptEncodingEntries) + "gzip", ""},
+		{strings.Repeat("x;q=0.5,", 100000) + "gzip", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header, supportedEncodings); got != tt.expected {
+			t.Errorf("negotiateEncoding(%q): expected %q got %q", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestCompressionEncodings(t *testing.T) {
+	body := compressibleBody(8192)
+
+	decoders := map[string]func([]byte) ([]byte, error){
+		"zstd": func(data []byte) ([]byte, error) {
+			dec, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			defer dec.Close()
+			return dec.DecodeAll(data, nil)
+		},
+		"br": func(data []byte) ([]byte, error) {
+			return io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+		},
+	}
+
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			rec := serveCompressed(t, DefaultCompressionConfig(), "image/svg+xml", body, encoding)
+			if got := rec.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("expected Content-Encoding %s got %q", encoding, got)
+			}
+			if rec.Header().Get("Content-Length") != fmt.Sprint(rec.Body.Len()) {
+				t.Fatalf("expected Content-Length %d got %s", rec.Body.Len(), rec.Header().Get("Content-Length"))
+			}
+			decoded, err := decode(rec.Body.Bytes())
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !bytes.Equal(decoded, body) {
+				t.Fatal("decoded body does not match")
+			}
+		})
+	}
+}
+
+func TestCompressionRestrictedEncodings(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.Encodings = []string{"gzip"}
+	body := compressibleBody(8192)
+
+	rec := serveCompressed(t, cfg, "image/svg+xml", body, "zstd, br, gzip;q=0.1")
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected gzip when brotli and zstd are not offered, got %q", got)
+	}
+
+	rec = serveCompressed(t, cfg, "image/svg+xml", body, "br")
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no compression for a brotli-only client, got %q", got)
+	}
+}
+
+func TestCompressionNoTransform(t *testing.T) {
+	body := compressibleBody(8192)
+
+	tests := []struct {
+		name                 string
+		requestCacheControl  string
+		responseCacheControl string
+		wantEncoding         string
+	}{
+		{"No directive", "", "", "gzip"},
+		{"Request no-transform", "no-transform", "", ""},
+		{"Request no-transform among directives", "max-age=0, No-Transform", "", ""},
+		{"Response no-transform", "", "public, no-transform", ""},
+		{"Other request directives", "no-cache", "", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressionMiddleware(DefaultCompressionConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/svg+xml")
+				if tt.responseCacheControl != "" {
+					w.Header().Set("Cache-Control", tt.responseCacheControl)
+				}
+				_, _ = w.Write(body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			if tt.requestCacheControl != "" {
+				req.Header.Set("Cache-Control", tt.requestCacheControl)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if enc := rec.Header().Get("Content-Encoding"); enc != tt.wantEncoding {
+				t.Fatalf("expected Content-Encoding %q got %q", tt.wantEncoding, enc)
+			}
+			if tt.wantEncoding == "" && !bytes.Equal(rec.Body.Bytes(), body) {
+				t.Fatal("expected body to pass through unchanged")
+			}
+		})
+	}
+}
+
+func TestCompressionDebug(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.Debug = true
+	body := compressibleBody(8192)
+
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+		compressed     bool
+		compressible   bool
+		reason         string
+		level          int
+	}{
+		{"Compressed SVG", "image/svg+xml", "gzip", true, true, "", gzip.BestSpeed},
+		{"Uncompressed PNG", "image/png", "gzip", false, false, "content type not compressible", 0},
+		{"No accepted encoding", "image/svg+xml", "", false, true, "no accepted encoding", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveCompressed(t, cfg, tt.contentType, body, tt.acceptEncoding)
+			header := rec.Header().Get("X-Compression-Debug")
+			var debug compressionDebug
+			if err := json.Unmarshal([]byte(header), &debug); err != nil {
+				t.Fatalf("expected JSON in X-Compression-Debug, got %q: %v", header, err)
+			}
+			if debug.Compressed != tt.compressed || debug.Compressible != tt.compressible || debug.Reason != tt.reason || debug.Level != tt.level {
+				t.Fatalf("unexpected decision %+v", debug)
+			}
+			if debug.Encoding != tt.acceptEncoding || debug.Bytes != len(body) || debug.LargeThreshold != cfg.LargeBodyThreshold {
+				t.Fatalf("unexpected negotiation details %+v", debug)
+			}
+			if got := rec.Header().Get("Content-Encoding") == "gzip"; got != tt.compressed {
+				t.Fatalf("expected compressed %t, Content-Encoding %q", tt.compressed, rec.Header().Get("Content-Encoding"))
+			}
+			if tt.compressed && debug.CompressedBytes != rec.Body.Len() {
+				t.Fatalf("expected compressed_bytes %d got %d", rec.Body.Len(), debug.CompressedBytes)
+			}
+		})
+	}
+
+	t.Run("Large body uses the large level", func(t *testing.T) {
+		large := compressibleBody(cfg.LargeBodyThreshold)
+		rec := serveCompressed(t, cfg, "image/svg+xml", large, "gzip")
+		var debug compressionDebug
+		_ = json.Unmarshal([]byte(rec.Header().Get("X-Compression-Debug")), &debug)
+		if debug.Level != cfg.LargeLevel {
+			t.Fatalf("expected level %d got %+v", cfg.LargeLevel, debug)
+		}
+	})
+
+	t.Run("Off by default", func(t *testing.T) {
+		rec := serveCompressed(t, DefaultCompressionConfig(), "image/svg+xml", body, "gzip")
+		if header := rec.Header().Get("X-Compression-Debug"); header != "" {
+			t.Fatalf("expected no debug header got %q", header)
+		}
+	})
+}
+
+func TestCompressionAdaptive(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.AdaptiveThreshold = 1
+	cfg.Debug = true
+	small := compressibleBody(4096)
+	large := compressibleBody(cfg.LargeBodyThreshold)
+
+	// The first request to /hold blocks until release is closed, keeping the in-flight count up
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := CompressionMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := small
+		switch r.URL.Path {
+		case "/hold":
+			close(entered)
+			<-release
+		case "/large":
+			body = large
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		_, _ = w.Write(body)
+	}))
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Low load keeps brotli", func(t *testing.T) {
+		rec := serve("/small", "br, gzip")
+		if got := rec.Header().Get("Content-Encoding"); got != "br" {
+			t.Fatalf("expected br got %q", got)
+		}
+		if got := serve("/large", "br, gzip").Header().Get("Content-Encoding"); got != "br" {
+			t.Fatalf("expected large bodies to be compressed got %q", got)
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve("/hold", "gzip")
+	}()
+	<-entered
+
+	t.Run("High load downgrades brotli to gzip", func(t *testing.T) {
+		rec := serve("/small", "br, gzip")
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("expected gzip got %q", got)
+		}
+		if !bytes.Equal(gunzip(t, rec.Body.Bytes()), small) {
+			t.Fatal("gzip body did not round-trip")
+		}
+		if !strings.Contains(rec.Header().Get("X-Compression-Debug"), `"under_load":true`) {
+			t.Fatalf("expected under_load in %q", rec.Header().Get("X-Compression-Debug"))
+		}
+	})
+
+	t.Run("High load skips large bodies", func(t *testing.T) {
+		rec := serve("/large", "br, gzip")
+		if got := rec.Header().Get("Content-Encoding"); got != "" {
+			t.Fatalf("expected no encoding got %q", got)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), large) {
+			t.Fatal("expected the body unchanged")
+		}
+		var debug compressionDebug
+		_ = json.Unmarshal([]byte(rec.Header().Get("X-Compression-Debug")), &debug)
+		if debug.Reason != "under load" {
+			t.Fatalf("expected reason under load got %+v", debug)
+		}
+	})
+
+	close(release)
+	<-done
+
+	t.Run("Reverts when load drops", func(t *testing.T) {
+		if got := serve("/large", "br, gzip").Header().Get("Content-Encoding"); got != "br" {
+			t.Fatalf("expected br got %q", got)
+		}
+	})
+}
+
+func TestCompressionVary(t *testing.T) {
+	body := compressibleBody(4096)
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+		handlerVary    string
+		expected       []string
+	}{
+		{"Compressed", "image/svg+xml", "gzip", "", []string{"Accept-Encoding"}},
+		{"Client accepts none", "image/svg+xml", "", "", []string{"Accept-Encoding"}},
+		{"Raster image", "image/png", "gzip", "", []string{"Accept-Encoding"}},
+		{"Handler varies on more", "image/svg+xml", "br", "Sec-CH-DPR, DPR", []string{"Accept-Encoding", "Sec-CH-DPR, DPR"}},
+		{"Handler already varies on it", "image/svg+xml", "gzip", "accept-encoding", []string{"Accept-Encoding"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressionMiddleware(DefaultCompressionConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				if tt.handlerVary != "" {
+					AddVary(w.Header(), tt.handlerVary)
+				}
+				_, _ = w.Write(body)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Values("Vary"); strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Fatalf("expected Vary %q got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompressionETag(t *testing.T) {
+	body := compressibleBody(4096)
+	// etagHandler serves body with etag and answers a matching If-None-Match with 304, like serveImage
+	etagHandler := func(etag string) http.Handler {
+		return CompressionMiddleware(DefaultCompressionConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = w.Write(body)
+		}))
+	}
+	get := func(handler http.Handler, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		etag           string // Set by the handler
+		acceptEncoding string
+		ifNoneMatch    string
+		expectedStatus int
+		expectedETag   string
+	}{
+		{"Compressed gets a coded ETag", `"abc"`, "gzip", "", http.StatusOK, `"abc-gzip"`},
+		{"Each coding its own", `"abc"`, "br", "", http.StatusOK, `"abc-br"`},
+		{"Uncompressed keeps it", `"abc"`, "", "", http.StatusOK, `"abc"`},
+		{"Weak ETag unchanged", `W/"abc"`, "gzip", "", http.StatusOK, `W/"abc"`},
+		{"Revalidates the coded ETag", `"abc"`, "gzip", `"abc-gzip"`, http.StatusNotModified, `"abc-gzip"`},
+		{"Revalidates the plain ETag", `"abc"`, "", `"abc"`, http.StatusNotModified, `"abc"`},
+		// A cached brotli copy does not validate the gzip representation
+		{"Other coding's ETag", `"abc"`, "gzip", `"abc-br"`, http.StatusOK, `"abc-gzip"`},
+		{"Stale ETag", `"abc"`, "gzip", `"old-gzip"`, http.StatusOK, `"abc-gzip"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(etagHandler(tt.etag), tt.acceptEncoding, tt.ifNoneMatch)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if etag := rec.Header().Get("ETag"); etag != tt.expectedETag {
+				t.Fatalf("expected ETag %s got %s", tt.expectedETag, etag)
+			}
+			if tt.expectedStatus == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Fatalf("expected an empty 304 got %d bytes", rec.Body.Len())
+			}
+		})
+	}
+
+	t.Run("Cached round trip", func(t *testing.T) {
+		handler := etagHandler(`"v1"`)
+		first := get(handler, "gzip, br", "")
+		if first.Header().Get("Content-Encoding") != "br" {
+			t.Fatalf("expected br got %q", first.Header().Get("Content-Encoding"))
+		}
+		second := get(handler, "gzip, br", first.Header().Get("ETag"))
+		if second.Code != http.StatusNotModified || second.Header().Get("ETag") != first.Header().Get("ETag") {
+			t.Fatalf("expected 304 confirming %s got %d %s", first.Header().Get("ETag"), second.Code, second.Header().Get("ETag"))
+		}
+	})
+}
+
+func TestCompressionStreaming(t *testing.T) {
+	// release lets the handler finish once the client has read the first chunk
+	release := make(chan struct{})
+	handler := CompressionMiddleware(DefaultCompressionConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		contentType    string
+		acceptEncoding string
+		expectedCoding string
+	}{
+		{"Compressed event stream", "text/event-stream", "gzip", "gzip"},
+		{"Brotli", "text/event-stream", "br", "br"},
+		{"Zstd", "text/event-stream", "zstd", "zstd"},
+		{"Not compressible", "application/octet-stream", "gzip", ""},
+		{"Client accepts none", "text/event-stream", "identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+"/?type="+tt.contentType, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer resp.Body.Close()
+			if coding := resp.Header.Get("Content-Encoding"); coding != tt.expectedCoding {
+				t.Fatalf("expected Content-Encoding %q got %q", tt.expectedCoding, coding)
+			}
+
+			var body io.Reader = resp.Body
+			switch tt.expectedCoding {
+			case "gzip":
+				if body, err = gzip.NewReader(resp.Body); err != nil {
+					t.Fatalf("gzip reader: %v", err)
+				}
+			case "br":
+				body = brotli.NewReader(resp.Body)
+			case "zstd":
+				dec, err := zstd.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("zstd reader: %v", err)
+				}
+				defer dec.Close()
+				body = dec
+			}
+			// The first event arrives while the handler is still blocked
+			first := make([]byte, len("data: first\n\n"))
+			if _, err := io.ReadFull(body, first); err != nil || string(first) != "data: first\n\n" {
+				t.Fatalf("expected the flushed event before the handler finished got %q, %v", first, err)
+			}
+			release <- struct{}{}
+			rest, err := io.ReadAll(body)
+			if err != nil || string(rest) != "data: second\n\n" {
+				t.Fatalf("expected the second event got %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestCompressionStreamingDebug(t *testing.T) {
+	cfg := DefaultCompressionConfig()
+	cfg.Debug = true
+	handler := CompressionMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", "5")
+		_, _ = io.WriteString(w, "hello")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush through ResponseController: %v", err)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if !rec.Flushed {
+		t.Fatal("expected the flush to reach the underlying writer")
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Fatalf("expected Content-Length dropped from the streamed response got %q", rec.Header().Get("Content-Length"))
+	}
+	// Below the minimum size, yet streamed responses are compressed regardless
+	if got := string(gunzip(t, rec.Body.Bytes())); got != "hello" {
+		t.Fatalf("expected the streamed body got %q", got)
+	}
+	var debug compressionDebug
+	if err := json.Unmarshal([]byte(rec.Header().Get("X-Compression-Debug")), &debug); err != nil {
+		t.Fatalf("decode debug header: %v", err)
+	}
+	if !debug.Streamed || !debug.Compressed || debug.Bytes != 5 {
+		t.Fatalf("expected a compressed stream of 5 buffered bytes got %+v", debug)
+	}
+}
+
+func TestCompressionStreamsLargeBodies(t *testing.T) {
+	cfg := CompressionConfig{SmallLevel: gzip.BestSpeed, LargeLevel: gzip.BestCompression, LargeBodyThreshold: 4096}
+	body := compressibleBody(16 * 1024)
+
+	tests := []struct {
+		name          string
+		contentLength bool
+		committedFrom int // Bytes written once the response must have started
+	}{
+		{"Written past the threshold", false, cfg.LargeBodyThreshold},
+		{"Declared by Content-Length", true, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler := CompressionMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/svg+xml")
+				if tt.contentLength {
+					w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+				}
+				for written := 0; written < len(body); written += 1024 {
+					_, _ = w.Write(body[written : written+1024])
+					if written+1024 >= tt.committedFrom && rec.Body.Len() == 0 {
+						t.Fatalf("expected compressed output after %d bytes, the body is still buffered", written+1024)
+					}
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			handler.ServeHTTP(rec, req)
+
+			if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" {
+				t.Fatalf("expected a gzip stream without Content-Length got %v", rec.Header())
+			}
+			if !bytes.Equal(gunzip(t, rec.Body.Bytes()), body) {
+				t.Fatal("decompressed body does not match")
+			}
+			// The gzip header's XFL byte is 2 for the best compression, the large level
+			if xfl := rec.Body.Bytes()[8]; xfl != 2 {
+				t.Fatalf("expected XFL 2 (best) got %d", xfl)
+			}
+		})
+	}
+}
+
+func TestCompressionHijackAndPush(t *testing.T) {
+	handler := CompressionMiddleware(DefaultCompressionConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if err := w.(http.Pusher).Push("/style.css", nil); err != http.ErrNotSupported {
+			t.Errorf("expected ErrNotSupported over HTTP/1.1 got %v", err)
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		_ = rw.Flush()
+	}))
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "hijacked" || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("expected the hijacked connection's raw response got %q (%q)", body, resp.Header.Get("Content-Encoding"))
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		handler := CompressionMiddleware(DefaultCompressionConfig())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				t.Error("expected an error hijacking a writer without a connection")
+			}
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	})
+}
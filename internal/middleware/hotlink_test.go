@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHotlinkMiddleware(t *testing.T) {
+	allowed := []string{"example.com", "*.partner.org"}
+
+	tests := []struct {
+		name         string
+		allowEmpty   bool
+		referer      string
+		expectedCode int
+	}{
+		{"Allowed host", true, "https://example.com/profile", http.StatusOK},
+		{"Allowed host with port", true, "http://EXAMPLE.com:8443/", http.StatusOK},
+		{"Allowed subdomain", true, "https://cdn.partner.org/page", http.StatusOK},
+		{"Wildcard excludes apex", true, "https://partner.org/page", http.StatusForbidden},
+		{"Same host", true, "https://img.grout.test/play", http.StatusOK},
+		{"Disallowed host", true, "https://leech.example.net/", http.StatusForbidden},
+		{"Lookalike host", true, "https://notexample.com/", http.StatusForbidden},
+		{"Malformed referer", true, "::not a url", http.StatusForbidden},
+		{"Empty referer allowed", true, "", http.StatusOK},
+		{"Empty referer denied", false, "", http.StatusForbidden},
+		{"Allowed host with empty referer denied", false, "https://example.com/", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := HotlinkMiddleware(HotlinkConfig{AllowedHosts: allowed, AllowEmptyReferer: tt.allowEmpty})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				_, _ = w.Write([]byte("image"))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "http://img.grout.test/avatar/JD", nil)
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if tt.expectedCode == http.StatusForbidden {
+				if rec.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(rec.Body.String(), "Hotlinking not allowed") {
+					t.Fatalf("expected the fallback image got %s: %s", rec.Header().Get("Content-Type"), rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestHotlinkMiddlewareDisabled(t *testing.T) {
+	handler := HotlinkMiddleware(HotlinkConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/avatar/JD", nil)
+	req.Header.Set("Referer", "https://leech.example.net/")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 without allowed hosts got %d", rec.Code)
+	}
+}
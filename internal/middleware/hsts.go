@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HSTSConfig controls the Strict-Transport-Security header
+type HSTSConfig struct {
+	MaxAge            int // Seconds browsers remember to use HTTPS; 0 disables the header
+	IncludeSubDomains bool
+	Preload           bool
+	// TrustProxy treats X-Forwarded-Proto: https as HTTPS, for deployments behind a
+	// TLS-terminating proxy. Only enable it when the proxy sets or strips the header.
+	TrustProxy bool
+}
+
+// headerValue builds the Strict-Transport-Security value, e.g. "max-age=31536000; includeSubDomains"
+func (c HSTSConfig) headerValue() string {
+	value := "max-age=" + strconv.Itoa(c.MaxAge)
+	if c.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if c.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// HSTSMiddleware adds Strict-Transport-Security to responses for HTTPS requests.
+// Browsers ignore the header over plain HTTP, so it is never sent there.
+func HSTSMiddleware(cfg HSTSConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.MaxAge <= 0 {
+			return next
+		}
+		value := cfg.headerValue()
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isHTTPS(r, cfg.TrustProxy) {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isHTTPS reports whether the request reached the client-facing listener over TLS.
+// Behind a trusted proxy, the first X-Forwarded-Proto entry is the client's scheme.
+func isHTTPS(r *http.Request, trustProxy bool) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !trustProxy {
+		return false
+	}
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHSTSMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       HSTSConfig
+		tls       bool
+		forwarded string
+		expected  string
+	}{
+		{"TLS", HSTSConfig{MaxAge: 31536000}, true, "", "max-age=31536000"},
+		{"TLS with directives", HSTSConfig{MaxAge: 63072000, IncludeSubDomains: true, Preload: true}, true, "", "max-age=63072000; includeSubDomains; preload"},
+		{"Plain HTTP", HSTSConfig{MaxAge: 31536000}, false, "", ""},
+		{"Untrusted forwarded proto", HSTSConfig{MaxAge: 31536000}, false, "https", ""},
+		{"Trusted forwarded proto", HSTSConfig{MaxAge: 31536000, TrustProxy: true}, false, "https", "max-age=31536000"},
+		{"Trusted forwarded proto chain", HSTSConfig{MaxAge: 31536000, TrustProxy: true}, false, "HTTPS, http", "max-age=31536000"},
+		{"Trusted plain HTTP proxy", HSTSConfig{MaxAge: 31536000, TrustProxy: true}, false, "http", ""},
+		{"Disabled", HSTSConfig{MaxAge: 0}, true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := HSTSMiddleware(tt.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Strict-Transport-Security"); got != tt.expected {
+				t.Fatalf("expected %q got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -107,13 +109,24 @@ func getIP(r *http.Request) string {
 	return ip
 }
 
-// Middleware creates an HTTP middleware that applies rate limiting
+// ClientIP returns the client IP the rate limiter keys its buckets by, for handlers that
+// scope other per-client state the same way
+func ClientIP(r *http.Request) string {
+	return getIP(r)
+}
+
+// Middleware creates an HTTP middleware that applies rate limiting.
+// Rejected requests get 429 with a Retry-After of the whole seconds until the client's
+// bucket holds a token again.
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip := getIP(r)
 		limiter := rl.getLimiter(ip)
 
-		if !limiter.Allow() {
+		if wait, ok := reserveToken(limiter, time.Now()); !ok {
+			if wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			}
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
@@ -121,3 +134,25 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// reserveToken takes a token when one is available at now. Otherwise it reports how long
+// until the next token, without consuming it, or 0 when the bucket never refills.
+func reserveToken(limiter *rate.Limiter, now time.Time) (time.Duration, bool) {
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return 0, false
+	}
+	wait := reservation.DelayFrom(now)
+	if wait == 0 {
+		return 0, true
+	}
+	// Give the token back so rejected requests do not push the next one further out
+	reservation.CancelAt(now)
+	return wait, false
+}
+
+// retryAfterSeconds rounds wait up to whole seconds, as Retry-After requires, so clients
+// that honor it are not rejected again
+func retryAfterSeconds(wait time.Duration) int {
+	return max(int(math.Ceil(wait.Seconds())), 1)
+}
@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 func TestRateLimiterAllow(t *testing.T) {
@@ -324,3 +326,89 @@ func TestGetIP(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rpm      int
+		expected string
+	}{
+		{"One per second", 60, "1"},
+		{"One per 10 seconds", 6, "10"},
+		{"One per 15 seconds", 4, "15"},
+		{"One per minute", 1, "60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewRateLimiter(tt.rpm, 1)
+			handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			send := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.RemoteAddr = "192.168.1.1:1234"
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			if rec := send(); rec.Code != http.StatusOK || rec.Header().Get("Retry-After") != "" {
+				t.Fatalf("expected 200 without Retry-After got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+			}
+			// Repeated rejections report the same wait instead of queueing further tokens
+			for range 3 {
+				rec := send()
+				if rec.Code != http.StatusTooManyRequests {
+					t.Fatalf("expected 429 got %d", rec.Code)
+				}
+				if got := rec.Header().Get("Retry-After"); got != tt.expected {
+					t.Fatalf("expected Retry-After %s got %q", tt.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReserveToken(t *testing.T) {
+	// One token every 10 seconds with a burst of one
+	limiter := rate.NewLimiter(rate.Every(10*time.Second), 1)
+	start := time.Now()
+
+	if _, ok := reserveToken(limiter, start); !ok {
+		t.Fatalf("expected the first token to be available")
+	}
+	tests := []struct {
+		name     string
+		after    time.Duration
+		expected time.Duration
+	}{
+		{"Just emptied", 0, 10 * time.Second},
+		{"Partly refilled", 4 * time.Second, 6 * time.Second},
+		{"Nearly refilled", 9500 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, ok := reserveToken(limiter, start.Add(tt.after))
+			if ok {
+				t.Fatalf("expected no token")
+			}
+			// The limiter works in float seconds, so allow for rounding
+			if diff := wait - tt.expected; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Fatalf("expected wait %v got %v", tt.expected, wait)
+			}
+		})
+	}
+	if _, ok := reserveToken(limiter, start.Add(10*time.Second)); !ok {
+		t.Fatalf("expected a token once the bucket refilled")
+	}
+	if got := retryAfterSeconds(500 * time.Millisecond); got != 1 {
+		t.Fatalf("expected sub-second waits to round up to 1 got %d", got)
+	}
+
+	// A limiter that never refills cannot name a retry time
+	empty := rate.NewLimiter(0, 0)
+	if wait, ok := reserveToken(empty, start); ok || wait != 0 {
+		t.Fatalf("expected no token and no wait got %v %t", wait, ok)
+	}
+}
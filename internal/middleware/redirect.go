@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"grout/internal/config"
+)
+
+// redirectCaptureRegex matches a {param} capture in a redirect pattern or template
+var redirectCaptureRegex = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// redirectRule is a compiled legacy path pattern and the template it redirects to
+type redirectRule struct {
+	pattern *regexp.Regexp
+	to      string
+}
+
+// Redirector answers requests for legacy paths with a 301 to their new location
+type Redirector struct {
+	rules []redirectRule
+}
+
+// NewRedirector compiles the redirect table. Each {param} in From matches one path segment
+// and every {param} used in To must be captured by From.
+func NewRedirector(rules []config.RedirectRule) (*Redirector, error) {
+	rd := &Redirector{}
+	for _, rule := range rules {
+		captured := make(map[string]bool)
+		var pattern strings.Builder
+		pattern.WriteString("^")
+		last := 0
+		for _, loc := range redirectCaptureRegex.FindAllStringSubmatchIndex(rule.From, -1) {
+			name := rule.From[loc[2]:loc[3]]
+			if captured[name] {
+				return nil, fmt.Errorf("redirect %q: duplicate capture {%s}", rule.From, name)
+			}
+			captured[name] = true
+			pattern.WriteString(regexp.QuoteMeta(rule.From[last:loc[0]]))
+			pattern.WriteString("(?P<" + name + ">[^/]+)")
+			last = loc[1]
+		}
+		pattern.WriteString(regexp.QuoteMeta(rule.From[last:]))
+		pattern.WriteString("$")
+
+		for _, m := range redirectCaptureRegex.FindAllStringSubmatch(rule.To, -1) {
+			if !captured[m[1]] {
+				return nil, fmt.Errorf("redirect %q: target uses unknown capture {%s}", rule.From, m[1])
+			}
+		}
+
+		re, err := regexp.Compile(pattern.String())
+		if err != nil {
+			return nil, fmt.Errorf("redirect %q: %w", rule.From, err)
+		}
+		rd.rules = append(rd.rules, redirectRule{pattern: re, to: rule.To})
+	}
+	return rd, nil
+}
+
+// Middleware redirects matching paths before they reach the router; other requests pass through
+func (rd *Redirector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := rd.target(r.URL); ok {
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// target returns the redirect location for u, carrying over the original query string.
+// The first matching rule wins.
+func (rd *Redirector) target(u *url.URL) (string, bool) {
+	for _, rule := range rd.rules {
+		m := rule.pattern.FindStringSubmatch(u.Path)
+		if m == nil {
+			continue
+		}
+
+		path, query, hasQuery := strings.Cut(rule.to, "?")
+		substitute := func(template string, escape func(string) string) string {
+			return redirectCaptureRegex.ReplaceAllStringFunc(template, func(capture string) string {
+				name := capture[1 : len(capture)-1]
+				return escape(m[rule.pattern.SubexpIndex(name)])
+			})
+		}
+
+		location := substitute(path, url.PathEscape)
+		if hasQuery {
+			query = substitute(query, url.QueryEscape)
+		}
+		if u.RawQuery != "" {
+			if query != "" {
+				query += "&"
+			}
+			query += u.RawQuery
+		}
+		if query != "" {
+			location += "?" + query
+		}
+		return location, true
+	}
+	return "", false
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"grout/internal/config"
+)
+
+func TestRedirector(t *testing.T) {
+	rd, err := NewRedirector([]config.RedirectRule{
+		{From: "/u/{name}/{size}.png", To: "/avatar/{name}.png?size={size}"},
+		{From: "/legacy/{name}", To: "/avatar/{name}"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handler := rd.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"Captures substituted", "/u/Jane%20Doe/64.png", http.StatusMovedPermanently, "/avatar/Jane%20Doe.png?size=64"},
+		{"Original query kept", "/legacy/jd?rounded=true", http.StatusMovedPermanently, "/avatar/jd?rounded=true"},
+		{"Capture spans one segment only", "/legacy/a/b", http.StatusOK, ""},
+		{"Non-matching path passes through", "/avatar/jd", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected %d got %d", tt.expectedStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.expectedLocation {
+				t.Fatalf("expected Location %q got %q", tt.expectedLocation, loc)
+			}
+		})
+	}
+}
+
+func TestNewRedirectorInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.RedirectRule
+	}{
+		{"Unknown capture in target", config.RedirectRule{From: "/u/{name}", To: "/avatar/{id}"}},
+		{"Duplicate capture", config.RedirectRule{From: "/u/{name}/{name}", To: "/avatar/{name}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRedirector([]config.RedirectRule{tt.rule}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
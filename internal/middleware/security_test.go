@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveWithSecurityHeaders(scope SecurityHeadersScope, contentType, csp string) *httptest.ResponseRecorder {
+	handler := SecurityHeadersMiddleware(scope, DefaultSecurityHeaders())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if csp != "" {
+			w.Header().Set("Content-Security-Policy", csp)
+		}
+		_, _ = w.Write([]byte("body"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/play", nil))
+	return rec
+}
+
+func TestSecurityHeadersOnPages(t *testing.T) {
+	for _, contentType := range []string{"text/html; charset=utf-8", "application/json"} {
+		t.Run(contentType, func(t *testing.T) {
+			rec := serveWithSecurityHeaders(SecurityHeadersPages, contentType, "")
+			for name, value := range DefaultSecurityHeaders() {
+				if got := rec.Header().Get(name); got != value {
+					t.Errorf("expected %s %q got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersKeepHandlerCSP(t *testing.T) {
+	pageCSP := "default-src 'self'; script-src 'self' 'unsafe-inline'"
+	rec := serveWithSecurityHeaders(SecurityHeadersPages, "text/html", pageCSP)
+
+	if got := rec.Header().Get("Content-Security-Policy"); got != pageCSP {
+		t.Fatalf("expected handler CSP %q got %q", pageCSP, got)
+	}
+	if rec.Header().Get("Referrer-Policy") == "" {
+		t.Fatal("expected Referrer-Policy to be added")
+	}
+}
+
+func TestSecurityHeadersOnImages(t *testing.T) {
+	t.Run("Pages scope only adds nosniff", func(t *testing.T) {
+		rec := serveWithSecurityHeaders(SecurityHeadersPages, "image/svg+xml", "")
+		if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Fatalf("expected nosniff got %q", got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "image/svg+xml" {
+			t.Fatalf("expected SVG content type to be kept, got %q", got)
+		}
+		for _, name := range []string{"Content-Security-Policy", "X-Frame-Options", "Referrer-Policy"} {
+			if got := rec.Header().Get(name); got != "" {
+				t.Errorf("did not expect %s on image, got %q", name, got)
+			}
+		}
+	})
+
+	t.Run("All scope covers images", func(t *testing.T) {
+		rec := serveWithSecurityHeaders(SecurityHeadersAll, "image/png", "")
+		if rec.Header().Get("X-Frame-Options") != "DENY" {
+			t.Fatal("expected X-Frame-Options on image")
+		}
+	})
+
+	t.Run("Off adds nothing", func(t *testing.T) {
+		rec := serveWithSecurityHeaders(SecurityHeadersOff, "text/html", "")
+		if got := rec.Header().Get("X-Content-Type-Options"); got != "" {
+			t.Fatalf("expected no headers got nosniff %q", got)
+		}
+	})
+}
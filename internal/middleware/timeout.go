@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// timeoutMessage is the body of the 503 sent when a request runs out of time
+const timeoutMessage = "request timed out"
+
+// TimeoutMiddleware answers requests that take longer than timeout, or the timeout of the
+// longest matching path prefix in routes, with 503. The handler's context is cancelled at
+// the deadline. Unlike http.TimeoutHandler the response is not buffered: writes, Flush and
+// Hijack go straight to the wrapped writer, so streaming handlers keep streaming. A handler
+// that has already started its response when time runs out has its connection aborted
+// instead. A timeout of 0 or less disables it.
+func TimeoutMiddleware(timeout time.Duration, routes map[string]time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			routeTimeout := routeSetting(r.URL.Path, timeout, routes)
+			if routeTimeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			serveWithTimeout(w, r, next, routeTimeout)
+		})
+	}
+}
+
+func serveWithTimeout(w http.ResponseWriter, r *http.Request, next http.Handler, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{w: w, header: make(http.Header), ctx: ctx}
+	done := make(chan struct{})
+	panicChan := make(chan any, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		next.ServeHTTP(tw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		switch {
+		case tw.wroteHeader || tw.hijacked:
+		case tw.timedOut:
+			// Its writes were refused at the deadline, which it returned on
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(timeoutMessage))
+		default:
+			// A handler that only set headers, e.g. for HEAD, still gets them sent
+			tw.writeHeaderLocked(http.StatusOK)
+		}
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		select {
+		case <-done:
+			if !tw.timedOut && tw.wroteHeader {
+				// Finished its response just as time ran out
+				return
+			}
+		default:
+		}
+		tw.timedOut = true
+		switch {
+		case tw.hijacked:
+			// The handler owns the connection now
+		case tw.wroteHeader:
+			// The status is out, so a 503 is no longer possible; abort rather than let the
+			// truncated body pass for a complete response
+			panic(http.ErrAbortHandler)
+		case ctx.Err() == context.DeadlineExceeded:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(timeoutMessage))
+		}
+	}
+}
+
+// timeoutWriter passes the handler's response through to w until the deadline. Headers are
+// kept apart until the status is written, so the 503 never races the handler's headers.
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	ctx    context.Context
+
+	mu          sync.Mutex
+	wroteHeader bool
+	hijacked    bool
+	timedOut    bool
+}
+
+// expiredLocked reports whether the deadline has passed, latching timedOut so the handler
+// cannot slip a write in before the middleware reacts
+func (tw *timeoutWriter) expiredLocked() bool {
+	if !tw.timedOut && tw.ctx.Err() == context.DeadlineExceeded {
+		tw.timedOut = true
+	}
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	// Informational responses go out as they are, the final status still follows
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		tw.wroteHeader = true
+	}
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.w.Write(p)
+}
+
+// Flush sends the status, if not yet written, and flushes the wrapped writer
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	_ = http.NewResponseController(tw.w).Flush()
+}
+
+// Hijack hands the connection to the handler; the middleware writes nothing afterwards
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, rw, err := http.NewResponseController(tw.w).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("timeout: hijack: %w", err)
+	}
+	tw.hijacked = true
+	return conn, rw, nil
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	const work = 100 * time.Millisecond
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(work):
+			_, _ = w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	})
+	routes := map[string]time.Duration{"/batch": 2 * time.Second, "/placeholder": 0}
+	handler := TimeoutMiddleware(20*time.Millisecond, routes)(slow)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode int
+	}{
+		{"Avatar uses the default", http.MethodGet, "/avatar/Jane", http.StatusServiceUnavailable},
+		{"Batch gets its longer timeout", http.MethodPost, "/batch", http.StatusOK},
+		{"Sprite inherits the batch prefix", http.MethodPost, "/batch/sprite", http.StatusOK},
+		{"Zero disables the timeout", http.MethodGet, "/placeholder/300x200", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedCode {
+				t.Fatalf("expected %d got %d", tt.expectedCode, rec.Code)
+			}
+			if tt.expectedCode == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), timeoutMessage) {
+				t.Fatalf("expected %q in %q", timeoutMessage, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTimeoutMiddlewareDisabled(t *testing.T) {
+	handler := TimeoutMiddleware(0, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("expected no deadline when timeouts are disabled")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/avatar/Jane", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 got %d", rec.Code)
+	}
+}
+
+func TestTimeoutMiddlewareHeaderOnly(t *testing.T) {
+	handler := TimeoutMiddleware(time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Content-Length", "42")
+		w.Header().Set("ETag", `"abc"`)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/avatar/Jane", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d", rec.Code)
+	}
+	for field, want := range map[string]string{"Content-Type": "image/svg+xml", "Content-Length": "42", "ETag": `"abc"`} {
+		if got := rec.Header().Get(field); got != want {
+			t.Errorf("expected %s %q got %q", field, want, got)
+		}
+	}
+}
+
+func TestTimeoutMiddlewareStreams(t *testing.T) {
+	flushed := make(chan struct{})
+	release := make(chan struct{})
+	handler := TimeoutMiddleware(5*time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Streamed", "1")
+		_, _ = w.Write([]byte("first"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+		}
+		close(flushed)
+		<-release
+		_, _ = w.Write([]byte("second"))
+	}))
+	rec := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+		close(served)
+	}()
+
+	<-flushed
+	// The recorder is only read once the handler is blocked, after its flush
+	if !rec.Flushed || rec.Body.String() != "first" || rec.Header().Get("X-Streamed") != "1" {
+		t.Fatalf("expected the first write flushed through, got %q (flushed %t)", rec.Body.String(), rec.Flushed)
+	}
+	close(release)
+	<-served
+	if rec.Body.String() != "firstsecond" {
+		t.Fatalf("expected the whole body got %q", rec.Body.String())
+	}
+}
+
+func TestTimeoutMiddlewareAbortsStartedResponse(t *testing.T) {
+	handler := TimeoutMiddleware(20*time.Millisecond, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		<-r.Context().Done()
+		if _, err := w.Write([]byte("late")); err == nil {
+			t.Error("expected writes after the deadline to fail")
+		}
+	}))
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Fatalf("expected the response to be aborted, got %v", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream", nil))
+}
+
+func TestTimeoutMiddlewarePropagatesPanics(t *testing.T) {
+	handler := TimeoutMiddleware(time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("expected the handler's panic, got %v", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/avatar/Jane", nil))
+}
@@ -0,0 +1,107 @@
+package render
+
+import (
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// Corner is the corner of the background shape a badge is drawn at
+type Corner string
+
+const (
+	CornerBottomRight Corner = "bottom-right"
+	CornerBottomLeft  Corner = "bottom-left"
+	CornerTopRight    Corner = "top-right"
+	CornerTopLeft     Corner = "top-left"
+)
+
+// ParseCorner converts a query value into a Corner; empty means CornerBottomRight.
+func ParseCorner(s string) (Corner, bool) {
+	switch Corner(strings.ToLower(s)) {
+	case "", CornerBottomRight:
+		return CornerBottomRight, true
+	case CornerBottomLeft:
+		return CornerBottomLeft, true
+	case CornerTopRight:
+		return CornerTopRight, true
+	case CornerTopLeft:
+		return CornerTopLeft, true
+	default:
+		return CornerBottomRight, false
+	}
+}
+
+// badgeStatusColors are the presence colors used by chat UIs
+var badgeStatusColors = map[string]string{
+	"online":  "2ecc71",
+	"offline": "95a5a6",
+	"busy":    "e74c3c",
+	"away":    "f1c40f",
+}
+
+// BadgeStatusColor returns the dot color for a presence status such as "online"
+func BadgeStatusColor(status string) (string, bool) {
+	c, ok := badgeStatusColors[strings.ToLower(status)]
+	return c, ok
+}
+
+// badgeLayout is the placement of the status dot; ring is the width of the contrasting
+// outline around it, so the dot covers radius+ring from its center
+type badgeLayout struct {
+	cx, cy, radius, ring float64
+}
+
+// badgeFor places the dot at opts.BadgeCorner of the background shape's bounds, with the
+// ring touching both edges. The dot is an eighth of the smaller dimension across the
+// radius, which puts its center close to the outline of a circle or fully rounded corner.
+func badgeFor(opts Options) badgeLayout {
+	w, h := float64(opts.Width), float64(opts.Height)
+	minDim := math.Min(w, h)
+	radius := minDim * 0.125
+	ring := math.Max(1, round2(radius*0.25))
+
+	// A circle is centered in non-square images
+	left, top, right, bottom := 0.0, 0.0, w, h
+	if opts.Shape == ShapeCircle {
+		left, top = (w-minDim)/2, (h-minDim)/2
+		right, bottom = left+minDim, top+minDim
+	}
+	inset := radius + ring
+
+	cx, cy := right-inset, bottom-inset
+	switch opts.BadgeCorner {
+	case CornerBottomLeft:
+		cx = left + inset
+	case CornerTopRight:
+		cy = top + inset
+	case CornerTopLeft:
+		cx, cy = left+inset, top+inset
+	}
+	return badgeLayout{cx: round2(cx), cy: round2(cy), radius: round2(radius), ring: ring}
+}
+
+// badgeRingColor contrasts with the avatar background so the dot stands out from it
+func badgeRingColor(opts Options) string {
+	return GetContrastColor(opts.Background)
+}
+
+// writeSVGBadge draws the status dot with its ring on top of everything else
+func writeSVGBadge(sw *svgWriter, opts Options) {
+	b := badgeFor(opts)
+	sw.printf(`<circle cx="%g" cy="%g" r="%g" fill="#%s" stroke="#%s" stroke-width="%g" />`,
+		b.cx, b.cy, b.radius+b.ring/2, opts.BadgeColor, badgeRingColor(opts), b.ring)
+	sw.writeString("\n")
+}
+
+// drawBadge draws the status dot with its ring on top of everything else
+func drawBadge(dc *gg.Context, opts Options) {
+	b := badgeFor(opts)
+	dc.DrawCircle(b.cx, b.cy, b.radius+b.ring)
+	dc.SetColor(ParseHexColor(badgeRingColor(opts)))
+	dc.Fill()
+	dc.DrawCircle(b.cx, b.cy, b.radius)
+	dc.SetColor(ParseHexColor(opts.BadgeColor))
+	dc.Fill()
+}
@@ -0,0 +1,119 @@
+package render
+
+import (
+	"image"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// MaxBlur caps the blur standard deviation, in pixels
+const MaxBlur = 50.0
+
+// writeSVGBlurStart opens the blurred background layer: a Gaussian blur filter whose
+// result is clipped to the background shape. Edge pixels are repeated so the blurred
+// layer does not fade out at the image border. Close it with writeSVGBlurEnd.
+func writeSVGBlurStart(sw *svgWriter, opts Options) {
+	sw.printf(`<defs><filter id="%s" x="0" y="0" width="100%%" height="100%%"><feGaussianBlur stdDeviation="%g" edgeMode="duplicate" /></filter>`, sw.id("bg-blur"), round2(opts.Blur))
+	sw.printf(`<clipPath id="%s">`, sw.id("bg-clip"))
+	writeSVGShape(sw, opts, "#000")
+	sw.writeString(`</clipPath></defs>`)
+	sw.writeString("\n")
+	sw.printf(`<g clip-path="url(#%s)"><g filter="url(#%s)">`, sw.id("bg-clip"), sw.id("bg-blur"))
+	sw.writeString("\n")
+}
+
+// writeSVGBlurEnd closes the groups opened by writeSVGBlurStart
+func writeSVGBlurEnd(sw *svgWriter) {
+	sw.writeString("</g></g>\n")
+}
+
+// drawBlurredBackground draws the background and tiles as a full-size square layer,
+// blurs it and paints the result clipped to the background shape
+func (r *Renderer) drawBlurredBackground(dc *gg.Context, opts Options) {
+	layerOpts := opts
+	layerOpts.Shape = ShapeSquare
+	layer := gg.NewContext(opts.Width, opts.Height)
+	r.drawBackground(layer, layerOpts)
+
+	blurred := gaussianBlur(layer.Image().(*image.RGBA), opts.Blur)
+	traceShape(dc, opts)
+	dc.Clip()
+	dc.DrawImage(blurred, 0, 0)
+	dc.ResetClip()
+}
+
+// gaussianBlur approximates a Gaussian blur with three box blurs, which costs the same
+// for any sigma. Pixels outside the image repeat the nearest edge pixel.
+func gaussianBlur(src *image.RGBA, sigma float64) *image.RGBA {
+	img := image.NewRGBA(src.Bounds())
+	copy(img.Pix, src.Pix)
+	tmp := image.NewRGBA(src.Bounds())
+	for _, size := range boxSizesForGaussian(sigma, 3) {
+		radius := (size - 1) / 2
+		boxBlur(img, tmp, radius, true)
+		boxBlur(tmp, img, radius, false)
+	}
+	return img
+}
+
+// boxSizesForGaussian returns n odd box widths whose successive box blurs approximate
+// a Gaussian with the given standard deviation
+func boxSizesForGaussian(sigma float64, n int) []int {
+	ideal := math.Sqrt(12*sigma*sigma/float64(n) + 1)
+	lower := int(math.Floor(ideal))
+	if lower%2 == 0 {
+		lower--
+	}
+	upper := lower + 2
+	m := int(math.Round((12*sigma*sigma - float64(n*lower*lower) - float64(4*n*lower) - float64(3*n)) / float64(-4*lower-4)))
+
+	sizes := make([]int, n)
+	for i := range sizes {
+		if i < m {
+			sizes[i] = lower
+		} else {
+			sizes[i] = upper
+		}
+	}
+	return sizes
+}
+
+// boxBlur averages every pixel of src with its radius neighbours along one axis into dst,
+// using a running sum so the cost does not depend on the radius
+func boxBlur(src, dst *image.RGBA, radius int, horizontal bool) {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lines, length := h, w
+	if !horizontal {
+		lines, length = w, h
+	}
+	offset := func(line, i int) int {
+		i = min(max(i, 0), length-1)
+		if horizontal {
+			return line*src.Stride + i*4
+		}
+		return i*src.Stride + line*4
+	}
+	window := 2*radius + 1
+
+	for line := 0; line < lines; line++ {
+		var sum [4]int
+		for i := -radius; i <= radius; i++ {
+			o := offset(line, i)
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[o+c])
+			}
+		}
+		for i := 0; i < length; i++ {
+			o := offset(line, i)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8((sum[c] + window/2) / window)
+			}
+			out, in := offset(line, i-radius), offset(line, i+radius+1)
+			for c := 0; c < 4; c++ {
+				sum[c] += int(src.Pix[in+c]) - int(src.Pix[out+c])
+			}
+		}
+	}
+}
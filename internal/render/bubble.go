@@ -0,0 +1,182 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// Tail is the side of the image a ShapeBubble's tail points to
+type Tail string
+
+const (
+	TailBottom Tail = "bottom"
+	TailTop    Tail = "top"
+	TailLeft   Tail = "left"
+	TailRight  Tail = "right"
+)
+
+// ParseTail converts a query value into a Tail; empty means TailBottom.
+func ParseTail(s string) (Tail, bool) {
+	switch t := Tail(strings.ToLower(s)); t {
+	case "":
+		return TailBottom, true
+	case TailBottom, TailTop, TailLeft, TailRight:
+		return t, true
+	default:
+		return TailBottom, false
+	}
+}
+
+// Bubble proportions, as shares of the smaller image dimension
+const (
+	bubbleTailLength = 0.16
+	bubbleTailWidth  = 0.24
+	bubbleRadius     = 0.16
+)
+
+// bubbleLayout is a speech bubble: a rounded body inset from the image by the tail length
+// on the tail's side, and a triangular tail whose tip touches the middle of that side
+type bubbleLayout struct {
+	x, y, w, h float64
+	radius     float64
+	tail       Tail
+	length     float64
+	width      float64
+}
+
+// bubbleFor sizes the bubble of opts relative to its smaller dimension
+func bubbleFor(opts Options) bubbleLayout {
+	s := float64(min(opts.Width, opts.Height))
+	b := bubbleLayout{
+		w:      float64(opts.Width),
+		h:      float64(opts.Height),
+		radius: round2(s * bubbleRadius),
+		tail:   opts.Tail,
+		length: math.Round(s * bubbleTailLength),
+		width:  math.Round(s * bubbleTailWidth),
+	}
+	switch b.tail {
+	case TailTop:
+		b.y, b.h = b.length, b.h-b.length
+	case TailLeft:
+		b.x, b.w = b.length, b.w-b.length
+	case TailRight:
+		b.w -= b.length
+	default:
+		b.tail = TailBottom
+		b.h -= b.length
+	}
+	return b
+}
+
+// pathOp is one step of an outline: M, L or Q (one control point) to the last point, or Z
+type pathOp struct {
+	cmd byte
+	pts []float64
+}
+
+// outline walks the bubble clockwise from the top-left corner, splicing the tail into its
+// side. Corners are quadratic curves so SVG and raster trace exactly the same shape.
+func (b bubbleLayout) outline() []pathOp {
+	x0, y0, x1, y1 := b.x, b.y, b.x+b.w, b.y+b.h
+	cx, cy, half, r := b.x+b.w/2, b.y+b.h/2, b.width/2, b.radius
+	ops := []pathOp{{'M', []float64{x0 + r, y0}}}
+	tail := func(side Tail, pts ...float64) {
+		if b.tail == side {
+			for i := 0; i < len(pts); i += 2 {
+				ops = append(ops, pathOp{'L', pts[i : i+2]})
+			}
+		}
+	}
+	tail(TailTop, cx-half, y0, cx, y0-b.length, cx+half, y0)
+	ops = append(ops, pathOp{'L', []float64{x1 - r, y0}}, pathOp{'Q', []float64{x1, y0, x1, y0 + r}})
+	tail(TailRight, x1, cy-half, x1+b.length, cy, x1, cy+half)
+	ops = append(ops, pathOp{'L', []float64{x1, y1 - r}}, pathOp{'Q', []float64{x1, y1, x1 - r, y1}})
+	tail(TailBottom, cx+half, y1, cx, y1+b.length, cx-half, y1)
+	ops = append(ops, pathOp{'L', []float64{x0 + r, y1}}, pathOp{'Q', []float64{x0, y1, x0, y1 - r}})
+	tail(TailLeft, x0, cy+half, x0-b.length, cy, x0, cy-half)
+	ops = append(ops, pathOp{'L', []float64{x0, y0 + r}}, pathOp{'Q', []float64{x0, y0, x0 + r, y0}}, pathOp{'Z', nil})
+	return ops
+}
+
+// svgPath returns the outline as SVG path data
+func (b bubbleLayout) svgPath() string {
+	var sb strings.Builder
+	for _, op := range b.outline() {
+		sb.WriteByte(op.cmd)
+		for i, v := range op.pts {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			fmt.Fprintf(&sb, "%g", round2(v))
+		}
+	}
+	return sb.String()
+}
+
+// trace adds the outline to dc's current path
+func (b bubbleLayout) trace(dc *gg.Context) {
+	for _, op := range b.outline() {
+		p := op.pts
+		switch op.cmd {
+		case 'M':
+			dc.MoveTo(p[0], p[1])
+		case 'L':
+			dc.LineTo(p[0], p[1])
+		case 'Q':
+			dc.QuadraticTo(p[0], p[1], p[2], p[3])
+		case 'Z':
+			dc.ClosePath()
+		}
+	}
+}
+
+// bubbleContent returns opts sized to the bubble's body, so the text is centered away from
+// the tail; other shapes are returned unchanged
+func bubbleContent(opts Options) Options {
+	if opts.Shape != ShapeBubble {
+		return opts
+	}
+	b := bubbleFor(opts)
+	opts.Width, opts.Height = int(b.w), int(b.h)
+	return opts
+}
+
+// writeSVGBubbleContentStart clips what follows to the bubble and moves it onto the body.
+// Close it with writeSVGBubbleContentEnd.
+func writeSVGBubbleContentStart(sw *svgWriter, opts Options) {
+	b := bubbleFor(opts)
+	sw.printf(`<defs><clipPath id="%s"><path d="%s" /></clipPath></defs>`, sw.id("bubble-clip"), b.svgPath())
+	sw.printf(`<g clip-path="url(#%s)">`, sw.id("bubble-clip"))
+	if b.x != 0 || b.y != 0 {
+		sw.printf(`<g transform="translate(%g %g)">`, b.x, b.y)
+	}
+	sw.writeString("\n")
+}
+
+// writeSVGBubbleContentEnd closes the groups opened by writeSVGBubbleContentStart
+func writeSVGBubbleContentEnd(sw *svgWriter, opts Options) {
+	if b := bubbleFor(opts); b.x != 0 || b.y != 0 {
+		sw.writeString("</g>")
+	}
+	sw.writeString("</g>\n")
+}
+
+// withBubbleContent runs draw clipped to the bubble with the origin on its body, matching
+// writeSVGBubbleContentStart; other shapes run draw as is
+func withBubbleContent(dc *gg.Context, opts Options, draw func()) {
+	if opts.Shape != ShapeBubble {
+		draw()
+		return
+	}
+	b := bubbleFor(opts)
+	dc.Push()
+	defer dc.Pop()
+	b.trace(dc)
+	dc.Clip()
+	dc.Translate(b.x, b.y)
+	draw()
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Caps selects the capitalization of avatar text
+type Caps string
+
+const (
+	CapsNormal Caps = ""      // Text as given; initials are already capitals
+	CapsSmall  Caps = "small" // All letters as small capitals, through the font's smcp feature
+	CapsAll    Caps = "all"   // All letters as capitals, e.g. for a wordmark
+)
+
+// ParseCaps converts a query value into a Caps; empty and "normal" mean CapsNormal.
+func ParseCaps(s string) (Caps, bool) {
+	switch c := Caps(strings.ToLower(s)); c {
+	case "", "normal":
+		return CapsNormal, true
+	case CapsSmall, CapsAll:
+		return c, true
+	default:
+		return CapsNormal, false
+	}
+}
+
+// smallCapsGlyphs maps text to the small capital glyphs of f: every letter is lowercased and
+// replaced through subs, the font's smcp substitutions. Characters without a small capital
+// keep their own glyph.
+func smallCapsGlyphs(f *truetype.Font, subs glyphSubstitutions, text string) []truetype.Index {
+	var glyphs []truetype.Index
+	for _, r := range text {
+		glyph := f.Index(r)
+		for _, lower := range strings.ToLower(string(r)) {
+			if sub, ok := subs[f.Index(lower)]; ok {
+				glyph = sub
+			}
+		}
+		glyphs = append(glyphs, glyph)
+	}
+	return glyphs
+}
+
+// glyphsWidth is the advance of glyphs at size, with spacing pixels between each pair
+func glyphsWidth(f *truetype.Font, size float64, glyphs []truetype.Index, spacing float64) float64 {
+	scale := fixed.Int26_6(size * 64)
+	width := spacing * float64(len(glyphs)-1)
+	for _, glyph := range glyphs {
+		width += float64(f.HMetric(scale, glyph).AdvanceWidth) / 64
+	}
+	return width
+}
+
+// drawGlyphsAnchored fills glyphs of f at size like dc.DrawStringAnchored, for glyphs that
+// have no character of their own and so cannot go through dc's font face. spacing pixels
+// are added between each pair of advance widths.
+func drawGlyphsAnchored(dc *gg.Context, f *truetype.Font, size float64, glyphs []truetype.Index, x, y, ax, ay, spacing float64) {
+	scale := fixed.Int26_6(size * 64)
+	x -= ax * glyphsWidth(f, size, glyphs, spacing)
+	y += ay * dc.FontHeight()
+
+	var buf truetype.GlyphBuf
+	for _, glyph := range glyphs {
+		if err := buf.Load(f, scale, glyph, font.HintingNone); err == nil {
+			start := 0
+			for _, end := range buf.Ends {
+				traceContour(dc, buf.Points[start:end], x, y)
+				start = end
+			}
+		}
+		x += float64(f.HMetric(scale, glyph).AdvanceWidth)/64 + spacing
+	}
+	dc.Fill()
+}
+
+// traceContour adds one quadratic TrueType contour to dc's path with its origin at (x, y).
+// Two off-curve points in a row imply an on-curve point halfway between them.
+func traceContour(dc *gg.Context, ps []truetype.Point, x, y float64) {
+	if len(ps) == 0 {
+		return
+	}
+	at := func(p truetype.Point) (float64, float64) {
+		// Glyph coordinates grow upwards
+		return x + float64(p.X)/64, y - float64(p.Y)/64
+	}
+	mid := func(a, b truetype.Point) truetype.Point {
+		return truetype.Point{X: (a.X + b.X) / 2, Y: (a.Y + b.Y) / 2, Flags: 1}
+	}
+	onCurve := func(p truetype.Point) bool { return p.Flags&1 != 0 }
+
+	start := ps[0]
+	switch last := ps[len(ps)-1]; {
+	case onCurve(start):
+		ps = ps[1:]
+	case onCurve(last):
+		start, ps = last, ps[:len(ps)-1]
+	default:
+		start = mid(start, last)
+	}
+	dc.MoveTo(at(start))
+	prev := start
+	for _, p := range ps {
+		switch {
+		case onCurve(p) && onCurve(prev):
+			dc.LineTo(at(p))
+		case onCurve(p):
+			cx, cy := at(prev)
+			px, py := at(p)
+			dc.QuadraticTo(cx, cy, px, py)
+		case !onCurve(prev):
+			cx, cy := at(prev)
+			px, py := at(mid(prev, p))
+			dc.QuadraticTo(cx, cy, px, py)
+		}
+		prev = p
+	}
+	if onCurve(prev) {
+		dc.LineTo(at(start))
+	} else {
+		cx, cy := at(prev)
+		px, py := at(start)
+		dc.QuadraticTo(cx, cy, px, py)
+	}
+	dc.ClosePath()
+}
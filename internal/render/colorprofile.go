@@ -0,0 +1,65 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ColorProfile selects how raster output declares its color space
+type ColorProfile string
+
+const (
+	ColorProfileSRGB ColorProfile = "srgb" // Tag PNG and JPEG output as sRGB; the default
+	ColorProfileNone ColorProfile = "none" // Leave output untagged for the smallest files
+)
+
+// ParseColorProfile converts a query value into a ColorProfile; empty means ColorProfileSRGB.
+func ParseColorProfile(s string) (ColorProfile, bool) {
+	switch ColorProfile(strings.ToLower(s)) {
+	case "", ColorProfileSRGB:
+		return ColorProfileSRGB, true
+	case ColorProfileNone:
+		return ColorProfileNone, true
+	default:
+		return ColorProfileSRGB, false
+	}
+}
+
+// pngSRGBIntent is the perceptual rendering intent stored in the sRGB chunk
+const pngSRGBIntent = 0
+
+// jpegSRGBExif is an APP1 segment holding a minimal big-endian Exif block whose only
+// entry is ColorSpace = 1 (sRGB), reached through IFD0's Exif IFD pointer
+var jpegSRGBExif = []byte{
+	0xff, 0xe1, 0x00, 0x34, // APP1, length 52
+	'E', 'x', 'i', 'f', 0, 0,
+	'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08, // TIFF header, IFD0 at 8
+	0x00, 0x01, // IFD0: one entry
+	0x87, 0x69, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x1a, // Exif IFD pointer -> 26
+	0x00, 0x00, 0x00, 0x00, // no next IFD
+	0x00, 0x01, // Exif IFD: one entry
+	0xa0, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, // ColorSpace SHORT 1
+	0x00, 0x00, 0x00, 0x00, // no next IFD
+}
+
+// tagSRGB marks encoded output as sRGB: an sRGB chunk for PNG and an Exif ColorSpace
+// entry for JPEG. Other formats have no tag short of a full ICC profile and are returned as is.
+func tagSRGB(data []byte, format ImageFormat) ([]byte, error) {
+	switch format {
+	case FormatPNG:
+		return insertPNGChunk(data, "sRGB", []byte{pngSRGBIntent})
+	case FormatJPG, FormatJPEG:
+		if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+			return nil, errors.New("tag jpeg: missing SOI marker")
+		}
+		var out bytes.Buffer
+		out.Grow(len(data) + len(jpegSRGBExif))
+		out.Write(data[:2])
+		out.Write(jpegSRGBExif)
+		out.Write(data[2:])
+		return out.Bytes(), nil
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,20 @@
+package render
+
+import "fmt"
+
+// CSS custom properties that recolor SVG output drawn with Options.CSSVars, e.g.
+// `.avatar { --grout-bg: #222; --grout-fg: #eee; }` on a page embedding the SVG inline
+const (
+	CSSVarBackground    = "--grout-bg"     // Solid background, or the start of a gradient
+	CSSVarBackgroundEnd = "--grout-bg-end" // End of a background gradient
+	CSSVarForeground    = "--grout-fg"     // Text, icons and the tile pattern
+)
+
+// svgColor returns the SVG paint for hex: the color itself, or with opts.CSSVars a var() of
+// property that falls back to it, so the SVG looks the same until a stylesheet sets property
+func svgColor(opts Options, property, hex string) string {
+	if !opts.CSSVars {
+		return "#" + hex
+	}
+	return fmt.Sprintf("var(%s, #%s)", property, hex)
+}
@@ -0,0 +1,26 @@
+package render
+
+import (
+	"image"
+)
+
+// writeSVGFadeStart opens a group drawing everything inside it at 1-amount opacity, as one
+// layer so overlapping shapes do not show through each other. Close it with writeSVGFadeEnd.
+func writeSVGFadeStart(sw *svgWriter, amount float64) {
+	sw.printf(`<g opacity="%g">`, round2(1-amount))
+	sw.writeString("\n")
+}
+
+// writeSVGFadeEnd closes the group opened by writeSVGFadeStart
+func writeSVGFadeEnd(sw *svgWriter) {
+	sw.writeString("</g>\n")
+}
+
+// fade multiplies the alpha of img by 1-amount in place, the raster counterpart of
+// writeSVGFadeStart. Channels are premultiplied, so all four are scaled together.
+func fade(img *image.RGBA, amount float64) {
+	opacity := round2(1 - amount)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(float64(img.Pix[i])*opacity + 0.5)
+	}
+}
@@ -0,0 +1,92 @@
+package render
+
+import (
+	"image"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// Flip mirrors the generated image, e.g. for pairs of avatars facing each other
+type Flip string
+
+const (
+	FlipNone       Flip = ""
+	FlipHorizontal Flip = "horizontal" // Mirrors left and right
+	FlipVertical   Flip = "vertical"   // Mirrors top and bottom
+	FlipBoth       Flip = "both"       // Mirrors both ways, the same as a half turn
+)
+
+// ParseFlip converts a query value into a Flip.
+// Empty values select FlipNone; unknown values report false.
+func ParseFlip(s string) (Flip, bool) {
+	switch f := Flip(strings.ToLower(s)); f {
+	case FlipNone, FlipHorizontal, FlipVertical, FlipBoth:
+		return f, true
+	default:
+		return FlipNone, false
+	}
+}
+
+// flipMatrix returns the scale and translation mirroring a w x h image as f asks
+func flipMatrix(f Flip, w, h int) (sx, sy, tx, ty float64) {
+	sx, sy = 1, 1
+	if f == FlipHorizontal || f == FlipBoth {
+		sx, tx = -1, float64(w)
+	}
+	if f == FlipVertical || f == FlipBoth {
+		sy, ty = -1, float64(h)
+	}
+	return sx, sy, tx, ty
+}
+
+// writeSVGFlipStart opens a group mirroring everything inside it across the image center.
+// Close it with writeSVGFlipEnd.
+func writeSVGFlipStart(sw *svgWriter, opts Options) {
+	sx, sy, tx, ty := flipMatrix(opts.Flip, opts.Width, opts.Height)
+	sw.printf(`<g transform="matrix(%g 0 0 %g %g %g)">`, sx, sy, tx, ty)
+	sw.writeString("\n")
+}
+
+// writeSVGFlipEnd closes the group opened by writeSVGFlipStart
+func writeSVGFlipEnd(sw *svgWriter) {
+	sw.writeString("</g>\n")
+}
+
+// withFlip runs draw with dc's matrix mirrored like writeSVGFlipStart, for shapes drawn
+// after the background has been flipped in place
+func withFlip(dc *gg.Context, opts Options, draw func()) {
+	sx, sy, tx, ty := flipMatrix(opts.Flip, opts.Width, opts.Height)
+	dc.Push()
+	defer dc.Pop()
+	dc.Translate(tx, ty)
+	dc.Scale(sx, sy)
+	draw()
+}
+
+// flipImage mirrors img in place as f asks
+func flipImage(img *image.RGBA, f Flip) {
+	b := img.Bounds()
+	horizontal := f == FlipHorizontal || f == FlipBoth
+	vertical := f == FlipVertical || f == FlipBoth
+	swap := func(x1, y1, x2, y2 int) {
+		i, j := img.PixOffset(x1, y1), img.PixOffset(x2, y2)
+		for k := 0; k < 4; k++ {
+			img.Pix[i+k], img.Pix[j+k] = img.Pix[j+k], img.Pix[i+k]
+		}
+	}
+	if horizontal {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for l, r := b.Min.X, b.Max.X-1; l < r; l, r = l+1, r-1 {
+				swap(l, y, r, y)
+			}
+		}
+	}
+	if vertical {
+		for t, btm := b.Min.Y, b.Max.Y-1; t < btm; t, btm = t+1, btm-1 {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				swap(x, t, x, btm)
+			}
+		}
+	}
+}
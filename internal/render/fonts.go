@@ -0,0 +1,198 @@
+package render
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+)
+
+// DefaultFontFamily is the family the embedded Go fonts are registered under
+const DefaultFontFamily = "go"
+
+// MonoFontFamily is the family the embedded Go Mono fonts are registered under, e.g. for labels
+const MonoFontFamily = "go-mono"
+
+// FontWeight names a weight within a font family
+type FontWeight string
+
+const (
+	WeightLight   FontWeight = "light"
+	WeightRegular FontWeight = "regular"
+	WeightMedium  FontWeight = "medium"
+	WeightBold    FontWeight = "bold"
+)
+
+// ParseFontWeight converts a query value into a FontWeight; empty means regular.
+func ParseFontWeight(s string) (FontWeight, bool) {
+	switch FontWeight(strings.ToLower(s)) {
+	case "", WeightRegular:
+		return WeightRegular, true
+	case WeightLight:
+		return WeightLight, true
+	case WeightMedium:
+		return WeightMedium, true
+	case WeightBold:
+		return WeightBold, true
+	default:
+		return WeightRegular, false
+	}
+}
+
+// svgFontWeight maps a weight to the SVG font-weight attribute value
+func svgFontWeight(weight FontWeight) string {
+	switch weight {
+	case WeightLight:
+		return "300"
+	case WeightMedium:
+		return "500"
+	case WeightBold:
+		return "bold"
+	default:
+		return "normal"
+	}
+}
+
+// fontFamily groups the registered weights of one typeface
+type fontFamily struct {
+	weights map[FontWeight]*truetype.Font
+}
+
+// fontRegistry holds every registered family; it is safe for concurrent use
+type fontRegistry struct {
+	mu       sync.RWMutex
+	families map[string]*fontFamily
+	// smallCaps holds the smcp substitutions of each registered font that has the feature
+	smallCaps map[*truetype.Font]glyphSubstitutions
+}
+
+// RegisterFont parses ttf and adds it to family under weight, replacing any earlier face.
+// Its OpenType small capitals (smcp), if any, are picked up for caps=small.
+func (r *Renderer) RegisterFont(family string, weight FontWeight, ttf []byte) error {
+	font, err := truetype.Parse(ttf)
+	if err != nil {
+		return fmt.Errorf("parse %s %s font: %w", family, weight, err)
+	}
+	r.registerFace(family, weight, font)
+	if subs := parseSingleSubstitutions(ttf, "smcp"); subs != nil {
+		r.fonts.mu.Lock()
+		if r.fonts.smallCaps == nil {
+			r.fonts.smallCaps = make(map[*truetype.Font]glyphSubstitutions)
+		}
+		r.fonts.smallCaps[font] = subs
+		r.fonts.mu.Unlock()
+	}
+	return nil
+}
+
+// smallCaps returns the smcp substitutions of font, or nil when it has none
+func (r *Renderer) smallCaps(font *truetype.Font) glyphSubstitutions {
+	r.fonts.mu.RLock()
+	defer r.fonts.mu.RUnlock()
+	return r.fonts.smallCaps[font]
+}
+
+func (r *Renderer) registerFace(family string, weight FontWeight, font *truetype.Font) {
+	r.fonts.mu.Lock()
+	defer r.fonts.mu.Unlock()
+	if r.fonts.families == nil {
+		r.fonts.families = make(map[string]*fontFamily)
+	}
+	f, ok := r.fonts.families[family]
+	if !ok {
+		f = &fontFamily{weights: make(map[FontWeight]*truetype.Font)}
+		r.fonts.families[family] = f
+	}
+	f.weights[weight] = font
+}
+
+// FontFamilies lists the registered families for error messages, DefaultFontFamily first and
+// the rest sorted
+func (r *Renderer) FontFamilies() []string {
+	r.fonts.mu.RLock()
+	defer r.fonts.mu.RUnlock()
+	var names []string
+	for name := range r.fonts.families {
+		if name != DefaultFontFamily {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return append([]string{DefaultFontFamily}, names...)
+}
+
+// HasFontFamily reports whether family has been registered
+func (r *Renderer) HasFontFamily(family string) bool {
+	r.fonts.mu.RLock()
+	defer r.fonts.mu.RUnlock()
+	_, ok := r.fonts.families[family]
+	return ok
+}
+
+// resolveFamily returns family when it has been registered and DefaultFontFamily otherwise
+func (r *Renderer) resolveFamily(family string) string {
+	if family == "" || !r.HasFontFamily(family) {
+		return DefaultFontFamily
+	}
+	return family
+}
+
+// svgFontFamily maps a registered family to the SVG font-family attribute value. The
+// embedded fonts become generic families; other families are named, with sans-serif as
+// fallback for viewers that do not have them.
+func svgFontFamily(family string) string {
+	switch family {
+	case "", DefaultFontFamily:
+		return "sans-serif"
+	case MonoFontFamily:
+		return "monospace"
+	default:
+		return escapeXML(family) + ", sans-serif"
+	}
+}
+
+// resolveWeight returns the weight that is actually drawn for a request:
+// the requested one when the family has it, regular otherwise.
+func (r *Renderer) resolveWeight(family string, weight FontWeight) FontWeight {
+	r.fonts.mu.RLock()
+	defer r.fonts.mu.RUnlock()
+	if f, ok := r.fonts.families[family]; ok {
+		if _, ok := f.weights[weight]; ok {
+			return weight
+		}
+	}
+	return WeightRegular
+}
+
+// face returns the font for family and weight, falling back to the family's regular face
+// and then to the default family's regular face.
+func (r *Renderer) face(family string, weight FontWeight) *truetype.Font {
+	r.fonts.mu.RLock()
+	defer r.fonts.mu.RUnlock()
+	for _, fam := range []string{family, DefaultFontFamily} {
+		f, ok := r.fonts.families[fam]
+		if !ok {
+			continue
+		}
+		if font, ok := f.weights[weight]; ok {
+			return font
+		}
+		if font, ok := f.weights[WeightRegular]; ok {
+			return font
+		}
+	}
+	return nil
+}
+
+// fontWeightFor returns the requested weight of opts, honoring the legacy Bold flag
+func fontWeightFor(opts Options) FontWeight {
+	if opts.Weight != "" {
+		return opts.Weight
+	}
+	if opts.Bold {
+		return WeightBold
+	}
+	return WeightRegular
+}
@@ -0,0 +1,40 @@
+package render
+
+import (
+	"image"
+)
+
+// writeSVGGrayscaleStart opens a group desaturating everything drawn inside it by amount
+// (1 is fully gray). The filter works in sRGB so SVG and raster output match.
+// Close it with writeSVGGrayscaleEnd.
+func writeSVGGrayscaleStart(sw *svgWriter, amount float64) {
+	sw.printf(`<defs><filter id="%s" color-interpolation-filters="sRGB"><feColorMatrix type="saturate" values="%g" /></filter></defs>`, sw.id("desaturate"), round2(1-amount))
+	sw.writeString("\n")
+	sw.printf(`<g filter="url(#%s)">`, sw.id("desaturate"))
+	sw.writeString("\n")
+}
+
+// writeSVGGrayscaleEnd closes the group opened by writeSVGGrayscaleStart
+func writeSVGGrayscaleEnd(sw *svgWriter) {
+	sw.writeString("</g>\n")
+}
+
+// desaturate applies the feColorMatrix saturate matrix to img in place, so raster output
+// matches the SVG filter. Alpha is left alone; the matrix is linear, so premultiplied
+// channels stay consistent.
+func desaturate(img *image.RGBA, amount float64) {
+	s := round2(1 - amount)
+	m := [3][3]float64{
+		{0.213 + 0.787*s, 0.715 - 0.715*s, 0.072 - 0.072*s},
+		{0.213 - 0.213*s, 0.715 + 0.285*s, 0.072 - 0.072*s},
+		{0.213 - 0.213*s, 0.715 - 0.715*s, 0.072 + 0.928*s},
+	}
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])
+		a := float64(img.Pix[i+3])
+		for c := range 3 {
+			v := m[c][0]*r + m[c][1]*g + m[c][2]*b
+			img.Pix[i+c] = uint8(min(max(v+0.5, 0), a))
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package render
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"hash/crc32"
+	"hash/fnv"
+	"strings"
+)
+
+// HashAlgorithm selects the hash that maps names to colors. Matching another avatar
+// service's algorithm reproduces its color choices for the same palette.
+type HashAlgorithm string
+
+const (
+	HashMD5    HashAlgorithm = "md5" // Default, used by grout from the start
+	HashFNV32  HashAlgorithm = "fnv32"
+	HashFNV64  HashAlgorithm = "fnv64"
+	HashSHA256 HashAlgorithm = "sha256"
+	HashCRC32  HashAlgorithm = "crc32"
+)
+
+// ParseHashAlgorithm converts a config value into a HashAlgorithm; empty means HashMD5.
+// fnv32 and fnv64 are the FNV-1a variants.
+func ParseHashAlgorithm(s string) (HashAlgorithm, bool) {
+	switch a := HashAlgorithm(strings.ToLower(s)); a {
+	case "", HashMD5:
+		return HashMD5, true
+	case HashFNV32, HashFNV64, HashSHA256, HashCRC32:
+		return a, true
+	default:
+		return HashMD5, false
+	}
+}
+
+// Sum returns the big-endian digest of seed; it is always at least 4 bytes long
+func (a HashAlgorithm) Sum(seed string) []byte {
+	switch a {
+	case HashFNV32:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(seed))
+		return h.Sum(nil)
+	case HashFNV64:
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(seed))
+		return h.Sum(nil)
+	case HashSHA256:
+		sum := sha256.Sum256([]byte(seed))
+		return sum[:]
+	case HashCRC32:
+		h := crc32.NewIEEE()
+		_, _ = h.Write([]byte(seed))
+		return h.Sum(nil)
+	default:
+		sum := md5.Sum([]byte(seed))
+		return sum[:]
+	}
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MinHueRangeContrast is the WCAG contrast ratio a generated hue range color keeps against
+// its black or white text, the level for normal-sized text
+const MinHueRangeContrast = 4.5
+
+// HueRange is a band of the color wheel in degrees. Min greater than Max wraps through red,
+// e.g. 330-30 covers magentas, reds and oranges.
+type HueRange struct {
+	Min, Max float64
+}
+
+// ParseHueRange parses "min-max" in whole degrees from 0 to 360
+func ParseHueRange(s string) (HueRange, bool) {
+	lo, hi, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return HueRange{}, false
+	}
+	minHue, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	maxHue, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || minHue < 0 || minHue > 360 || maxHue < 0 || maxHue > 360 {
+		return HueRange{}, false
+	}
+	return HueRange{Min: float64(minHue), Max: float64(maxHue)}, true
+}
+
+// span is the width of the range in degrees, going clockwise from Min
+func (h HueRange) span() float64 {
+	if h.Max >= h.Min {
+		return h.Max - h.Min
+	}
+	return 360 - h.Min + h.Max
+}
+
+// Contains reports whether hue, in degrees, lies within the range
+func (h HueRange) Contains(hue float64) bool {
+	return h.span() >= 360 || math.Mod(hue-h.Min+360, 360) <= h.span()
+}
+
+// Hue returns the hue of a hex color in degrees and whether it has one; grays do not
+func Hue(hex string) (float64, bool) {
+	c := ParseHexColor(hex).(color.RGBA)
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	hi, lo := max(r, g, b), min(r, g, b)
+	delta := hi - lo
+	if delta == 0 {
+		return 0, false
+	}
+	var hue float64
+	switch hi {
+	case r:
+		hue = math.Mod((g-b)/delta, 6)
+	case g:
+		hue = (b-r)/delta + 2
+	default:
+		hue = (r-g)/delta + 4
+	}
+	return math.Mod(hue*60+360, 360), true
+}
+
+// hslHex converts hue in degrees and saturation and lightness in 0..1 to a 6 digit hex color
+func hslHex(hue, saturation, lightness float64) string {
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g = chroma, x
+	case hue < 120:
+		r, g = x, chroma
+	case hue < 180:
+		g, b = chroma, x
+	case hue < 240:
+		g, b = x, chroma
+	case hue < 300:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := lightness - chroma/2
+	channel := func(v float64) uint8 { return uint8(math.Round(min(max(v+m, 0), 1) * 255)) }
+	return fmt.Sprintf("%02x%02x%02x", channel(r), channel(g), channel(b))
+}
+
+// ColorInHueRange deterministically picks a color for seed whose hue lies in hueRange. Palette
+// colors in the range are preferred; when none is, the color is generated in HSL and its
+// lightness adjusted until the black or white text GetContrastColor picks stays readable.
+func ColorInHueRange(seed string, palette []string, algo HashAlgorithm, hueRange HueRange) string {
+	var candidates []string
+	for _, hex := range palette {
+		if hue, ok := Hue(hex); ok && hueRange.Contains(hue) {
+			candidates = append(candidates, hex)
+		}
+	}
+	if len(candidates) > 0 {
+		return ColorFromPaletteWithHash(seed, candidates, algo)
+	}
+
+	// Every algorithm yields at least four bytes: the low half picks the hue, the high bytes
+	// the saturation and lightness. The hue keeps clear of the edges of the range, which
+	// rounding to 8-bit channels could otherwise push it over.
+	v := binary.BigEndian.Uint32(algo.Sum(seed)[:4])
+	inset := min(2, hueRange.span()/2)
+	hue := math.Mod(hueRange.Min+inset+(hueRange.span()-2*inset)*float64(v&0xffff)/0xffff, 360)
+	saturation := 0.5 + 0.3*float64(v>>16&0xff)/255
+	lightness := 0.35 + 0.2*float64(v>>24)/255
+	hex := hslHex(hue, saturation, lightness)
+	for range 20 {
+		fg := GetContrastColor(hex)
+		if ContrastRatio(hex, fg) >= MinHueRangeContrast {
+			break
+		}
+		// Move away from the text: darker under white, lighter under black
+		if fg == "ffffff" {
+			lightness -= 0.025
+		} else {
+			lightness += 0.025
+		}
+		hex = hslHex(hue, saturation, lightness)
+	}
+	return hex
+}
@@ -0,0 +1,68 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// MaxICOSize is the largest image an ICO directory entry can describe
+	MaxICOSize = 256
+	// MaxICOImages caps how many sizes a single ICO file packs
+	MaxICOImages = 8
+)
+
+// DefaultICOSizes are the favicon sizes Windows picks from
+var DefaultICOSizes = []int{16, 32, 48}
+
+// icoHeaderSize and icoEntrySize are the lengths of the ICONDIR header and each ICONDIRENTRY
+const (
+	icoHeaderSize = 6
+	icoEntrySize  = 16
+)
+
+// RenderICO renders opts once per size as a square PNG and packs the results into a single
+// ICO file, in the order given. Sizes must be between 1 and MaxICOSize.
+func (r *Renderer) RenderICO(w io.Writer, opts Options, sizes []int) error {
+	if len(sizes) == 0 || len(sizes) > MaxICOImages {
+		return fmt.Errorf("render ico: need 1 to %d sizes, got %d", MaxICOImages, len(sizes))
+	}
+	images := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		if size < 1 || size > MaxICOSize {
+			return fmt.Errorf("render ico: size %d outside 1..%d", size, MaxICOSize)
+		}
+		sized := opts
+		sized.Width, sized.Height, sized.Format = size, size, FormatPNG
+		data, err := r.drawRasterImageWithWrapping(sized, fontSizeFor(sized))
+		if err != nil {
+			return err
+		}
+		images[i] = data
+	}
+	_, err := w.Write(encodeICO(sizes, images))
+	return err
+}
+
+// encodeICO writes the ICONDIR header, one directory entry per image and then the PNG
+// payloads. A width or height of 256 is stored as 0, as the format requires.
+func encodeICO(sizes []int, images [][]byte) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	_ = binary.Write(&buf, le, [3]uint16{0, 1, uint16(len(images))}) // Reserved, type 1 = icon, count
+
+	offset := icoHeaderSize + icoEntrySize*len(images)
+	for i, data := range images {
+		dim := uint8(sizes[i] % MaxICOSize)
+		buf.Write([]byte{dim, dim, 0, 0})                                        // Width, height, palette size, reserved
+		_ = binary.Write(&buf, le, [2]uint16{1, 32})                             // Color planes, bits per pixel
+		_ = binary.Write(&buf, le, [2]uint32{uint32(len(data)), uint32(offset)}) // Size and offset of the image
+		offset += len(data)
+	}
+	for _, data := range images {
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,78 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"testing"
+)
+
+// icoEntry is a parsed ICONDIRENTRY with its decoded PNG dimensions
+type icoEntry struct {
+	width, height       int // As stored in the directory, 0 meaning 256
+	pngWidth, pngHeight int
+}
+
+// parseICO checks the ICONDIR header and decodes every PNG the directory points at
+func parseICO(t *testing.T, data []byte) []icoEntry {
+	t.Helper()
+	if len(data) < icoHeaderSize {
+		t.Fatalf("ico too short: %d bytes", len(data))
+	}
+	le := binary.LittleEndian
+	if reserved, kind := le.Uint16(data[0:]), le.Uint16(data[2:]); reserved != 0 || kind != 1 {
+		t.Fatalf("expected an icon header got reserved=%d type=%d", reserved, kind)
+	}
+	count := int(le.Uint16(data[4:]))
+	entries := make([]icoEntry, count)
+	for i := range entries {
+		e := data[icoHeaderSize+i*icoEntrySize:]
+		if planes, bits := le.Uint16(e[4:]), le.Uint16(e[6:]); planes != 1 || bits != 32 {
+			t.Fatalf("entry %d: expected 1 plane at 32 bits got %d and %d", i, planes, bits)
+		}
+		size, offset := le.Uint32(e[8:]), le.Uint32(e[12:])
+		cfg, err := png.DecodeConfig(bytes.NewReader(data[offset : offset+size]))
+		if err != nil {
+			t.Fatalf("entry %d: decode png: %v", i, err)
+		}
+		entries[i] = icoEntry{width: int(e[0]), height: int(e[1]), pngWidth: cfg.Width, pngHeight: cfg.Height}
+	}
+	return entries
+}
+
+func TestRenderICO(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	opts := Options{Background: "667eea", Foreground: "ffffff", Text: "G"}
+
+	t.Run("Sizes", func(t *testing.T) {
+		var buf bytes.Buffer
+		sizes := []int{16, 32, 48, 256}
+		if err := r.RenderICO(&buf, opts, sizes); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		entries := parseICO(t, buf.Bytes())
+		if len(entries) != len(sizes) {
+			t.Fatalf("expected %d images got %d", len(sizes), len(entries))
+		}
+		for i, size := range sizes {
+			e := entries[i]
+			if e.pngWidth != size || e.pngHeight != size {
+				t.Fatalf("expected image %d to be %dx%d got %dx%d", i, size, size, e.pngWidth, e.pngHeight)
+			}
+			if e.width != size%256 || e.height != size%256 {
+				t.Fatalf("expected directory size %d for %dpx got %dx%d", size%256, size, e.width, e.height)
+			}
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		for _, sizes := range [][]int{nil, {0}, {257}, {1, 2, 3, 4, 5, 6, 7, 8, 9}} {
+			if err := r.RenderICO(&bytes.Buffer{}, opts, sizes); err == nil {
+				t.Fatalf("expected an error for sizes %v", sizes)
+			}
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// iconViewBox is the size of the square coordinate space every bundled icon is drawn in
+const iconViewBox = 24.0
+
+// iconScale is the icon size relative to the smaller image dimension
+const iconScale = 0.5
+
+// icons holds the bundled monochrome icons as SVG path data, filled with the even-odd rule.
+// Only absolute M, L, H, V, C and Z commands are used so the raster renderer can draw them too.
+var icons = map[string]string{
+	"image": "M2 4 H22 V20 H2 Z M4 6 V18 H20 V6 Z M5 17 L10 10 L13 14 L15 12 L19 17 Z " +
+		"M17.5 9 C17.5 9.83 16.83 10.5 16 10.5 C15.17 10.5 14.5 9.83 14.5 9 C14.5 8.17 15.17 7.5 16 7.5 C16.83 7.5 17.5 8.17 17.5 9 Z",
+	"photo": "M2 7 H7 L9 4 H15 L17 7 H22 V20 H2 Z " +
+		"M16.5 13.5 C16.5 15.99 14.49 18 12 18 C9.51 18 7.5 15.99 7.5 13.5 C7.5 11.01 9.51 9 12 9 C14.49 9 16.5 11.01 16.5 13.5 Z " +
+		"M15 13.5 C15 15.16 13.66 16.5 12 16.5 C10.34 16.5 9 15.16 9 13.5 C9 11.84 10.34 10.5 12 10.5 C13.66 10.5 15 11.84 15 13.5 Z",
+	"user": "M16 8 C16 10.21 14.21 12 12 12 C9.79 12 8 10.21 8 8 C8 5.79 9.79 4 12 4 C14.21 4 16 5.79 16 8 Z " +
+		"M4 21 C4 16 8 14 12 14 C16 14 20 16 20 21 Z",
+	"file": "M6 2 H14 L20 8 V22 H6 Z M8 4 V20 H18 V9 H13 V4 Z",
+}
+
+// IsIcon reports whether name is a bundled icon
+func IsIcon(name string) bool {
+	_, ok := icons[name]
+	return ok
+}
+
+// IconNames returns the bundled icon names in alphabetical order
+func IconNames() []string {
+	names := make([]string, 0, len(icons))
+	for name := range icons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// iconPlacement returns the offset and scale that center an icon in a w x h image
+func iconPlacement(w, h int) (x, y, scale float64) {
+	size := float64(min(w, h)) * iconScale
+	return (float64(w) - size) / 2, (float64(h) - size) / 2, size / iconViewBox
+}
+
+// writeSVGIcon writes the named icon centered and tinted with fill
+func writeSVGIcon(sw *svgWriter, name string, w, h int, fill string) {
+	x, y, scale := iconPlacement(w, h)
+	sw.printf(`<path transform="translate(%g %g) scale(%g)" fill="%s" fill-rule="evenodd" d="%s" />`, x, y, scale, fill, icons[name])
+	sw.writeString("\n")
+}
+
+// drawIcon fills the named icon centered on the context using the current color
+func drawIcon(dc *gg.Context, name string, w, h int) error {
+	x, y, scale := iconPlacement(w, h)
+	pt := func(px, py float64) (float64, float64) { return x + px*scale, y + py*scale }
+
+	tokens := strings.Fields(icons[name])
+	var curX, curY float64
+	var cmd byte
+	for i := 0; i < len(tokens); {
+		if c := tokens[i][0]; c >= 'A' && c <= 'Z' {
+			cmd = c
+			tokens[i] = tokens[i][1:]
+			if tokens[i] == "" {
+				i++
+			}
+			if cmd == 'Z' {
+				dc.ClosePath()
+				continue
+			}
+		}
+
+		argc := map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6}[cmd]
+		if argc == 0 || i+argc > len(tokens) {
+			return fmt.Errorf("icon %s: malformed path", name)
+		}
+		args := make([]float64, argc)
+		for j := range args {
+			v, err := strconv.ParseFloat(tokens[i+j], 64)
+			if err != nil {
+				return fmt.Errorf("icon %s: %w", name, err)
+			}
+			args[j] = v
+		}
+		i += argc
+
+		switch cmd {
+		case 'M':
+			curX, curY = args[0], args[1]
+			dc.MoveTo(pt(curX, curY))
+		case 'L':
+			curX, curY = args[0], args[1]
+			dc.LineTo(pt(curX, curY))
+		case 'H':
+			curX = args[0]
+			dc.LineTo(pt(curX, curY))
+		case 'V':
+			curY = args[0]
+			dc.LineTo(pt(curX, curY))
+		case 'C':
+			x1, y1 := pt(args[0], args[1])
+			x2, y2 := pt(args[2], args[3])
+			curX, curY = args[4], args[5]
+			x3, y3 := pt(curX, curY)
+			dc.CubicTo(x1, y1, x2, y2, x3, y3)
+		}
+	}
+
+	dc.SetFillRuleEvenOdd()
+	dc.Fill()
+	dc.SetFillRuleWinding()
+	return nil
+}
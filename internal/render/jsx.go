@@ -0,0 +1,150 @@
+package render
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+var (
+	// jsxTagRegex matches a start, end or self-closing tag with double-quoted attributes
+	jsxTagRegex = regexp.MustCompile(`<(/?)([A-Za-z][\w:-]*)((?:\s+[\w:-]+="[^"]*")*)\s*(/?)>`)
+	// jsxAttrRegex matches one double-quoted attribute
+	jsxAttrRegex = regexp.MustCompile(`([\w:-]+)="([^"]*)"`)
+	// jsxPrologRegex matches the XML declaration and doctype of standalone documents
+	jsxPrologRegex = regexp.MustCompile(`<\?xml[^>]*\?>\s*|<!DOCTYPE[^>]*>\s*`)
+)
+
+// jsxAttrNames are the renamed attributes that do not follow the kebab-case rule
+var jsxAttrNames = map[string]string{
+	"class":      "className",
+	"xlink:href": "xlinkHref",
+	"xml:lang":   "xmlLang",
+	"xml:space":  "xmlSpace",
+}
+
+// jsxTag is one tag of the document being rewritten
+type jsxTag struct {
+	start, end  int
+	closing     bool
+	selfClosing bool
+	name, attrs string
+}
+
+// svgToJSX rewrites generated SVG markup for pasting into JSX: attributes are camelCased,
+// style strings become objects, elements without children self-close and braces in text
+// are escaped so they are not read as expressions.
+func svgToJSX(svg []byte) []byte {
+	svg = jsxPrologRegex.ReplaceAll(svg, nil)
+
+	var tags []jsxTag
+	for _, m := range jsxTagRegex.FindAllSubmatchIndex(svg, -1) {
+		tags = append(tags, jsxTag{
+			start: m[0], end: m[1],
+			closing:     m[3] > m[2],
+			name:        string(svg[m[4]:m[5]]),
+			attrs:       string(svg[m[6]:m[7]]),
+			selfClosing: m[9] > m[8],
+		})
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(svg))
+	last := 0
+	for i := 0; i < len(tags); i++ {
+		tag := tags[i]
+		writeJSXText(&out, svg[last:tag.start])
+		last = tag.end
+
+		if tag.closing {
+			out.WriteString("</" + tag.name + ">")
+			continue
+		}
+		out.WriteString("<" + tag.name)
+		for _, a := range jsxAttrRegex.FindAllStringSubmatch(tag.attrs, -1) {
+			out.WriteString(" " + jsxAttr(a[1], a[2]))
+		}
+		// An element whose end tag follows right away has no children
+		if !tag.selfClosing && i+1 < len(tags) {
+			if next := tags[i+1]; next.closing && next.name == tag.name && next.start == tag.end {
+				tag.selfClosing = true
+				last = next.end
+				i++
+			}
+		}
+		if tag.selfClosing {
+			out.WriteString(" />")
+		} else {
+			out.WriteString(">")
+		}
+	}
+	writeJSXText(&out, svg[last:])
+	return out.Bytes()
+}
+
+// jsxAttr renders one attribute in JSX syntax
+func jsxAttr(name, value string) string {
+	if name == "style" {
+		return "style={" + jsxStyleObject(value) + "}"
+	}
+	return jsxAttrName(name) + `="` + value + `"`
+}
+
+// jsxAttrName camelCases a kebab-case or namespaced attribute name, e.g. stroke-width to
+// strokeWidth. data-* and aria-* attributes keep their dashes, as JSX expects.
+func jsxAttrName(name string) string {
+	if renamed, ok := jsxAttrNames[name]; ok {
+		return renamed
+	}
+	if strings.HasPrefix(name, "data-") || strings.HasPrefix(name, "aria-") {
+		return name
+	}
+	return camelCase(name)
+}
+
+// camelCase joins dash or colon separated words, capitalizing all but the first
+func camelCase(s string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range s {
+		if r == '-' || r == ':' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = []rune(strings.ToUpper(string(r)))[0]
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// jsxStyleObject converts an inline CSS declaration list such as "stop-color:#fff;stop-opacity:1"
+// into a JSX style object literal: {stopColor: "#fff", stopOpacity: "1"}
+func jsxStyleObject(css string) string {
+	var props []string
+	for _, decl := range strings.Split(css, ";") {
+		prop, value, ok := strings.Cut(decl, ":")
+		prop, value = strings.TrimSpace(prop), strings.TrimSpace(value)
+		if !ok || prop == "" {
+			continue
+		}
+		props = append(props, camelCase(prop)+`: "`+strings.ReplaceAll(value, `"`, `\"`)+`"`)
+	}
+	return "{" + strings.Join(props, ", ") + "}"
+}
+
+// writeJSXText copies text between tags, escaping braces as entities
+func writeJSXText(out *bytes.Buffer, text []byte) {
+	for _, c := range text {
+		switch c {
+		case '{':
+			out.WriteString("&#123;")
+		case '}':
+			out.WriteString("&#125;")
+		default:
+			out.WriteByte(c)
+		}
+	}
+}
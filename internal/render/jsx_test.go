@@ -0,0 +1,83 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSVGToJSX(t *testing.T) {
+	tests := []struct {
+		name     string
+		svg      string
+		expected string
+	}{
+		{"Kebab attributes", `<text font-family="sans-serif" text-anchor="middle" dominant-baseline="middle">JD</text>`,
+			`<text fontFamily="sans-serif" textAnchor="middle" dominantBaseline="middle">JD</text>`},
+		{"Stroke and clip path", `<g clip-path="url(#bg-clip)"><circle r="4" stroke-width="2" /></g>`,
+			`<g clipPath="url(#bg-clip)"><circle r="4" strokeWidth="2" /></g>`},
+		{"Renamed attributes", `<use xlink:href="#a" class="icon" data-id="1" />`, `<use xlinkHref="#a" className="icon" data-id="1" />`},
+		{"Style object", `<stop offset="0%" style="stop-color:#ff0000;stop-opacity:1" />`,
+			`<stop offset="0%" style={{stopColor: "#ff0000", stopOpacity: "1"}} />`},
+		{"Empty element self-closes", `<rect width="1"></rect>`, `<rect width="1" />`},
+		{"Braces in text", `<text>{name}</text>`, `<text>&#123;name&#125;</text>`},
+		{"Prolog dropped", svgProlog + `<svg width="1"></svg>`, `<svg width="1" />`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(svgToJSX([]byte(tt.svg))); got != tt.expected {
+				t.Fatalf("expected %s got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// jsxTagPattern matches any JSX tag; style objects contain no angle brackets
+var jsxTagPattern = regexp.MustCompile(`<(/?)([A-Za-z][\w:-]*)[^<>]*?(/?)>`)
+
+func TestRenderJSX(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	opts := Options{Width: 128, Height: 128, Background: "ff0000,0000ff", Foreground: "ffffff", Text: "JD", Shape: ShapeCircle,
+		Blur: 4, BadgeColor: "2ecc71", LetterSpacing: LetterSpacing{Value: 2}, Standalone: true, Format: FormatJSX}
+	out, err := r.DrawAvatar(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jsx := string(out)
+
+	if !strings.HasPrefix(jsx, "<svg ") {
+		t.Fatalf("expected the markup to start with the svg element: %s", jsx)
+	}
+	for _, attr := range []string{"stroke-width", "clip-path", "font-family", "letter-spacing", "stop-color"} {
+		if strings.Contains(jsx, attr) {
+			t.Fatalf("expected %s to be camelCased: %s", attr, jsx)
+		}
+	}
+	for _, attr := range []string{"strokeWidth=", "clipPath=", "fontFamily=", "letterSpacing=", "stopColor:", `edgeMode="duplicate"`} {
+		if !strings.Contains(jsx, attr) {
+			t.Fatalf("expected %s in %s", attr, jsx)
+		}
+	}
+
+	// Every opened tag must be closed in order
+	var stack []string
+	for _, m := range jsxTagPattern.FindAllStringSubmatch(jsx, -1) {
+		switch {
+		case m[3] == "/":
+		case m[1] == "/":
+			if len(stack) == 0 || stack[len(stack)-1] != m[2] {
+				t.Fatalf("unbalanced </%s> in %s", m[2], jsx)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			stack = append(stack, m[2])
+		}
+	}
+	if len(stack) != 0 {
+		t.Fatalf("unclosed tags %v in %s", stack, jsx)
+	}
+}
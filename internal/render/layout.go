@@ -0,0 +1,65 @@
+package render
+
+import (
+	"math"
+	"strings"
+)
+
+// InitialsLayout controls how the initials of the default style are arranged
+type InitialsLayout string
+
+const (
+	InitialsHorizontal InitialsLayout = "horizontal" // One line, left to right
+	InitialsVertical   InitialsLayout = "vertical"   // One initial per row, top to bottom
+)
+
+// Vertical rows are this many font sizes apart, and the stack may fill this share of the height
+const (
+	verticalLineHeight = 1.1
+	verticalFill       = 0.85
+)
+
+// ParseInitialsLayout converts a query value into an InitialsLayout.
+// Empty values select InitialsHorizontal; unknown values report false.
+func ParseInitialsLayout(s string) (InitialsLayout, bool) {
+	switch InitialsLayout(strings.ToLower(s)) {
+	case "", InitialsHorizontal:
+		return InitialsHorizontal, true
+	case InitialsVertical:
+		return InitialsVertical, true
+	default:
+		return InitialsHorizontal, false
+	}
+}
+
+// verticalRows returns the characters of opts.Text stacked one per row, or nil when the
+// text is drawn as a single line
+func verticalRows(opts Options) []string {
+	if opts.InitialsLayout != InitialsVertical || opts.Style != StyleDefault {
+		return nil
+	}
+	rows := strings.Split(opts.Text, "")
+	if len(rows) < 2 {
+		return nil
+	}
+	return rows
+}
+
+// verticalFontSize sizes stacked initials so each is as wide as a single initial in a
+// square of the image's width, while the whole stack fits in the height
+func verticalFontSize(opts Options, rows int) float64 {
+	fontSize := float64(opts.Width) * 0.5
+	fontSize = math.Min(fontSize, float64(opts.Height)*verticalFill/(float64(rows)*verticalLineHeight))
+	return math.Max(fontSize, 1)
+}
+
+// verticalRowCenters returns the vertical center of each of n rows, centered on the image
+func verticalRowCenters(height int, n int, fontSize float64) []float64 {
+	step := fontSize * verticalLineHeight
+	first := float64(height)/2 - step*float64(n-1)/2
+	centers := make([]float64, n)
+	for i := range centers {
+		centers[i] = first + step*float64(i)
+	}
+	return centers
+}
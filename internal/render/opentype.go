@@ -0,0 +1,125 @@
+package render
+
+import (
+	"encoding/binary"
+
+	"github.com/golang/freetype/truetype"
+)
+
+// fontTable is a table of an OpenType font. Reads past its end yield 0, so a truncated or
+// malformed table produces no substitutions instead of a panic.
+type fontTable []byte
+
+func (t fontTable) u16(off int) int {
+	if off < 0 || off+2 > len(t) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(t[off:]))
+}
+
+func (t fontTable) u32(off int) int {
+	if off < 0 || off+4 > len(t) {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(t[off:]))
+}
+
+func (t fontTable) from(off int) fontTable {
+	if off < 0 || off > len(t) {
+		return nil
+	}
+	return t[off:]
+}
+
+// sfntTable returns the table tagged tag from the font file ttf, or nil when it has none
+func sfntTable(ttf []byte, tag string) fontTable {
+	font := fontTable(ttf)
+	for i := range font.u16(4) {
+		record := font.from(12 + 16*i)
+		if len(record) < 16 || string(record[:4]) != tag {
+			continue
+		}
+		offset, length := record.u32(8), record.u32(12)
+		if offset+length > len(ttf) {
+			return nil
+		}
+		return fontTable(ttf[offset : offset+length])
+	}
+	return nil
+}
+
+// glyphSubstitutions maps glyphs to the glyphs an OpenType feature replaces them with
+type glyphSubstitutions map[truetype.Index]truetype.Index
+
+// parseSingleSubstitutions collects the single substitutions (GSUB lookup type 1, also
+// when wrapped in an extension lookup) of feature in the font file ttf, for every script
+// and language. It returns nil when the font does not have the feature.
+func parseSingleSubstitutions(ttf []byte, feature string) glyphSubstitutions {
+	gsub := sfntTable(ttf, "GSUB")
+	if gsub == nil {
+		return nil
+	}
+	features, lookups := gsub.from(gsub.u16(6)), gsub.from(gsub.u16(8))
+
+	subs := make(glyphSubstitutions)
+	for i := range features.u16(0) {
+		record := features.from(2 + 6*i)
+		if len(record) < 6 || string(record[:4]) != feature {
+			continue
+		}
+		table := features.from(record.u16(4))
+		for j := range table.u16(2) {
+			lookup := lookups.from(lookups.u16(2 + 2*table.u16(4+2*j)))
+			for k := range lookup.u16(4) {
+				sub, kind := lookup.from(lookup.u16(6+2*k)), lookup.u16(0)
+				if kind == 7 {
+					kind, sub = sub.u16(2), sub.from(sub.u32(4))
+				}
+				if kind == 1 {
+					addSingleSubstitutions(subs, sub)
+				}
+			}
+		}
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	return subs
+}
+
+// addSingleSubstitutions adds the mappings of a single substitution subtable to subs
+func addSingleSubstitutions(subs glyphSubstitutions, sub fontTable) {
+	coverage := coverageGlyphs(sub.from(sub.u16(2)))
+	switch sub.u16(0) {
+	case 1:
+		delta := int16(sub.u16(4))
+		for _, glyph := range coverage {
+			subs[glyph] = truetype.Index(uint16(int(glyph) + int(delta)))
+		}
+	case 2:
+		for i, glyph := range coverage {
+			if i < sub.u16(4) {
+				subs[glyph] = truetype.Index(sub.u16(6 + 2*i))
+			}
+		}
+	}
+}
+
+// coverageGlyphs lists the glyphs of a coverage table in coverage index order
+func coverageGlyphs(coverage fontTable) []truetype.Index {
+	var glyphs []truetype.Index
+	switch coverage.u16(0) {
+	case 1:
+		for i := range coverage.u16(2) {
+			glyphs = append(glyphs, truetype.Index(coverage.u16(4+2*i)))
+		}
+	case 2:
+		for i := range coverage.u16(2) {
+			start, end := coverage.u16(4+6*i), coverage.u16(6+6*i)
+			for glyph := start; glyph <= end; glyph++ {
+				glyphs = append(glyphs, truetype.Index(glyph))
+			}
+		}
+	}
+	return glyphs
+}
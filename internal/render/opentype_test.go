@@ -0,0 +1,155 @@
+package render
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// be16 appends big-endian 16-bit values to b
+func be16(b []byte, vs ...int) []byte {
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint16(b, uint16(v))
+	}
+	return b
+}
+
+// gsubLookup is one lookup of a test GSUB table: its type and a single subtable
+type gsubLookup struct {
+	kind     int
+	subtable []byte
+}
+
+// buildGSUB returns a GSUB table with an empty script list and one feature using every lookup
+func buildGSUB(feature string, lookups ...gsubLookup) []byte {
+	const headerSize, scriptListSize = 10, 2
+	featureList := be16([]byte{}, 1)
+	featureList = append(featureList, feature...)
+	featureList = be16(featureList, 8, 0, len(lookups))
+	for i := range lookups {
+		featureList = be16(featureList, i)
+	}
+
+	lookupList := be16([]byte{}, len(lookups))
+	var bodies []byte
+	for _, l := range lookups {
+		lookupList = be16(lookupList, 2+2*len(lookups)+len(bodies))
+		bodies = be16(bodies, l.kind, 0, 1, 8)
+		bodies = append(bodies, l.subtable...)
+	}
+	lookupList = append(lookupList, bodies...)
+
+	gsub := be16([]byte{}, 1, 0, headerSize, headerSize+scriptListSize, headerSize+scriptListSize+len(featureList))
+	gsub = be16(gsub, 0)
+	gsub = append(gsub, featureList...)
+	return append(gsub, lookupList...)
+}
+
+// singleSubstFormat2 returns a single substitution subtable replacing each glyph of from with
+// the glyph at the same position of to; from must be sorted
+func singleSubstFormat2(from, to []int) []byte {
+	sub := be16([]byte{}, 2, 6+2*len(to), len(to))
+	sub = be16(sub, to...)
+	sub = be16(sub, 1, len(from))
+	return be16(sub, from...)
+}
+
+// singleSubstFormat1 returns a single substitution subtable adding delta to the glyphs first
+// through last, with a range coverage table
+func singleSubstFormat1(first, last, delta int) []byte {
+	return be16([]byte{}, 1, 6, delta, 2, 1, first, last, 0)
+}
+
+// extensionLookup wraps a subtable of kind in an extension subtable (lookup type 7)
+func extensionLookup(kind int, subtable []byte) gsubLookup {
+	ext := be16([]byte{}, 1, kind)
+	ext = binary.BigEndian.AppendUint32(ext, 8)
+	return gsubLookup{kind: 7, subtable: append(ext, subtable...)}
+}
+
+// withTable returns the font file ttf with table added under tag
+func withTable(ttf []byte, tag string, table []byte) []byte {
+	numTables := int(binary.BigEndian.Uint16(ttf[4:]))
+	out := slices.Clone(ttf[:12])
+	binary.BigEndian.PutUint16(out[4:], uint16(numTables+1))
+	// Every table moves down by the new directory record
+	for i := range numTables {
+		record := slices.Clone(ttf[12+16*i : 28+16*i])
+		binary.BigEndian.PutUint32(record[8:], binary.BigEndian.Uint32(record[8:])+16)
+		out = append(out, record...)
+	}
+	rest := ttf[12+16*numTables:]
+	tableOffset := len(out) + 16 + len(rest)
+	tableOffset += (4 - tableOffset%4) % 4
+	out = append(out, tag...)
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint32(out, uint32(tableOffset))
+	out = binary.BigEndian.AppendUint32(out, uint32(len(table)))
+	out = append(out, rest...)
+	for len(out) < tableOffset {
+		out = append(out, 0)
+	}
+	return append(out, table...)
+}
+
+// smallCapsFont returns goregular with an smcp feature mapping the lowercase letters to the
+// capitals, standing in for a font with real small capitals
+func smallCapsFont(t *testing.T) []byte {
+	t.Helper()
+	f, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatalf("parse goregular: %v", err)
+	}
+	var from, to []int
+	for c := 'a'; c <= 'z'; c++ {
+		from, to = append(from, int(f.Index(c))), append(to, int(f.Index(c-'a'+'A')))
+	}
+	// Coverage tables list glyphs in ascending order
+	order := make([]int, len(from))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return from[a] - from[b] })
+	var sortedFrom, sortedTo []int
+	for _, i := range order {
+		sortedFrom, sortedTo = append(sortedFrom, from[i]), append(sortedTo, to[i])
+	}
+	return withTable(goregular.TTF, "GSUB", buildGSUB("smcp", gsubLookup{kind: 1, subtable: singleSubstFormat2(sortedFrom, sortedTo)}))
+}
+
+func TestParseSingleSubstitutions(t *testing.T) {
+	gsub := buildGSUB("smcp",
+		gsubLookup{kind: 1, subtable: singleSubstFormat2([]int{10, 12}, []int{20, 22})},
+		extensionLookup(1, singleSubstFormat1(30, 32, -5)),
+		gsubLookup{kind: 4, subtable: be16([]byte{}, 1, 0, 0)},
+	)
+	ttf := withTable(goregular.TTF, "GSUB", gsub)
+	if _, err := truetype.Parse(ttf); err != nil {
+		t.Fatalf("expected the font to stay valid got %v", err)
+	}
+
+	subs := parseSingleSubstitutions(ttf, "smcp")
+	want := glyphSubstitutions{10: 20, 12: 22, 30: 25, 31: 26, 32: 27}
+	if len(subs) != len(want) {
+		t.Fatalf("expected %v got %v", want, subs)
+	}
+	for from, to := range want {
+		if subs[from] != to {
+			t.Fatalf("expected %d -> %d got %v", from, to, subs)
+		}
+	}
+
+	if subs := parseSingleSubstitutions(ttf, "c2sc"); subs != nil {
+		t.Fatalf("expected no substitutions for a missing feature got %v", subs)
+	}
+	if subs := parseSingleSubstitutions(goregular.TTF, "smcp"); subs != nil {
+		t.Fatalf("expected no substitutions without a GSUB table got %v", subs)
+	}
+	// Truncated tables must not panic
+	for n := range len(gsub) {
+		parseSingleSubstitutions(withTable(goregular.TTF, "GSUB", gsub[:n]), "smcp")
+	}
+}
@@ -0,0 +1,84 @@
+package render
+
+import (
+	"image"
+)
+
+// Bounds of the Pixelate grid, in blocks per side
+const (
+	MinPixelate = 2
+	MaxPixelate = 64
+)
+
+// pixelGrid returns the number of blocks per side for a pixelate request, clamped to the
+// supported range and to the image so no block is narrower than a pixel
+func pixelGrid(n, w, h int) int {
+	return min(max(n, MinPixelate), MaxPixelate, w, h)
+}
+
+// pixelBlock returns the bounds of block (i, j) of an n x n grid over a w x h image
+func pixelBlock(i, j, n, w, h int) image.Rectangle {
+	return image.Rect(i*w/n, j*h/n, (i+1)*w/n, (j+1)*h/n)
+}
+
+// pixelate downsamples img to an n x n grid by averaging each block and scales it back up
+// with nearest-neighbor, so every block is one flat color. Channels are averaged
+// premultiplied, so transparent pixels do not darken the edges of a shape.
+func pixelate(img *image.RGBA, n int) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	n = pixelGrid(n, w, h)
+	for j := range n {
+		for i := range n {
+			block := pixelBlock(i, j, n, w, h).Add(img.Bounds().Min)
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					o := img.PixOffset(x, y)
+					for c := range 4 {
+						sum[c] += int(img.Pix[o+c])
+					}
+				}
+			}
+			count := block.Dx() * block.Dy()
+			var avg [4]uint8
+			for c := range 4 {
+				avg[c] = uint8((sum[c] + count/2) / count)
+			}
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					copy(img.Pix[img.PixOffset(x, y):], avg[:])
+				}
+			}
+		}
+	}
+}
+
+// writeSVGPixelated draws img, already pixelated to an n x n grid, as one rect per block.
+// Fully transparent blocks are left out; crispEdges keeps neighboring blocks from showing
+// hairline seams.
+func writeSVGPixelated(sw *svgWriter, img *image.RGBA, n int) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	n = pixelGrid(n, w, h)
+	sw.writeString(`<g shape-rendering="crispEdges">` + "\n")
+	for j := range n {
+		for i := range n {
+			block := pixelBlock(i, j, n, w, h)
+			o := img.PixOffset(block.Min.X+img.Bounds().Min.X, block.Min.Y+img.Bounds().Min.Y)
+			a := img.Pix[o+3]
+			if a == 0 {
+				continue
+			}
+			// Unpremultiply for the fill; the alpha goes into fill-opacity
+			var rgb [3]uint8
+			for c := range 3 {
+				rgb[c] = uint8(min((int(img.Pix[o+c])*255+int(a)/2)/int(a), 255))
+			}
+			sw.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"`, block.Min.X, block.Min.Y, block.Dx(), block.Dy(), rgb[0], rgb[1], rgb[2])
+			if a < 255 {
+				sw.printf(` fill-opacity="%g"`, round2(float64(a)/255))
+			}
+			sw.writeString(" />\n")
+		}
+	}
+	sw.writeString("</g>\n")
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"strings"
+
+	"grout/internal/config"
+)
+
+// PowerOfTwo selects how output dimensions are rounded to a power of two, as GPU texture
+// atlases require
+type PowerOfTwo string
+
+const (
+	PowerOfTwoNone    PowerOfTwo = ""        // Dimensions are used as requested
+	PowerOfTwoUp      PowerOfTwo = "up"      // Next power of two at or above the dimension
+	PowerOfTwoDown    PowerOfTwo = "down"    // Power of two at or below the dimension
+	PowerOfTwoNearest PowerOfTwo = "nearest" // Closer of the two; ties round up
+)
+
+// ParsePowerOfTwo converts a query value into a PowerOfTwo; empty means PowerOfTwoNone.
+func ParsePowerOfTwo(s string) (PowerOfTwo, bool) {
+	switch p := PowerOfTwo(strings.ToLower(s)); p {
+	case PowerOfTwoNone, PowerOfTwoUp, PowerOfTwoDown, PowerOfTwoNearest:
+		return p, true
+	default:
+		return PowerOfTwoNone, false
+	}
+}
+
+// Round returns n rounded to a power of two in the selected direction. The result never
+// exceeds the largest power of two within config.MaxImageSize.
+func (p PowerOfTwo) Round(n int) int {
+	if p == PowerOfTwoNone || n <= 0 {
+		return n
+	}
+	limit := 1
+	for limit*2 <= config.MaxImageSize {
+		limit *= 2
+	}
+	down := 1
+	for down*2 <= n && down < limit {
+		down *= 2
+	}
+	up := down
+	if down < n && down < limit {
+		up = down * 2
+	}
+	switch p {
+	case PowerOfTwoUp:
+		return up
+	case PowerOfTwoDown:
+		return down
+	default:
+		if n-down < up-n {
+			return down
+		}
+		return up
+	}
+}
@@ -0,0 +1,51 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ProvenanceKey names the PNG tEXt chunk that carries Options.Provenance
+const ProvenanceKey = "grout:provenance"
+
+// pngHeaderLength covers the PNG signature and the IHDR chunk, which must come first
+const pngHeaderLength = 8 + 4 + 4 + 13 + 4
+
+// writeSVGMetadata writes the provenance as the document's <metadata> element
+func writeSVGMetadata(sw *svgWriter, provenance string) {
+	sw.printf(`<metadata id="%s">%s</metadata>`, ProvenanceKey, escapeXML(provenance))
+	sw.writeString("\n")
+}
+
+// insertPNGText adds a tEXt chunk right after the IHDR chunk of an encoded PNG.
+// Keyword and text must be Latin-1; the keyword is 1-79 bytes long.
+func insertPNGText(data []byte, keyword, text string) ([]byte, error) {
+	chunk := make([]byte, 0, len(keyword)+1+len(text))
+	chunk = append(chunk, keyword...)
+	chunk = append(chunk, 0)
+	chunk = append(chunk, text...)
+	return insertPNGChunk(data, "tEXt", chunk)
+}
+
+// insertPNGChunk adds a chunk of the given type right after the IHDR chunk of an encoded
+// PNG, which is before PLTE and IDAT as ancillary chunks like sRGB require
+func insertPNGChunk(data []byte, chunkType string, chunk []byte) ([]byte, error) {
+	if len(data) < pngHeaderLength || !bytes.Equal(data[12:16], []byte("IHDR")) {
+		return nil, errors.New("insert png " + chunkType + ": missing IHDR chunk")
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data) + len(chunk) + 12)
+	out.Write(data[:pngHeaderLength])
+	_ = binary.Write(&out, binary.BigEndian, uint32(len(chunk)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(chunk)
+	out.WriteString(chunkType)
+	out.Write(chunk)
+	_ = binary.Write(&out, binary.BigEndian, crc.Sum32())
+	out.Write(data[pngHeaderLength:])
+	return out.Bytes(), nil
+}
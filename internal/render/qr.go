@@ -0,0 +1,108 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fogleman/gg"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QRLevel is the error correction level of a QR code: how much of the code may be damaged
+// or covered and still decode. Higher levels need more modules for the same text.
+type QRLevel string
+
+const (
+	QRLevelLow      QRLevel = "L" // Recovers 7% of the code
+	QRLevelMedium   QRLevel = "M" // Recovers 15% of the code
+	QRLevelQuartile QRLevel = "Q" // Recovers 25% of the code
+	QRLevelHigh     QRLevel = "H" // Recovers 30% of the code
+)
+
+// qrRecovery maps each level onto the encoder's names for it
+var qrRecovery = map[QRLevel]qrcode.RecoveryLevel{
+	QRLevelLow:      qrcode.Low,
+	QRLevelMedium:   qrcode.Medium,
+	QRLevelQuartile: qrcode.High,
+	QRLevelHigh:     qrcode.Highest,
+}
+
+// QRQuietZone is the light margin, in modules, that readers need around a QR code
+const QRQuietZone = 4
+
+// ParseQRLevel converts L, M, Q or H, in either case, into a QRLevel
+func ParseQRLevel(s string) (QRLevel, bool) {
+	level := QRLevel(strings.ToUpper(s))
+	_, ok := qrRecovery[level]
+	return level, ok
+}
+
+// QRModules returns the modules per side of the QR code encoding text at level, quiet zone
+// included. It fails when text does not fit the largest QR version, 40, at that level.
+func QRModules(text string, level QRLevel) (int, error) {
+	bitmap, err := qrBitmap(text, level)
+	if err != nil {
+		return 0, err
+	}
+	return len(bitmap), nil
+}
+
+// qrBitmap encodes text as a QR code; bitmap[y][x] is true for dark modules and includes
+// the quiet zone
+func qrBitmap(text string, level QRLevel) ([][]bool, error) {
+	recovery, ok := qrRecovery[level]
+	if !ok {
+		return nil, fmt.Errorf("unknown QR error correction level %q", level)
+	}
+	code, err := qrcode.New(text, recovery)
+	if err != nil {
+		return nil, fmt.Errorf("qr: %w", err)
+	}
+	return code.Bitmap(), nil
+}
+
+// qrPlacement returns the offset and whole-pixel module size that center a QR code of
+// modules per side in a w x h image, so raster modules stay crisp
+func qrPlacement(w, h, modules int) (x, y, size int) {
+	size = max(1, min(w, h)/modules)
+	return (w - size*modules) / 2, (h - size*modules) / 2, size
+}
+
+// writeSVGQR writes the QR code for text as a single path of dark modules filled with fill
+func writeSVGQR(sw *svgWriter, text string, level QRLevel, w, h int, fill string) {
+	bitmap, err := qrBitmap(text, level)
+	if err != nil {
+		if sw.err == nil {
+			sw.err = err
+		}
+		return
+	}
+	x, y, size := qrPlacement(w, h, len(bitmap))
+	sw.printf(`<path transform="translate(%d %d) scale(%d)" fill="%s" shape-rendering="crispEdges" d="`, x, y, size, fill)
+	for row, modules := range bitmap {
+		for col, dark := range modules {
+			if dark {
+				sw.printf("M%d %dh1v1h-1z", col, row)
+			}
+		}
+	}
+	sw.writeString(`" />`)
+}
+
+// drawQR fills the dark modules of the QR code for text using the current color
+func drawQR(dc *gg.Context, text string, level QRLevel, w, h int) error {
+	bitmap, err := qrBitmap(text, level)
+	if err != nil {
+		return err
+	}
+	x, y, size := qrPlacement(w, h, len(bitmap))
+	for row, modules := range bitmap {
+		for col, dark := range modules {
+			if dark {
+				dc.DrawRectangle(float64(x+col*size), float64(y+row*size), float64(size), float64(size))
+			}
+		}
+	}
+	dc.Fill()
+	return nil
+}
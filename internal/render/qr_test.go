@@ -0,0 +1,300 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readQRModules reads the module grid back out of a rendered QR code by sampling the center
+// of every module, dark meaning the foreground color
+func readQRModules(t *testing.T, data []byte, w, h, modules int, fg string) [][]bool {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	x, y, size := qrPlacement(w, h, modules)
+	grid := make([][]bool, modules)
+	for row := range grid {
+		grid[row] = make([]bool, modules)
+		for col := range grid[row] {
+			grid[row][col] = hexAt(img, x+col*size+size/2, y+row*size+size/2) == fg
+		}
+	}
+	return grid
+}
+
+var svgQRModuleRegex = regexp.MustCompile(`M(\d+) (\d+)h1v1h-1z`)
+
+func TestQRCode(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	const text = "https://example.org/grout?demo=1"
+
+	for _, level := range []QRLevel{QRLevelLow, QRLevelMedium, QRLevelQuartile, QRLevelHigh} {
+		t.Run(string(level), func(t *testing.T) {
+			want, err := qrBitmap(text, level)
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			modules, err := QRModules(text, level)
+			if err != nil || modules != len(want) {
+				t.Fatalf("expected %d modules got %d: %v", len(want), modules, err)
+			}
+			opts := Options{Width: 300, Height: 240, Background: "ffffff", Foreground: "123456", QR: text, QRLevel: level, Format: FormatPNG}
+
+			data, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("render png: %v", err)
+			}
+			pngModules := readQRModules(t, data, opts.Width, opts.Height, modules, "123456")
+			if fmt.Sprint(pngModules) != fmt.Sprint(want) {
+				t.Fatal("expected the PNG modules to match the encoded bitmap")
+			}
+			if got := decodeQR(t, pngModules); got != text {
+				t.Fatalf("expected the PNG to decode as %q got %q", text, got)
+			}
+
+			opts.Format = FormatSVG
+			svg, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("render svg: %v", err)
+			}
+			got := make([][]bool, modules)
+			for row := range got {
+				got[row] = make([]bool, modules)
+			}
+			for _, m := range svgQRModuleRegex.FindAllStringSubmatch(string(svg), -1) {
+				col, _ := strconv.Atoi(m[1])
+				row, _ := strconv.Atoi(m[2])
+				got[row][col] = true
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatal("expected the SVG modules to match the encoded bitmap")
+			}
+			if decoded := decodeQR(t, got); decoded != text {
+				t.Fatalf("expected the SVG to decode as %q got %q", text, decoded)
+			}
+			if !strings.Contains(string(svg), `fill="#123456" shape-rendering="crispEdges"`) {
+				t.Fatalf("expected the modules in the foreground color, got %s", svg)
+			}
+		})
+	}
+
+	t.Run("Higher levels need more modules", func(t *testing.T) {
+		low, _ := QRModules(text, QRLevelLow)
+		high, _ := QRModules(text, QRLevelHigh)
+		if high <= low {
+			t.Fatalf("expected level H to need more than %d modules got %d", low, high)
+		}
+	})
+
+	t.Run("Too long", func(t *testing.T) {
+		// Version 40 holds 2953 bytes at level L and 1273 at level H
+		if _, err := QRModules(strings.Repeat("x", 2953), QRLevelLow); err != nil {
+			t.Fatalf("expected the largest level L code to fit: %v", err)
+		}
+		if _, err := QRModules(strings.Repeat("x", 1274), QRLevelHigh); err == nil {
+			t.Fatal("expected an error beyond the level H capacity")
+		}
+	})
+
+	t.Run("Levels", func(t *testing.T) {
+		if level, ok := ParseQRLevel("q"); !ok || level != QRLevelQuartile {
+			t.Fatalf("expected q to parse as Q got %q %t", level, ok)
+		}
+		if _, ok := ParseQRLevel("X"); ok {
+			t.Fatal("expected X to be rejected")
+		}
+	})
+}
+
+// qrBlocks lists, for QR versions 1 to 6 and each level, the error correction codewords per
+// block and the data codewords of every block in order
+var qrBlocks = map[int]map[QRLevel]struct {
+	ec   int
+	data []int
+}{
+	1: {QRLevelLow: {7, []int{19}}, QRLevelMedium: {10, []int{16}}, QRLevelQuartile: {13, []int{13}}, QRLevelHigh: {17, []int{9}}},
+	2: {QRLevelLow: {10, []int{34}}, QRLevelMedium: {16, []int{28}}, QRLevelQuartile: {22, []int{22}}, QRLevelHigh: {28, []int{16}}},
+	3: {QRLevelLow: {15, []int{55}}, QRLevelMedium: {26, []int{44}}, QRLevelQuartile: {18, []int{17, 17}}, QRLevelHigh: {22, []int{13, 13}}},
+	4: {QRLevelLow: {20, []int{80}}, QRLevelMedium: {18, []int{32, 32}}, QRLevelQuartile: {26, []int{24, 24}}, QRLevelHigh: {16, []int{9, 9, 9, 9}}},
+	5: {QRLevelLow: {26, []int{108}}, QRLevelMedium: {24, []int{43, 43}}, QRLevelQuartile: {18, []int{15, 15, 16, 16}}, QRLevelHigh: {22, []int{11, 11, 12, 12}}},
+	6: {QRLevelLow: {18, []int{68, 68}}, QRLevelMedium: {16, []int{27, 27, 27, 27}}, QRLevelQuartile: {24, []int{19, 19, 19, 19}}, QRLevelHigh: {28, []int{15, 15, 15, 15}}},
+}
+
+// qrFormatBits returns the masked 15 bit format information for level and mask
+func qrFormatBits(level QRLevel, mask int) int {
+	ecBits := map[QRLevel]int{QRLevelLow: 1, QRLevelMedium: 0, QRLevelQuartile: 3, QRLevelHigh: 2}[level]
+	data := ecBits<<3 | mask
+	rem := data << 10
+	for i := 14; i >= 10; i-- {
+		if rem&(1<<i) != 0 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrMasked reports whether mask inverts the module at row, col
+func qrMasked(mask, row, col int) bool {
+	switch mask {
+	case 0:
+		return (row+col)%2 == 0
+	case 1:
+		return row%2 == 0
+	case 2:
+		return col%3 == 0
+	case 3:
+		return (row+col)%3 == 0
+	case 4:
+		return (row/2+col/3)%2 == 0
+	case 5:
+		return row*col%2+row*col%3 == 0
+	case 6:
+		return (row*col%2+row*col%3)%2 == 0
+	default:
+		return ((row+col)%2+row*col%3)%2 == 0
+	}
+}
+
+// decodeQR decodes the text of an undamaged QR code of version 1 to 6 from its module grid,
+// quiet zone included. It is independent of the encoder so tests can round-trip the output.
+func decodeQR(t *testing.T, grid [][]bool) string {
+	t.Helper()
+	border := 0
+	for border < len(grid) && !grid[border][border] {
+		border++
+	}
+	dim := len(grid) - 2*border
+	version := (dim - 17) / 4
+	if dim < 21 || (dim-17)%4 != 0 || qrBlocks[version] == nil {
+		t.Fatalf("decoder only reads versions 1 to 6, got %d modules", dim)
+	}
+	dark := func(row, col int) bool { return grid[border+row][border+col] }
+
+	// Finder patterns with separators and format information, timing patterns and the
+	// single alignment pattern of versions 2 to 6
+	function := func(row, col int) bool {
+		switch {
+		case row <= 8 && (col <= 8 || col >= dim-8), row >= dim-8 && col <= 8, row == 6, col == 6:
+			return true
+		}
+		center := 4*version + 10
+		return version > 1 && row >= center-2 && row <= center+2 && col >= center-2 && col <= center+2
+	}
+
+	format := 0
+	for _, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		format <<= 1
+		if dark(p[0], p[1]) {
+			format |= 1
+		}
+	}
+	level, mask := QRLevel(""), -1
+	for _, l := range []QRLevel{QRLevelLow, QRLevelMedium, QRLevelQuartile, QRLevelHigh} {
+		for m := range 8 {
+			if qrFormatBits(l, m) == format {
+				level, mask = l, m
+			}
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("unreadable format information %015b", format)
+	}
+
+	// Codewords are read in two module wide columns from the right, alternating up and down
+	var raw []byte
+	var cur byte
+	bits := 0
+	up := true
+	for right := dim - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := range dim {
+			row := i
+			if up {
+				row = dim - 1 - i
+			}
+			for _, col := range []int{right, right - 1} {
+				if function(row, col) {
+					continue
+				}
+				cur <<= 1
+				if dark(row, col) != qrMasked(mask, row, col) {
+					cur |= 1
+				}
+				if bits++; bits == 8 {
+					raw, cur, bits = append(raw, cur), 0, 0
+				}
+			}
+		}
+		up = !up
+	}
+
+	// Data codewords are interleaved across the blocks
+	blocks := qrBlocks[version][level]
+	data := make([][]byte, len(blocks.data))
+	k := 0
+	for i := range slices.Max(blocks.data) {
+		for b, n := range blocks.data {
+			if i < n {
+				data[b] = append(data[b], raw[k])
+				k++
+			}
+		}
+	}
+	stream := slices.Concat(data...)
+
+	pos := 0
+	read := func(n int) int {
+		v := 0
+		for range n {
+			if pos >= len(stream)*8 {
+				t.Fatal("QR data ended inside a segment")
+			}
+			v = v<<1 | int(stream[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+	var text strings.Builder
+	for pos+4 <= len(stream)*8 {
+		switch mode := read(4); mode {
+		case 0:
+			return text.String()
+		case 1:
+			for n := read(10); n > 0; n -= 3 {
+				digits := min(n, 3)
+				fmt.Fprintf(&text, "%0*d", digits, read([]int{0, 4, 7, 10}[digits]))
+			}
+		case 2:
+			for n := read(9); n > 0; n -= 2 {
+				if n == 1 {
+					text.WriteByte(alphanumeric[read(6)])
+					break
+				}
+				v := read(11)
+				text.WriteByte(alphanumeric[v/45])
+				text.WriteByte(alphanumeric[v%45])
+			}
+		case 4:
+			for n := read(8); n > 0; n-- {
+				text.WriteByte(byte(read(8)))
+			}
+		default:
+			t.Fatalf("unsupported QR mode %04b", mode)
+		}
+	}
+	return text.String()
+}
@@ -3,12 +3,14 @@ package render
 import (
 	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"math"
 	"strconv"
 	"strings"
 
@@ -36,15 +38,138 @@ func parseGradientColors(bgHex string) (string, string) {
 	return "", ""
 }
 
+// Checkerboard preview colors and cell size in pixels
+const (
+	checkerCellSize = 8
+	checkerLight    = "ffffff"
+	checkerDark     = "cccccc"
+)
+
 // drawRasterImageWithWrapping renders a raster image with text wrapping support
-func (r *Renderer) drawRasterImageWithWrapping(w, h int, bgHex, fgHex, text string, rounded, bold bool, fontSize float64, isQuoteOrJoke bool, format ImageFormat) ([]byte, error) {
+func (r *Renderer) drawRasterImageWithWrapping(opts Options, fontSize float64) ([]byte, error) {
+	img, err := r.composeRaster(opts, fontSize)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := encodeImage(img, opts.Format, opts.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ColorProfile != ColorProfileNone {
+		if data, err = tagSRGB(data, opts.Format); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Provenance == "" || opts.Format != FormatPNG {
+		return data, nil
+	}
+	return insertPNGText(data, ProvenanceKey, opts.Provenance)
+}
+
+// composeRaster draws every layer and whole-image effect of opts onto a new image
+func (r *Renderer) composeRaster(opts Options, fontSize float64) (*image.RGBA, error) {
+	w, h := opts.Width, opts.Height
+	fgHex, text := opts.Foreground, opts.Text
+	isQuoteOrJoke := opts.QuoteOrJoke
+
 	dc := gg.NewContext(w, h)
 
-	// Check if bgHex contains a gradient (comma-separated colors)
-	color1, color2 := parseGradientColors(bgHex)
+	if opts.Checker {
+		drawChecker(dc, w, h)
+	}
+
+	if opts.Blur > 0 {
+		r.drawBlurredBackground(dc, opts)
+	} else {
+		r.drawBackground(dc, opts)
+	}
+	if opts.Vignette > 0 {
+		darkenEdges(dc.Image().(*image.RGBA), opts.Vignette)
+	}
+	// Keeping text unflipped, only the background is mirrored before the text is drawn
+	if opts.Flip != FlipNone && opts.FlipSkipsText {
+		flipImage(dc.Image().(*image.RGBA), opts.Flip)
+	}
+
+	// With GammaCorrect the text goes onto a layer of its own, blended in linear light below
+	base := dc
+	if opts.GammaCorrect {
+		dc = gg.NewContext(w, h)
+	}
+
+	fg := ParseHexColor(fgHex)
+	font := r.face(r.resolveFamily(opts.FontFamily), fontWeightFor(opts))
+	face := truetype.NewFace(font, &truetype.Options{Size: fontSize})
+	dc.SetFontFace(face)
+	dc.SetColor(fg)
+
+	// A bubble clips the text to its outline and centers it on the body, away from the tail
+	content := bubbleContent(opts)
+	var contentErr error
+	withBubbleContent(dc, opts, func() {
+		cw, ch := content.Width, content.Height
+		// Wrap text if it's a quote/joke (use wrapping for readability)
+		// Short text like initials or dimensions is drawn by the avatar style
+		if opts.QR != "" {
+			contentErr = drawQR(dc, opts.QR, opts.QRLevel, cw, ch)
+		} else if opts.Icon != "" {
+			contentErr = drawIcon(dc, opts.Icon, cw, ch)
+		} else if isQuoteOrJoke {
+			lines := r.wrapText(dc, text, float64(cw), fontSize)
+			drawMultiLineText(dc, lines, float64(cw), float64(ch), fontSize)
+		} else {
+			contentErr = r.styleDrawer(opts.Style).DrawRaster(dc, r.styleContext(content, fontSize))
+		}
+
+		if contentErr == nil && hasTagline(content) {
+			r.drawTagline(dc, content)
+		}
+	})
+	if contentErr != nil {
+		return nil, contentErr
+	}
+
+	if opts.RingText != "" {
+		r.drawRingText(dc, opts)
+	}
+	if opts.GammaCorrect {
+		blendLinear(base.Image().(*image.RGBA), dc.Image().(*image.RGBA))
+		dc = base
+	}
+
+	if hasRibbon(opts) {
+		r.drawRibbon(dc, opts)
+	}
+
+	if opts.BadgeColor != "" && opts.Flip != FlipNone && opts.FlipSkipsText {
+		withFlip(dc, opts, func() { drawBadge(dc, opts) })
+	} else if opts.BadgeColor != "" {
+		drawBadge(dc, opts)
+	}
+
+	if opts.Flip != FlipNone && !opts.FlipSkipsText {
+		flipImage(dc.Image().(*image.RGBA), opts.Flip)
+	}
+	if opts.Pixelate > 0 {
+		pixelate(dc.Image().(*image.RGBA), opts.Pixelate)
+	}
+	if opts.Grayscale > 0 {
+		desaturate(dc.Image().(*image.RGBA), opts.Grayscale)
+	}
+	if opts.Fade > 0 {
+		fade(dc.Image().(*image.RGBA), opts.Fade)
+	}
+	return dc.Image().(*image.RGBA), nil
+}
+
+// drawBackground fills the background shape, then the optional tiled glyphs on top of it
+func (r *Renderer) drawBackground(dc *gg.Context, opts Options) {
+	// Check if the background contains a gradient (comma-separated colors)
+	color1, color2 := parseGradientColors(opts.Background)
 	if color1 != "" && color2 != "" {
 		// Create linear gradient from left to right
-		gradient := gg.NewLinearGradient(0, 0, float64(w), 0)
+		gradient := gg.NewLinearGradient(0, 0, float64(opts.Width), 0)
 		gradient.AddColorStop(0, ParseHexColor(color1))
 		gradient.AddColorStop(1, ParseHexColor(color2))
 		dc.SetFillStyle(gradient)
@@ -53,50 +178,106 @@ func (r *Renderer) drawRasterImageWithWrapping(w, h int, bgHex, fgHex, text stri
 		if color1 != "" {
 			dc.SetColor(ParseHexColor(color1))
 		} else {
-			dc.SetColor(ParseHexColor(bgHex))
+			dc.SetColor(ParseHexColor(opts.Background))
 		}
 	}
+	traceShape(dc, opts)
+	dc.Fill()
 
-	fg := ParseHexColor(fgHex)
-	if rounded {
-		dc.DrawCircle(float64(w)/2, float64(h)/2, float64(w)/2)
-		dc.Fill()
-	} else {
-		dc.DrawRectangle(0, 0, float64(w), float64(h))
-		dc.Fill()
+	if glyph := tileGlyph(opts.Text); opts.Tile && glyph != "" {
+		r.drawTile(dc, opts, glyph)
 	}
+}
 
-	font := r.regular
-	if bold {
-		font = r.bold
+// traceShape adds the background shape's outline to the current path
+func traceShape(dc *gg.Context, opts Options) {
+	w, h := float64(opts.Width), float64(opts.Height)
+	switch opts.Shape {
+	case ShapeCircle:
+		// Use the smaller dimension so the circle fits non-square images
+		dc.DrawCircle(w/2, h/2, math.Min(w, h)/2)
+	case ShapeRounded:
+		dc.DrawRoundedRectangle(0, 0, w, h, opts.Radius)
+	case ShapeBubble:
+		bubbleFor(opts).trace(dc)
+	default:
+		dc.DrawRectangle(0, 0, w, h)
 	}
-	dc.SetFontFace(truetype.NewFace(font, &truetype.Options{Size: fontSize}))
-	dc.SetColor(fg)
+}
 
-	// Wrap text if it's a quote/joke (use wrapping for readability)
-	// For short text like initials or dimensions, use single-line rendering
-	if isQuoteOrJoke {
-		lines := r.wrapText(dc, text, float64(w), fontSize)
-		drawMultiLineText(dc, lines, float64(w), float64(h), fontSize)
-	} else {
-		// For initials/short text/dimensions, draw as single line
-		dc.DrawStringAnchored(text, float64(w)/2, float64(h)/2, 0.5, 0.5)
+// drawChecker fills the context with a light/dark checkerboard so transparency is visible
+func drawChecker(dc *gg.Context, w, h int) {
+	dc.SetColor(ParseHexColor(checkerLight))
+	dc.DrawRectangle(0, 0, float64(w), float64(h))
+	dc.Fill()
+
+	dc.SetColor(ParseHexColor(checkerDark))
+	for y := 0; y < h; y += checkerCellSize {
+		for x := 0; x < w; x += checkerCellSize {
+			if (x/checkerCellSize+y/checkerCellSize)%2 == 0 {
+				dc.DrawRectangle(float64(x), float64(y), checkerCellSize, checkerCellSize)
+			}
+		}
 	}
+	dc.Fill()
+}
+
+// DefaultQuality is the JPEG and WebP quality used when Encoding leaves it unset
+const DefaultQuality = 90
+
+// PNGCompression selects the zlib effort of PNG output
+type PNGCompression string
+
+const (
+	PNGCompressionDefault PNGCompression = "default"
+	PNGCompressionNone    PNGCompression = "none"
+	PNGCompressionFast    PNGCompression = "fast"
+	PNGCompressionBest    PNGCompression = "best"
+)
 
-	return encodeImage(dc.Image(), format)
+// level maps c to the encoder's compression level; unknown values use the default
+func (c PNGCompression) level() png.CompressionLevel {
+	switch c {
+	case PNGCompressionNone:
+		return png.NoCompression
+	case PNGCompressionFast:
+		return png.BestSpeed
+	case PNGCompressionBest:
+		return png.BestCompression
+	default:
+		return png.DefaultCompression
+	}
+}
+
+// Encoding holds the raster encoder settings. Zero values select the encoder defaults:
+// DefaultQuality for JPEG and lossy WebP, and zlib's default level for PNG.
+type Encoding struct {
+	JPEGQuality    int // 1-100
+	WebPQuality    int // 1-100, ignored when WebPLossless is set
+	WebPLossless   bool
+	PNGCompression PNGCompression
+}
+
+// quality returns q, or DefaultQuality when q is unset
+func quality(q int) int {
+	if q <= 0 {
+		return DefaultQuality
+	}
+	return q
 }
 
 // encodeImage encodes a rasterized image in the specified format (PNG, JPEG, GIF, WebP)
-func encodeImage(img image.Image, format ImageFormat) ([]byte, error) {
+func encodeImage(img image.Image, format ImageFormat, enc Encoding) ([]byte, error) {
 	var buf bytes.Buffer
 
 	switch format {
 	case FormatPNG:
-		if err := png.Encode(&buf, img); err != nil {
+		encoder := png.Encoder{CompressionLevel: enc.PNGCompression.level()}
+		if err := encoder.Encode(&buf, img); err != nil {
 			return nil, fmt.Errorf("encode png: %w", err)
 		}
 	case FormatJPG, FormatJPEG:
-		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality(enc.JPEGQuality)}); err != nil {
 			return nil, fmt.Errorf("encode jpeg: %w", err)
 		}
 	case FormatGIF:
@@ -104,7 +285,7 @@ func encodeImage(img image.Image, format ImageFormat) ([]byte, error) {
 			return nil, fmt.Errorf("encode gif: %w", err)
 		}
 	case FormatWebP:
-		if err := webp.Encode(&buf, img, &webp.Options{Lossless: false, Quality: 90}); err != nil {
+		if err := webp.Encode(&buf, img, &webp.Options{Lossless: enc.WebPLossless, Quality: float32(quality(enc.WebPQuality))}); err != nil {
 			return nil, fmt.Errorf("encode webp: %w", err)
 		}
 	default:
@@ -149,6 +330,47 @@ func GenerateColorHash(seed string) string {
 	return fmt.Sprintf("%02x%02x%02x", hash[0], hash[1], hash[2])
 }
 
+// ColorFromPalette deterministically picks a color from palette for the given seed.
+// The hash is spread across the whole palette so any palette size is usable.
+func ColorFromPalette(seed string, palette []string) string {
+	return ColorFromPaletteWithHash(seed, palette, HashMD5)
+}
+
+// ColorFromPaletteWithHash is ColorFromPalette using the given hash algorithm.
+// Without a palette the color is taken from the first three bytes of the digest.
+func ColorFromPaletteWithHash(seed string, palette []string, algo HashAlgorithm) string {
+	hash := algo.Sum(seed)
+	if len(palette) == 0 {
+		return fmt.Sprintf("%02x%02x%02x", hash[0], hash[1], hash[2])
+	}
+	return palette[binary.BigEndian.Uint32(hash[:4])%uint32(len(palette))]
+}
+
+// SaltedSeed mixes salt into a color seed so the same name maps to different colors per salt.
+// An empty salt returns seed unchanged, keeping unsalted colors stable.
+func SaltedSeed(seed, salt string) string {
+	if salt == "" {
+		return seed
+	}
+	return salt + "\x00" + seed
+}
+
+// DominantColor returns the 6 digit hex color covering most of an image with the given background:
+// the background itself, or the average of both stops for a gradient.
+func DominantColor(bgHex string) string {
+	color1, color2 := parseGradientColors(bgHex)
+	if color1 != "" && color2 != "" {
+		c1 := ParseHexColor(color1).(color.RGBA)
+		c2 := ParseHexColor(color2).(color.RGBA)
+		return fmt.Sprintf("%02x%02x%02x", (int(c1.R)+int(c2.R))/2, (int(c1.G)+int(c2.G))/2, (int(c1.B)+int(c2.B))/2)
+	}
+	if color1 != "" {
+		bgHex = color1
+	}
+	c := ParseHexColor(bgHex).(color.RGBA)
+	return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+}
+
 // GetContrastColor determines if white or black text should be used
 func GetContrastColor(bgHex string) string {
 	// Handle gradient colors by averaging the two colors
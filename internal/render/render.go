@@ -1,10 +1,13 @@
 package render
 
 import (
-	"fmt"
+	"io"
+	"math"
+	"strings"
 
-	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
 	"golang.org/x/image/font/gofont/goregular"
 
 	"grout/internal/config"
@@ -12,21 +15,30 @@ import (
 
 // Renderer is responsible for drawing avatars and placeholders.
 type Renderer struct {
-	regular *truetype.Font
-	bold    *truetype.Font
+	fonts  fontRegistry
+	styles styleRegistry
 }
 
-// New creates a renderer preloaded with embedded fonts.
+// New creates a renderer preloaded with the embedded Go fonts as the regular and bold
+// weights of DefaultFontFamily and MonoFontFamily, and with the built-in avatar styles.
 func New() (*Renderer, error) {
-	regular, err := truetype.Parse(goregular.TTF)
-	if err != nil {
-		return nil, fmt.Errorf("parse regular font: %w", err)
+	r := &Renderer{}
+	r.registerStyle(StyleDefault, initialsStyle{})
+	r.registerStyle(StyleTiles, tilesStyle{})
+	r.registerStyle(StyleWordmark, wordmarkStyle{})
+	if err := r.RegisterFont(DefaultFontFamily, WeightRegular, goregular.TTF); err != nil {
+		return nil, err
 	}
-	bold, err := truetype.Parse(gobold.TTF)
-	if err != nil {
-		return nil, fmt.Errorf("parse bold font: %w", err)
+	if err := r.RegisterFont(DefaultFontFamily, WeightBold, gobold.TTF); err != nil {
+		return nil, err
+	}
+	if err := r.RegisterFont(MonoFontFamily, WeightRegular, gomono.TTF); err != nil {
+		return nil, err
 	}
-	return &Renderer{regular: regular, bold: bold}, nil
+	if err := r.RegisterFont(MonoFontFamily, WeightBold, gomonobold.TTF); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 // ImageFormat represents the output image format
@@ -39,8 +51,192 @@ const (
 	FormatGIF  ImageFormat = "gif"
 	FormatWebP ImageFormat = "webp"
 	FormatSVG  ImageFormat = "svg"
+	FormatJSX  ImageFormat = "jsx" // SVG markup rewritten for JSX, served as text
+	FormatICO  ImageFormat = "ico" // Several PNG sizes in one icon file, see RenderICO
+)
+
+// IsRaster reports whether the format is a bitmap rather than SVG markup
+func (f ImageFormat) IsRaster() bool {
+	return f != FormatSVG && f != FormatJSX && f != ""
+}
+
+// Shape is the outline of the image background
+type Shape string
+
+const (
+	ShapeSquare  Shape = "square"
+	ShapeCircle  Shape = "circle"
+	ShapeRounded Shape = "rounded" // Square with corners rounded by Options.Radius
+	ShapeBubble  Shape = "bubble"  // Speech bubble with a tail pointing to Options.Tail
+)
+
+// ParseShape converts a query value into a Shape, reporting false for unknown shapes.
+func ParseShape(s string) (Shape, bool) {
+	switch Shape(strings.ToLower(s)) {
+	case ShapeSquare:
+		return ShapeSquare, true
+	case ShapeCircle:
+		return ShapeCircle, true
+	case ShapeRounded:
+		return ShapeRounded, true
+	case ShapeBubble:
+		return ShapeBubble, true
+	default:
+		return "", false
+	}
+}
+
+// Animation is an optional SVG animation; raster formats ignore it
+type Animation string
+
+const (
+	AnimationNone  Animation = ""
+	AnimationPulse Animation = "pulse" // Gently fades the image in and out, repeating
+	AnimationSpin  Animation = "spin"  // Rotates the image around its center, repeating
+	AnimationFade  Animation = "fade"  // Fades the image in once
 )
 
+// ParseAnimation converts a query value into an Animation; empty means none.
+func ParseAnimation(s string) (Animation, bool) {
+	switch Animation(strings.ToLower(s)) {
+	case AnimationNone:
+		return AnimationNone, true
+	case AnimationPulse:
+		return AnimationPulse, true
+	case AnimationSpin:
+		return AnimationSpin, true
+	case AnimationFade:
+		return AnimationFade, true
+	default:
+		return AnimationNone, false
+	}
+}
+
+// LetterSpacing is extra space between characters of single-line text, in pixels or em
+type LetterSpacing struct {
+	Value float64
+	Em    bool // Value is relative to the font size
+}
+
+// Maximum letter spacing in either direction, relative to the font size
+const (
+	minLetterSpacingEm = -0.25
+	maxLetterSpacingEm = 1.0
+)
+
+// pixels resolves the spacing for fontSize, clamped so glyphs neither collapse onto each
+// other nor drift more than a full em apart.
+func (ls LetterSpacing) pixels(fontSize float64) float64 {
+	px := ls.Value
+	if ls.Em {
+		px *= fontSize
+	}
+	return math.Max(minLetterSpacingEm*fontSize, math.Min(px, maxLetterSpacingEm*fontSize))
+}
+
+// Options describes a single render request.
+//
+// The SVG and raster pipelines compose the layers bottom to top in the same order:
+// checkerboard, background (solid or gradient), tile pattern, vignette, content (initials,
+// text, icon or QR code) with its tagline, ring text, ribbon and badge. Flip, pixelate, grayscale and
+// opacity then apply to the whole image. Rendering reads no clock, randomness or map order,
+// so the same Options always produce byte-identical output.
+type Options struct {
+	Width      int
+	Height     int
+	Background string // Hex color, or two comma-separated colors for a gradient
+	Foreground string // Hex text color
+	Text       string
+	Shape      Shape
+	Radius     float64    // Corner radius in pixels for ShapeRounded
+	Tail       Tail       // Side the tail of ShapeBubble points to; empty means TailBottom
+	Bold       bool       // Legacy shorthand for Weight: WeightBold
+	Weight     FontWeight // Font weight; falls back to regular when the family lacks it
+	// FontFamily is the registered family of the text; empty or unknown families use
+	// DefaultFontFamily. Ribbons, taglines and ring text keep the default family.
+	FontFamily string
+	Format     ImageFormat
+	// LetterSpacing spreads the characters of single-line text such as initials
+	LetterSpacing LetterSpacing
+	// Icon names a bundled icon drawn in place of the text
+	Icon string
+	// QR is text encoded as a QR code drawn in place of the text, at QRLevel error correction
+	QR      string
+	QRLevel QRLevel
+	// QuoteOrJoke wraps long text across lines and sizes the font for reading
+	QuoteOrJoke bool
+	// TextGradient fills single-line text with a left-to-right gradient between two
+	// comma-separated hex colors instead of Foreground
+	TextGradient string
+	// RingText is written along a circle at the edge around the initials, e.g. the full name;
+	// it is truncated with an ellipsis to fit
+	RingText string
+	// Style selects how the initials are laid out
+	Style Style
+	// Tagline is a line of smaller text, wrapped onto at most two lines, below the initials,
+	// which move up to make room. It is omitted on images smaller than MinTaglineSize
+	Tagline string
+	// Caps renders the text as small capitals or all capitals; CapsSmall needs a font with the
+	// OpenType smcp feature and draws the text as is otherwise
+	Caps Caps
+	// InitialsLayout stacks the initials of the default style one per row when InitialsVertical
+	InitialsLayout InitialsLayout
+	// TileColors fills the letter tiles of StyleTiles, one color per initial
+	TileColors []string
+	// Blur applies a Gaussian blur with this standard deviation, in pixels, to the background
+	// layer only; the text stays sharp. 0 disables it
+	Blur float64
+	// BadgeColor draws a status dot in this hex color at BadgeCorner, e.g. for presence; empty omits it
+	BadgeColor  string
+	BadgeCorner Corner
+	// Ribbon writes this text on a diagonal banner across the top-right corner, e.g. "DRAFT";
+	// it is omitted on images smaller than MinRibbonSize
+	Ribbon string
+	// Vignette darkens the edges of the background below the text, from 0 (off) to 1 (black corners)
+	Vignette float64
+	// Fade lowers the opacity of the whole composed image, from 0 (opaque) to 1 (invisible),
+	// e.g. for skeleton states; it is separate from any transparency of the background
+	Fade float64
+	// Flip mirrors the composed image; FlipSkipsText mirrors only the background and badge,
+	// drawing text unmirrored in its usual place
+	Flip          Flip
+	FlipSkipsText bool
+	// Grayscale desaturates the whole composed image, from 0 (unchanged) to 1 (fully gray)
+	Grayscale float64
+	// Pixelate reduces the composed image to a Pixelate x Pixelate grid of flat blocks for a
+	// retro look, clamped to MinPixelate..MaxPixelate; SVG output draws one rect per block.
+	// 0 disables it
+	Pixelate int
+	// Tile repeats the first character of Text across the background at reduced opacity
+	Tile bool
+	// Checker draws a checkerboard behind transparent areas so they are visible in previews
+	Checker bool
+	// Animate adds a small SMIL animation to SVG output
+	Animate Animation
+	// Provenance is embedded as SVG <metadata> or a PNG tEXt chunk; it does not affect rendering
+	Provenance string
+	// ColorProfile tags PNG and JPEG output as sRGB unless it is ColorProfileNone; empty means sRGB
+	ColorProfile ColorProfile
+	// Title is the accessible label of SVG output, written as <title> and aria-label with
+	// role="img"; empty leaves the image unlabeled
+	Title string
+	// GammaCorrect blends the text of raster output in linear light instead of sRGB, which keeps
+	// anti-aliased edges from turning muddy on colored backgrounds. SVG output is blended by
+	// the viewer and ignores it
+	GammaCorrect bool
+	// CSSVars paints the background and text of SVG output with var(--grout-bg, ...) and
+	// var(--grout-fg, ...) falling back to Background and Foreground, so embedders can
+	// recolor it with CSS; see CSSVarBackground. Raster output ignores it
+	CSSVars bool
+	// SymbolID wraps SVG output in a <symbol> with this id followed by a <use> of it, so pages
+	// can define an avatar once and reference it many times
+	SymbolID string
+	// Standalone prefixes SVG output with an XML declaration and doctype for saving as a .svg file
+	Standalone bool
+	// Encoding tunes the PNG, JPEG and WebP encoders; it does not affect SVG output
+	Encoding Encoding
+}
+
 // DrawImage renders an image with provided options.
 func (r *Renderer) DrawImage(w, h int, bgHex, fgHex, text string, rounded, bold bool) ([]byte, error) {
 	return r.DrawImageWithFormat(w, h, bgHex, fgHex, text, rounded, bold, FormatSVG)
@@ -48,57 +244,95 @@ func (r *Renderer) DrawImage(w, h int, bgHex, fgHex, text string, rounded, bold
 
 // DrawPlaceholderImage renders a placeholder image with optimized font sizing for quotes/jokes
 func (r *Renderer) DrawPlaceholderImage(w, h int, bgHex, fgHex, text string, isQuoteOrJoke bool, format ImageFormat) ([]byte, error) {
-	// Calculate font size based on whether it's a quote/joke or regular placeholder
-	var fontSize float64
-
-	if isQuoteOrJoke {
-		// For quotes/jokes, use dynamic sizing based on text length and image dimensions
-		// Start with a base size relative to height
-		fontSize = float64(h) * 0.08
-
-		// Adjust based on text length
-		textLen := len(text)
-		if textLen > 200 {
-			fontSize = float64(h) * 0.05
-		} else if textLen > 100 {
-			fontSize = float64(h) * 0.06
-		}
+	return r.DrawPlaceholder(Options{
+		Width:       w,
+		Height:      h,
+		Background:  bgHex,
+		Foreground:  fgHex,
+		Text:        text,
+		Shape:       ShapeSquare,
+		Bold:        true,
+		Format:      format,
+		QuoteOrJoke: isQuoteOrJoke,
+	})
+}
 
-		// Apply min/max bounds from config
-		if fontSize < config.MinFontSize {
-			fontSize = config.MinFontSize
-		}
-		if fontSize > config.MaxFontSize {
-			fontSize = config.MaxFontSize
-		}
-	} else {
-		// For regular placeholders (dimensions text, initials), use existing logic
-		minDim := float64(w)
-		if float64(h) < minDim {
-			minDim = float64(h)
-		}
+// DrawImageWithFormat renders an image in the specified format with provided options.
+func (r *Renderer) DrawImageWithFormat(w, h int, bgHex, fgHex, text string, rounded, bold bool, format ImageFormat) ([]byte, error) {
+	shape := ShapeSquare
+	if rounded {
+		shape = ShapeCircle
+	}
+	return r.DrawAvatar(Options{
+		Width:      w,
+		Height:     h,
+		Background: bgHex,
+		Foreground: fgHex,
+		Text:       text,
+		Shape:      shape,
+		Bold:       bold,
+		Format:     format,
+	})
+}
 
-		fontSize = minDim * 0.5
-		if len(text) > config.MinTextLengthForWrapping {
-			fontSize = minDim * 0.15
-			if fontSize < 12 {
-				fontSize = 12
-			}
-		}
+// DrawPlaceholder renders a placeholder, sizing the font for quotes/jokes when opts.QuoteOrJoke is set
+func (r *Renderer) DrawPlaceholder(opts Options) ([]byte, error) {
+	return r.render(opts, fontSizeFor(opts))
+}
+
+// fontSizeFor picks the font size for opts: reading size for quotes/jokes, avatar sizing otherwise
+func fontSizeFor(opts Options) float64 {
+	if !opts.QuoteOrJoke {
+		// For regular placeholders (dimensions text, initials), use existing logic
+		return initialsFontSize(opts)
 	}
 
-	// For SVG format, generate directly without rasterization
-	if format == FormatSVG {
-		return r.generateSVGWithWrapping(w, h, bgHex, fgHex, text, false, true, fontSize, isQuoteOrJoke)
+	// For quotes/jokes, use dynamic sizing based on text length and image dimensions
+	// Start with a base size relative to height, the body's in a bubble
+	h := bubbleContent(opts).Height
+	fontSize := float64(h) * 0.08
+
+	// Adjust based on text length
+	textLen := len(opts.Text)
+	if textLen > 200 {
+		fontSize = float64(h) * 0.05
+	} else if textLen > 100 {
+		fontSize = float64(h) * 0.06
 	}
 
-	// For raster formats, create the image using gg
-	return r.drawRasterImageWithWrapping(w, h, bgHex, fgHex, text, false, true, fontSize, isQuoteOrJoke, format)
+	// Apply min/max bounds from config
+	if fontSize < config.MinFontSize {
+		fontSize = config.MinFontSize
+	}
+	if fontSize > config.MaxFontSize {
+		fontSize = config.MaxFontSize
+	}
+	return fontSize
 }
 
-// DrawImageWithFormat renders an image in the specified format with provided options.
-func (r *Renderer) DrawImageWithFormat(w, h int, bgHex, fgHex, text string, rounded, bold bool, format ImageFormat) ([]byte, error) {
+// DrawAvatar renders an avatar with the given options
+func (r *Renderer) DrawAvatar(opts Options) ([]byte, error) {
 	// Calculate font size for consistent rendering across formats
+	return r.render(opts, initialsFontSize(opts))
+}
+
+// initialsFontSize is avatarFontSize, or verticalFontSize for stacked initials, shrunk to fit inside the ring when RingText is set
+func initialsFontSize(opts Options) float64 {
+	// In a bubble the initials are sized for its body; with a tagline, for the region above it
+	opts = bubbleContent(opts)
+	opts.Height = taglineInitialsHeight(opts)
+	fontSize := avatarFontSize(opts.Width, opts.Height, opts.Text)
+	if rows := verticalRows(opts); rows != nil {
+		fontSize = verticalFontSize(opts, len(rows))
+	}
+	if opts.RingText != "" {
+		return fontSize * ringInitialsScale
+	}
+	return fontSize
+}
+
+// avatarFontSize scales the font with the smaller dimension, shrinking it for longer text
+func avatarFontSize(w, h int, text string) float64 {
 	minDim := float64(w)
 	if float64(h) < minDim {
 		minDim = float64(h)
@@ -111,12 +345,37 @@ func (r *Renderer) DrawImageWithFormat(w, h int, bgHex, fgHex, text string, roun
 			fontSize = 12
 		}
 	}
+	return fontSize
+}
+
+// Render writes the image for opts to w. SVG is streamed as it is generated;
+// raster formats are encoded in full first.
+func (r *Renderer) Render(w io.Writer, opts Options) error {
+	if opts.Format == FormatSVG || opts.Format == "" {
+		return r.RenderSVG(w, opts)
+	}
+	data, err := r.render(opts, fontSizeFor(opts))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
 
+// render dispatches to the SVG or raster pipeline
+func (r *Renderer) render(opts Options, fontSize float64) ([]byte, error) {
 	// For SVG format, generate directly without rasterization
-	if format == FormatSVG {
-		return r.generateSVGWithWrapping(w, h, bgHex, fgHex, text, rounded, bold, fontSize, false)
+	if opts.Format == FormatSVG || opts.Format == "" {
+		return r.generateSVGWithWrapping(opts, fontSize)
+	}
+	if opts.Format == FormatJSX {
+		svg, err := r.generateSVGWithWrapping(opts, fontSize)
+		if err != nil {
+			return nil, err
+		}
+		return svgToJSX(svg), nil
 	}
 
 	// For raster formats, create the image using gg
-	return r.drawRasterImageWithWrapping(w, h, bgHex, fgHex, text, rounded, bold, fontSize, false, format)
+	return r.drawRasterImageWithWrapping(opts, fontSize)
 }
@@ -1,8 +1,24 @@
 package render
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"slices"
 	"strings"
 	"testing"
+	"unicode/utf8"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/text/language"
 )
 
 func TestGetInitials(t *testing.T) {
@@ -28,6 +44,158 @@ func TestGetInitials(t *testing.T) {
 	}
 }
 
+func TestGetInitialsWithMode(t *testing.T) {
+	cases := []struct {
+		name        string
+		input       string
+		mode        InitialsMode
+		maxInitials int
+		exp         string
+	}{
+		{"firstlast three words", "Mary Jane Watson", InitialsFirstLast, 2, "MW"},
+		{"firstn three words", "Mary Jane Watson", InitialsFirstN, 2, "MJ"},
+		{"all three words", "Mary Jane Watson", InitialsAll, 4, "MJW"},
+		{"all capped by max", "Mary Jane Watson", InitialsAll, 2, "MJ"},
+		{"firstlast capped to one", "Mary Jane Watson", InitialsFirstLast, 1, "M"},
+		{"firstn three initials", "Mary Jane Watson", InitialsFirstN, 3, "MJW"},
+		{"single word firstlast", "mary", InitialsFirstLast, 2, "M"},
+		{"single word all", "mary", InitialsAll, 4, "M"},
+		{"many words firstlast", "a b c d e f", InitialsFirstLast, 2, "AF"},
+		{"empty", "   ", InitialsAll, 4, ""},
+		{"zero max uses default", "Mary Jane Watson", InitialsFirstN, 0, "MJ"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := GetInitialsWithMode(tc.input, tc.mode, tc.maxInitials); got != tc.exp {
+				t.Fatalf("expected %q got %q", tc.exp, got)
+			}
+		})
+	}
+}
+
+func TestGetInitialsWithSplit(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		split WordSplit
+		mode  InitialsMode
+		exp   string
+	}{
+		{"camelCase", "johnDoe", SplitCamelHump, InitialsFirstN, "JD"},
+		{"PascalCase", "JohnDoe", SplitCamelHump, InitialsFirstN, "JD"},
+		{"snake_case", "john_doe", SplitCamelHump, InitialsFirstN, "JD"},
+		{"kebab-case", "john-doe", SplitCamelHump, InitialsFirstN, "JD"},
+		{"dotted", "john.doe", SplitCamelHump, InitialsFirstN, "JD"},
+		{"separator runs", "__john--doe__", SplitCamelHump, InitialsFirstN, "JD"},
+		{"capitals run", "XMLParser", SplitCamelHump, InitialsFirstN, "XP"},
+		{"all capitals", "JOHN", SplitCamelHump, InitialsFirstN, "J"},
+		{"mixed with spaces", "mary jane_watson", SplitCamelHump, InitialsFirstLast, "MW"},
+		{"every hump", "maryJaneWatson", SplitCamelHump, InitialsAll, "MJW"},
+		{"digits", "user42Admin", SplitCamelHump, InitialsFirstN, "UA"},
+		{"default camelCase", "johnDoe", SplitWords, InitialsFirstN, "J"},
+		{"default snake_case", "john_doe", SplitWords, InitialsFirstN, "J"},
+		{"default spaces", "john doe", SplitWords, InitialsFirstN, "JD"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := GetInitialsWithSplit(tc.input, tc.mode, 4, language.Und, tc.split); got != tc.exp {
+				t.Fatalf("expected %q got %q", tc.exp, got)
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		input string
+		exp   WordSplit
+		ok    bool
+	}{
+		{"", SplitWords, true},
+		{"words", SplitWords, true},
+		{"Camel-Hump", SplitCamelHump, true},
+		{"snake", SplitWords, false},
+	} {
+		if got, ok := ParseWordSplit(tc.input); got != tc.exp || ok != tc.ok {
+			t.Errorf("ParseWordSplit(%q): expected %q/%t got %q/%t", tc.input, tc.exp, tc.ok, got, ok)
+		}
+	}
+}
+
+// countingRuneReader records how many runes have been read
+type countingRuneReader struct {
+	r     *strings.Reader
+	count int
+}
+
+func (c *countingRuneReader) ReadRune() (rune, int, error) {
+	c.count++
+	return c.r.ReadRune()
+}
+
+func TestInitialsStopEarlyOnLongNames(t *testing.T) {
+	long := "Mary Jane " + strings.Repeat("x", 10000)
+
+	for _, mode := range []InitialsMode{InitialsFirstN, InitialsAll} {
+		rr := &countingRuneReader{r: strings.NewReader(long)}
+		if got := initialsFromReader(rr, mode, 2, SplitWords); got != "MJ" {
+			t.Fatalf("%s: expected MJ got %q", mode, got)
+		}
+		if rr.count > len("Mary J") {
+			t.Fatalf("%s: expected extraction to stop after the second initial, read %d of %d runes", mode, rr.count, utf8.RuneCountInString(long))
+		}
+	}
+}
+
+func TestParseInitialsMode(t *testing.T) {
+	cases := []struct {
+		input string
+		exp   InitialsMode
+		ok    bool
+	}{
+		{"", InitialsFirstN, true},
+		{"firstn", InitialsFirstN, true},
+		{"firstlast", InitialsFirstLast, true},
+		{"ALL", InitialsAll, true},
+		{"bogus", InitialsFirstN, false},
+	}
+	for _, tc := range cases {
+		if got, ok := ParseInitialsMode(tc.input); got != tc.exp || ok != tc.ok {
+			t.Errorf("ParseInitialsMode(%q): expected %q/%t got %q/%t", tc.input, tc.exp, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestColorFromPalette(t *testing.T) {
+	palette := []string{"111111", "222222", "333333", "444444", "555555"}
+
+	seen := make(map[string]bool)
+	for _, name := range []string{"Alice", "Bob", "Carol", "Dave", "Eve", "Frank", "Grace", "Heidi"} {
+		first := ColorFromPalette(name, palette)
+		if second := ColorFromPalette(name, palette); first != second {
+			t.Fatalf("expected stable color for %s, got %s and %s", name, first, second)
+		}
+		found := false
+		for _, c := range palette {
+			if c == first {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("color %s for %s is not in the palette", first, name)
+		}
+		seen[first] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("expected names to spread across the palette, got %v", seen)
+	}
+
+	// An empty palette keeps the built-in hash colors
+	if got := ColorFromPalette("Alice", nil); got != GenerateColorHash("Alice") {
+		t.Fatalf("expected hash color for empty palette, got %s", got)
+	}
+}
+
 func TestGetContrastColorWithGradient(t *testing.T) {
 	cases := []struct {
 		name  string
@@ -213,3 +381,2290 @@ func TestWrapTextForSVG(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckerBackground(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("failed to create renderer: %v", err)
+	}
+	opts := Options{Width: 64, Height: 64, Background: "ff0000", Foreground: "ffffff", Text: "AB", Shape: ShapeCircle}
+
+	t.Run("SVG", func(t *testing.T) {
+		opts := opts
+		opts.Format = FormatSVG
+		plain, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if strings.Contains(string(plain), "checker") {
+			t.Fatalf("expected no checker pattern when disabled, got: %s", plain)
+		}
+
+		opts.Checker = true
+		checked, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		svg := string(checked)
+		patternIdx := strings.Index(svg, `fill="url(#checker)"`)
+		circleIdx := strings.Index(svg, "<circle")
+		if !strings.Contains(svg, `<pattern id="checker"`) || patternIdx < 0 {
+			t.Fatalf("expected checker pattern, got: %s", svg)
+		}
+		if patternIdx > circleIdx {
+			t.Fatal("expected checker to be drawn behind the shape")
+		}
+	})
+
+	t.Run("PNG", func(t *testing.T) {
+		opts := opts
+		opts.Format = FormatPNG
+		plain, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(plain))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+			t.Fatalf("expected transparent corner without checker, got alpha %d", a)
+		}
+
+		opts.Checker = true
+		checked, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		img, err = png.Decode(bytes.NewReader(checked))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		dark := color.RGBAModel.Convert(img.At(0, 0)).(color.RGBA)
+		light := color.RGBAModel.Convert(img.At(checkerCellSize, 0)).(color.RGBA)
+		if dark != (color.RGBA{0xcc, 0xcc, 0xcc, 0xff}) || light != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+			t.Fatalf("expected checker cells in the corner, got %v and %v", dark, light)
+		}
+	})
+}
+
+// chunkWriter records how many writes reached it, to confirm output is streamed in pieces
+type chunkWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func TestRenderSVGStreamMatchesBytes(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"Avatar", Options{Width: 128, Height: 128, Background: "f0e9e9", Foreground: "8b5d5d", Text: "JD", Shape: ShapeCircle, Bold: true, Format: FormatSVG}},
+		{"Gradient with checker", Options{Width: 200, Height: 100, Background: "ff0000,0000ff", Foreground: "ffffff", Text: "AB", Checker: true}},
+		{"Quote", Options{Width: 600, Height: 300, Background: "cccccc", Foreground: "333333", Text: strings.Repeat("a long quote ", 400), QuoteOrJoke: true, Format: FormatSVG}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffered, err := r.RenderSVGBytes(tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var streamed chunkWriter
+			if err := r.RenderSVG(&streamed, tt.opts); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(streamed.Bytes(), buffered) {
+				t.Fatalf("streamed output differs from buffered output:\n%s\n---\n%s", streamed.Bytes(), buffered)
+			}
+			if len(buffered) > svgBufferSize && streamed.writes < 2 {
+				t.Fatalf("expected a %d byte document to be streamed in chunks, got %d write", len(buffered), streamed.writes)
+			}
+
+			if tt.opts.QuoteOrJoke {
+				return
+			}
+			legacy, err := r.DrawAvatar(tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(legacy, buffered) {
+				t.Fatal("expected DrawAvatar to match RenderSVGBytes")
+			}
+		})
+	}
+}
+
+var benchSVGOptions = Options{
+	Width: 1200, Height: 800, Background: "ff0000,0000ff", Foreground: "ffffff",
+	Text: strings.Repeat("streaming keeps memory flat ", 150), QuoteOrJoke: true, Checker: true, Format: FormatSVG,
+}
+
+func BenchmarkRenderSVGBytes(b *testing.B) {
+	r, err := New()
+	if err != nil {
+		b.Fatalf("renderer init: %v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.RenderSVGBytes(benchSVGOptions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderSVGStream(b *testing.B) {
+	r, err := New()
+	if err != nil {
+		b.Fatalf("renderer init: %v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := r.RenderSVG(io.Discard, benchSVGOptions); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSVGAnimation(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 128, Background: "f0e9e9", Foreground: "8b5d5d", Text: "JD", Shape: ShapeCircle, Format: FormatSVG}
+	still, err := r.DrawAvatar(base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		animation Animation
+		expected  string
+	}{
+		{AnimationPulse, `<animate attributeName="opacity" values="1;0.6;1"`},
+		{AnimationSpin, `<animateTransform attributeName="transform" type="rotate" from="0 64 64" to="360 64 64"`},
+		{AnimationFade, `<animate attributeName="opacity" from="0" to="1" dur="1s" fill="freeze"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.animation), func(t *testing.T) {
+			opts := base
+			opts.Animate = tt.animation
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			svg := string(out)
+			if !strings.Contains(svg, tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, svg)
+			}
+			if strings.Index(svg, "<g>") > strings.Index(svg, "<circle") || !strings.Contains(svg, "</g>\n</svg>") {
+				t.Fatalf("expected the shape and text to be wrapped in the animated group: %s", svg)
+			}
+			if growth := len(out) - len(still); growth > 200 {
+				t.Fatalf("expected animation to add under 200 bytes, added %d", growth)
+			}
+		})
+	}
+
+	t.Run("raster ignores animation", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		plain, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		opts.Animate = AnimationSpin
+		animated, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(plain, animated) {
+			t.Fatal("expected PNG output to be unaffected by animation")
+		}
+	})
+}
+
+func TestIcons(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+
+	for _, name := range IconNames() {
+		t.Run(name, func(t *testing.T) {
+			opts := Options{Width: 200, Height: 100, Background: "000000", Foreground: "ffffff", Text: "ignored", Icon: name, Format: FormatSVG}
+			out, err := r.DrawPlaceholder(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			svg := string(out)
+			if !strings.Contains(svg, `d="`+icons[name]+`"`) {
+				t.Fatalf("expected the %s icon path in %s", name, svg)
+			}
+			// 50px icon centered in 200x100: offset (75, 25), scale 50/24
+			if !strings.Contains(svg, `transform="translate(75 25) scale(2.0833333333333335)" fill="#ffffff"`) {
+				t.Fatalf("expected the icon to be centered, scaled and tinted: %s", svg)
+			}
+			if strings.Contains(svg, "<text") {
+				t.Fatal("expected the icon to replace the text")
+			}
+
+			opts.Format = FormatPNG
+			data, err := r.DrawPlaceholder(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode png: %v", err)
+			}
+			lit := 0
+			for y := 25; y < 75; y++ {
+				for x := 75; x < 125; x++ {
+					if r, _, _, _ := img.At(x, y).RGBA(); r > 0x8000 {
+						lit++
+					}
+				}
+			}
+			if lit == 0 || lit == 50*50 {
+				t.Fatalf("expected the icon to fill part of its box, got %d of %d pixels", lit, 50*50)
+			}
+			if r, _, _, _ := img.At(10, 50).RGBA(); r != 0 {
+				t.Fatal("expected nothing drawn outside the icon box")
+			}
+		})
+	}
+}
+
+func TestFontWeights(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+
+	regular := r.face(DefaultFontFamily, WeightRegular)
+	bold := r.face(DefaultFontFamily, WeightBold)
+	if regular == nil || bold == nil {
+		t.Fatal("expected regular and bold to be registered")
+	}
+	if regular == bold {
+		t.Fatal("expected bold to resolve to a different face than regular")
+	}
+	if r.face(DefaultFontFamily, WeightLight) != regular {
+		t.Fatal("expected an unregistered weight to fall back to regular")
+	}
+	if r.face("missing", WeightBold) != bold {
+		t.Fatal("expected an unknown family to fall back to the default family")
+	}
+
+	if err := r.RegisterFont(DefaultFontFamily, WeightMedium, gomedium.TTF); err != nil {
+		t.Fatalf("register medium: %v", err)
+	}
+	if medium := r.face(DefaultFontFamily, WeightMedium); medium == regular || medium == bold {
+		t.Fatal("expected the registered medium face to be selected")
+	}
+	if err := r.RegisterFont("broken", WeightRegular, []byte("not a font")); err == nil {
+		t.Fatal("expected an error for invalid font data")
+	}
+
+	render := func(weight FontWeight, format ImageFormat) []byte {
+		out, err := r.DrawAvatar(Options{Width: 64, Height: 64, Background: "ffffff", Foreground: "000000", Text: "AB", Weight: weight, Format: format})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+	if bytes.Equal(render(WeightRegular, FormatPNG), render(WeightBold, FormatPNG)) {
+		t.Fatal("expected bold and regular PNGs to differ")
+	}
+	if !bytes.Equal(render(WeightRegular, FormatPNG), render(WeightLight, FormatPNG)) {
+		t.Fatal("expected light to render with the regular face")
+	}
+	if !strings.Contains(string(render(WeightBold, FormatSVG)), `font-weight="bold"`) {
+		t.Fatal("expected bold SVG font-weight")
+	}
+	if !strings.Contains(string(render(WeightLight, FormatSVG)), `font-weight="normal"`) {
+		t.Fatal("expected light SVG to fall back to normal")
+	}
+	if !strings.Contains(string(render(WeightMedium, FormatSVG)), `font-weight="500"`) {
+		t.Fatal("expected medium SVG font-weight 500")
+	}
+}
+
+// inkBounds returns the leftmost and rightmost columns containing dark pixels
+func inkBounds(t *testing.T, data []byte) (int, int) {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode png: %v", err)
+	}
+	b := img.Bounds()
+	left, right := b.Max.X, -1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+				left, right = min(left, x), max(right, x)
+			}
+		}
+	}
+	return left, right
+}
+
+func TestLetterSpacing(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	// Two initials on a 128px avatar use a 64px font
+	base := Options{Width: 128, Height: 128, Background: "ffffff", Foreground: "000000", Text: "II", Format: FormatSVG}
+
+	svgTests := []struct {
+		name     string
+		spacing  LetterSpacing
+		expected string
+	}{
+		{"Pixels", LetterSpacing{Value: 4}, `letter-spacing="4"`},
+		{"Em", LetterSpacing{Value: 0.1, Em: true}, `letter-spacing="6.4"`},
+		{"Negative", LetterSpacing{Value: -2}, `letter-spacing="-2"`},
+		{"Clamped high", LetterSpacing{Value: 1000}, `letter-spacing="64"`},
+		{"Clamped low", LetterSpacing{Value: -1, Em: true}, `letter-spacing="-16"`},
+	}
+	for _, tt := range svgTests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			opts.LetterSpacing = tt.spacing
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(out), tt.expected) {
+				t.Fatalf("expected %s in %s", tt.expected, out)
+			}
+		})
+	}
+
+	plain, err := r.DrawAvatar(base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(plain), "letter-spacing") {
+		t.Fatal("expected no letter-spacing attribute by default")
+	}
+
+	t.Run("Raster glyphs shift by the spacing", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		data, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		left, right := inkBounds(t, data)
+
+		opts.LetterSpacing = LetterSpacing{Value: 20}
+		spaced, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		spacedLeft, spacedRight := inkBounds(t, spaced)
+
+		// The pair stays centered, so each glyph moves outwards by half the spacing
+		if shift := left - spacedLeft; shift < 9 || shift > 11 {
+			t.Fatalf("expected the first glyph to move left by ~10px, moved %d", shift)
+		}
+		if shift := spacedRight - right; shift < 9 || shift > 11 {
+			t.Fatalf("expected the second glyph to move right by ~10px, moved %d", shift)
+		}
+	})
+}
+
+func TestTile(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 480, Height: 160, Background: "2c3e50", Foreground: "ecf0f1", Text: "G", Shape: ShapeCircle, Format: FormatSVG, Tile: true}
+
+	t.Run("SVG pattern", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		for _, expected := range []string{
+			`<pattern id="tile" width="48" height="48" patternUnits="userSpaceOnUse">`,
+			`fill-opacity="0.15"`,
+			`<circle cx="240" cy="80" r="80" fill="url(#tile)" />`,
+		} {
+			if !strings.Contains(svg, expected) {
+				t.Fatalf("expected %s in %s", expected, svg)
+			}
+		}
+		// The tile sits between the background and the main text
+		if strings.Index(svg, "url(#tile)") > strings.LastIndex(svg, "<text") {
+			t.Fatalf("expected the tiled background behind the text: %s", svg)
+		}
+	})
+
+	t.Run("Empty text draws no tile", func(t *testing.T) {
+		opts := base
+		opts.Text = ""
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "pattern") {
+			t.Fatalf("expected no pattern without a glyph: %s", out)
+		}
+	})
+
+	t.Run("Raster", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		tiled, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		opts.Tile = false
+		plain, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if bytes.Equal(tiled, plain) {
+			t.Fatal("expected the tiled raster to differ from the plain one")
+		}
+	})
+
+	t.Run("Tile count scales with size", func(t *testing.T) {
+		tests := []struct {
+			w, h     int
+			expected int
+		}{
+			{48, 48, 1},
+			{96, 96, 4},
+			{480, 96, 20},
+			{960, 480, 200},
+		}
+		for _, tt := range tests {
+			if _, cols, rows := tileLayout(tt.w, tt.h); cols*rows != tt.expected {
+				t.Errorf("tileLayout(%d, %d): expected %d tiles got %d", tt.w, tt.h, tt.expected, cols*rows)
+			}
+		}
+		for _, size := range [][2]int{{4000, 4000}, {4000, 50}, {1000, 999}} {
+			if cell, cols, rows := tileLayout(size[0], size[1]); cols*rows > maxTiles || cols*cell < size[0] || rows*cell < size[1] {
+				t.Errorf("tileLayout(%d, %d): %dx%d cells of %d exceed the cap or leave gaps", size[0], size[1], cols, rows, cell)
+			}
+		}
+	})
+}
+
+func TestLetterTiles(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	palette := []string{"111111", "222222", "333333", "444444"}
+
+	for _, text := range []string{"A", "AB", "ABC", "ABCD"} {
+		t.Run(text, func(t *testing.T) {
+			expectedTiles := min(len(text), MaxLetterTiles)
+			colors := TileColors("Jane Doe", palette, expectedTiles)
+			opts := Options{Width: 128, Height: 128, Background: "ffffff", Foreground: "000000", Text: text, Shape: ShapeCircle, Format: FormatSVG, Style: StyleTiles, TileColors: colors}
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			svg := string(out)
+
+			if got := strings.Count(svg, "<text"); got != expectedTiles {
+				t.Fatalf("expected %d letters got %d: %s", expectedTiles, got, svg)
+			}
+			seen := map[string]bool{}
+			for _, c := range colors {
+				if seen[c] {
+					t.Fatalf("expected distinct tile colors got %v", colors)
+				}
+				seen[c] = true
+				if strings.Count(svg, `fill="#`+c+`" />`) != 1 {
+					t.Fatalf("expected one tile filled with %s: %s", c, svg)
+				}
+			}
+			// Tiles stay inside the circle's inscribed square
+			for _, tile := range letterTiles(opts) {
+				if tile.x < 64-64/math.Sqrt2-0.01 || tile.x+tile.size > 64+64/math.Sqrt2+0.01 {
+					t.Fatalf("expected tile %+v inside the circle", tile)
+				}
+			}
+
+			opts.Format = FormatPNG
+			if _, err := r.DrawAvatar(opts); err != nil {
+				t.Fatalf("unexpected raster error: %v", err)
+			}
+		})
+	}
+
+	t.Run("Colors are sequential and deterministic", func(t *testing.T) {
+		colors := TileColors("Jane Doe", palette, 3)
+		if !slices.Equal(colors, TileColors("Jane Doe", palette, 3)) {
+			t.Fatal("expected the same seed to give the same colors")
+		}
+		start := slices.Index(palette, colors[0])
+		for i, c := range colors {
+			if c != palette[(start+i)%len(palette)] {
+				t.Fatalf("expected consecutive palette colors got %v", colors)
+			}
+		}
+		if got := TileColors("Jane Doe", nil, 3); !slices.Contains(DefaultTilePalette, got[0]) {
+			t.Fatalf("expected the default tile palette without a palette, got %v", got)
+		}
+	})
+}
+
+func TestInitialsForLocale(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		locale   language.Tag
+		expected string
+	}{
+		{"Turkish dotted i", "istanbul ilçe", language.Turkish, "İİ"},
+		{"Turkish dotless i", "ılgın", language.Turkish, "I"},
+		{"Default maps i to I", "istanbul ilçe", language.Und, "II"},
+		{"German sharp s", "ßmith", language.German, "SS"},
+		{"Default keeps sharp s", "ßmith", language.Und, "ß"},
+		{"English", "jane doe", language.English, "JD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetInitialsForLocale(tt.input, InitialsFirstN, 2, tt.locale); got != tt.expected {
+				t.Fatalf("expected %q got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestBlur(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 128, Background: "ff0000,0000ff", Foreground: "ffffff", Text: "JD", Shape: ShapeCircle, Format: FormatSVG, Blur: 8}
+
+	t.Run("SVG filter on the background layer only", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if !strings.Contains(svg, `<feGaussianBlur stdDeviation="8" edgeMode="duplicate" />`) {
+			t.Fatalf("expected a Gaussian blur filter in %s", svg)
+		}
+		layerStart := strings.Index(svg, `<g filter="url(#bg-blur)">`)
+		layerEnd := strings.Index(svg, "</g></g>")
+		text := strings.Index(svg, "<text")
+		if layerStart < 0 || layerEnd < layerStart {
+			t.Fatalf("expected the background inside a blurred group: %s", svg)
+		}
+		if !strings.Contains(svg[layerStart:layerEnd], "url(#grad_ff0000_0000ff)") {
+			t.Fatalf("expected the gradient background inside the blurred group: %s", svg)
+		}
+		if text < layerEnd {
+			t.Fatalf("expected the initials after the blurred layer so they stay sharp: %s", svg)
+		}
+		if !strings.Contains(svg, `<clipPath id="bg-clip"><circle cx="64" cy="64" r="64" fill="#000" /></clipPath>`) {
+			t.Fatalf("expected the blurred layer clipped to the circle: %s", svg)
+		}
+	})
+
+	t.Run("No filter by default", func(t *testing.T) {
+		opts := base
+		opts.Blur = 0
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "filter") {
+			t.Fatalf("expected no filter without blur: %s", out)
+		}
+	})
+
+	t.Run("Raster", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		opts.Shape = ShapeSquare
+		opts.Text = ""
+		blurredPNG, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		opts.Blur = 0
+		sharpPNG, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		blurred, _ := png.Decode(bytes.NewReader(blurredPNG))
+		sharp, _ := png.Decode(bytes.NewReader(sharpPNG))
+
+		// A horizontal gradient is unchanged along a column, so the left edge stays close
+		// to red when edges are repeated rather than faded to transparent
+		br, _, _, ba := blurred.At(0, 64).RGBA()
+		sr, _, _, _ := sharp.At(0, 64).RGBA()
+		if ba>>8 != 255 || absDiff(br>>8, sr>>8) > 8 {
+			t.Fatalf("expected an opaque edge close to the original, got r=%d a=%d want r=%d", br>>8, ba>>8, sr>>8)
+		}
+	})
+
+	t.Run("Box sizes approximate sigma", func(t *testing.T) {
+		for _, sigma := range []float64{1, 4, 12.5, MaxBlur} {
+			var variance float64
+			for _, size := range boxSizesForGaussian(sigma, 3) {
+				if size%2 != 1 {
+					t.Fatalf("expected odd box sizes for sigma %g", sigma)
+				}
+				variance += float64(size*size-1) / 12
+			}
+			if math.Abs(math.Sqrt(variance)-sigma) > 1 {
+				t.Fatalf("expected boxes to approximate sigma %g, got %g", sigma, math.Sqrt(variance))
+			}
+		}
+	})
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestBadge(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+
+	// A 32px dot with a 4px ring sits 20px in from each edge of the shape's bounds
+	tests := []struct {
+		name   string
+		width  int
+		shape  Shape
+		corner Corner
+		cx, cy int
+	}{
+		{"Square bottom-right", 128, ShapeSquare, CornerBottomRight, 108, 108},
+		{"Square bottom-left", 128, ShapeSquare, CornerBottomLeft, 20, 108},
+		{"Square top-right", 128, ShapeSquare, CornerTopRight, 108, 20},
+		{"Square top-left", 128, ShapeSquare, CornerTopLeft, 20, 20},
+		{"Circle in wide image top-left", 200, ShapeCircle, CornerTopLeft, 56, 20},
+		{"Circle in wide image bottom-right", 200, ShapeCircle, CornerBottomRight, 144, 108},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Width: tt.width, Height: 128, Background: "3366cc", Foreground: "ffffff", Text: "JD", Shape: tt.shape,
+				Format: FormatSVG, BadgeColor: "2ecc71", BadgeCorner: tt.corner}
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := fmt.Sprintf(`<circle cx="%d" cy="%d" r="18" fill="#2ecc71" stroke="#ffffff" stroke-width="4" />`, tt.cx, tt.cy)
+			svg := string(out)
+			if !strings.Contains(svg, expected) {
+				t.Fatalf("expected %s in %s", expected, svg)
+			}
+			if strings.Index(svg, expected) < strings.Index(svg, "<text") {
+				t.Fatalf("expected the badge drawn over the initials: %s", svg)
+			}
+
+			opts.Format = FormatPNG
+			data, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode png: %v", err)
+			}
+			if got := hexAt(img, tt.cx, tt.cy); got != "2ecc71" {
+				t.Fatalf("expected badge color at the dot center got %s", got)
+			}
+			if got := hexAt(img, tt.cx+18, tt.cy); got != "ffffff" {
+				t.Fatalf("expected the ring color around the dot got %s", got)
+			}
+		})
+	}
+
+}
+
+// hexAt returns the color of the pixel at x, y as lowercase hex
+func hexAt(img image.Image, x, y int) string {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return fmt.Sprintf("%02x%02x%02x", r>>8, g>>8, b>>8)
+}
+
+func TestTextGradient(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	opts := Options{Width: 128, Height: 128, Background: "222222", Foreground: "ffffff", Text: "JD", Format: FormatSVG, TextGradient: "ff6b6b,feca57"}
+
+	t.Run("SVG fill references the gradient", func(t *testing.T) {
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if !strings.Contains(svg, `fill="url(#text_grad_ff6b6b_feca57)" text-anchor="middle"`) {
+			t.Fatalf("expected the text filled with the gradient: %s", svg)
+		}
+		def := strings.Index(svg, `<linearGradient id="text_grad_ff6b6b_feca57"`)
+		if def < 0 || def > strings.Index(svg, "<text") {
+			t.Fatalf("expected the gradient defined before the text: %s", svg)
+		}
+		if !strings.Contains(svg, "stop-color:#ff6b6b") || !strings.Contains(svg, "stop-color:#feca57") {
+			t.Fatalf("expected both gradient stops: %s", svg)
+		}
+	})
+
+	t.Run("Raster fills the glyphs only", func(t *testing.T) {
+		raster := opts
+		raster.Format = FormatPNG
+		data, err := r.DrawAvatar(raster)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode png: %v", err)
+		}
+		if got := hexAt(img, 2, 2); got != "222222" {
+			t.Fatalf("expected the background outside the text got %s", got)
+		}
+		// Fully covered glyph pixels carry gradient colors, with red fading towards yellow
+		var leftmost, rightmost string
+		for x := 0; x < 128; x++ {
+			for y := 0; y < 128; y++ {
+				if c := hexAt(img, x, y); c[:2] == "ff" || c[:2] == "fe" {
+					if leftmost == "" {
+						leftmost = c
+					}
+					rightmost = c
+				}
+			}
+		}
+		if leftmost == "" {
+			t.Fatal("expected gradient-colored text pixels")
+		}
+		if leftmost[2:4] >= rightmost[2:4] {
+			t.Fatalf("expected green to increase left to right, got %s and %s", leftmost, rightmost)
+		}
+	})
+
+	t.Run("Contrast ratio", func(t *testing.T) {
+		if got := ContrastRatio("000000", "ffffff"); math.Abs(got-21) > 0.01 {
+			t.Fatalf("expected 21:1 got %g", got)
+		}
+		if got := ContrastRatio("777777", "777777"); got != 1 {
+			t.Fatalf("expected 1:1 got %g", got)
+		}
+	})
+}
+
+func TestPowerOfTwoRound(t *testing.T) {
+	tests := []struct {
+		pot      PowerOfTwo
+		input    int
+		expected int
+	}{
+		{PowerOfTwoNone, 200, 200},
+		{PowerOfTwoUp, 200, 256},
+		{PowerOfTwoUp, 256, 256},
+		{PowerOfTwoUp, 1, 1},
+		{PowerOfTwoUp, 3000, 4096},
+		{PowerOfTwoDown, 200, 128},
+		{PowerOfTwoDown, 256, 256},
+		{PowerOfTwoDown, 4095, 2048},
+		{PowerOfTwoNearest, 200, 256},
+		{PowerOfTwoNearest, 180, 128},
+		{PowerOfTwoNearest, 192, 256},
+		{PowerOfTwoNearest, 4000, 4096},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.pot, tt.input), func(t *testing.T) {
+			got := tt.pot.Round(tt.input)
+			if got != tt.expected {
+				t.Fatalf("expected %d got %d", tt.expected, got)
+			}
+			if tt.pot != PowerOfTwoNone && got&(got-1) != 0 {
+				t.Fatalf("expected a power of two got %d", got)
+			}
+		})
+	}
+}
+
+func TestColorFromPaletteWithHash(t *testing.T) {
+	// Golden values pin each mapping so deployments matching another service stay matched
+	tests := []struct {
+		algo     HashAlgorithm
+		expected string
+		palette  string
+	}{
+		{HashMD5, "1c2720", "34495e"},
+		{HashFNV32, "a0e569", "f39c12"},
+		{HashFNV64, "a62825", "e74c3c"},
+		{HashSHA256, "01332c", "34495e"},
+		{HashCRC32, "9b8eb8", "34495e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.algo), func(t *testing.T) {
+			if got := ColorFromPaletteWithHash("Jane Doe", nil, tt.algo); got != tt.expected {
+				t.Fatalf("expected %s got %s", tt.expected, got)
+			}
+			if got := ColorFromPaletteWithHash("Jane Doe", DefaultTilePalette, tt.algo); got != tt.palette {
+				t.Fatalf("expected palette color %s got %s", tt.palette, got)
+			}
+			if len(tt.algo.Sum("")) < 4 {
+				t.Fatal("expected a digest of at least 4 bytes")
+			}
+		})
+	}
+
+	// MD5 stays the default so existing colors do not change
+	if got := ColorFromPalette("Jane Doe", nil); got != ColorFromPaletteWithHash("Jane Doe", nil, HashMD5) {
+		t.Fatalf("expected ColorFromPalette to use md5, got %s", got)
+	}
+	if algo, ok := ParseHashAlgorithm(""); !ok || algo != HashMD5 {
+		t.Fatalf("expected empty to mean md5, got %s %t", algo, ok)
+	}
+	if _, ok := ParseHashAlgorithm("sha1"); ok {
+		t.Fatal("expected sha1 to be rejected")
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 64, Height: 64, Background: "ff0000,00ff00", Foreground: "ffff00", Text: "JD", Style: StyleTiles, TileColors: []string{"3498db", "e74c3c"}, Format: FormatSVG, Grayscale: 1}
+
+	t.Run("SVG filter wraps the whole image", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if !strings.Contains(svg, `<feColorMatrix type="saturate" values="0" />`) {
+			t.Fatalf("expected a saturate color matrix in %s", svg)
+		}
+		group := strings.Index(svg, `<g filter="url(#desaturate)">`)
+		gradient := strings.Index(svg, "<linearGradient")
+		if group < 0 || gradient < group || !strings.HasSuffix(svg, "</g>\n</svg>") {
+			t.Fatalf("expected the gradient and tiles inside the filtered group: %s", svg)
+		}
+	})
+
+	t.Run("Partial saturation", func(t *testing.T) {
+		opts := base
+		opts.Grayscale = 0.25
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(out), `values="0.75"`) {
+			t.Fatalf("expected saturation 0.75 in %s", out)
+		}
+	})
+
+	t.Run("No filter by default", func(t *testing.T) {
+		opts := base
+		opts.Grayscale = 0
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "desaturate") {
+			t.Fatalf("expected no filter in %s", out)
+		}
+	})
+
+	t.Run("Raster channels are equal", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		for y := 0; y < opts.Height; y += 3 {
+			for x := 0; x < opts.Width; x += 3 {
+				cr, cg, cb, _ := img.At(x, y).RGBA()
+				if absDiff(cr>>8, cg>>8) > 1 || absDiff(cg>>8, cb>>8) > 1 {
+					t.Fatalf("expected gray at %d,%d got %s", x, y, hexAt(img, x, y))
+				}
+			}
+		}
+		// Pure red keeps its luminance rather than turning black
+		if got := hexAt(img, 0, 32); got[:2] == "00" {
+			t.Fatalf("expected a mid gray for the red edge, got %s", got)
+		}
+	})
+
+	t.Run("Raster partial saturation keeps some color", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		opts.Style = StyleDefault
+		opts.Background = "ff0000"
+		opts.Grayscale = 0.5
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got := hexAt(img, 1, 1); got == "ff0000" || got[0:2] == got[2:4] {
+			t.Fatalf("expected a half desaturated red, got %s", got)
+		}
+	})
+}
+
+func TestRingText(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 256, Height: 256, Background: "3498db", Foreground: "ffffff", Text: "JD", RingText: "Jane Doe", Shape: ShapeCircle, Format: FormatSVG}
+
+	ringText := func(svg string) string {
+		start := strings.Index(svg, `startOffset="25%" text-anchor="middle">`)
+		end := strings.Index(svg, "</textPath>")
+		if start < 0 || end < start {
+			t.Fatalf("expected a textPath in %s", svg)
+		}
+		return svg[start+len(`startOffset="25%" text-anchor="middle">`) : end]
+	}
+
+	t.Run("SVG textPath on a circle path", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if !strings.Contains(svg, `<path id="ring-path" d="M 23.04 128 A 104.96 104.96 0 1 1 232.96 128 A 104.96 104.96 0 1 1 23.04 128" />`) {
+			t.Fatalf("expected a circular ring path in %s", svg)
+		}
+		if !strings.Contains(svg, `<textPath href="#ring-path"`) {
+			t.Fatalf("expected the textPath to reference the ring path in %s", svg)
+		}
+		if got := ringText(svg); got != "Jane Doe" {
+			t.Fatalf("expected the full name got %q", got)
+		}
+		if !strings.Contains(svg, `font-size="77"`) {
+			t.Fatalf("expected the initials to shrink inside the ring in %s", svg)
+		}
+	})
+
+	t.Run("Long names are truncated", func(t *testing.T) {
+		opts := base
+		opts.Width, opts.Height = 64, 64
+		opts.RingText = strings.Repeat("Maximiliana ", 10)
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := ringText(string(out))
+		if !strings.HasSuffix(got, "…") || len(got) >= len(opts.RingText) || !strings.HasPrefix(opts.RingText, strings.TrimSuffix(got, "…")) {
+			t.Fatalf("expected a truncated prefix with an ellipsis, got %q", got)
+		}
+	})
+
+	t.Run("No ring by default", func(t *testing.T) {
+		opts := base
+		opts.RingText = ""
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "textPath") {
+			t.Fatalf("expected no ring in %s", out)
+		}
+	})
+
+	t.Run("Raster draws the ring at the top", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		// Some pixels in the band the ring text occupies above the initials turn white
+		found := false
+		for y := 6; y < 28 && !found; y++ {
+			for x := 80; x < 176; x++ {
+				if hexAt(img, x, y) == "ffffff" {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			t.Fatal("expected ring text pixels near the top edge")
+		}
+	})
+}
+
+func TestWordmark(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 400, Height: 120, Background: "2c3e50", Foreground: "ffffff", Style: StyleWordmark, Format: FormatSVG}
+
+	fontSize := func(text string) float64 {
+		opts := base
+		opts.Text = text
+		return wordmarkFontSize(r.styleContext(opts, 0))
+	}
+
+	t.Run("Short text is capped by the height", func(t *testing.T) {
+		if got := fontSize("GO"); got != 120*wordmarkHeightScale {
+			t.Fatalf("expected %v got %v", 120*wordmarkHeightScale, got)
+		}
+	})
+
+	t.Run("Longer text shrinks to fit the width", func(t *testing.T) {
+		short, long := fontSize("GROUT"), fontSize("Grout Image Service")
+		if long >= short {
+			t.Fatalf("expected longer text to get a smaller font, got %v >= %v", long, short)
+		}
+		face := truetype.NewFace(r.face(DefaultFontFamily, r.resolveWeight(DefaultFontFamily, fontWeightFor(base))), &truetype.Options{Size: long})
+		defer face.Close()
+		if width := float64(font.MeasureString(face, "Grout Image Service")) / 64; width > 400*wordmarkWidthScale+1 {
+			t.Fatalf("expected the text to fit within %v got %v", 400*wordmarkWidthScale, width)
+		}
+	})
+
+	t.Run("SVG renders the whole text", func(t *testing.T) {
+		opts := base
+		opts.Text = "Grout Image Service"
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(out), ">Grout Image Service</text>") {
+			t.Fatalf("expected the full text in %s", out)
+		}
+	})
+}
+
+func TestRibbon(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 400, Height: 250, Background: "cccccc", Foreground: "333333", Text: "400 x 250", Ribbon: "DRAFT", Format: FormatSVG}
+
+	t.Run("SVG banner is rotated across the corner", func(t *testing.T) {
+		out, err := r.DrawPlaceholder(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if !strings.Contains(svg, `<g transform="rotate(45 350 50)"><rect x="249.29" y="35" width="201.42" height="30" fill="#000000" />`) {
+			t.Fatalf("expected a rotated band in %s", svg)
+		}
+		if !strings.Contains(svg, `fill="#ffffff" text-anchor="middle" dominant-baseline="middle">DRAFT</text></g>`) {
+			t.Fatalf("expected white ribbon text on the dark band in %s", svg)
+		}
+	})
+
+	t.Run("Omitted on tiny images", func(t *testing.T) {
+		opts := base
+		opts.Width, opts.Height = MinRibbonSize-1, MinRibbonSize-1
+		out, err := r.DrawPlaceholder(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "DRAFT") || strings.Contains(string(out), "rotate(45") {
+			t.Fatalf("expected no ribbon in %s", out)
+		}
+	})
+
+	t.Run("Long text shrinks to fit", func(t *testing.T) {
+		opts := base
+		short := r.ribbonFor(opts).fontSize
+		opts.Ribbon = "STAGING PREVIEW"
+		if long := r.ribbonFor(opts).fontSize; long >= short {
+			t.Fatalf("expected a smaller font for longer text, got %v >= %v", long, short)
+		}
+	})
+
+	t.Run("Raster draws the band in the corner", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		out, err := r.DrawPlaceholder(opts)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		// The band crosses the diagonal from the corner; its edge near the corner stays clear
+		if got := hexAt(img, 340, 20); got != "000000" {
+			t.Fatalf("expected the band at (340,20) got %s", got)
+		}
+		if got := hexAt(img, 398, 2); got != "cccccc" {
+			t.Fatalf("expected the background at the very corner got %s", got)
+		}
+	})
+}
+
+// stripeStyle is a custom style drawing a bar under the text, to exercise RegisterStyle
+type stripeStyle struct{}
+
+func (stripeStyle) WriteSVG(w io.Writer, ctx StyleContext) error {
+	_, err := fmt.Fprintf(w, `<rect class="stripe" width="%d" height="4" fill="#%s" /><text>%s</text>`, ctx.Width, ctx.Foreground, ctx.Text)
+	return err
+}
+
+func (stripeStyle) DrawRaster(dc *gg.Context, ctx StyleContext) error {
+	dc.DrawRectangle(0, float64(ctx.Height)-4, float64(ctx.Width), 4)
+	dc.SetColor(ParseHexColor(ctx.Foreground))
+	dc.Fill()
+	return nil
+}
+
+func TestRegisterStyle(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+
+	t.Run("Built-in styles", func(t *testing.T) {
+		if got := strings.Join(r.StyleNames(), ","); got != "default,tiles,wordmark" {
+			t.Fatalf("expected default,tiles,wordmark got %s", got)
+		}
+		if style, ok := r.ParseStyle("Default"); !ok || style != StyleDefault {
+			t.Fatalf("expected default to map to StyleDefault, got %q %t", style, ok)
+		}
+		if _, ok := r.ParseStyle("stripe"); ok {
+			t.Fatal("expected an unregistered style to be rejected")
+		}
+	})
+
+	t.Run("Invalid names", func(t *testing.T) {
+		for _, name := range []Style{"", "default", "Stripe", "a b", Style(strings.Repeat("a", 33))} {
+			if err := r.RegisterStyle(name, stripeStyle{}); err == nil {
+				t.Fatalf("expected %q to be rejected", name)
+			}
+		}
+		if err := r.RegisterStyle("stripe", nil); err == nil {
+			t.Fatal("expected a nil drawer to be rejected")
+		}
+	})
+
+	if err := r.RegisterStyle("stripe", stripeStyle{}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	opts := Options{Width: 64, Height: 64, Background: "ffffff", Foreground: "ff0000", Text: "JD", Style: "stripe", Format: FormatSVG}
+
+	t.Run("Custom style is selectable", func(t *testing.T) {
+		if style, ok := r.ParseStyle("STRIPE"); !ok || style != "stripe" {
+			t.Fatalf("expected stripe, got %q %t", style, ok)
+		}
+		if got := strings.Join(r.StyleNames(), ","); got != "default,stripe,tiles,wordmark" {
+			t.Fatalf("expected the custom style in %s", got)
+		}
+	})
+
+	t.Run("SVG uses the custom drawer inside the document", func(t *testing.T) {
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if !strings.Contains(svg, `<rect class="stripe" width="64" height="4" fill="#ff0000" /><text>JD</text>`) || !strings.HasSuffix(svg, "</svg>") {
+			t.Fatalf("expected the stripe in a complete document, got %s", svg)
+		}
+		if strings.Contains(svg, `font-family="sans-serif"`) {
+			t.Fatalf("expected the default initials to be replaced in %s", svg)
+		}
+	})
+
+	t.Run("Raster uses the custom drawer", func(t *testing.T) {
+		raster := opts
+		raster.Format = FormatPNG
+		out, err := r.DrawAvatar(raster)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got := hexAt(img, 32, 62); got != "ff0000" {
+			t.Fatalf("expected the stripe at the bottom got %s", got)
+		}
+		if got := hexAt(img, 32, 32); got != "ffffff" {
+			t.Fatalf("expected no initials in the middle got %s", got)
+		}
+	})
+}
+
+func TestSymbol(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 96, Background: "3498db", Foreground: "ffffff", Text: "JD", Shape: ShapeCircle, Format: FormatSVG}
+
+	t.Run("Geometry is preserved inside the symbol", func(t *testing.T) {
+		plain, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		opts := base
+		opts.SymbolID = "avatar-1"
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		header := `<svg xmlns="http://www.w3.org/2000/svg" width="128" height="96" viewBox="0 0 128 96">` + "\n"
+		body := strings.TrimSuffix(strings.TrimPrefix(string(plain), header), "</svg>")
+		want := header + `<symbol id="avatar-1" viewBox="0 0 128 96">` + "\n" + body +
+			"</symbol>\n" + `<use href="#avatar-1" width="128" height="96" />` + "\n</svg>"
+		if string(out) != want {
+			t.Fatalf("expected\n%s\ngot\n%s", want, out)
+		}
+	})
+
+	t.Run("Ids inside the symbol are namespaced", func(t *testing.T) {
+		opts := base
+		opts.SymbolID = "avatar-1"
+		opts.Blur, opts.Grayscale, opts.RingText = 4, 1, "Jane Doe"
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		for _, id := range []string{"avatar-1-bg-blur", "avatar-1-bg-clip", "avatar-1-desaturate", "avatar-1-ring-path"} {
+			if !strings.Contains(svg, `id="`+id+`"`) || !strings.Contains(svg, "#"+id) {
+				t.Fatalf("expected id %s to be defined and referenced in %s", id, svg)
+			}
+		}
+	})
+}
+
+func TestColorProfile(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 64, Height: 64, Background: "3498db", Foreground: "ffffff", Text: "JD"}
+	exifColorSpace := []byte{0xa0, 0x01, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01}
+
+	tests := []struct {
+		name    string
+		format  ImageFormat
+		profile ColorProfile
+		marker  []byte
+		tagged  bool
+	}{
+		{"PNG default", FormatPNG, "", []byte("\x00\x00\x00\x01sRGB\x00"), true},
+		{"PNG srgb", FormatPNG, ColorProfileSRGB, []byte("\x00\x00\x00\x01sRGB\x00"), true},
+		{"PNG none", FormatPNG, ColorProfileNone, []byte("sRGB"), false},
+		{"JPEG default", FormatJPG, "", exifColorSpace, true},
+		{"JPEG none", FormatJPG, ColorProfileNone, []byte("Exif\x00\x00"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := base
+			opts.Format, opts.ColorProfile = tt.format, tt.profile
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := bytes.Contains(out, tt.marker); got != tt.tagged {
+				t.Fatalf("expected tagged %t got %t", tt.tagged, got)
+			}
+			// Decoding checks chunk CRCs and JPEG segment lengths
+			if _, _, err := image.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		})
+	}
+
+	t.Run("JPEG Exif follows SOI", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatJPG
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.HasPrefix(out, []byte("\xff\xd8\xff\xe1\x00\x34Exif\x00\x00MM")) {
+			t.Fatalf("expected an Exif APP1 segment after SOI, got % x", out[:16])
+		}
+	})
+
+	t.Run("sRGB precedes provenance and image data", func(t *testing.T) {
+		opts := base
+		opts.Format, opts.Provenance = FormatPNG, "/avatar/JD.png"
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		srgb, idat := bytes.Index(out, []byte("sRGB")), bytes.Index(out, []byte("IDAT"))
+		if srgb < 0 || idat < srgb || !bytes.Contains(out, []byte(ProvenanceKey)) {
+			t.Fatalf("expected sRGB before IDAT alongside provenance, got sRGB at %d IDAT at %d", srgb, idat)
+		}
+	})
+}
+
+func TestVignette(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 400, Height: 250, Background: "cccccc", Foreground: "333333", Text: "400 x 250", Bold: true, Vignette: 0.5, Format: FormatSVG}
+
+	t.Run("SVG overlay sits below the text", func(t *testing.T) {
+		out, err := r.DrawPlaceholder(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		overlay := `<rect width="400" height="250" fill="url(#vignette)" />`
+		if !strings.Contains(svg, `<radialGradient id="vignette" cx="50%" cy="50%" r="71%"><stop offset="50%" stop-color="#000" stop-opacity="0" /><stop offset="100%" stop-color="#000" stop-opacity="0.5" /></radialGradient>`) {
+			t.Fatalf("expected the vignette gradient in %s", svg)
+		}
+		background, vignette, text := strings.Index(svg, `fill="#cccccc"`), strings.Index(svg, overlay), strings.Index(svg, "<text")
+		if background < 0 || vignette < background || text < vignette {
+			t.Fatalf("expected background, vignette, then text, got %d %d %d in %s", background, vignette, text, svg)
+		}
+	})
+
+	t.Run("Off by default", func(t *testing.T) {
+		opts := base
+		opts.Vignette = 0
+		out, err := r.DrawPlaceholder(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "vignette") {
+			t.Fatalf("expected no vignette in %s", out)
+		}
+	})
+
+	t.Run("Raster darkens edges, not the center or the text", func(t *testing.T) {
+		opts := base
+		opts.Format, opts.Text = FormatPNG, "█"
+		out, err := r.DrawPlaceholder(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got := hexAt(img, 200, 60); got != "cccccc" {
+			t.Fatalf("expected the inner area untouched got %s", got)
+		}
+		corner := ParseHexColor(hexAt(img, 0, 0)).(color.RGBA)
+		if want := uint32(0xcc * 0.5); absDiff(uint32(corner.R), want) > 2 {
+			t.Fatalf("expected the corner darkened to about %02x got %02x", want, corner.R)
+		}
+		if got := hexAt(img, 200, 125); got != "333333" {
+			t.Fatalf("expected the text color unchanged got %s", got)
+		}
+	})
+}
+
+func TestTitle(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	opts := Options{Width: 128, Height: 128, Background: "3498db", Foreground: "ffffff", Text: "JD", Shape: ShapeCircle, Format: FormatSVG}
+
+	plain, err := r.DrawAvatar(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(plain), "<title>") || strings.Contains(string(plain), "aria-label") {
+		t.Fatalf("expected no title without Title, got %s", plain)
+	}
+
+	opts.Title = `Avatar for <Jane> & "Co"`
+	out, err := r.DrawAvatar(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	escaped := "Avatar for &lt;Jane&gt; &amp; &quot;Co&quot;"
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="128" height="128" viewBox="0 0 128 128" role="img" aria-label="` + escaped + `">` +
+		"\n<title>" + escaped + "</title>\n"
+	if !strings.HasPrefix(string(out), want) {
+		t.Fatalf("expected output to start with\n%s\ngot\n%s", want, out)
+	}
+	// The drawing itself is unchanged
+	if strings.TrimPrefix(string(out), want) != strings.SplitN(string(plain), "\n", 2)[1] {
+		t.Fatalf("expected the title to leave the drawing unchanged, got %s", out)
+	}
+}
+
+func TestInitialsLayout(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 80, Height: 320, Background: "2c3e50", Foreground: "ffffff", Text: "ABC", Shape: ShapeSquare, Format: FormatSVG}
+
+	t.Run("SVG rows", func(t *testing.T) {
+		opts := base
+		opts.InitialsLayout = InitialsVertical
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// 0.85*320/(3*1.1) leaves room for 82px, so the width's 40px wins; the rows are 44px apart
+		want := []string{
+			`<text x="40" y="116" font-family="sans-serif" font-size="40" font-weight="normal" fill="#ffffff" text-anchor="middle" dominant-baseline="middle">A</text>`,
+			`<text x="40" y="160" font-family="sans-serif" font-size="40" font-weight="normal" fill="#ffffff" text-anchor="middle" dominant-baseline="middle">B</text>`,
+			`<text x="40" y="204" font-family="sans-serif" font-size="40" font-weight="normal" fill="#ffffff" text-anchor="middle" dominant-baseline="middle">C</text>`,
+		}
+		if !strings.Contains(string(out), strings.Join(want, "\n")) {
+			t.Fatalf("expected rows\n%s\nin\n%s", strings.Join(want, "\n"), out)
+		}
+	})
+
+	t.Run("Scales to fit the height", func(t *testing.T) {
+		opts := base
+		opts.InitialsLayout = InitialsVertical
+		opts.Width, opts.Height = 300, 120
+		fontSize := initialsFontSize(opts)
+		centers := verticalRowCenters(opts.Height, 3, fontSize)
+		if top, bottom := centers[0]-fontSize/2, centers[2]+fontSize/2; top < 0 || bottom > float64(opts.Height) {
+			t.Fatalf("expected rows inside 0-%d got %g-%g", opts.Height, top, bottom)
+		}
+		if fontSize >= float64(opts.Width)*0.5 {
+			t.Fatalf("expected the height to limit the font size, got %g", fontSize)
+		}
+	})
+
+	t.Run("Single initial and other styles stay on one line", func(t *testing.T) {
+		for _, opts := range []Options{
+			{Width: 80, Height: 320, Text: "A", InitialsLayout: InitialsVertical},
+			{Width: 80, Height: 320, Text: "ABC", InitialsLayout: InitialsVertical, Style: StyleTiles},
+		} {
+			if rows := verticalRows(opts); rows != nil {
+				t.Fatalf("expected no rows for %+v got %q", opts, rows)
+			}
+		}
+	})
+
+	t.Run("Raster rows", func(t *testing.T) {
+		// Ink in the top and bottom quarters of the center column only appears when stacked
+		inkIn := func(layout InitialsLayout, y0, y1 int) bool {
+			opts := base
+			opts.Format = FormatPNG
+			opts.InitialsLayout = layout
+			data, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			for y := y0; y < y1; y++ {
+				for x := 20; x < 60; x++ {
+					if hexAt(img, x, y) != "2c3e50" {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		if !inkIn(InitialsVertical, 80, 140) || !inkIn(InitialsVertical, 180, 240) {
+			t.Fatalf("expected stacked initials above and below the center")
+		}
+		if inkIn(InitialsHorizontal, 80, 140) || inkIn(InitialsHorizontal, 180, 240) {
+			t.Fatalf("expected horizontal initials only around the center")
+		}
+	})
+}
+
+func TestFade(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	opts := Options{Width: 100, Height: 100, Background: "3498db", Foreground: "ffffff", Text: "JD", Shape: ShapeSquare, Format: FormatSVG, Fade: 0.6}
+
+	t.Run("SVG group", func(t *testing.T) {
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		header := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="100" viewBox="0 0 100 100">` + "\n"
+		if !strings.HasPrefix(svg, header+`<g opacity="0.4">`+"\n") || !strings.HasSuffix(svg, "</g>\n</svg>") {
+			t.Fatalf("expected the whole image in an opacity group, got %s", svg)
+		}
+		if strings.Index(svg, "<text") < strings.Index(svg, `<g opacity`) {
+			t.Fatalf("expected the text inside the group, got %s", svg)
+		}
+	})
+
+	t.Run("Raster alpha", func(t *testing.T) {
+		raster := opts
+		raster.Format = FormatPNG
+		data, err := r.DrawAvatar(raster)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		// A background pixel keeps its color at 40% alpha
+		c := color.NRGBAModel.Convert(img.At(5, 5)).(color.NRGBA)
+		if c.A != 102 {
+			t.Fatalf("expected alpha 102 got %d", c.A)
+		}
+		if absDiff(uint32(c.R), 0x34) > 2 || absDiff(uint32(c.G), 0x98) > 2 || absDiff(uint32(c.B), 0xdb) > 2 {
+			t.Fatalf("expected the background color to be kept got %v", c)
+		}
+	})
+}
+
+func TestTagline(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 256, Height: 256, Background: "2c3e50", Foreground: "ffffff", Text: "JD", Shape: ShapeSquare, Format: FormatSVG, Tagline: "Staff engineer"}
+
+	t.Run("Initials above the tagline", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The initials are centered in the top 65% and sized for it; the tagline in the rest
+		want := `<text x="128" y="83" font-family="sans-serif" font-size="83" font-weight="normal" fill="#ffffff" text-anchor="middle" dominant-baseline="middle">JD</text>` + "\n" +
+			`<text x="128" y="211" font-family="sans-serif" font-size="23.04" font-weight="normal" fill="#ffffff" text-anchor="middle" dominant-baseline="middle">Staff engineer</text>`
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("expected\n%s\nin\n%s", want, out)
+		}
+	})
+
+	t.Run("Long taglines wrap and shrink", func(t *testing.T) {
+		opts := base
+		opts.Tagline = "Staff engineer at Grout, building image services for everyone who needs avatars quickly"
+		l := r.taglineFor(opts)
+		if len(l.lines) != 2 || strings.Join(l.lines, " ") != opts.Tagline {
+			t.Fatalf("expected the tagline on two lines got %q", l.lines)
+		}
+		if l.fontSize >= 23.04 || l.centers[0] < 166 || l.centers[1]+l.fontSize/2 > 256 {
+			t.Fatalf("expected a smaller font below the initials got %+v", l)
+		}
+
+		opts.Tagline = strings.Repeat("word ", 30)
+		if l := r.taglineFor(opts); len(l.lines) != 2 || !strings.HasSuffix(l.lines[1], "…") {
+			t.Fatalf("expected text beyond two lines to be cut off got %q", l.lines)
+		}
+	})
+
+	t.Run("Omitted on small avatars", func(t *testing.T) {
+		opts := base
+		opts.Width, opts.Height = 96, 96
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "Staff engineer") || !strings.Contains(string(out), `y="48"`) {
+			t.Fatalf("expected centered initials without a tagline got %s", out)
+		}
+	})
+
+	t.Run("Raster", func(t *testing.T) {
+		draw := func(tagline string) image.Image {
+			opts := base
+			opts.Format = FormatPNG
+			opts.Tagline = tagline
+			data, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			return img
+		}
+		inked := func(img image.Image, top, bottom int) bool {
+			for y := top; y < bottom; y++ {
+				for x := 0; x < 256; x++ {
+					if hexAt(img, x, y) != "2c3e50" {
+						return true
+					}
+				}
+			}
+			return false
+		}
+
+		// With a tagline the initials shrink into the top region, leaving a gap above the tagline
+		img := draw("Staff engineer")
+		if !inked(img, 40, 125) || inked(img, 140, 190) || !inked(img, 200, 222) {
+			t.Fatalf("expected initials on top and the tagline below a gap")
+		}
+		if !inked(draw(""), 140, 190) {
+			t.Fatalf("expected full size initials without a tagline")
+		}
+	})
+}
+
+func TestFlip(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 96, Background: "2c3e50", Foreground: "ffffff", Text: "JD", Shape: ShapeSquare, Format: FormatSVG, BadgeColor: "e74c3c", BadgeCorner: CornerBottomRight}
+
+	t.Run("SVG transform", func(t *testing.T) {
+		for flip, want := range map[Flip]string{
+			FlipHorizontal: `<g transform="matrix(-1 0 0 1 128 0)">`,
+			FlipVertical:   `<g transform="matrix(1 0 0 -1 0 96)">`,
+			FlipBoth:       `<g transform="matrix(-1 0 0 -1 128 96)">`,
+		} {
+			opts := base
+			opts.Flip = flip
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			svg := string(out)
+			start := strings.Index(svg, want)
+			if start < 0 || start > strings.Index(svg, "<rect") || strings.LastIndex(svg, "</g>") < strings.Index(svg, ">JD</text>") {
+				t.Fatalf("expected %s around the whole drawing got %s", want, svg)
+			}
+		}
+
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Contains(string(out), "matrix(") {
+			t.Fatalf("expected no transform without flip got %s", out)
+		}
+	})
+
+	t.Run("SVG keeps text unflipped", func(t *testing.T) {
+		opts := base
+		opts.Flip, opts.FlipSkipsText = FlipHorizontal, true
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		// The background group closes before the text, and the badge is mirrored on its own
+		text := strings.Index(svg, ">JD</text>")
+		if strings.Count(svg, `<g transform="matrix(-1 0 0 1 128 0)">`) != 2 || strings.Index(svg, "</g>") > text {
+			t.Fatalf("expected text outside the flipped groups got %s", svg)
+		}
+	})
+
+	draw := func(flip Flip, skipsText bool) image.Image {
+		opts := base
+		opts.Format = FormatPNG
+		opts.Flip, opts.FlipSkipsText = flip, skipsText
+		data, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return img
+	}
+	plain := draw(FlipNone, false)
+
+	t.Run("Raster pixels mirrored", func(t *testing.T) {
+		for _, flip := range []Flip{FlipHorizontal, FlipVertical, FlipBoth} {
+			img := draw(flip, false)
+			for y := 0; y < 96; y++ {
+				for x := 0; x < 128; x++ {
+					mx, my := x, y
+					if flip != FlipVertical {
+						mx = 127 - x
+					}
+					if flip != FlipHorizontal {
+						my = 95 - y
+					}
+					if got, want := hexAt(img, x, y), hexAt(plain, mx, my); got != want {
+						t.Fatalf("%s: expected %s at %d,%d got %s", flip, want, x, y, got)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Raster keeps text unflipped", func(t *testing.T) {
+		img := draw(FlipHorizontal, true)
+		// The badge moves to the bottom-left corner while the initials stay as drawn
+		b := badgeFor(base)
+		if got := hexAt(img, 127-int(b.cx), int(b.cy)); got != "e74c3c" {
+			t.Fatalf("expected the badge mirrored to the left got %s", got)
+		}
+		if got := hexAt(img, int(b.cx), int(b.cy)); got != "2c3e50" {
+			t.Fatalf("expected no badge on the right got %s", got)
+		}
+		for y := 20; y < 76; y++ {
+			for x := 30; x < 98; x++ {
+				if got, want := hexAt(img, x, y), hexAt(plain, x, y); got != want {
+					t.Fatalf("expected unflipped text %s at %d,%d got %s", want, x, y, got)
+				}
+			}
+		}
+	})
+}
+
+func TestBubble(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 128, Background: "2c3e50", Foreground: "ffffff", Text: "JD", Shape: ShapeBubble, Format: FormatSVG}
+
+	t.Run("Tail on each side", func(t *testing.T) {
+		// The tail is 20px long and 31px wide at the base, its tip on the image edge
+		for tail, want := range map[Tail]string{
+			TailBottom: "L79.5 108L64 128L48.5 108",
+			TailTop:    "L48.5 20L64 0L79.5 20",
+			TailLeft:   "L20 79.5L0 64L20 48.5",
+			TailRight:  "L108 48.5L128 64L108 79.5",
+		} {
+			opts := base
+			opts.Tail = tail
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(out), `<path d="M`) || !strings.Contains(string(out), want) {
+				t.Fatalf("%s: expected the tail %s in %s", tail, want, out)
+			}
+		}
+	})
+
+	t.Run("SVG content clipped and centered on the body", func(t *testing.T) {
+		opts := base
+		opts.Tail = TailLeft
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		clip := strings.Index(svg, `<g clip-path="url(#bubble-clip)"><g transform="translate(20 0)">`)
+		text := strings.Index(svg, `<text x="54" y="64"`)
+		if !strings.Contains(svg, `<clipPath id="bubble-clip"><path d="M40.48 0`) || clip < 0 || text < clip || strings.Index(svg[text:], "</g></g>") < 0 {
+			t.Fatalf("expected the initials inside the bubble clip got %s", svg)
+		}
+	})
+
+	t.Run("Raster clips content to the bubble", func(t *testing.T) {
+		dc := gg.NewContext(128, 128)
+		opts := base
+		withBubbleContent(dc, opts, func() {
+			dc.DrawRectangle(-10, -10, 200, 200)
+			dc.SetColor(color.White)
+			dc.Fill()
+		})
+		img := dc.Image()
+		if _, _, _, a := img.At(64, 50).RGBA(); a == 0 {
+			t.Fatalf("expected content inside the bubble")
+		}
+		for _, p := range []image.Point{{1, 1}, {5, 120}, {126, 126}} {
+			if _, _, _, a := img.At(p.X, p.Y).RGBA(); a != 0 {
+				t.Fatalf("expected content outside the bubble at %v to be clipped", p)
+			}
+		}
+	})
+
+	t.Run("Raster tail", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		data, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if got := hexAt(img, 64, 124); got != "2c3e50" {
+			t.Fatalf("expected the tail near its tip got %s", got)
+		}
+		if _, _, _, a := img.At(20, 124).RGBA(); a != 0 {
+			t.Fatalf("expected transparency beside the tail")
+		}
+	})
+}
+
+func TestPixelate(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 128, Background: "2c3e50", Foreground: "ffffff", Text: "JD", Shape: ShapeSquare, Format: FormatSVG, Pixelate: 8}
+
+	t.Run("SVG draws one rect per block", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		if n := strings.Count(svg, "<rect"); n != 64 {
+			t.Fatalf("expected 64 rects got %d", n)
+		}
+		if strings.Contains(svg, "<text") || !strings.Contains(svg, `shape-rendering="crispEdges"`) {
+			t.Fatalf("expected only crisp blocks got %s", svg)
+		}
+		if !strings.Contains(svg, `<rect x="112" y="112" width="16" height="16" fill="#2c3e50" />`) {
+			t.Fatalf("expected an opaque background block in the corner got %s", svg)
+		}
+	})
+
+	t.Run("SVG leaves transparent blocks out", func(t *testing.T) {
+		opts := base
+		opts.Shape = ShapeCircle
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		n := strings.Count(svg, "<rect")
+		if n == 0 || n >= 64 {
+			t.Fatalf("expected fewer than 64 rects for a circle got %d", n)
+		}
+		if !strings.Contains(svg, "fill-opacity=") {
+			t.Fatal("expected partly covered edge blocks to be translucent")
+		}
+	})
+
+	t.Run("Grid clamped", func(t *testing.T) {
+		for _, tc := range []struct {
+			pixelate, width, height, want int
+		}{
+			{1, 128, 128, MinPixelate * MinPixelate},
+			{1000, 128, 128, MaxPixelate * MaxPixelate},
+			{64, 16, 40, 16 * 16},
+		} {
+			opts := base
+			opts.Pixelate, opts.Width, opts.Height = tc.pixelate, tc.width, tc.height
+			out, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n := strings.Count(string(out), "<rect"); n != tc.want {
+				t.Fatalf("pixelate=%d on %dx%d: expected %d rects got %d", tc.pixelate, tc.width, tc.height, tc.want, n)
+			}
+		}
+	})
+
+	t.Run("Raster blocks are uniform", func(t *testing.T) {
+		opts := base
+		opts.Format = FormatPNG
+		data, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		colors := make(map[string]bool)
+		for by := 0; by < 128; by += 16 {
+			for bx := 0; bx < 128; bx += 16 {
+				want := hexAt(img, bx, by)
+				colors[want] = true
+				for y := by; y < by+16; y++ {
+					for x := bx; x < bx+16; x++ {
+						if got := hexAt(img, x, y); got != want {
+							t.Fatalf("expected block at %d,%d to be %s got %s at %d,%d", bx, by, want, got, x, y)
+						}
+					}
+				}
+			}
+		}
+		// The initials survive as lighter blocks among the background ones
+		if len(colors) < 3 {
+			t.Fatalf("expected the initials to show in the blocks got colors %v", colors)
+		}
+	})
+}
+
+func TestCaps(t *testing.T) {
+	plain, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	smcp, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	if err := smcp.RegisterFont(DefaultFontFamily, WeightRegular, smallCapsFont(t)); err != nil {
+		t.Fatalf("register small caps font: %v", err)
+	}
+	render := func(r *Renderer, text string, caps Caps, format ImageFormat) []byte {
+		out, err := r.DrawAvatar(Options{Width: 128, Height: 128, Background: "ffffff", Foreground: "000000", Text: text, Caps: caps, Format: format})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+
+	t.Run("Parse", func(t *testing.T) {
+		for _, tc := range []struct {
+			in   string
+			want Caps
+			ok   bool
+		}{
+			{"", CapsNormal, true},
+			{"normal", CapsNormal, true},
+			{"Small", CapsSmall, true},
+			{"all", CapsAll, true},
+			{"petite", CapsNormal, false},
+		} {
+			if got, ok := ParseCaps(tc.in); got != tc.want || ok != tc.ok {
+				t.Fatalf("%q: expected %q, %v got %q, %v", tc.in, tc.want, tc.ok, got, ok)
+			}
+		}
+	})
+
+	t.Run("Small capitals from smcp", func(t *testing.T) {
+		if bytes.Equal(render(smcp, "Jd", CapsSmall, FormatPNG), render(smcp, "Jd", CapsNormal, FormatPNG)) {
+			t.Fatal("expected small capitals to change the raster")
+		}
+		// The test font's small capitals are its capitals, so the ink spans about as wide
+		smallLeft, smallRight := inkBounds(t, render(smcp, "Jd", CapsSmall, FormatPNG))
+		allLeft, allRight := inkBounds(t, render(smcp, "JD", CapsNormal, FormatPNG))
+		if absDiff(uint32(smallLeft), uint32(allLeft)) > 1 || absDiff(uint32(smallRight), uint32(allRight)) > 1 {
+			t.Fatalf("expected ink %d-%d like the capitals got %d-%d", allLeft, allRight, smallLeft, smallRight)
+		}
+		if svg := string(render(smcp, "Jd", CapsSmall, FormatSVG)); !strings.Contains(svg, `style="font-variant-caps:all-small-caps"`) {
+			t.Fatalf("expected font-variant-caps in SVG got %s", svg)
+		}
+	})
+
+	t.Run("Ignored without smcp", func(t *testing.T) {
+		if !bytes.Equal(render(plain, "Jd", CapsSmall, FormatPNG), render(plain, "Jd", CapsNormal, FormatPNG)) {
+			t.Fatal("expected caps=small to draw the text as is without smcp")
+		}
+		if svg := string(render(plain, "Jd", CapsSmall, FormatSVG)); strings.Contains(svg, "font-variant-caps") {
+			t.Fatalf("expected no font-variant-caps without smcp got %s", svg)
+		}
+	})
+
+	t.Run("All capitals", func(t *testing.T) {
+		if svg := string(render(plain, "jd", CapsAll, FormatSVG)); !strings.Contains(svg, ">JD</text>") {
+			t.Fatalf("expected capitals in SVG got %s", svg)
+		}
+		if !bytes.Equal(render(plain, "jd", CapsAll, FormatPNG), render(plain, "JD", CapsNormal, FormatPNG)) {
+			t.Fatal("expected caps=all to draw like capitals")
+		}
+	})
+}
+
+func TestFontFamily(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	if got := strings.Join(r.FontFamilies(), ","); got != DefaultFontFamily+","+MonoFontFamily {
+		t.Fatalf("expected the embedded families got %s", got)
+	}
+	if !r.HasFontFamily(MonoFontFamily) || r.HasFontFamily("missing") {
+		t.Fatal("expected only registered families to be reported")
+	}
+
+	render := func(family string, format ImageFormat) []byte {
+		out, err := r.DrawPlaceholder(Options{Width: 200, Height: 100, Background: "ffffff", Foreground: "000000", Text: "200 x 100", Bold: true, FontFamily: family, Format: format})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return out
+	}
+	if bytes.Equal(render(MonoFontFamily, FormatPNG), render("", FormatPNG)) {
+		t.Fatal("expected the mono family to change the raster")
+	}
+	if !bytes.Equal(render("missing", FormatPNG), render("", FormatPNG)) {
+		t.Fatal("expected an unknown family to fall back to the default")
+	}
+	for family, want := range map[string]string{
+		"":             `font-family="sans-serif"`,
+		MonoFontFamily: `font-family="monospace"`,
+		"missing":      `font-family="sans-serif"`,
+	} {
+		if svg := string(render(family, FormatSVG)); !strings.Contains(svg, want) {
+			t.Fatalf("family %q: expected %s got %s", family, want, svg)
+		}
+	}
+	if got := svgFontFamily("Fira <Code>"); got != "Fira &lt;Code&gt;, sans-serif" {
+		t.Fatalf("expected a named family with fallback got %s", got)
+	}
+}
+
+func TestCSSVars(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{Width: 128, Height: 128, Background: "2c3e50", Foreground: "ffffff", Text: "JD", Shape: ShapeSquare, Format: FormatSVG, CSSVars: true}
+	render := func(opts Options) string {
+		out, err := r.DrawAvatar(opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(out)
+	}
+
+	svg := render(base)
+	for _, want := range []string{
+		`<rect width="128" height="128" fill="var(--grout-bg, #2c3e50)" />`,
+		`fill="var(--grout-fg, #ffffff)" text-anchor="middle"`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Fatalf("expected %s in %s", want, svg)
+		}
+	}
+
+	opts := base
+	opts.Background, opts.Tagline, opts.Width, opts.Height = "ff0000,0000ff", "Engineering", 256, 256
+	svg = render(opts)
+	for _, want := range []string{"stop-color:var(--grout-bg, #ff0000)", "stop-color:var(--grout-bg-end, #0000ff)"} {
+		if !strings.Contains(svg, want) {
+			t.Fatalf("expected %s in %s", want, svg)
+		}
+	}
+	if n := strings.Count(svg, `fill="var(--grout-fg, #ffffff)"`); n != 2 {
+		t.Fatalf("expected the initials and tagline to follow --grout-fg got %d in %s", n, svg)
+	}
+
+	opts = base
+	opts.CSSVars = false
+	if svg := render(opts); strings.Contains(svg, "var(") {
+		t.Fatalf("expected plain colors without CSSVars got %s", svg)
+	}
+	opts.Format = FormatPNG
+	plain := render(opts)
+	opts.CSSVars = true
+	if render(opts) != plain {
+		t.Fatal("expected raster output to ignore CSSVars")
+	}
+}
+
+func TestGammaCorrect(t *testing.T) {
+	t.Run("Blends in linear light", func(t *testing.T) {
+		dst := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		copy(dst.Pix, []uint8{0, 0, 0, 255, 0, 0, 0, 0})
+		layer := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		// Half-covered white over opaque black, and over nothing
+		copy(layer.Pix, []uint8{128, 128, 128, 128, 128, 128, 128, 128})
+		blendLinear(dst, layer)
+		// Half the light of white is sRGB 188, where sRGB blending gives 128
+		if got := dst.Pix[:4]; got[0] < 186 || got[0] > 190 || got[3] != 255 {
+			t.Fatalf("expected about 188 over black got %v", got)
+		}
+		if got := dst.Pix[4:]; !slices.Equal(got, []uint8{128, 128, 128, 128}) {
+			t.Fatalf("expected the layer unchanged over transparency got %v", got)
+		}
+	})
+
+	t.Run("Text edges", func(t *testing.T) {
+		r, err := New()
+		if err != nil {
+			t.Fatalf("renderer init: %v", err)
+		}
+		render := func(gamma bool) image.Image {
+			out, err := r.DrawAvatar(Options{Width: 128, Height: 128, Background: "2c3e50", Foreground: "ffffff", Text: "JD", Shape: ShapeSquare, Format: FormatPNG, GammaCorrect: gamma})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			return img
+		}
+		plain, corrected := render(false), render(true)
+
+		var edges, lighter int
+		for y := range 128 {
+			for x := range 128 {
+				before, after := hexAt(plain, x, y), hexAt(corrected, x, y)
+				if before == "2c3e50" || before == "ffffff" {
+					// Background and fully covered pixels are not blended
+					if after != before {
+						t.Fatalf("expected %s at %d,%d to stay got %s", before, x, y, after)
+					}
+					continue
+				}
+				edges++
+				pr, _, _, _ := plain.At(x, y).RGBA()
+				cr, _, _, _ := corrected.At(x, y).RGBA()
+				if cr > pr {
+					lighter++
+				}
+			}
+		}
+		if edges == 0 {
+			t.Fatal("expected anti-aliased edge pixels")
+		}
+		// Light text on a dark background keeps more of its light at the edges in linear light
+		if lighter < edges*9/10 {
+			t.Fatalf("expected the edges to be lighter with gamma correction, got %d of %d", lighter, edges)
+		}
+	})
+}
+
+func TestParseHueRange(t *testing.T) {
+	tests := []struct {
+		value string
+		want  HueRange
+		ok    bool
+	}{
+		{"180-240", HueRange{180, 240}, true},
+		{" 0 - 360 ", HueRange{0, 360}, true},
+		{"330-30", HueRange{330, 30}, true},
+		{"200", HueRange{}, false},
+		{"-10-20", HueRange{}, false},
+		{"0-361", HueRange{}, false},
+		{"a-b", HueRange{}, false},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseHueRange(tt.value); got != tt.want || ok != tt.ok {
+			t.Fatalf("ParseHueRange(%q): expected %v %t got %v %t", tt.value, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestColorInHueRange(t *testing.T) {
+	names := []string{"Jane Doe", "John Smith", "Ada Lovelace", "Grace Hopper", "Alan Turing", "Linus", "Margaret Hamilton", "x"}
+	ranges := []HueRange{{180, 240}, {0, 30}, {330, 30}, {50, 70}, {90, 150}, {0, 360}}
+
+	for _, hueRange := range ranges {
+		t.Run(fmt.Sprintf("%g-%g", hueRange.Min, hueRange.Max), func(t *testing.T) {
+			for _, name := range names {
+				for _, algo := range []HashAlgorithm{HashMD5, HashFNV32, HashCRC32} {
+					hex := ColorInHueRange(name, nil, algo, hueRange)
+					if hue, ok := Hue(hex); !ok || !hueRange.Contains(hue) {
+						t.Fatalf("%q: expected a hue in %v got %s (%g)", name, hueRange, hex, hue)
+					}
+					if ratio := ContrastRatio(hex, GetContrastColor(hex)); ratio < MinHueRangeContrast {
+						t.Fatalf("%q: expected contrast of at least %g got %.2f for %s", name, MinHueRangeContrast, ratio, hex)
+					}
+					if again := ColorInHueRange(name, nil, algo, hueRange); again != hex {
+						t.Fatalf("%q: expected the same color every time got %s and %s", name, hex, again)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("Palette", func(t *testing.T) {
+		palette := []string{"e74c3c", "3498db", "2980b9", "2ecc71", "7f7f7f"}
+		for _, name := range names {
+			hex := ColorInHueRange(name, palette, HashMD5, HueRange{180, 240})
+			if hex != "3498db" && hex != "2980b9" {
+				t.Fatalf("%q: expected a blue palette color got %s", name, hex)
+			}
+		}
+		// No palette color is green enough, so one is generated
+		hex := ColorInHueRange("Jane Doe", palette, HashMD5, HueRange{100, 110})
+		if hue, ok := Hue(hex); !ok || hue < 100 || hue > 110 {
+			t.Fatalf("expected a generated green got %s", hex)
+		}
+	})
+}
+
+func TestLayeredOutputDeterministic(t *testing.T) {
+	r, err := New()
+	if err != nil {
+		t.Fatalf("renderer init: %v", err)
+	}
+	base := Options{
+		Width: 160, Height: 160, Background: "3498db,2c3e50",
+		Foreground: "ffffff", Text: "JD", Shape: ShapeRounded, Radius: 24, Weight: WeightBold,
+		Tile: true, Vignette: 0.4, TextGradient: "f1c40f,e67e22", Tagline: "Staff engineer",
+		BadgeColor: "2ecc71", BadgeCorner: CornerBottomRight, Ribbon: "DRAFT", Checker: true,
+		Grayscale: 0.2, Fade: 0.9, GammaCorrect: true, SymbolID: "avatar-1",
+	}
+
+	for _, format := range []ImageFormat{FormatSVG, FormatPNG, FormatWebP, FormatGIF} {
+		t.Run(string(format), func(t *testing.T) {
+			opts := base
+			opts.Format = format
+			if format != FormatSVG {
+				opts.SymbolID = ""
+			}
+			first, err := r.DrawAvatar(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i := range 25 {
+				out, err := r.DrawAvatar(opts)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(out, first) {
+					t.Fatalf("render %d differs from the first", i+2)
+				}
+			}
+		})
+	}
+
+	t.Run("Layer order", func(t *testing.T) {
+		out, err := r.DrawAvatar(base)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		svg := string(out)
+		last := -1
+		for _, layer := range []string{`id="checker"`, `<linearGradient id="grad_`, `<pattern id="avatar-1-tile"`, `<radialGradient id="avatar-1-vignette"`, `>JD</text>`, `>Staff engineer</text>`, `>DRAFT</text>`, `<circle`} {
+			i := strings.Index(svg, layer)
+			if i < 0 {
+				t.Fatalf("expected %s in %s", layer, svg)
+			}
+			if i < last {
+				t.Fatalf("expected %s above the layers before it in %s", layer, svg)
+			}
+			last = i
+		}
+	})
+}
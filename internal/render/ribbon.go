@@ -0,0 +1,87 @@
+package render
+
+import (
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// MaxRibbonLength is the longest ribbon text, in characters
+const MaxRibbonLength = 16
+
+// MinRibbonSize is the smallest dimension, in pixels, an image needs for a ribbon to be drawn;
+// below it the banner would cover most of the image
+const MinRibbonSize = 64
+
+// ribbonLayout is the band across the top-right corner, before rotation by 45 degrees
+// about its center (cx, cy)
+type ribbonLayout struct {
+	cx, cy, length, thickness, fontSize float64
+}
+
+// ribbonFor places the band so its center line crosses the top and right edges at
+// offset*2 from the corner, with room to spare so the rotated ends are cut off by the edges
+func (r *Renderer) ribbonFor(opts Options) ribbonLayout {
+	minDim := math.Min(float64(opts.Width), float64(opts.Height))
+	offset := minDim * 0.2
+	thickness := minDim * 0.12
+	l := ribbonLayout{
+		cx:        float64(opts.Width) - offset,
+		cy:        offset,
+		length:    2*offset*math.Sqrt2 + 2*thickness,
+		thickness: thickness,
+		fontSize:  thickness * 0.6,
+	}
+
+	// The text has to fit between the edges, where the band is only 2*offset*sqrt(2) long
+	face := truetype.NewFace(r.face(DefaultFontFamily, WeightBold), &truetype.Options{Size: l.fontSize})
+	width := float64(font.MeasureString(face, opts.Ribbon)) / 64
+	_ = face.Close()
+	if available := 2 * (offset - thickness/2) * math.Sqrt2 * 0.9; width > available {
+		l.fontSize *= available / width
+	}
+
+	l.cx, l.cy, l.length, l.thickness = round2(l.cx), round2(l.cy), round2(l.length), round2(l.thickness)
+	l.fontSize = math.Max(round2(l.fontSize), 1)
+	return l
+}
+
+// hasRibbon reports whether opts asks for a ribbon and the image is large enough for one
+func hasRibbon(opts Options) bool {
+	return opts.Ribbon != "" && min(opts.Width, opts.Height) >= MinRibbonSize
+}
+
+// ribbonColors returns the band color, contrasting with the background, and the text color on it
+func ribbonColors(opts Options) (band, text string) {
+	band = GetContrastColor(opts.Background)
+	return band, GetContrastColor(band)
+}
+
+// writeSVGRibbon draws the rotated banner across the top-right corner
+func (r *Renderer) writeSVGRibbon(sw *svgWriter, opts Options) {
+	l := r.ribbonFor(opts)
+	band, text := ribbonColors(opts)
+	sw.printf(`<g transform="rotate(45 %g %g)">`, l.cx, l.cy)
+	sw.printf(`<rect x="%g" y="%g" width="%g" height="%g" fill="#%s" />`,
+		round2(l.cx-l.length/2), round2(l.cy-l.thickness/2), l.length, l.thickness, band)
+	sw.printf(`<text x="%g" y="%g" font-family="sans-serif" font-size="%g" font-weight="bold" fill="#%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+		l.cx, l.cy, l.fontSize, text, escapeXML(opts.Ribbon))
+	sw.writeString("</g>\n")
+}
+
+// drawRibbon draws the rotated banner across the top-right corner
+func (r *Renderer) drawRibbon(dc *gg.Context, opts Options) {
+	l := r.ribbonFor(opts)
+	band, text := ribbonColors(opts)
+	dc.Push()
+	defer dc.Pop()
+	dc.RotateAbout(gg.Radians(45), l.cx, l.cy)
+	dc.DrawRectangle(l.cx-l.length/2, l.cy-l.thickness/2, l.length, l.thickness)
+	dc.SetColor(ParseHexColor(band))
+	dc.Fill()
+	dc.SetFontFace(truetype.NewFace(r.face(DefaultFontFamily, WeightBold), &truetype.Options{Size: l.fontSize}))
+	dc.SetColor(ParseHexColor(text))
+	dc.DrawStringAnchored(opts.Ribbon, l.cx, l.cy, 0.5, 0.5)
+}
@@ -0,0 +1,88 @@
+package render
+
+import (
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+const (
+	ringFontScale     = 0.09 // Ring font size relative to the smaller image dimension
+	ringCoverage      = 0.8  // Share of the circumference the ring text may use, leaving a gap at the bottom
+	ringInitialsScale = 0.6  // Initials shrink so they stay inside the ring
+	ringEllipsis      = "…"
+)
+
+// ringLayout returns the center and baseline radius of the ring text and its font size.
+// The baseline sits inside the edge so the glyphs, which stand outward, stay in the image.
+func ringLayout(opts Options) (cx, cy, radius, fontSize float64) {
+	minDim := float64(min(opts.Width, opts.Height))
+	fontSize = math.Max(minDim*ringFontScale, 6)
+	return float64(opts.Width) / 2, float64(opts.Height) / 2, minDim/2 - fontSize, fontSize
+}
+
+// fitRingText truncates text with an ellipsis until it fits the ring's usable arc length
+func (r *Renderer) fitRingText(text string, weight FontWeight, fontSize, radius float64) string {
+	face := truetype.NewFace(r.face(DefaultFontFamily, weight), &truetype.Options{Size: fontSize})
+	defer face.Close()
+	limit := 2 * math.Pi * radius * ringCoverage
+	if float64(font.MeasureString(face, text))/64 <= limit {
+		return text
+	}
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		candidate := strings.TrimSpace(string(runes)) + ringEllipsis
+		if float64(font.MeasureString(face, candidate))/64 <= limit {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// writeSVGRingText writes opts.RingText along a circle through the top of the image. The path
+// starts at the left and runs clockwise, so 25% is the top center where the text is anchored.
+func (r *Renderer) writeSVGRingText(sw *svgWriter, opts Options) {
+	cx, cy, radius, fontSize := ringLayout(opts)
+	weight := r.resolveWeight(DefaultFontFamily, fontWeightFor(opts))
+	text := r.fitRingText(opts.RingText, weight, fontSize, radius)
+	if text == "" {
+		return
+	}
+	id := sw.id("ring-path")
+	left, right, y, radius := round2(cx-radius), round2(cx+radius), round2(cy), round2(radius)
+	sw.printf(`<defs><path id="%s" d="M %g %g A %g %g 0 1 1 %g %g A %g %g 0 1 1 %g %g" /></defs>`,
+		id, left, y, radius, radius, right, y, radius, radius, left, y)
+	sw.printf(`<text font-family="sans-serif" font-size="%g" font-weight="%s" fill="%s"><textPath href="#%s" startOffset="25%%" text-anchor="middle">%s</textPath></text>`,
+		round2(fontSize), svgFontWeight(weight), svgColor(opts, CSSVarForeground, opts.Foreground), id, escapeXML(text))
+	sw.writeString("\n")
+}
+
+// drawRingText draws opts.RingText centered on the top of the ring, rotating each character
+// to follow the circle like the SVG textPath
+func (r *Renderer) drawRingText(dc *gg.Context, opts Options) {
+	cx, cy, radius, fontSize := ringLayout(opts)
+	weight := r.resolveWeight(DefaultFontFamily, fontWeightFor(opts))
+	text := r.fitRingText(opts.RingText, weight, fontSize, radius)
+	if text == "" {
+		return
+	}
+	dc.SetFontFace(truetype.NewFace(r.face(DefaultFontFamily, weight), &truetype.Options{Size: fontSize}))
+	dc.SetColor(ParseHexColor(opts.Foreground))
+
+	total, _ := dc.MeasureString(text)
+	angle := -math.Pi/2 - total/(2*radius)
+	for _, ch := range strings.Split(text, "") {
+		advance, _ := dc.MeasureString(ch)
+		mid := angle + advance/(2*radius)
+		x, y := cx+radius*math.Cos(mid), cy+radius*math.Sin(mid)
+		dc.Push()
+		dc.RotateAbout(mid+math.Pi/2, x, y)
+		dc.DrawStringAnchored(ch, x, y, 0.5, 0)
+		dc.Pop()
+		angle += advance / radius
+	}
+}
@@ -0,0 +1,319 @@
+package render
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// Style names how the initials of an avatar are laid out; see RegisterStyle
+type Style string
+
+const (
+	StyleDefault  Style = ""         // Initials as a single line of text
+	StyleTiles    Style = "tiles"    // Each initial in its own colored tile
+	StyleWordmark Style = "wordmark" // The whole text, sized to fill the width like a logo
+)
+
+// StyleDrawer draws the foreground of an avatar, usually its initials, for one Style.
+// The renderer draws the background shape first and the ring, ribbon and badge on top,
+// and applies blur, grayscale and animation around it, so a style only draws its own layer.
+type StyleDrawer interface {
+	// WriteSVG writes the SVG elements of the foreground to w
+	WriteSVG(w io.Writer, ctx StyleContext) error
+	// DrawRaster draws the foreground onto dc, within ctx.Width by ctx.Height pixels from its
+	// top-left corner; dc is taller when a tagline sits below
+	DrawRaster(dc *gg.Context, ctx StyleContext) error
+}
+
+// StyleContext is the normalized request a StyleDrawer draws: the validated options plus
+// the font resolved for them
+type StyleContext struct {
+	Options
+	FontSize   float64        // Size of single-line initials, already reduced for a ring
+	Font       *truetype.Font // Face of the requested weight, or the regular face when it is missing
+	FontWeight FontWeight     // The weight Font actually has
+	// SmallCaps maps glyphs of Font to its small capitals when Caps is CapsSmall and Font has
+	// the smcp feature; nil otherwise, and the text is drawn as is
+	SmallCaps map[truetype.Index]truetype.Index
+}
+
+// styleRegistry maps style names to their drawers; it is safe for concurrent use
+type styleRegistry struct {
+	mu     sync.RWMutex
+	styles map[Style]StyleDrawer
+}
+
+// styleNamePattern keeps style names usable as query values and cache key parts
+var styleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// RegisterStyle makes drawer available as the avatar style=name, replacing any earlier
+// drawer for name, built-in ones included. Register styles at startup, before serving.
+func (r *Renderer) RegisterStyle(name Style, drawer StyleDrawer) error {
+	if !styleNamePattern.MatchString(string(name)) || name == "default" {
+		return fmt.Errorf("invalid style name %q: use up to 32 lowercase letters, digits and dashes", name)
+	}
+	if drawer == nil {
+		return fmt.Errorf("style %q has no drawer", name)
+	}
+	r.registerStyle(name, drawer)
+	return nil
+}
+
+func (r *Renderer) registerStyle(name Style, drawer StyleDrawer) {
+	r.styles.mu.Lock()
+	defer r.styles.mu.Unlock()
+	if r.styles.styles == nil {
+		r.styles.styles = make(map[Style]StyleDrawer)
+	}
+	r.styles.styles[name] = drawer
+}
+
+// ParseStyle converts a query value into a registered Style; empty and "default" mean StyleDefault.
+func (r *Renderer) ParseStyle(s string) (Style, bool) {
+	name := Style(strings.ToLower(s))
+	if name == "default" {
+		return StyleDefault, true
+	}
+	r.styles.mu.RLock()
+	defer r.styles.mu.RUnlock()
+	if _, ok := r.styles.styles[name]; !ok {
+		return StyleDefault, false
+	}
+	return name, true
+}
+
+// StyleNames lists the registered styles for error messages, "default" first and the rest sorted
+func (r *Renderer) StyleNames() []string {
+	r.styles.mu.RLock()
+	defer r.styles.mu.RUnlock()
+	var names []string
+	for name := range r.styles.styles {
+		if name != StyleDefault {
+			names = append(names, string(name))
+		}
+	}
+	slices.Sort(names)
+	return append([]string{"default"}, names...)
+}
+
+// styleDrawer returns the drawer for name, falling back to the default style for
+// names that were never registered
+func (r *Renderer) styleDrawer(name Style) StyleDrawer {
+	r.styles.mu.RLock()
+	defer r.styles.mu.RUnlock()
+	if drawer, ok := r.styles.styles[name]; ok {
+		return drawer
+	}
+	return initialsStyle{}
+}
+
+// styleContext resolves the font of opts for a StyleDrawer. With a tagline the style draws
+// into the region above it, so its height is reduced to that region. CapsAll is applied to
+// the text here, so every style draws capitals.
+func (r *Renderer) styleContext(opts Options, fontSize float64) StyleContext {
+	opts.Height = taglineInitialsHeight(opts)
+	if opts.Caps == CapsAll {
+		opts.Text = strings.ToUpper(opts.Text)
+	}
+	opts.FontFamily = r.resolveFamily(opts.FontFamily)
+	weight := fontWeightFor(opts)
+	ctx := StyleContext{
+		Options:    opts,
+		FontSize:   fontSize,
+		Font:       r.face(opts.FontFamily, weight),
+		FontWeight: r.resolveWeight(opts.FontFamily, weight),
+	}
+	if opts.Caps == CapsSmall {
+		ctx.SmallCaps = r.smallCaps(ctx.Font)
+	}
+	return ctx
+}
+
+// svgCapsStyle returns the style attribute asking for small capitals when ctx draws them,
+// so SVG output follows the raster fallback for fonts without smcp
+func svgCapsStyle(ctx StyleContext) string {
+	if ctx.SmallCaps == nil {
+		return ""
+	}
+	return ` style="font-variant-caps:all-small-caps"`
+}
+
+// initialsStyle is StyleDefault: the text as a single centered line, with optional
+// letter spacing and gradient fill, or stacked one character per row for InitialsVertical
+type initialsStyle struct{}
+
+func (initialsStyle) WriteSVG(w io.Writer, ctx StyleContext) error {
+	sw := &svgWriter{w: w}
+	spacing := ""
+	if px := ctx.LetterSpacing.pixels(ctx.FontSize); px != 0 {
+		spacing = fmt.Sprintf(` letter-spacing="%g"`, math.Round(px*100)/100)
+	}
+	fill := svgColor(ctx.Options, CSSVarForeground, ctx.Foreground)
+	if ctx.TextGradient != "" {
+		fill = writeSVGTextGradient(sw, ctx.TextGradient)
+	}
+	caps := svgCapsStyle(ctx)
+	if rows := verticalRows(ctx.Options); rows != nil {
+		for i, y := range verticalRowCenters(ctx.Height, len(rows), ctx.FontSize) {
+			sw.printf(`<text x="%d" y="%g" font-family="%s" font-size="%.0f" font-weight="%s"%s fill="%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+				ctx.Width/2, round2(y), svgFontFamily(ctx.FontFamily), ctx.FontSize, svgFontWeight(ctx.FontWeight), caps, fill, escapeXML(rows[i]))
+			sw.writeString("\n")
+		}
+		return sw.err
+	}
+	sw.printf(`<text x="%d" y="%d" font-family="%s" font-size="%.0f" font-weight="%s"%s%s fill="%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+		ctx.Width/2, ctx.Height/2, svgFontFamily(ctx.FontFamily), ctx.FontSize, svgFontWeight(ctx.FontWeight), spacing, caps, fill, escapeXML(ctx.Text))
+	sw.writeString("\n")
+	return sw.err
+}
+
+func (initialsStyle) DrawRaster(dc *gg.Context, ctx StyleContext) error {
+	face := truetype.NewFace(ctx.Font, &truetype.Options{Size: ctx.FontSize})
+	dc.SetFontFace(face)
+	dc.SetColor(ParseHexColor(ctx.Foreground))
+	drawLine := func(dc *gg.Context) {
+		if ctx.SmallCaps != nil {
+			// Small capitals have no characters of their own, so their outlines are drawn directly
+			rows := verticalRows(ctx.Options)
+			centers := verticalRowCenters(ctx.Height, len(rows), ctx.FontSize)
+			spacing := 0.0
+			if rows == nil {
+				rows, centers = []string{ctx.Text}, []float64{float64(ctx.Height) / 2}
+				spacing = ctx.LetterSpacing.pixels(ctx.FontSize)
+			}
+			for i, y := range centers {
+				glyphs := smallCapsGlyphs(ctx.Font, ctx.SmallCaps, rows[i])
+				drawGlyphsAnchored(dc, ctx.Font, ctx.FontSize, glyphs, float64(ctx.Width)/2, y, 0.5, 0.5, spacing)
+			}
+		} else if rows := verticalRows(ctx.Options); rows != nil {
+			for i, y := range verticalRowCenters(ctx.Height, len(rows), ctx.FontSize) {
+				dc.DrawStringAnchored(rows[i], float64(ctx.Width)/2, y, 0.5, 0.5)
+			}
+		} else if spacing := ctx.LetterSpacing.pixels(ctx.FontSize); spacing != 0 {
+			drawSpacedString(dc, ctx.Text, float64(ctx.Width)/2, float64(ctx.Height)/2, spacing)
+		} else {
+			dc.DrawStringAnchored(ctx.Text, float64(ctx.Width)/2, float64(ctx.Height)/2, 0.5, 0.5)
+		}
+	}
+	if ctx.TextGradient != "" {
+		drawGradientText(dc, ctx.Options, face, drawLine)
+	} else {
+		drawLine(dc)
+	}
+	return nil
+}
+
+// tilesStyle is StyleTiles, see letterTiles
+type tilesStyle struct{}
+
+func (tilesStyle) WriteSVG(w io.Writer, ctx StyleContext) error {
+	sw := &svgWriter{w: w}
+	writeSVGLetterTiles(sw, ctx)
+	return sw.err
+}
+
+func (tilesStyle) DrawRaster(dc *gg.Context, ctx StyleContext) error {
+	drawLetterTiles(dc, ctx)
+	return nil
+}
+
+// MaxLetterTiles is the most initials StyleTiles draws; further letters are dropped
+const MaxLetterTiles = 3
+
+// DefaultTilePalette colors the letter tiles when no palette is configured
+var DefaultTilePalette = []string{"e74c3c", "f39c12", "f1c40f", "2ecc71", "1abc9c", "3498db", "9b59b6", "34495e"}
+
+// TileColors picks n consecutive palette colors for letter tiles, starting at a position
+// derived from seed so different names get different, but stable, color runs.
+func TileColors(seed string, palette []string, n int) []string {
+	return TileColorsWithHash(seed, palette, n, HashMD5)
+}
+
+// TileColorsWithHash is TileColors using the given hash algorithm
+func TileColorsWithHash(seed string, palette []string, n int, algo HashAlgorithm) []string {
+	if len(palette) == 0 {
+		palette = DefaultTilePalette
+	}
+	hash := algo.Sum(seed)
+	start := int(binary.BigEndian.Uint32(hash[:4]) % uint32(len(palette)))
+	colors := make([]string, n)
+	for i := range colors {
+		colors[i] = palette[(start+i)%len(palette)]
+	}
+	return colors
+}
+
+// letterTile is the placement of a single initial's tile
+type letterTile struct {
+	letter     string
+	color      string
+	x, y, size float64
+}
+
+// letterTiles lays the first MaxLetterTiles initials out as a centered horizontal row of
+// square tiles. Circles use their inscribed square so no tile pokes out of the shape.
+func letterTiles(opts Options) []letterTile {
+	letters := []rune(opts.Text)
+	if len(letters) > MaxLetterTiles {
+		letters = letters[:MaxLetterTiles]
+	}
+	n := min(len(letters), len(opts.TileColors))
+	if n == 0 {
+		return nil
+	}
+
+	w, h := float64(opts.Width), float64(opts.Height)
+	minDim := math.Min(w, h)
+	boxW, boxH := w*0.8, h*0.8
+	if opts.Shape == ShapeCircle {
+		boxW, boxH = minDim/math.Sqrt2, minDim/math.Sqrt2
+	}
+	gap := minDim * 0.05
+	size := math.Min(boxH, (boxW-gap*float64(n-1))/float64(n))
+	x := (w - size*float64(n) - gap*float64(n-1)) / 2
+	y := (h - size) / 2
+
+	tiles := make([]letterTile, n)
+	for i := range tiles {
+		tiles[i] = letterTile{letter: string(letters[i]), color: opts.TileColors[i], x: x + float64(i)*(size+gap), y: y, size: size}
+	}
+	return tiles
+}
+
+// writeSVGLetterTiles writes one rounded tile per initial, each with its letter in a contrasting color
+func writeSVGLetterTiles(sw *svgWriter, ctx StyleContext) {
+	fontWeight := svgFontWeight(ctx.FontWeight)
+	for _, tile := range letterTiles(ctx.Options) {
+		sw.printf(`<rect x="%g" y="%g" width="%g" height="%g" rx="%g" fill="#%s" />`,
+			round2(tile.x), round2(tile.y), round2(tile.size), round2(tile.size), round2(tile.size*0.15), tile.color)
+		sw.printf(`<text x="%g" y="%g" font-family="%s" font-size="%.0f" font-weight="%s" fill="#%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+			round2(tile.x+tile.size/2), round2(tile.y+tile.size/2), svgFontFamily(ctx.FontFamily), tile.size*0.6, fontWeight, GetContrastColor(tile.color), escapeXML(tile.letter))
+		sw.writeString("\n")
+	}
+}
+
+// drawLetterTiles is the raster counterpart of writeSVGLetterTiles
+func drawLetterTiles(dc *gg.Context, ctx StyleContext) {
+	for _, tile := range letterTiles(ctx.Options) {
+		dc.SetColor(ParseHexColor(tile.color))
+		dc.DrawRoundedRectangle(tile.x, tile.y, tile.size, tile.size, tile.size*0.15)
+		dc.Fill()
+		dc.SetFontFace(truetype.NewFace(ctx.Font, &truetype.Options{Size: tile.size * 0.6}))
+		dc.SetColor(ParseHexColor(GetContrastColor(tile.color)))
+		dc.DrawStringAnchored(tile.letter, tile.x+tile.size/2, tile.y+tile.size/2, 0.5, 0.5)
+	}
+}
+
+// round2 rounds to two decimals to keep SVG coordinates short
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
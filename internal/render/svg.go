@@ -1,91 +1,342 @@
 package render
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 )
 
+// svgProlog is written before the root element of standalone SVG documents
+const svgProlog = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+`
+
+// svgBufferSize bounds how much of an SVG document is held in memory while streaming
+const svgBufferSize = 4096
+
+// svgWriter streams SVG markup to an io.Writer, remembering the first write error
+// so the generator does not need to check every element.
+type svgWriter struct {
+	w   io.Writer
+	err error
+	// idPrefix namespaces element ids whose content differs between images, so several
+	// symbols on one page do not resolve each other's filters, clips and paths
+	idPrefix string
+}
+
+// id returns the document id for name
+func (sw *svgWriter) id(name string) string {
+	return sw.idPrefix + name
+}
+
+func (sw *svgWriter) printf(format string, args ...interface{}) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = fmt.Fprintf(sw.w, format, args...)
+}
+
+// Write lets a StyleDrawer write through sw, sharing its sticky error
+func (sw *svgWriter) Write(p []byte) (int, error) {
+	if sw.err != nil {
+		return 0, sw.err
+	}
+	var n int
+	n, sw.err = sw.w.Write(p)
+	return n, sw.err
+}
+
+func (sw *svgWriter) writeString(s string) {
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = io.WriteString(sw.w, s)
+}
+
+// RenderSVG streams the SVG document for opts to w without building it in memory first
+func (r *Renderer) RenderSVG(w io.Writer, opts Options) error {
+	bw := bufio.NewWriterSize(w, svgBufferSize)
+	if err := r.writeSVGWithWrapping(bw, opts, fontSizeFor(opts)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// RenderSVGBytes renders the SVG document for opts into a byte slice, for callers that keep the result
+func (r *Renderer) RenderSVGBytes(opts Options) ([]byte, error) {
+	return r.generateSVGWithWrapping(opts, fontSizeFor(opts))
+}
+
 // generateSVGWithWrapping creates an SVG representation with text wrapping support
-func (r *Renderer) generateSVGWithWrapping(w, h int, bgHex, fgHex, text string, rounded, bold bool, fontSize float64, isQuoteOrJoke bool) ([]byte, error) {
+func (r *Renderer) generateSVGWithWrapping(opts Options, fontSize float64) ([]byte, error) {
 	var buf bytes.Buffer
+	if err := r.writeSVGWithWrapping(&buf, opts, fontSize); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeSVGWithWrapping writes an SVG representation with text wrapping support to out
+func (r *Renderer) writeSVGWithWrapping(out io.Writer, opts Options, fontSize float64) error {
+	w, h := opts.Width, opts.Height
+	bgHex, fgHex, text := opts.Background, opts.Foreground, opts.Text
+	isQuoteOrJoke := opts.QuoteOrJoke
+
+	sw := &svgWriter{w: out}
+
+	if opts.Standalone {
+		sw.writeString(svgProlog)
+	}
 
 	// SVG header
-	buf.WriteString(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, w, h, w, h))
-	buf.WriteString("\n")
+	if opts.Title != "" {
+		title := escapeXML(opts.Title)
+		sw.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img" aria-label="%s">`, w, h, w, h, title)
+		sw.printf("\n<title>%s</title>", title)
+	} else {
+		sw.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, w, h, w, h)
+	}
+	sw.writeString("\n")
 
-	// Check if bgHex contains a gradient (comma-separated colors)
-	color1, color2 := parseGradientColors(bgHex)
+	if opts.Provenance != "" {
+		writeSVGMetadata(sw, opts.Provenance)
+	}
+
+	// A symbol wraps the whole drawing so it can be defined once and referenced with <use>
+	if opts.SymbolID != "" {
+		sw.printf(`<symbol id="%s" viewBox="0 0 %d %d">`, opts.SymbolID, w, h)
+		sw.writeString("\n")
+		sw.idPrefix = opts.SymbolID + "-"
+	}
+
+	// A pixelated image is composed as raster and sampled, so the effects above are in its blocks
+	if opts.Pixelate > 0 {
+		if opts.Animate != AnimationNone {
+			sw.writeString("<g>")
+			writeSVGAnimation(sw, opts.Animate, w, h)
+			sw.writeString("\n")
+		}
+		img, err := r.composeRaster(opts, fontSize)
+		if err != nil {
+			return err
+		}
+		writeSVGPixelated(sw, img, opts.Pixelate)
+		if opts.Animate != AnimationNone {
+			sw.writeString("</g>\n")
+		}
+		writeSVGEnd(sw, opts)
+		return sw.err
+	}
+
+	// Everything, the checkerboard included, is faded and desaturated as one group
+	if opts.Fade > 0 {
+		writeSVGFadeStart(sw, opts.Fade)
+	}
+	if opts.Grayscale > 0 {
+		writeSVGGrayscaleStart(sw, opts.Grayscale)
+	}
+
+	if opts.Checker {
+		writeSVGChecker(sw, w, h)
+	}
+
+	// The checkerboard stays still; everything drawn on top of it is animated as one group
+	if opts.Animate != AnimationNone {
+		sw.writeString("<g>")
+		writeSVGAnimation(sw, opts.Animate, w, h)
+		sw.writeString("\n")
+	}
 
-	// Calculate radius for rounded shapes (use minimum dimension to ensure circle fits)
-	radius := w
-	if h < w {
-		radius = h
+	// The drawing is mirrored as one group. Keeping text unflipped, the group ends with the
+	// background and the badge is mirrored on its own.
+	if opts.Flip != FlipNone {
+		writeSVGFlipStart(sw, opts)
 	}
-	radius = radius / 2
+
+	// A blurred background is drawn as a full square so its edges stay solid, then clipped to the shape
+	bgOpts := opts
+	if opts.Blur > 0 {
+		writeSVGBlurStart(sw, opts)
+		bgOpts.Shape = ShapeSquare
+	}
+
+	// Check if bgHex contains a gradient (comma-separated colors)
+	color1, color2 := parseGradientColors(bgHex)
 
 	if color1 != "" && color2 != "" {
 		// Generate unique gradient ID based on colors to avoid conflicts
 		gradientID := fmt.Sprintf("grad_%s_%s", color1, color2)
 
 		// Define linear gradient
-		buf.WriteString(fmt.Sprintf(`<defs><linearGradient id="%s" x1="0%%" y1="0%%" x2="100%%" y2="0%%">`, gradientID))
-		buf.WriteString(fmt.Sprintf(`<stop offset="0%%" style="stop-color:#%s;stop-opacity:1" />`, color1))
-		buf.WriteString(fmt.Sprintf(`<stop offset="100%%" style="stop-color:#%s;stop-opacity:1" />`, color2))
-		buf.WriteString(`</linearGradient></defs>`)
-		buf.WriteString("\n")
+		writeSVGLinearGradient(sw, gradientID, svgColor(opts, CSSVarBackground, color1), svgColor(opts, CSSVarBackgroundEnd, color2))
 
 		// Background shape with gradient
-		if rounded {
-			buf.WriteString(fmt.Sprintf(`<circle cx="%d" cy="%d" r="%d" fill="url(#%s)" />`, w/2, h/2, radius, gradientID))
-		} else {
-			buf.WriteString(fmt.Sprintf(`<rect width="%d" height="%d" fill="url(#%s)" />`, w, h, gradientID))
-		}
+		writeSVGShape(sw, bgOpts, "url(#"+gradientID+")")
 	} else {
 		// Solid color background
 		if color1 != "" {
 			bgHex = color1
 		}
-		if rounded {
-			buf.WriteString(fmt.Sprintf(`<circle cx="%d" cy="%d" r="%d" fill="#%s" />`, w/2, h/2, radius, bgHex))
-		} else {
-			buf.WriteString(fmt.Sprintf(`<rect width="%d" height="%d" fill="#%s" />`, w, h, bgHex))
-		}
+		writeSVGShape(sw, bgOpts, svgColor(opts, CSSVarBackground, bgHex))
+	}
+	sw.writeString("\n")
+
+	if glyph := tileGlyph(text); opts.Tile && glyph != "" {
+		writeSVGTile(sw, bgOpts, glyph)
+	}
+	if opts.Blur > 0 {
+		writeSVGBlurEnd(sw)
+	}
+	if opts.Vignette > 0 {
+		writeSVGVignette(sw, opts)
+	}
+	if opts.Flip != FlipNone && opts.FlipSkipsText {
+		writeSVGFlipEnd(sw)
 	}
-	buf.WriteString("\n")
 
 	// Text element(s)
-	fontWeight := "normal"
-	if bold {
-		fontWeight = "bold"
+	family := r.resolveFamily(opts.FontFamily)
+	fontWeight := svgFontWeight(r.resolveWeight(family, fontWeightFor(opts)))
+
+	// A bubble clips the text to its outline and centers it on the body, away from the tail
+	content := bubbleContent(opts)
+	cw, ch := content.Width, content.Height
+	if opts.Shape == ShapeBubble {
+		writeSVGBubbleContentStart(sw, opts)
 	}
 
 	// Wrap text if it's a quote/joke (use wrapping for readability)
-	// For short text like initials or dimensions, use single-line rendering
-	if isQuoteOrJoke {
-		lines := wrapTextForSVG(text, float64(w), fontSize)
+	// Short text like initials or dimensions is drawn by the avatar style
+	if opts.QR != "" {
+		writeSVGQR(sw, opts.QR, opts.QRLevel, cw, ch, svgColor(opts, CSSVarForeground, fgHex))
+	} else if opts.Icon != "" {
+		writeSVGIcon(sw, opts.Icon, cw, ch, svgColor(opts, CSSVarForeground, fgHex))
+	} else if isQuoteOrJoke {
+		lines := wrapTextForSVG(text, float64(cw), fontSize)
 		lineHeight := fontSize * 1.5
 		totalHeight := float64(len(lines)) * lineHeight
-		centerY := float64(h) / 2
+		centerY := float64(ch) / 2
 		startY := centerY - (totalHeight-lineHeight)/2
 
 		for i, line := range lines {
 			y := startY + float64(i)*lineHeight
-			buf.WriteString(fmt.Sprintf(`<text x="%d" y="%.0f" font-family="sans-serif" font-size="%.0f" font-weight="%s" fill="#%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
-				w/2, y, fontSize, fontWeight, fgHex, escapeXML(line)))
-			buf.WriteString("\n")
+			sw.printf(`<text x="%d" y="%.0f" font-family="%s" font-size="%.0f" font-weight="%s" fill="%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+				cw/2, y, svgFontFamily(family), fontSize, fontWeight, svgColor(opts, CSSVarForeground, fgHex), escapeXML(line))
+			sw.writeString("\n")
 		}
-	} else {
-		// For initials/short text/dimensions, draw as single line
-		buf.WriteString(fmt.Sprintf(`<text x="%d" y="%d" font-family="sans-serif" font-size="%.0f" font-weight="%s" fill="#%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
-			w/2, h/2, fontSize, fontWeight, fgHex, escapeXML(text)))
-		buf.WriteString("\n")
+	} else if err := r.styleDrawer(opts.Style).WriteSVG(sw, r.styleContext(content, fontSize)); err != nil && sw.err == nil {
+		sw.err = err
+	}
+
+	if hasTagline(content) {
+		r.writeSVGTagline(sw, content)
+	}
+	if opts.Shape == ShapeBubble {
+		writeSVGBubbleContentEnd(sw, opts)
+	}
+
+	if opts.RingText != "" {
+		r.writeSVGRingText(sw, opts)
+	}
+
+	if hasRibbon(opts) {
+		r.writeSVGRibbon(sw, opts)
+	}
+
+	if opts.BadgeColor != "" && opts.Flip != FlipNone && opts.FlipSkipsText {
+		writeSVGFlipStart(sw, opts)
+		writeSVGBadge(sw, opts)
+		writeSVGFlipEnd(sw)
+	} else if opts.BadgeColor != "" {
+		writeSVGBadge(sw, opts)
+	}
+
+	if opts.Flip != FlipNone && !opts.FlipSkipsText {
+		writeSVGFlipEnd(sw)
+	}
+
+	if opts.Animate != AnimationNone {
+		sw.writeString("</g>\n")
+	}
+
+	if opts.Grayscale > 0 {
+		writeSVGGrayscaleEnd(sw)
+	}
+	if opts.Fade > 0 {
+		writeSVGFadeEnd(sw)
+	}
+
+	writeSVGEnd(sw, opts)
+	return sw.err
+}
+
+// writeSVGEnd closes the symbol opened for opts.SymbolID, referencing it, and the SVG itself
+func writeSVGEnd(sw *svgWriter, opts Options) {
+	if opts.SymbolID != "" {
+		sw.printf(`</symbol>`+"\n"+`<use href="#%s" width="%d" height="%d" />`, opts.SymbolID, opts.Width, opts.Height)
+		sw.writeString("\n")
 	}
 
 	// Close SVG
-	buf.WriteString("</svg>")
+	sw.writeString("</svg>")
+}
 
-	return buf.Bytes(), nil
+// writeSVGLinearGradient defines a left-to-right gradient between two SVG colors
+func writeSVGLinearGradient(sw *svgWriter, id, color1, color2 string) {
+	sw.printf(`<defs><linearGradient id="%s" x1="0%%" y1="0%%" x2="100%%" y2="0%%">`, id)
+	sw.printf(`<stop offset="0%%" style="stop-color:%s;stop-opacity:1" />`, color1)
+	sw.printf(`<stop offset="100%%" style="stop-color:%s;stop-opacity:1" />`, color2)
+	sw.writeString(`</linearGradient></defs>`)
+	sw.writeString("\n")
+}
+
+// writeSVGShape writes the background shape filled with fill
+func writeSVGShape(sw *svgWriter, opts Options, fill string) {
+	w, h := opts.Width, opts.Height
+	switch opts.Shape {
+	case ShapeCircle:
+		// Use the minimum dimension to ensure the circle fits
+		radius := w
+		if h < w {
+			radius = h
+		}
+		sw.printf(`<circle cx="%d" cy="%d" r="%d" fill="%s" />`, w/2, h/2, radius/2, fill)
+	case ShapeRounded:
+		sw.printf(`<rect width="%d" height="%d" rx="%g" ry="%g" fill="%s" />`, w, h, opts.Radius, opts.Radius, fill)
+	case ShapeBubble:
+		sw.printf(`<path d="%s" fill="%s" />`, bubbleFor(opts).svgPath(), fill)
+	default:
+		sw.printf(`<rect width="%d" height="%d" fill="%s" />`, w, h, fill)
+	}
+}
+
+// writeSVGAnimation writes the SMIL element animating the enclosing group.
+// Each animation is a single fixed-size element, so it adds well under 200 bytes.
+func writeSVGAnimation(sw *svgWriter, animation Animation, w, h int) {
+	switch animation {
+	case AnimationPulse:
+		sw.writeString(`<animate attributeName="opacity" values="1;0.6;1" dur="2s" repeatCount="indefinite" />`)
+	case AnimationSpin:
+		sw.printf(`<animateTransform attributeName="transform" type="rotate" from="0 %g %g" to="360 %g %g" dur="8s" repeatCount="indefinite" />`,
+			float64(w)/2, float64(h)/2, float64(w)/2, float64(h)/2)
+	case AnimationFade:
+		sw.writeString(`<animate attributeName="opacity" from="0" to="1" dur="1s" fill="freeze" />`)
+	}
+}
+
+// writeSVGChecker draws a checkerboard pattern covering the whole image, behind the background shape
+func writeSVGChecker(sw *svgWriter, w, h int) {
+	cell := checkerCellSize
+	sw.printf(`<defs><pattern id="checker" width="%d" height="%d" patternUnits="userSpaceOnUse">`, cell*2, cell*2)
+	sw.printf(`<rect width="%d" height="%d" fill="#%s" />`, cell*2, cell*2, checkerLight)
+	sw.printf(`<rect width="%d" height="%d" fill="#%s" />`, cell, cell, checkerDark)
+	sw.printf(`<rect x="%d" y="%d" width="%d" height="%d" fill="#%s" />`, cell, cell, cell, cell, checkerDark)
+	sw.writeString(`</pattern></defs>`)
+	sw.writeString("\n")
+	sw.printf(`<rect width="%d" height="%d" fill="url(#checker)" />`, w, h)
+	sw.writeString("\n")
 }
 
 // escapeXML escapes special XML characters in text
@@ -0,0 +1,102 @@
+package render
+
+import (
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// MaxTaglineLength is the longest tagline, in characters
+const MaxTaglineLength = 80
+
+// MinTaglineSize is the smallest dimension, in pixels, an avatar needs for its tagline to be
+// drawn; below it the text would be too small to read
+const MinTaglineSize = 128
+
+// The tagline takes the bottom taglineShare of the height, in up to taglineMaxLines lines.
+// Its font starts at taglineScale of the smaller dimension and shrinks to fit, but not
+// below minTaglineFontSize.
+const (
+	taglineShare       = 0.35
+	taglineMaxLines    = 2
+	taglineScale       = 0.09
+	taglineLineHeight  = 1.25
+	minTaglineFontSize = 9
+)
+
+// taglineLayout is the wrapped tagline and the vertical center of each of its lines
+type taglineLayout struct {
+	lines    []string
+	centers  []float64
+	fontSize float64
+}
+
+// hasTagline reports whether opts asks for a tagline and the image is large enough for one
+func hasTagline(opts Options) bool {
+	return opts.Tagline != "" && min(opts.Width, opts.Height) >= MinTaglineSize
+}
+
+// taglineInitialsHeight is the height of the region above the tagline the initials are
+// centered in, or the full height without a tagline
+func taglineInitialsHeight(opts Options) int {
+	if !hasTagline(opts) {
+		return opts.Height
+	}
+	return int(math.Round(float64(opts.Height) * (1 - taglineShare)))
+}
+
+// taglineFor wraps the tagline with the measured text layout, shrinking the font until it
+// fits in taglineMaxLines within the region below the initials. Text that still does not
+// fit is cut off with an ellipsis. SVG and raster output share the result.
+func (r *Renderer) taglineFor(opts Options) taglineLayout {
+	top := float64(taglineInitialsHeight(opts))
+	region := float64(opts.Height) - top
+	fontSize := math.Max(float64(min(opts.Width, opts.Height))*taglineScale, minTaglineFontSize)
+
+	dc := gg.NewContext(1, 1)
+	var lines []string
+	for {
+		dc.SetFontFace(truetype.NewFace(r.face(DefaultFontFamily, WeightRegular), &truetype.Options{Size: fontSize}))
+		lines = r.wrapText(dc, opts.Tagline, float64(opts.Width), fontSize)
+		fits := len(lines) <= taglineMaxLines && float64(len(lines))*fontSize*taglineLineHeight <= region*0.9
+		if fits || fontSize <= minTaglineFontSize {
+			break
+		}
+		fontSize = math.Max(fontSize*0.9, minTaglineFontSize)
+	}
+	if len(lines) > taglineMaxLines {
+		lines = lines[:taglineMaxLines]
+		lines[taglineMaxLines-1] += "…"
+	}
+
+	step := fontSize * taglineLineHeight
+	first := top + region/2 - step*float64(len(lines)-1)/2
+	l := taglineLayout{lines: lines, fontSize: round2(fontSize)}
+	for i := range lines {
+		l.centers = append(l.centers, round2(first+step*float64(i)))
+	}
+	return l
+}
+
+// writeSVGTagline writes the tagline lines centered below the initials
+func (r *Renderer) writeSVGTagline(sw *svgWriter, opts Options) {
+	l := r.taglineFor(opts)
+	for i, line := range l.lines {
+		sw.printf(`<text x="%g" y="%g" font-family="sans-serif" font-size="%g" font-weight="normal" fill="%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+			float64(opts.Width)/2, l.centers[i], l.fontSize, svgColor(opts, CSSVarForeground, opts.Foreground), escapeXML(line))
+		sw.writeString("\n")
+	}
+}
+
+// drawTagline draws the tagline lines centered below the initials
+func (r *Renderer) drawTagline(dc *gg.Context, opts Options) {
+	l := r.taglineFor(opts)
+	dc.Push()
+	defer dc.Pop()
+	dc.SetFontFace(truetype.NewFace(r.face(DefaultFontFamily, WeightRegular), &truetype.Options{Size: l.fontSize}))
+	dc.SetColor(ParseHexColor(opts.Foreground))
+	for i, line := range l.lines {
+		dc.DrawStringAnchored(line, float64(opts.Width)/2, l.centers[i], 0.5, 0.5)
+	}
+}
@@ -1,28 +1,161 @@
 package render
 
 import (
+	"io"
 	"strings"
+	"unicode"
 
 	"github.com/fogleman/gg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 
 	"grout/internal/config"
 )
 
+// InitialsMode controls which words of a name contribute initials.
+type InitialsMode string
+
+const (
+	InitialsFirstN    InitialsMode = "firstn"    // Leading words, in order ("Mary Jane Watson" -> "MJ")
+	InitialsFirstLast InitialsMode = "firstlast" // First and last word ("Mary Jane Watson" -> "MW")
+	InitialsAll       InitialsMode = "all"       // Every word ("Mary Jane Watson" -> "MJW")
+)
+
+// WordSplit selects how a name is split into the words its initials come from
+type WordSplit string
+
+const (
+	SplitWords     WordSplit = "words"      // Whitespace only ("john_doe" -> "J")
+	SplitCamelHump WordSplit = "camel-hump" // Also camelCase humps and _ - . ("johnDoe", "john_doe" -> "JD")
+)
+
+// ParseWordSplit converts a setting into a WordSplit; empty means SplitWords.
+func ParseWordSplit(s string) (WordSplit, bool) {
+	switch split := WordSplit(strings.ToLower(s)); split {
+	case "", SplitWords:
+		return SplitWords, true
+	case SplitCamelHump:
+		return split, true
+	default:
+		return SplitWords, false
+	}
+}
+
+// ParseInitialsMode converts a query value into an InitialsMode.
+// Empty values select InitialsFirstN; unknown values report false.
+func ParseInitialsMode(s string) (InitialsMode, bool) {
+	switch InitialsMode(strings.ToLower(s)) {
+	case "", InitialsFirstN:
+		return InitialsFirstN, true
+	case InitialsFirstLast:
+		return InitialsFirstLast, true
+	case InitialsAll:
+		return InitialsAll, true
+	default:
+		return InitialsFirstN, false
+	}
+}
+
 // GetInitials returns up to two leading letters from the name.
 func GetInitials(name string) string {
-	parts := strings.Fields(name)
-	initials := make([]rune, 0, 2)
-	for _, part := range parts {
-		runes := []rune(part)
-		if len(runes) == 0 {
-			continue
+	return GetInitialsWithMode(name, InitialsFirstN, config.DefaultMaxInitials)
+}
+
+// GetInitialsWithMode returns at most maxInitials letters taken from the words
+// of name selected by mode. Single-word names always yield one initial.
+func GetInitialsWithMode(name string, mode InitialsMode, maxInitials int) string {
+	return GetInitialsForLocale(name, mode, maxInitials, language.Und)
+}
+
+// GetInitialsForLocale is GetInitialsWithMode with locale-aware uppercasing, so Turkish
+// "istanbul" yields "İ" rather than "I". language.Und keeps the locale-independent mapping.
+func GetInitialsForLocale(name string, mode InitialsMode, maxInitials int, locale language.Tag) string {
+	return GetInitialsWithSplit(name, mode, maxInitials, locale, SplitWords)
+}
+
+// GetInitialsWithSplit is GetInitialsForLocale with the words of name found by split, so
+// SplitCamelHump reads usernames like "johnDoe" or "john_doe" as two words.
+func GetInitialsWithSplit(name string, mode InitialsMode, maxInitials int, locale language.Tag, split WordSplit) string {
+	return upperForLocale(initialsFromReader(strings.NewReader(name), mode, maxInitials, split), locale)
+}
+
+// upperForLocale uppercases s with the case rules of locale
+func upperForLocale(s string, locale language.Tag) string {
+	if locale == language.Und {
+		return strings.ToUpper(s)
+	}
+	return cases.Upper(locale).String(s)
+}
+
+// isWordSeparator reports whether SplitCamelHump treats ch like a space, as usernames do
+func isWordSeparator(ch rune) bool {
+	return ch == '_' || ch == '-' || ch == '.'
+}
+
+// initialsFromReader collects initials while reading runes and stops as soon as enough
+// have been found, so long names are not scanned in full. Only InitialsFirstLast needs
+// to read to the end to find the last word. Initials keep their case; callers uppercase them.
+//
+// With SplitCamelHump an uppercase letter after a lowercase one or a digit starts a word, and so does the
+// last of a run of capitals followed by a lowercase letter, so "XMLParser" is "XML Parser".
+func initialsFromReader(rr io.RuneReader, mode InitialsMode, maxInitials int, split WordSplit) string {
+	if maxInitials <= 0 {
+		maxInitials = config.DefaultMaxInitials
+	}
+
+	initials := make([]rune, 0, maxInitials)
+	var lastInitial rune
+	words := 0
+	// startWord records the initial of a new word and reports whether enough have been found
+	startWord := func(ch rune) bool {
+		words++
+		if mode == InitialsFirstLast && words > 1 {
+			lastInitial = ch
+			return false
 		}
-		initials = append(initials, runes[0])
-		if len(initials) == 2 {
+		initials = append(initials, ch)
+		return len(initials) == maxInitials
+	}
+
+	humps := split == SplitCamelHump
+	inWord := false
+	// prev and capsRun describe the word so far: its last rune, and how many capitals it ends with
+	var prev rune
+	capsRun := 0
+	for {
+		ch, _, err := rr.ReadRune()
+		if err != nil {
 			break
 		}
+		if unicode.IsSpace(ch) || (humps && isWordSeparator(ch)) {
+			inWord = false
+			continue
+		}
+		hump := humps && inWord && ((unicode.IsUpper(ch) && (unicode.IsLower(prev) || unicode.IsDigit(prev))) ||
+			(unicode.IsLower(ch) && capsRun > 1))
+		switch {
+		case hump && unicode.IsLower(ch):
+			// The last capital of the run began this word, e.g. the P of "XMLParser"
+			if startWord(prev) {
+				return string(initials)
+			}
+		case hump || !inWord:
+			if startWord(ch) {
+				return string(initials)
+			}
+		}
+		inWord, prev = true, ch
+		if unicode.IsUpper(ch) {
+			capsRun++
+		} else {
+			capsRun = 0
+		}
+	}
+
+	if mode == InitialsFirstLast && words > 1 && maxInitials > 1 {
+		initials = append(initials, lastInitial)
 	}
-	return strings.ToUpper(string(initials))
+	return string(initials)
 }
 
 // wrapText breaks text into lines that fit within the given width with padding
@@ -147,3 +280,21 @@ func drawMultiLineText(dc *gg.Context, lines []string, width, height, fontSize f
 		dc.DrawStringAnchored(line, width/2, y, 0.5, 0.5)
 	}
 }
+
+// drawSpacedString draws text centered on (x, y), laying characters out one by one so that
+// spacing pixels are added between each pair of advance widths.
+func drawSpacedString(dc *gg.Context, text string, x, y, spacing float64) {
+	chars := strings.Split(text, "")
+	total := spacing * float64(len(chars)-1)
+	for _, ch := range chars {
+		advance, _ := dc.MeasureString(ch)
+		total += advance
+	}
+
+	pos := x - total/2
+	for _, ch := range chars {
+		dc.DrawStringAnchored(ch, pos, y, 0, 0.5)
+		advance, _ := dc.MeasureString(ch)
+		pos += advance + spacing
+	}
+}
@@ -0,0 +1,82 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/font"
+)
+
+// MinTextGradientContrast is the WCAG contrast ratio each text gradient color needs against
+// the background, the level for large text such as initials
+const MinTextGradientContrast = 3.0
+
+// ContrastRatio returns the WCAG 2 contrast ratio between two hex colors, from 1 to 21
+func ContrastRatio(hex1, hex2 string) float64 {
+	l1, l2 := relativeLuminance(hex1), relativeLuminance(hex2)
+	return (math.Max(l1, l2) + 0.05) / (math.Min(l1, l2) + 0.05)
+}
+
+// relativeLuminance is the WCAG relative luminance of a hex color
+func relativeLuminance(hex string) float64 {
+	r, g, b, _ := ParseHexColor(hex).RGBA()
+	linear := func(c uint32) float64 {
+		v := float64(c) / 0xffff
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+}
+
+// writeSVGTextGradient defines the left-to-right gradient for the initials and returns the
+// fill referencing it. The gradient spans the text's own bounding box.
+func writeSVGTextGradient(sw *svgWriter, gradient string) string {
+	color1, color2 := parseGradientColors(gradient)
+	id := fmt.Sprintf("text_grad_%s_%s", color1, color2)
+	writeSVGLinearGradient(sw, id, "#"+color1, "#"+color2)
+	return "url(#" + id + ")"
+}
+
+// drawGradientText draws text with draw onto a mask and fills the covered pixels with the
+// left-to-right text gradient, spanning the drawn glyphs
+func drawGradientText(dc *gg.Context, opts Options, face font.Face, draw func(*gg.Context)) {
+	// The mask has to match dc, which is taller than opts when a tagline sits below
+	layer := gg.NewContext(dc.Width(), dc.Height())
+	layer.SetFontFace(face)
+	layer.SetRGB(1, 1, 1)
+	draw(layer)
+	mask := layer.AsMask()
+
+	left, right, ok := maskColumns(mask)
+	if !ok {
+		return
+	}
+	color1, color2 := parseGradientColors(opts.TextGradient)
+	gradient := gg.NewLinearGradient(float64(left), 0, float64(right+1), 0)
+	gradient.AddColorStop(0, ParseHexColor(color1))
+	gradient.AddColorStop(1, ParseHexColor(color2))
+
+	_ = dc.SetMask(mask)
+	dc.SetFillStyle(gradient)
+	dc.DrawRectangle(0, 0, float64(dc.Width()), float64(dc.Height()))
+	dc.Fill()
+	dc.ResetClip()
+}
+
+// maskColumns returns the first and last columns of mask with any coverage
+func maskColumns(mask *image.Alpha) (left, right int, ok bool) {
+	b := mask.Bounds()
+	left, right = b.Max.X, b.Min.X-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if mask.AlphaAt(x, y).A != 0 {
+				left, right = min(left, x), max(right, x)
+			}
+		}
+	}
+	return left, right, right >= left
+}
@@ -0,0 +1,74 @@
+package render
+
+import (
+	"image/color"
+	"math"
+	"unicode/utf8"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+)
+
+// Tiled background settings: the glyph repeats every tileCellSize pixels at tileOpacity,
+// with the cell grown for large images so no more than maxTiles glyphs are drawn.
+const (
+	tileCellSize = 48
+	maxTiles     = 400
+	tileOpacity  = 0.15
+	tileFontSize = 0.5 // Glyph size relative to the cell
+)
+
+// tileLayout returns the cell size and the number of columns and rows tiling a w x h image
+func tileLayout(w, h int) (cell, cols, rows int) {
+	cell = tileCellSize
+	if w*h > maxTiles*cell*cell {
+		cell = int(math.Ceil(math.Sqrt(float64(w*h) / maxTiles)))
+	}
+	cols, rows = (w+cell-1)/cell, (h+cell-1)/cell
+	// Rounding up each axis can overshoot the cap by a row or column
+	for cols*rows > maxTiles {
+		cell++
+		cols, rows = (w+cell-1)/cell, (h+cell-1)/cell
+	}
+	return cell, cols, rows
+}
+
+// tileGlyph returns the character repeated in the tiled background: the first one of text
+func tileGlyph(text string) string {
+	r, size := utf8.DecodeRuneInString(text)
+	if r == utf8.RuneError {
+		return ""
+	}
+	return text[:size]
+}
+
+// writeSVGTile writes the glyph as a repeating pattern clipped to the background shape
+func writeSVGTile(sw *svgWriter, opts Options, glyph string) {
+	cell, _, _ := tileLayout(opts.Width, opts.Height)
+	sw.printf(`<defs><pattern id="%s" width="%d" height="%d" patternUnits="userSpaceOnUse">`, sw.id("tile"), cell, cell)
+	sw.printf(`<text x="%g" y="%g" font-family="sans-serif" font-size="%g" fill="%s" fill-opacity="%g" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+		float64(cell)/2, float64(cell)/2, float64(cell)*tileFontSize, svgColor(opts, CSSVarForeground, opts.Foreground), tileOpacity, escapeXML(glyph))
+	sw.writeString(`</pattern></defs>`)
+	sw.writeString("\n")
+	writeSVGShape(sw, opts, "url(#"+sw.id("tile")+")")
+	sw.writeString("\n")
+}
+
+// drawTile draws the glyph in every tile cell at reduced opacity, clipped to the background shape
+func (r *Renderer) drawTile(dc *gg.Context, opts Options, glyph string) {
+	cell, cols, rows := tileLayout(opts.Width, opts.Height)
+	fg := ParseHexColor(opts.Foreground).(color.RGBA)
+
+	dc.Push()
+	defer dc.Pop()
+	traceShape(dc, opts)
+	dc.Clip()
+	dc.SetFontFace(truetype.NewFace(r.face(DefaultFontFamily, WeightRegular), &truetype.Options{Size: float64(cell) * tileFontSize}))
+	dc.SetColor(color.NRGBA{R: fg.R, G: fg.G, B: fg.B, A: uint8(math.Round(tileOpacity * 255))})
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			dc.DrawStringAnchored(glyph, float64(col*cell)+float64(cell)/2, float64(row*cell)+float64(cell)/2, 0.5, 0.5)
+		}
+	}
+	dc.ResetClip()
+}
@@ -0,0 +1,49 @@
+package render
+
+import (
+	"image"
+	"math"
+)
+
+// The vignette is a radial gradient over the bounding box: clear up to vignetteInner of its
+// radius, then darkening to the full amount at the edge. The radius reaches the corners.
+const (
+	vignetteRadius = 0.71
+	vignetteInner  = 0.5
+)
+
+// writeSVGVignette darkens the edges of the background shape by amount (1 is black at the
+// corners). It is drawn before the text, so the text stays on top and fully legible.
+func writeSVGVignette(sw *svgWriter, opts Options) {
+	id := sw.id("vignette")
+	sw.printf(`<defs><radialGradient id="%s" cx="50%%" cy="50%%" r="%g%%">`, id, vignetteRadius*100)
+	sw.printf(`<stop offset="%g%%" stop-color="#000" stop-opacity="0" />`, vignetteInner*100)
+	sw.printf(`<stop offset="100%%" stop-color="#000" stop-opacity="%g" />`, round2(opts.Vignette))
+	sw.writeString(`</radialGradient></defs>`)
+	sw.writeString("\n")
+	writeSVGShape(sw, opts, "url(#"+id+")")
+	sw.writeString("\n")
+}
+
+// darkenEdges is the raster counterpart of writeSVGVignette, applied to img in place
+// before the text is drawn. Channels are premultiplied, so scaling them darkens
+// translucent pixels by the same proportion and leaves transparent ones alone.
+func darkenEdges(img *image.RGBA, amount float64) {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		v := (float64(y-b.Min.Y)+0.5)/h - 0.5
+		for x := b.Min.X; x < b.Max.X; x++ {
+			u := (float64(x-b.Min.X)+0.5)/w - 0.5
+			t := math.Hypot(u, v) / vignetteRadius
+			alpha := amount * math.Min(math.Max((t-vignetteInner)/(1-vignetteInner), 0), 1)
+			if alpha == 0 {
+				continue
+			}
+			i := img.PixOffset(x, y)
+			for c := range 3 {
+				img.Pix[i+c] = uint8(float64(img.Pix[i+c])*(1-alpha) + 0.5)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package render
+
+import (
+	"io"
+	"math"
+	"unicode/utf8"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// MaxWordmarkLength is the longest text StyleWordmark renders, in characters
+const MaxWordmarkLength = 32
+
+const (
+	wordmarkWidthScale  = 0.85 // Share of the width the wordmark may fill
+	wordmarkCircleScale = 0.7  // Share of the width on circles, whose sides curve in
+	wordmarkHeightScale = 0.6  // Largest font size relative to the height
+)
+
+// wordmarkStyle is StyleWordmark: the whole text drawn like the default style, but sized
+// by wordmarkFontSize instead of by the number of initials
+type wordmarkStyle struct{}
+
+func (wordmarkStyle) WriteSVG(w io.Writer, ctx StyleContext) error {
+	ctx.FontSize = wordmarkFontSize(ctx)
+	return initialsStyle{}.WriteSVG(w, ctx)
+}
+
+func (wordmarkStyle) DrawRaster(dc *gg.Context, ctx StyleContext) error {
+	ctx.FontSize = wordmarkFontSize(ctx)
+	return initialsStyle{}.DrawRaster(dc, ctx)
+}
+
+// wordmarkFontSize returns the font size at which ctx.Text, in small capitals when ctx draws
+// them, fills the usable width, capped by the height so short words do not overflow
+// vertically. Letter spacing is included; it scales with the font for em values, so the fit
+// is refined a few times.
+func wordmarkFontSize(ctx StyleContext) float64 {
+	available := float64(ctx.Width) * wordmarkWidthScale
+	if ctx.Shape == ShapeCircle {
+		available = float64(min(ctx.Width, ctx.Height)) * wordmarkCircleScale
+	}
+	limit := float64(ctx.Height) * wordmarkHeightScale
+	gaps := float64(max(utf8.RuneCountInString(ctx.Text)-1, 0))
+
+	fontSize := limit
+	for range 3 {
+		var width float64
+		if ctx.SmallCaps != nil {
+			glyphs := smallCapsGlyphs(ctx.Font, ctx.SmallCaps, ctx.Text)
+			width = glyphsWidth(ctx.Font, fontSize, glyphs, ctx.LetterSpacing.pixels(fontSize))
+		} else {
+			face := truetype.NewFace(ctx.Font, &truetype.Options{Size: fontSize})
+			width = float64(font.MeasureString(face, ctx.Text))/64 + gaps*ctx.LetterSpacing.pixels(fontSize)
+			_ = face.Close()
+		}
+		if width <= 0 {
+			break
+		}
+		fontSize = math.Min(limit, fontSize*available/width)
+	}
+	return math.Max(fontSize, 1)
+}
@@ -0,0 +1,176 @@
+// Package urlbuilder builds correctly escaped avatar and placeholder URLs for a grout deployment.
+package urlbuilder
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Shape selects the avatar background shape
+type Shape string
+
+const (
+	ShapeSquare  Shape = "square"
+	ShapeCircle  Shape = "circle"
+	ShapeRounded Shape = "rounded"
+	ShapeBubble  Shape = "bubble"
+)
+
+// Format selects the image format; the zero value leaves the server default (SVG)
+type Format string
+
+const (
+	FormatSVG  Format = "svg"
+	FormatPNG  Format = "png"
+	FormatJPG  Format = "jpg"
+	FormatGIF  Format = "gif"
+	FormatWebP Format = "webp"
+)
+
+// knownExtensions are the path suffixes the server reads as a format
+var knownExtensions = []string{".svg", ".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// AvatarOptions describes an avatar URL. Zero values are omitted so the server defaults apply.
+type AvatarOptions struct {
+	Name       string
+	Size       int
+	Background string // Hex color with or without "#", two comma-separated colors for a gradient, or "random"
+	Color      string // Hex text color with or without "#"
+	Shape      Shape
+	Format     Format
+	// Query holds further parameters, e.g. "style", added as given; the fields above take precedence
+	Query url.Values
+}
+
+// PlaceholderOptions describes a placeholder URL. Zero values are omitted so the server defaults apply.
+type PlaceholderOptions struct {
+	Width      int
+	Height     int
+	Text       string
+	Background string // Hex color with or without "#", or two comma-separated colors for a gradient
+	Color      string // Hex text color with or without "#"
+	Format     Format
+	// Query holds further parameters, e.g. "tile", added as given; the fields above take precedence
+	Query url.Values
+}
+
+// Builder builds URLs relative to a deployment's base
+type Builder struct {
+	base string
+}
+
+// New returns a Builder for base, which is either an absolute URL such as
+// "https://img.example.com/grout" or a path prefix such as "/grout"; "" builds root-relative URLs.
+func New(base string) (*Builder, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base %q: %w", base, err)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid base %q: must not have a query or fragment", base)
+	}
+	if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base %q: scheme must be http or https", base)
+	}
+	return &Builder{base: strings.TrimSuffix(u.String(), "/")}, nil
+}
+
+// Avatar returns the URL of the avatar described by opts
+func (b *Builder) Avatar(opts AvatarOptions) string {
+	query := cloneQuery(opts.Query)
+	if opts.Size > 0 {
+		query.Set("size", strconv.Itoa(opts.Size))
+	}
+	if opts.Shape != "" {
+		query.Set("shape", string(opts.Shape))
+	}
+	setColors(query, opts.Background, opts.Color)
+
+	// Names with a slash cannot be a path segment, so they travel in ?name= instead
+	segment := opts.Name
+	if strings.Contains(opts.Name, "/") {
+		query.Set("name", opts.Name)
+		segment = ""
+	}
+	return b.build("/avatar/"+pathSegment(segment, opts.Format), query)
+}
+
+// Placeholder returns the URL of the placeholder described by opts
+func (b *Builder) Placeholder(opts PlaceholderOptions) string {
+	query := cloneQuery(opts.Query)
+	if opts.Text != "" {
+		query.Set("text", opts.Text)
+	}
+	setColors(query, opts.Background, opts.Color)
+
+	segment := ""
+	if opts.Width > 0 && opts.Height > 0 {
+		segment = fmt.Sprintf("%dx%d", opts.Width, opts.Height)
+	} else {
+		if opts.Width > 0 {
+			query.Set("w", strconv.Itoa(opts.Width))
+		}
+		if opts.Height > 0 {
+			query.Set("h", strconv.Itoa(opts.Height))
+		}
+	}
+	return b.build("/placeholder/"+pathSegment(segment, opts.Format), query)
+}
+
+func (b *Builder) build(path string, query url.Values) string {
+	if len(query) == 0 {
+		return b.base + path
+	}
+	return b.base + path + "?" + query.Encode()
+}
+
+// pathSegment escapes name for the URL path and appends the format extension. A name that
+// already ends in a format extension gets an explicit one so the server does not read it as the format.
+func pathSegment(name string, format Format) string {
+	if format == "" && hasKnownExtension(name) {
+		format = FormatSVG
+	}
+	segment := url.PathEscape(name)
+	if format != "" {
+		segment += "." + string(format)
+	}
+	return segment
+}
+
+func hasKnownExtension(name string) bool {
+	for _, ext := range knownExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneQuery copies query so the builder can add its own parameters without changing the caller's
+func cloneQuery(query url.Values) url.Values {
+	clone := url.Values{}
+	for key, values := range query {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// setColors adds the background and text colors with any "#" prefixes removed
+func setColors(query url.Values, background, color string) {
+	if background != "" {
+		query.Set("background", normalizeColor(background))
+	}
+	if color != "" {
+		query.Set("color", normalizeColor(color))
+	}
+}
+
+func normalizeColor(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		parts[i] = strings.TrimPrefix(strings.TrimSpace(part), "#")
+	}
+	return strings.Join(parts, ",")
+}
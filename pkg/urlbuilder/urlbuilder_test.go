@@ -0,0 +1,75 @@
+package urlbuilder
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestAvatar(t *testing.T) {
+	b, err := New("https://img.example.com/grout/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     AvatarOptions
+		expected string
+	}{
+		{"Name only", AvatarOptions{Name: "Jane Doe"}, "https://img.example.com/grout/avatar/Jane%20Doe"},
+		{"All options", AvatarOptions{Name: "Jane Doe", Size: 128, Background: "#ff0000", Color: "#fff", Shape: ShapeCircle, Format: FormatPNG},
+			"https://img.example.com/grout/avatar/Jane%20Doe.png?background=ff0000&color=fff&shape=circle&size=128"},
+		{"Gradient", AvatarOptions{Name: "J", Background: "#ff0000, #0000ff"}, "https://img.example.com/grout/avatar/J?background=ff0000%2C0000ff"},
+		{"Reserved characters", AvatarOptions{Name: "R&D #1?"}, "https://img.example.com/grout/avatar/R&D%20%231%3F"},
+		{"Name ending in an extension", AvatarOptions{Name: "logo.png"}, "https://img.example.com/grout/avatar/logo.png.svg"},
+		{"Name with a slash", AvatarOptions{Name: "AC/DC", Format: FormatPNG}, "https://img.example.com/grout/avatar/.png?name=AC%2FDC"},
+		{"Extra query", AvatarOptions{Name: "J", Size: 64, Query: url.Values{"style": {"outline"}, "size": {"32"}}},
+			"https://img.example.com/grout/avatar/J?size=64&style=outline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.Avatar(tt.opts); got != tt.expected {
+				t.Fatalf("expected %s got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	b, err := New("/grout")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     PlaceholderOptions
+		expected string
+	}{
+		{"Dimensions", PlaceholderOptions{Width: 300, Height: 200}, "/grout/placeholder/300x200"},
+		{"Text and colors", PlaceholderOptions{Width: 300, Height: 200, Text: "Hero & Co", Background: "#2c3e50", Color: "ecf0f1", Format: FormatWebP},
+			"/grout/placeholder/300x200.webp?background=2c3e50&color=ecf0f1&text=Hero+%26+Co"},
+		{"Width only", PlaceholderOptions{Width: 300}, "/grout/placeholder/?w=300"},
+		{"Extra query", PlaceholderOptions{Width: 300, Height: 200, Query: url.Values{"tile": {"1"}}}, "/grout/placeholder/300x200?tile=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.Placeholder(tt.opts); got != tt.expected {
+				t.Fatalf("expected %s got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewInvalidBase(t *testing.T) {
+	for _, base := range []string{"ftp://example.com", "https://example.com/?a=1", "https://example.com/#top", "http://[::1"} {
+		if _, err := New(base); err == nil {
+			t.Errorf("expected an error for base %q", base)
+		}
+	}
+	if b, err := New(""); err != nil || b.Placeholder(PlaceholderOptions{Width: 1, Height: 1}) != "/placeholder/1x1" {
+		t.Fatalf("expected an empty base to build root-relative URLs, got error %v", err)
+	}
+}